@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+// Array is a local copy of a server slice kept up to date with the array
+// operations published for it, the Go side of applyBinaryOperation
+type Array[T any] struct {
+	mu     sync.RWMutex
+	items  []T
+	decode func([]byte) (any, error)
+	apply  func(op any, items []T) ([]T, error)
+}
+
+// NewArray reads operations of the bit protocol in the given version
+func NewArray[T any](version ArrayVersion, payloads Codec) *Array[T] {
+	return newArray[T](version, payloads, nil)
+}
+
+func newArray[T any](version protocol.Version, payloads codec.Codec, dict []byte) *Array[T] {
+	d := &protocol.Decoder{Version: version, Dict: dict}
+	return &Array[T]{
+		decode: func(data []byte) (any, error) {
+			return d.Decode(data)
+		},
+		apply: func(op any, items []T) ([]T, error) {
+			return protocol.ApplyCodec(op.(protocol.Operation), items, payloads)
+		},
+	}
+}
+
+// NewByteArray reads operations of the byte protocol
+func NewByteArray[T any](payloads Codec) *Array[T] {
+	return newByteArray[T](payloads, nil)
+}
+
+func newByteArray[T any](payloads codec.Codec, dict []byte) *Array[T] {
+	d := &byteprotocol.Decoder{Dict: dict}
+	return &Array[T]{
+		decode: func(data []byte) (any, error) {
+			return d.Decode(data)
+		},
+		apply: func(op any, items []T) ([]T, error) {
+			return byteprotocol.ApplyCodec(op.(byteprotocol.Operation), items, payloads)
+		},
+	}
+}
+
+// NewSessionArray reads the array encoding the server selected in the
+// handshake, inflating compressed bulk inserts with Options.Dict
+func NewSessionArray[T any](c *Client) *Array[T] {
+	welcome := c.Welcome()
+	if welcome.Array == "byte" {
+		return newByteArray[T](c.Codec(), c.opts.Dict)
+	}
+	return newArray[T](welcome.ArrayVersion, c.Codec(), c.opts.Dict)
+}
+
+// Apply decodes one operation and applies it, the items are left as they
+// were when it fails
+func (a *Array[T]) Apply(message []byte) error {
+	op, err := a.decode(message)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	items, err := a.apply(op, append([]T(nil), a.items...))
+	if err != nil {
+		return fmt.Errorf("applying operation: %w", err)
+	}
+	a.items = items
+	return nil
+}
+
+// Reset replaces the items, e.g. with a snapshot returned by an RPC
+func (a *Array[T]) Reset(items []T) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.items = append([]T(nil), items...)
+}
+
+// Items returns a copy of the current items
+func (a *Array[T]) Items() []T {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]T(nil), a.items...)
+}
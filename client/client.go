@@ -0,0 +1,554 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+//
+// Client talks to a GoReactiveHTML server the way web/lib/WebSocketUtil.js
+// does, for backend services and integration tests:
+//
+//   c, err := client.Dial(ctx, "ws://localhost:8080/ws", client.Options{Token: token})
+//   out, err := c.RPC("tasks", "add", map[string]any{"title": "x"}, nil).Wait(ctx)
+//   c.Subscribe("tasks/42", func(out client.Output) { ... }, nil, nil)
+//
+// Topics are concrete: the server refuses wildcard subscriptions.
+//
+// Every request gets a request id from 1 to 255 and a Future resolved by
+// the response carrying the same id. Id 0 is reserved for subscribed
+// events, they are dispatched to the callbacks of the matching topics.
+//
+// When the connection drops, pending requests fail with ErrDisconnected and
+// the client dials again with a growing delay. Once connected it subscribes
+// again to every topic, asking only for the retained messages it has not
+// seen yet.
+//
+
+// handshakeTimeout bounds the wait for the welcome when the context of
+// the dial has no deadline
+const handshakeTimeout = 10 * time.Second
+
+var (
+	ErrClosed          = errors.New("client closed")
+	ErrDisconnected    = errors.New("connection lost before the response")
+	ErrTooManyRequests = errors.New("all request ids are pending")
+)
+
+// Error is the error output of a request, MsgType 'E'
+type Error struct {
+	Destination string
+	Message     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Destination, e.Message)
+}
+
+type Options struct {
+	// Token is sent as "Authorization: Bearer <token>"
+	Token  string
+	Header http.Header
+
+	// Codecs are the payload codecs offered, in order of preference.
+	// JSON when empty or when the server accepts none of them.
+	Codecs []string
+
+	// Dict is the dictionary of the compressed bulk inserts, the one the
+	// server is configured with
+	Dict []byte
+
+	Dialer *websocket.Dialer
+
+	// ReconnectDelay is the first delay before dialing again, doubled up to
+	// MaxReconnectDelay. Defaults to 500ms and 30s.
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	NoReconnect       bool
+}
+
+type Client struct {
+	url  string
+	opts Options
+
+	mu      sync.Mutex
+	conn    *types.WebSocketConnection
+	pending [256]*Future
+	next    uint8
+	topics  map[string]*subscription
+	lastSeq map[string]uint64
+	welcome hello.Welcome
+	closed  bool
+	done    chan struct{}
+
+	events types.WebSocketClient
+}
+
+// subscription is what is needed to subscribe again after a reconnect
+type subscription struct {
+	data   string
+	header map[string]string
+}
+
+// Dial connects to the server. The first connection must succeed, later
+// ones are retried in the background.
+func Dial(ctx context.Context, url string, opts Options) (*Client, error) {
+	if opts.ReconnectDelay <= 0 {
+		opts.ReconnectDelay = 500 * time.Millisecond
+	}
+	if opts.MaxReconnectDelay <= 0 {
+		opts.MaxReconnectDelay = 30 * time.Second
+	}
+
+	c := &Client{
+		url:     url,
+		opts:    opts,
+		topics:  make(map[string]*subscription),
+		lastSeq: make(map[string]uint64),
+		done:    make(chan struct{}),
+	}
+	c.events.Match = func(subscribed, destination string) bool {
+		return topics.Matches(topics.Topic(subscribed), topics.Topic(destination))
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.read(conn)
+	return c, nil
+}
+
+func (c *Client) dial(ctx context.Context) (*types.WebSocketConnection, error) {
+	dialer := c.opts.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	d := *dialer
+	d.EnableCompression = true
+	for _, name := range c.opts.Codecs {
+		d.Subprotocols = append(d.Subprotocols, codec.SubprotocolPrefix+name)
+	}
+
+	header := c.opts.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if c.opts.Token != "" {
+		header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+
+	conn, _, err := d.DialContext(ctx, c.url, header)
+	if err != nil {
+		return nil, err
+	}
+	payloads, _ := codec.FromSubprotocols([]string{conn.Subprotocol()})
+	wsc := &types.WebSocketConnection{Conn: conn, Codec: payloads}
+
+	welcome, err := handshake(ctx, wsc)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	c.welcome = welcome
+	c.mu.Unlock()
+	return wsc, nil
+}
+
+// handshake sends the HELLO and reads the answer before anything else is
+// sent. A server without the handshake answers with an error, it is
+// served the default session.
+func handshake(ctx context.Context, wsc *types.WebSocketConnection) (hello.Welcome, error) {
+	message, err := hello.ClientInputHello{
+		Version:       types.HelloVersion,
+		ReqIdSize:     1,
+		Capabilities:  types.CapBitProtocol | types.CapByteProtocol | types.CapPathProtocol | types.CapDeflate,
+		ArrayVersions: protocol.Supported,
+	}.Marshal()
+	if err != nil {
+		return hello.Welcome{}, err
+	}
+	if err := wsc.Write(websocket.BinaryMessage, message); err != nil {
+		return hello.Welcome{}, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(handshakeTimeout)
+	}
+	wsc.Conn.SetReadDeadline(deadline)
+	defer wsc.Conn.SetReadDeadline(time.Time{})
+
+	_, message, err = wsc.Conn.ReadMessage()
+	if err != nil {
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			return hello.Welcome{}, fmt.Errorf("server refused the client: %w", err)
+		}
+		return hello.Welcome{}, err
+	}
+
+	var output types.ClientOutput
+	if err := output.UnmarshalWith(wsc.PayloadCodec(), message); err != nil {
+		return hello.Welcome{}, err
+	}
+	if output.MsgType == types.WSTypeErrorOutputMessage {
+		return hello.Welcome{
+			Version:      types.HelloVersion,
+			Capabilities: types.DefaultSession.Capabilities,
+			Array:        "bit",
+			ArrayVersion: types.DefaultSession.ArrayVersion,
+			ReqIdSize:    types.DefaultSession.ReqIdSize,
+			Codec:        wsc.PayloadCodec().Name(),
+		}, nil
+	}
+
+	var welcome hello.Welcome
+	if err := decode(wsc.PayloadCodec(), output, &welcome); err != nil {
+		return hello.Welcome{}, fmt.Errorf("reading welcome: %w", err)
+	}
+	return welcome, nil
+}
+
+// Welcome returns what the server selected in the handshake of the current
+// connection
+func (c *Client) Welcome() Welcome {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.welcome
+}
+
+// Codec returns the payload codec agreed with the server
+func (c *Client) Codec() Codec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.PayloadCodec()
+}
+
+// Close stops reconnecting and fails the pending requests with ErrClosed
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.failPending(ErrClosed)
+	c.mu.Unlock()
+
+	return conn.Conn.Close()
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  FUTURES
+// ─────────────────────────────────────────────────────────────
+//
+
+// Future is the pending response of a request
+type Future struct {
+	done   chan struct{}
+	output Output
+	err    error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func failed(err error) *Future {
+	f := newFuture()
+	f.resolve(Output{}, err)
+	return f
+}
+
+func (f *Future) resolve(output Output, err error) {
+	f.output, f.err = output, err
+	close(f.done)
+}
+
+// Done is closed once the response arrived or the request failed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait returns the response. An error output is returned along with an
+// *Error.
+func (f *Future) Wait(ctx context.Context) (Output, error) {
+	select {
+	case <-f.done:
+		return f.output, f.err
+	case <-ctx.Done():
+		return Output{}, ctx.Err()
+	}
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  REQUESTS
+// ─────────────────────────────────────────────────────────────
+//
+
+// RPC calls the method of the bff class
+func (c *Client) RPC(class, method string, params map[string]interface{}, header map[string]string) *Future {
+	return c.request(func(reqId uint8, conn *types.WebSocketConnection) ([]byte, error) {
+		return rpc.ClientInputRPC{ReqId: &reqId, Class: class, Method: method, Params: params, Header: header, WSConn: conn}.Marshal()
+	})
+}
+
+// Endpoint requests the websocket endpoint, data is encoded with the codec
+// of the connection
+func (c *Client) Endpoint(method Method, endpoint string, data any, header map[string]string) *Future {
+	return c.request(func(reqId uint8, conn *types.WebSocketConnection) ([]byte, error) {
+		payload, err := conn.PayloadCodec().Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		return rest.ClientInputRest{ReqId: &reqId, Method: method, Endpoint: endpoint, Data: string(payload), Header: header}.Marshal()
+	})
+}
+
+// Subscribe registers the callback for the events of the topic and
+// subscribes the connection. The topic is concrete, the server refuses
+// wildcards. The future resolves with the acknowledgement of the server.
+func (c *Client) Subscribe(topic string, callback func(Output), data any, header map[string]string) *Future {
+	payload, err := c.Codec().Marshal(data)
+	if err != nil {
+		return failed(err)
+	}
+
+	c.mu.Lock()
+	c.topics[topic] = &subscription{data: string(payload), header: header}
+	c.mu.Unlock()
+
+	c.events.Subscribe(types.Subscription{
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: topic,
+		Callback:    callback,
+	})
+	return c.subscribe(topic)
+}
+
+// Unsubscribe removes the callbacks of the topic and unsubscribes the
+// connection
+func (c *Client) Unsubscribe(topic string) *Future {
+	c.mu.Lock()
+	delete(c.topics, topic)
+	delete(c.lastSeq, topic)
+	c.mu.Unlock()
+
+	c.events.Unsubscribe(topic)
+	return c.request(func(reqId uint8, conn *types.WebSocketConnection) ([]byte, error) {
+		return subscribe.ClientInputSubscription{ReqId: &reqId, Topic: topic}.MarshalUnsubscribe()
+	})
+}
+
+// subscribe sends the SUBSCRIBE frame, with the last sequence number seen
+// on the topic so only newer retained messages are replayed
+func (c *Client) subscribe(topic string) *Future {
+	c.mu.Lock()
+	sub, ok := c.topics[topic]
+	if !ok {
+		c.mu.Unlock()
+		return failed(fmt.Errorf("not subscribed to %s", topic))
+	}
+	header := make(map[string]string, len(sub.header)+1)
+	for k, v := range sub.header {
+		header[k] = v
+	}
+	if seq := c.lastSeq[topic]; seq > 0 {
+		header[broker.HeaderSince] = strconv.FormatUint(seq, 10)
+	}
+	data := sub.data
+	c.mu.Unlock()
+
+	return c.request(func(reqId uint8, conn *types.WebSocketConnection) ([]byte, error) {
+		return subscribe.ClientInputSubscription{ReqId: &reqId, Topic: topic, Data: data, Header: header}.Marshal()
+	})
+}
+
+// request takes a free request id, sends the frame built for it and
+// returns the future of its response
+func (c *Client) request(frame func(reqId uint8, conn *types.WebSocketConnection) ([]byte, error)) *Future {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return failed(ErrClosed)
+	}
+
+	var reqId uint8
+	for i := 0; i < 255; i++ {
+		c.next++
+		if c.next == 0 {
+			c.next = 1
+		}
+		if c.pending[c.next] == nil {
+			reqId = c.next
+			break
+		}
+	}
+	if reqId == 0 {
+		c.mu.Unlock()
+		return failed(ErrTooManyRequests)
+	}
+
+	conn := c.conn
+	message, err := frame(reqId, conn)
+	if err != nil {
+		c.mu.Unlock()
+		return failed(err)
+	}
+	f := newFuture()
+	c.pending[reqId] = f
+	c.mu.Unlock()
+
+	if err := conn.Write(websocket.BinaryMessage, message); err != nil {
+		// the read loop fails the future once it sees the connection drop
+		log.Println("Error sending request:", err)
+	}
+	return f
+}
+
+// failPending resolves every pending future with err, c.mu must be held
+func (c *Client) failPending(err error) {
+	for i, f := range c.pending {
+		if f != nil {
+			f.resolve(types.ClientOutput{}, err)
+			c.pending[i] = nil
+		}
+	}
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  READ LOOP AND RECONNECT
+// ─────────────────────────────────────────────────────────────
+//
+
+func (c *Client) read(conn *types.WebSocketConnection) {
+	for {
+		_, message, err := conn.Conn.ReadMessage()
+		if err != nil {
+			c.disconnected(conn)
+			return
+		}
+
+		var output types.ClientOutput
+		if err := output.UnmarshalWith(conn.PayloadCodec(), message); err != nil {
+			log.Println("Error reading server message:", err)
+			continue
+		}
+		c.dispatch(output)
+	}
+}
+
+func (c *Client) dispatch(output types.ClientOutput) {
+	if output.ReqId == 0 {
+		if seq, err := strconv.ParseUint(output.Header[broker.HeaderSeq], 10, 64); err == nil {
+			c.mu.Lock()
+			if seq > c.lastSeq[output.Destination] {
+				c.lastSeq[output.Destination] = seq
+			}
+			c.mu.Unlock()
+		}
+		c.events.ProcessMessage(output)
+		return
+	}
+
+	c.mu.Lock()
+	f := c.pending[output.ReqId]
+	c.pending[output.ReqId] = nil
+	c.mu.Unlock()
+	if f == nil {
+		return
+	}
+
+	if output.MsgType == types.WSTypeErrorOutputMessage {
+		message, _ := output.Data.(string)
+		f.resolve(output, &Error{Destination: output.Destination, Message: message})
+		return
+	}
+	f.resolve(output, nil)
+}
+
+func (c *Client) disconnected(conn *types.WebSocketConnection) {
+	conn.Conn.Close()
+
+	c.mu.Lock()
+	c.failPending(ErrDisconnected)
+	stop := c.closed || c.opts.NoReconnect
+	c.mu.Unlock()
+	if stop {
+		return
+	}
+
+	delay := c.opts.ReconnectDelay
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+
+		next, err := c.dial(context.Background())
+		if err != nil {
+			log.Println("Error reconnecting:", err)
+			delay = min(delay*2, c.opts.MaxReconnectDelay)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			next.Conn.Close()
+			return
+		}
+		c.conn = next
+		subscribed := make([]string, 0, len(c.topics))
+		for topic := range c.topics {
+			subscribed = append(subscribed, topic)
+		}
+		c.mu.Unlock()
+
+		go c.read(next)
+		for _, topic := range subscribed {
+			c.subscribe(topic)
+		}
+		return
+	}
+}
+
+// Decode reads the Data of an output into v, like the codec of the
+// connection would have decoded it straight from the wire
+func (c *Client) Decode(output Output, v any) error {
+	return decode(c.Codec(), output, v)
+}
+
+func decode(payloads Codec, output Output, v any) error {
+	data, err := payloads.Marshal(output.Data)
+	if err != nil {
+		return err
+	}
+	return payloads.Unmarshal(data, v)
+}
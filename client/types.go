@@ -0,0 +1,68 @@
+package client
+
+import (
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
+)
+
+//
+// The types of the client API, so programs outside this module never have
+// to import the internal packages of the server.
+//
+
+// Output is a frame from the server: the response of a request or an event
+// of a subscribed topic
+type Output = types.ClientOutput
+
+// MsgType tells a successful output from an error one
+type MsgType = types.WSTypeOutputMessage
+
+const (
+	MsgSuccess MsgType = types.WSTypeSuccessOutputMessage
+	MsgError   MsgType = types.WSTypeErrorOutputMessage
+)
+
+// Welcome is what the server selected in the handshake
+type Welcome = hello.Welcome
+
+// Capability is a feature of the protocol, see Welcome.Capabilities
+type Capability = types.Capability
+
+const (
+	CapBitProtocol  Capability = types.CapBitProtocol
+	CapByteProtocol Capability = types.CapByteProtocol
+	CapPathProtocol Capability = types.CapPathProtocol
+	CapDeflate      Capability = types.CapDeflate
+)
+
+// Codec encodes the payloads, see Options.Codecs
+type Codec = codec.Codec
+
+var (
+	JSON    Codec = codec.JSON
+	MsgPack Codec = codec.MsgPack
+	CBOR    Codec = codec.CBOR
+)
+
+// Method of an Endpoint request
+type Method = rest.RESTMethod
+
+const (
+	GET    Method = rest.GET
+	POST   Method = rest.POST
+	PUT    Method = rest.PUT
+	PATCH  Method = rest.PATCH
+	DELETE Method = rest.DELETE
+	HEAD   Method = rest.HEAD
+)
+
+// ArrayVersion is the version of the bit protocol, see NewArray
+type ArrayVersion = protocol.Version
+
+const (
+	ArrayV1 ArrayVersion = protocol.V1
+	ArrayV2 ArrayVersion = protocol.V2
+)
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/conformance"
+)
+
+// Writes the conformance vectors of the array protocols, from the root of
+// the repository:
+//
+//	go run ./cmd/conformance
+func main() {
+	dir := flag.String("dir", "tests/conformance/testdata", "directory of the corpus")
+	flag.Parse()
+
+	corpus, err := conformance.Generate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := corpus.Marshal()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	path := filepath.Join(*dir, conformance.File())
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d vectors written to %s", len(corpus.Vectors), path)
+}
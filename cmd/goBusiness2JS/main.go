@@ -6,14 +6,17 @@ import (
 	"go/parser"
 	"go/token"
 	"os"
+	"strconv"
 	"strings"
 )
 
 func main() {
-	// Hardcoding the file path for now
-	filepath := "../../examples/0.01/signup/business/index.go"
+	// The business file is the first argument, the signup example by default
+	filepath := "../../examples/signup/business/index.go"
+	if len(os.Args) > 1 {
+		filepath = os.Args[1]
+	}
 
-	bff := "\"/signup/business\""
 	// Check if the file exists
 	if _, err := os.Stat(filepath); os.IsNotExist(err) {
 		fmt.Printf("File does not exist: %s\n", filepath)
@@ -29,18 +32,27 @@ func main() {
 		return
 	}
 
+	// The globals and the procedures are registered under the business
+	// name, $$sync and the RPCs must be sent to it
+	bff := strconv.Quote(business(f))
+
 	var globals []string
 	var functions []string
+	// Computed globals are derived on the server, they are only read
+	computed := make(map[string]bool)
 
 	// Collect global variables
 	for _, decl := range f.Decls {
 		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.VAR {
 			for _, spec := range genDecl.Specs {
 				if valueSpec, ok := spec.(*ast.ValueSpec); ok {
-					for _, name := range valueSpec.Names {
+					for i, name := range valueSpec.Names {
 						//Check as ASCII
 						if name.Name[0] >= 'A' && name.Name[0] <= 'Z' {
 							globals = append(globals, name.Name)
+							if i < len(valueSpec.Values) && isComputed(valueSpec.Values[i]) {
+								computed[name.Name] = true
+							}
 						}
 					}
 				}
@@ -65,32 +77,33 @@ func main() {
 	// Generate JS module
 	var sb strings.Builder
 	sb.WriteString("// AUTO-GENERATED by go-to-js-exporter\n")
-	sb.WriteString("// Exports Go globals as null and functions as placeholders\n")
+	sb.WriteString("// Exports Go globals as accessors of the connection state and functions as RPC calls\n")
 
-	sb.WriteString("import {wsconn} from './lib/ws/conn.js;\n\n")
+	sb.WriteString("import {wsconn} from './lib/ws/conn.js';\n\n")
 
 	sb.WriteString(fmt.Sprintf("let bff=%s;\n\n", bff))
 
-	//Create globals variable as null right now, not initialized with zero of its type
-	for _, nm := range globals {
-		// Create the variable in JS
-		sb.WriteString(fmt.Sprintf("let _%s = null;\n", nm))
-	}
-
+	//The globals are read from the state the connection receives, kept up
+	//to date with the $$state pushes of the server
 	if len(globals) > 0 {
-		//Generate space between variables and functions generated
-		sb.WriteString("\n")
+		sb.WriteString("function _state(){return wsconn.state[bff] = wsconn.state[bff] || {}}\n\n")
 	}
 
-	//@TODO The JavaScript code is almost correct, but you should ensure that after calling sync(), the internal variables are updated.
+	//The write is applied at once and sent with the version it was changed
+	//from, the one of the last $$version push or answer. A conflict shows
+	//the value of the server instead, other rejections roll it back, unless
+	//a later write replaced it meanwhile (see WebSocketUtil.syncValue).
 	if len(globals) > 0 {
-		sb.WriteString("function sync(var_nm,v){wsconn.requestRPC(bff,'$$sync',{[var_nm]:v},null).then(() => eval(`_${var_nm} = v`)),(error) => console.log(error)); return v}\n\n")
+		sb.WriteString("function sync(var_nm,v){wsconn.syncValue(bff,var_nm,v).catch((error) => console.log(error)); return v}\n\n")
 	}
 
-	//Create globals variable as null right now, not initialized with zero of its type
 	for _, nm := range globals {
-		// Create the variable in JS
-		sb.WriteString(fmt.Sprintf("export const %s = (value) => arguments.length === 0 ? _%s : (_%s != value) ? sync(%s,value) : value;\n", nm, nm, nm, nm))
+		if computed[nm] {
+			sb.WriteString(fmt.Sprintf("export function %s() { return _state().%s; }\n", nm, nm))
+			continue
+		}
+		// Getter without arguments, setter with one
+		sb.WriteString(fmt.Sprintf("export function %s(value) { return arguments.length === 0 ? _state().%s : (_state().%s !== value) ? sync('%s', value) : value; }\n", nm, nm, nm, nm))
 	}
 
 	if len(globals) > 0 {
@@ -109,10 +122,14 @@ func main() {
 			}
 		}
 
-		// Extract parameters from function signature
+		// Extract parameters from function signature, the scope is the one
+		// of the connection on the server, the client does not send it
 		var paramNames []string
 		if funcDecl != nil && funcDecl.Type.Params != nil {
 			for _, param := range funcDecl.Type.Params.List {
+				if isScope(param.Type) {
+					continue
+				}
 				for _, name := range param.Names {
 					paramNames = append(paramNames, name.Name)
 				}
@@ -125,14 +142,12 @@ func main() {
 		// Start the params object
 		sb.WriteString("  let params = {")
 
-		for i, param := range funcDecl.Type.Params.List {
-			for _, name := range param.Names {
-				// Add a comma between fields (but not before the first one)
-				if i > 0 {
-					sb.WriteString(",")
-				}
-				sb.WriteString(fmt.Sprintf("\"%s\":%s", name.Name, name.Name))
+		for i, name := range paramNames {
+			// Add a comma between fields (but not before the first one)
+			if i > 0 {
+				sb.WriteString(",")
 			}
+			sb.WriteString(fmt.Sprintf("\"%s\":%s", name, name))
 		}
 
 		sb.WriteString("};\n") // Close the params object
@@ -146,3 +161,64 @@ func main() {
 	// Print the generated JS code to stdout
 	fmt.Print(sb.String())
 }
+
+// business returns the name given to the first state.New... or
+// procedures.Register call of the file, the package name when there is none
+func business(f *ast.File) string {
+	name := f.Name.Name
+	found := false
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if found || !ok || len(call.Args) == 0 {
+			return !found
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		if !ok || !(pkg.Name == "state" && strings.HasPrefix(sel.Sel.Name, "New") || pkg.Name == "procedures" && sel.Sel.Name == "Register") {
+			return true
+		}
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			if s, err := strconv.Unquote(lit.Value); err == nil {
+				name, found = s, true
+			}
+		}
+		return !found
+	})
+	return name
+}
+
+// isScope reports whether the parameter type is *state.Scope
+func isScope(expr ast.Expr) bool {
+	star, ok := expr.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := star.X.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "state" && sel.Sel.Name == "Scope"
+}
+
+// isComputed reports whether the value is built by state.NewComputed, with
+// or without method calls chained on it
+func isComputed(expr ast.Expr) bool {
+	for {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		if pkg, ok := sel.X.(*ast.Ident); ok {
+			return pkg.Name == "state" && sel.Sel.Name == "NewComputed"
+		}
+		expr = sel.X
+	}
+}
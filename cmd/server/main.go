@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
 )
 
@@ -23,6 +24,10 @@ Msg pattern
 func main() {
 	http.HandleFunc("/ws", handle.WS)
 
+	// Expire the presence of sockets dropped without a close frame
+	stop := broker.StartSweeper(handle.PresenceTimeout/3, handle.PresenceTimeout)
+	defer stop()
+
 	// Serve static files from the "static" directory
 	/*
 	project/
@@ -2,67 +2,68 @@ package business
 
 import (
 	"fmt"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc/procedures"
 )
 
-var Email string
-var Password string
-var ConfirmPassword string
-var ErrorMsg string
-var SuccessMsg string
+// The globals live in the scope of each connection, the ones changed by an
+// RPC are pushed to its client when it returns
+var Email = state.NewSignal("signup", "Email", "")
+var Password = state.NewSignal("signup", "Password", "")
+var ConfirmPassword = state.NewSignal("signup", "ConfirmPassword", "")
+var ErrorMsg = state.NewSignal("signup", "ErrorMsg", "")
+var SuccessMsg = state.NewSignal("signup", "SuccessMsg", "")
+
+// PasswordsMatch is computed on the server, pushed when it flips
+var PasswordsMatch = state.NewComputed("signup", "PasswordsMatch", func(s *state.Scope) bool {
+	return Password.Get(s) == ConfirmPassword.Get(s)
+})
+
+func init() {
+	procedures.Register("signup", "SubmitSignup", func(in *types.ClientInputInterface) *types.ClientOutput {
+		call := (*in).(*rpc.ClientInputRPC)
+		jsEmail, _ := call.Params["jsEmail"].(string)
+		jsPassword, _ := call.Params["jsPassword"].(string)
+		jsConfirmPassword, _ := call.Params["jsConfirmPassword"].(string)
+		SubmitSignup(call.WSConn.Scope(), jsEmail, jsPassword, jsConfirmPassword)
+		return nil
+	})
+}
 
-func UpdateDescription(email string, login string) string {
-	return Email
+func UpdateDescription(s *state.Scope, email string, login string) string {
+	return Email.Get(s)
 }
 
-func SubmitSignup(jsEmail string, jsPassword string, jsConfirmPassword string) {
+func SubmitSignup(s *state.Scope, jsEmail string, jsPassword string, jsConfirmPassword string) {
 	//Checa
 	//Processa
 
 	//if !validation.Email(Email) {
 	if false {
-		ErrorMsg = "Invalid email"
+		ErrorMsg.Set(s, "Invalid email")
 		return
 	}
 	fmt.Println("Before")
 	fmt.Println("Global email", jsEmail)
 	fmt.Println("Global password", jsPassword)
 	fmt.Println("Global confirmpassword", jsConfirmPassword)
-	fmt.Println("Global successMsg", SuccessMsg)
-	fmt.Println("Local email", Email)
-	fmt.Println("Local password", Password)
-	fmt.Println("Local confirmpassword", ConfirmPassword)
-	fmt.Println("Local successMsg", SuccessMsg)
+	fmt.Println("Local email", Email.Get(s))
+	fmt.Println("Local password", Password.Get(s))
+	fmt.Println("Local confirmpassword", ConfirmPassword.Get(s))
+	fmt.Println("Local successMsg", SuccessMsg.Get(s))
 
-	Email = ""
-	Password = ""
-	ConfirmPassword = ""
-	ErrorMsg = ""
-	SuccessMsg = "Check your inbox mail to confirm your account creation on the validation link sent on the message"
+	Email.Set(s, "")
+	Password.Set(s, "")
+	ConfirmPassword.Set(s, "")
+	ErrorMsg.Set(s, "")
+	SuccessMsg.Set(s, "Check your inbox mail to confirm your account creation on the validation link sent on the message")
 
 	fmt.Println("After")
-	fmt.Println("Global email", jsEmail)
-	fmt.Println("Global password", jsPassword)
-	fmt.Println("Global confirmpassword", jsConfirmPassword)
-	fmt.Println("Global successMsg", SuccessMsg)
-	fmt.Println("Local email", Email)
-	fmt.Println("Local password", Password)
-	fmt.Println("Local confirmpassword", ConfirmPassword)
-	fmt.Println("Local successMsg", SuccessMsg)
+	fmt.Println("Local email", Email.Get(s))
+	fmt.Println("Local password", Password.Get(s))
+	fmt.Println("Local confirmpassword", ConfirmPassword.Get(s))
+	fmt.Println("Local successMsg", SuccessMsg.Get(s))
 }
-
-/*
-transpilacao
-setEmail("")
-setPassword("")
-setConfirmPassword("")
-setErrorMsg("")
-setSuccessMsg("")
-
-
-set.Update(&Email,"")
-
-updateJs(
-	//verifca o dirty de cada variável para subir
-	//as funcoes set quem alteram realmente o escopo global e sujaram o dirty
-)
-*/
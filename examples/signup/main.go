@@ -1,9 +1,20 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/milton-alvarenga/goreactivehtml/examples/signup/business"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
 )
 
 func main() {
-	business.SubmitSignup("argEmail", "argPassword", "argConfirmPassword")
+	s := state.NewScope()
+	business.SubmitSignup(s, "argEmail", "argPassword", "argConfirmPassword")
+
+	// What the server pushes to the client after the RPC
+	s.Flush(diff.Bit, nil, func(msg []byte) error {
+		fmt.Printf("state push: %d bytes\n", len(msg))
+		return nil
+	})
 }
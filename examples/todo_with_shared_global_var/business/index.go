@@ -6,9 +6,13 @@ import (
 	"log"
 
 	_ "github.com/lib/pq"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
 )
 
-var Description string
+// Description is typed by each user, Tasks is the same list for everyone:
+// one user's Add is broadcast to every client viewing "$$state/todo"
+var Description = state.NewSignal("todo", "Description", "")
 
 type Task struct {
 	Id          int
@@ -16,7 +20,7 @@ type Task struct {
 	Done        bool
 }
 
-var Tasks []Task
+var Tasks = state.NewSlice("todo", "Tasks", func(t Task) any { return t.Id }).Shared()
 
 var db *sql.DB
 
@@ -46,47 +50,71 @@ func main() {
 	ConnectDB()
 }
 
-func Load(user_id int) {
+func Load(s *state.Scope, user_id int) {
 	query := "SELECT id, description, done FROM task WHERE user_id = $1"
 
 	rows, _ := db.Conn.Query(query, user_id)
 	defer rows.Close()
 
 	// Iterate through the rows
+	var tasks []Task
 	for rows.Next() {
 		var task Task
 		err := rows.Scan(&task.Id, &task.Description, &task.Done)
 		if err != nil {
 			log.Fatal(err)
 		}
-		Tasks = append(Tasks, task)
+		tasks = append(tasks, task)
 	}
 
 	// Check for errors from iterating over rows
 	if err := rows.Err(); err != nil {
 		log.Fatal(err)
 	}
+	Tasks.Set(s, tasks)
 }
 
-func Add(description string) {
+func Add(s *state.Scope, description string) {
 	var id int
 
 	query := "INSERT INTO task (description) VALUE ($1) RETURNING id"
 
 	db.Conn.QueryRow(query, description).Scan(&id)
-	Description = ""
-	Tasks = append(Tasks, Task{Id: id, Description: description, Done: false})
+	Description.Set(s, "")
+	Tasks.Append(s, Task{Id: id, Description: description, Done: false})
 }
 
-func Update(task Task) bool {
+func Update(s *state.Scope, task Task) bool {
 	query := "UPDATE task SET done = $1 WHERE id = $2"
 	_, err := db.Conn.Exec(query, task.Done, task.Id)
-	return err == nil
+	if err != nil {
+		return false
+	}
+	Tasks.Update(s, func(tasks []Task) []Task {
+		for i := range tasks {
+			if tasks[i].Id == task.Id {
+				tasks[i].Done = task.Done
+			}
+		}
+		return tasks
+	})
+	return true
 }
 
-func Delete(id int) bool {
+func Delete(s *state.Scope, id int) bool {
 	query := "DELETE FROM task WHERE id = $1"
 	_, err := db.Conn.Exec(query, id)
-
-	return err == nil
+	if err != nil {
+		return false
+	}
+	Tasks.Update(s, func(tasks []Task) []Task {
+		kept := tasks[:0]
+		for _, t := range tasks {
+			if t.Id != id {
+				kept = append(kept, t)
+			}
+		}
+		return kept
+	})
+	return true
 }
@@ -2,4 +2,14 @@ module github.com/milton-alvarenga/goreactivehtml
 
 go 1.24.5
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/leanovate/gopter v0.2.11
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require (
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+)
@@ -0,0 +1,26 @@
+package broker
+
+import (
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+// DeliverFunc receives the messages published by other nodes, the payload
+// is the JSON of the published value
+type DeliverFunc func(topic topics.Topic, payload []byte)
+
+// Backend moves published messages between server processes.
+//
+// The broker always fans out to its own connections, so a backend only
+// has to deliver messages published by other nodes. Interest is declared
+// per node, not per connection: Listen is called when the first local
+// connection subscribes to a topic pattern and Unlisten when the last one
+// leaves, so every message crosses the network once per interested node.
+type Backend interface {
+	// Start registers the function receiving remote messages
+	Start(deliver DeliverFunc) error
+	// Publish sends the message to the other nodes
+	Publish(topic topics.Topic, payload []byte) error
+	Listen(topic topics.Topic) error
+	Unlisten(topic topics.Topic) error
+	Close() error
+}
@@ -0,0 +1,364 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+// Broker keeps track of which connections are subscribed to which topics
+// and fans out published messages to them.
+//
+// Subscriptions may use the wildcards accepted by topics.Trie, so a
+// connection subscribed to "chat/room/+" receives what is published to
+// "chat/room/42". Published frames are regular ClientOutput messages with
+// ReqId 0, the value reserved for subscribed events on the client side, and
+// Destination set to the published topic.
+//
+// Without a Backend the broker only reaches connections of this process.
+type Broker struct {
+	mu          sync.RWMutex
+	filters     *topics.Trie[map[*types.WebSocketConnection]bool]
+	connections map[*types.WebSocketConnection]map[topics.Topic]bool
+	backend     Backend
+	retention   *retention
+	presence    *presence
+
+	// listenMu serializes the backend calls, listening holds the topics
+	// the backend was asked for. Both are kept apart from mu so network
+	// I/O never blocks publishing.
+	listenMu  sync.Mutex
+	listening map[topics.Topic]bool
+}
+
+func New() *Broker {
+	return &Broker{
+		filters:     topics.NewTrie[map[*types.WebSocketConnection]bool](),
+		connections: make(map[*types.WebSocketConnection]map[topics.Topic]bool),
+		retention:   newRetention(),
+		presence:    newPresence(),
+		listening:   make(map[topics.Topic]bool),
+	}
+}
+
+// NewWithBackend returns a broker sharing its publications with the other
+// nodes attached to the backend.
+func NewWithBackend(backend Backend) (*Broker, error) {
+	b := New()
+	b.backend = backend
+
+	err := backend.Start(func(topic topics.Topic, payload []byte) {
+		data, err := codec.ParseJSON(payload)
+		if err != nil {
+			log.Println("Error decoding remote message:", err)
+			return
+		}
+		if _, err := b.deliver(topic, data); err != nil {
+			log.Println("Error delivering remote message:", err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Subscribe adds the connection to the topic broadcast list.
+// It returns false when the connection was already subscribed.
+func (b *Broker) Subscribe(wsc *types.WebSocketConnection, topic topics.Topic) (bool, error) {
+	b.mu.Lock()
+	subscribers, ok := b.filters.Get(topic)
+	if subscribers[wsc] {
+		b.mu.Unlock()
+		return false, nil
+	}
+
+	if !ok {
+		subscribers = make(map[*types.WebSocketConnection]bool)
+		if err := b.filters.Set(topic, subscribers); err != nil {
+			b.mu.Unlock()
+			return false, err
+		}
+	}
+	subscribers[wsc] = true
+
+	if b.connections[wsc] == nil {
+		b.connections[wsc] = make(map[topics.Topic]bool)
+	}
+	b.connections[wsc][topic] = true
+	b.mu.Unlock()
+
+	if err := b.listen(topic); err != nil {
+		b.mu.Lock()
+		b.unsubscribe(wsc, topic)
+		b.mu.Unlock()
+		b.listen(topic)
+		return false, err
+	}
+	return true, nil
+}
+
+// Unsubscribe removes the connection from the topic broadcast list.
+// It returns false when the connection was not subscribed.
+func (b *Broker) Unsubscribe(wsc *types.WebSocketConnection, topic topics.Topic) bool {
+	b.mu.Lock()
+	ok := b.unsubscribe(wsc, topic)
+	b.mu.Unlock()
+
+	if ok {
+		b.listen(topic)
+	}
+	b.Leave(wsc, topic)
+	return ok
+}
+
+func (b *Broker) unsubscribe(wsc *types.WebSocketConnection, topic topics.Topic) bool {
+	subscribers, _ := b.filters.Get(topic)
+	if !subscribers[wsc] {
+		return false
+	}
+
+	delete(subscribers, wsc)
+	if len(subscribers) == 0 {
+		b.filters.Delete(topic)
+	}
+
+	delete(b.connections[wsc], topic)
+	if len(b.connections[wsc]) == 0 {
+		delete(b.connections, wsc)
+	}
+
+	return true
+}
+
+// RemoveConnection drops every subscription held by the connection.
+// Must be called when the websocket is closed.
+func (b *Broker) RemoveConnection(wsc *types.WebSocketConnection) {
+	b.mu.Lock()
+	var left []topics.Topic
+	for topic := range b.connections[wsc] {
+		b.unsubscribe(wsc, topic)
+		left = append(left, topic)
+	}
+	b.mu.Unlock()
+
+	for _, topic := range left {
+		b.listen(topic)
+	}
+	b.leaveAll(wsc)
+}
+
+// listen brings the backend interest in the topic in line with the local
+// subscriptions. It runs outside mu and reads the current state itself,
+// so concurrent subscribes and unsubscribes converge in any order.
+func (b *Broker) listen(topic topics.Topic) error {
+	if b.backend == nil {
+		return nil
+	}
+
+	b.listenMu.Lock()
+	defer b.listenMu.Unlock()
+
+	b.mu.RLock()
+	_, wanted := b.filters.Get(topic)
+	b.mu.RUnlock()
+
+	if wanted == b.listening[topic] {
+		return nil
+	}
+	if wanted {
+		if err := b.backend.Listen(topic); err != nil {
+			return err
+		}
+		b.listening[topic] = true
+		return nil
+	}
+
+	delete(b.listening, topic)
+	if err := b.backend.Unlisten(topic); err != nil {
+		log.Println("Error leaving topic on backend:", err)
+	}
+	return nil
+}
+
+// Subscribers returns a snapshot of the connections that receive what is
+// published to the topic, each connection listed once.
+func (b *Broker) Subscribers(topic topics.Topic) []*types.WebSocketConnection {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var list []*types.WebSocketConnection
+	seen := make(map[*types.WebSocketConnection]bool)
+	for _, match := range b.filters.Match(topic) {
+		for wsc := range match.Value {
+			if !seen[wsc] {
+				seen[wsc] = true
+				list = append(list, wsc)
+			}
+		}
+	}
+	return list
+}
+
+// Topics returns a snapshot of the topics the connection is subscribed to.
+func (b *Broker) Topics(wsc *types.WebSocketConnection) []topics.Topic {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	list := make([]topics.Topic, 0, len(b.connections[wsc]))
+	for topic := range b.connections[wsc] {
+		list = append(list, topic)
+	}
+	return list
+}
+
+// Publish sends the data to every subscriber of the topic and returns how
+// many local connections received it. The data is encoded with the codec
+// of each connection. Connections that fail to receive the message are
+// unsubscribed from every topic. With a Backend the message is also handed
+// over to the other nodes, as JSON.
+func (b *Broker) Publish(topic topics.Topic, data any) (int, error) {
+	if topics.IsPattern(topic) {
+		return 0, fmt.Errorf("cannot publish to pattern %q", topic)
+	}
+
+	var payload []byte
+	if b.backend != nil {
+		var err error
+		if payload, err = json.Marshal(data); err != nil {
+			return 0, err
+		}
+	}
+
+	delivered, err := b.deliver(topic, data)
+	if err != nil {
+		return 0, err
+	}
+
+	if b.backend != nil {
+		if err := b.backend.Publish(topic, payload); err != nil {
+			return delivered, err
+		}
+	}
+
+	return delivered, nil
+}
+
+// Close releases the backend
+func (b *Broker) Close() error {
+	if b.backend == nil {
+		return nil
+	}
+	return b.backend.Close()
+}
+
+// deliver fans out the data to the connections of this node
+func (b *Broker) deliver(topic topics.Topic, data any) (int, error) {
+	output := types.ClientOutput{
+		ReqId:       0,
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: string(topic),
+		Data:        data,
+	}
+
+	if seq := b.retention.store(topic, data); seq > 0 {
+		output.Header = map[string]string{HeaderSeq: strconv.FormatUint(seq, 10)}
+	}
+
+	return b.send(b.Subscribers(topic), output)
+}
+
+// send marshals the output once per format of the subscribers
+func (b *Broker) send(subscribers []*types.WebSocketConnection, output types.ClientOutput) (int, error) {
+	type marshaled struct {
+		messageType int
+		data        []byte
+	}
+	messages := make(map[string]marshaled)
+	delivered := 0
+	for _, wsc := range subscribers {
+		message, ok := messages[wsc.Format()]
+		if !ok {
+			var err error
+			if message.messageType, message.data, err = wsc.MarshalOutput(output); err != nil {
+				return delivered, err
+			}
+			messages[wsc.Format()] = message
+		}
+		if err := wsc.Write(message.messageType, message.data); err != nil {
+			log.Println("Error publishing message, dropping subscriber:", err)
+			b.RemoveConnection(wsc)
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  DEFAULT BROKER
+// ─────────────────────────────────────────────────────────────
+//
+
+// Default is the broker used by the websocket handler.
+var Default = New()
+
+func Subscribe(wsc *types.WebSocketConnection, topic topics.Topic) (bool, error) {
+	return Default.Subscribe(wsc, topic)
+}
+
+func Unsubscribe(wsc *types.WebSocketConnection, topic topics.Topic) bool {
+	return Default.Unsubscribe(wsc, topic)
+}
+
+func RemoveConnection(wsc *types.WebSocketConnection) {
+	Default.RemoveConnection(wsc)
+}
+
+func Publish(topic topics.Topic, data any) (int, error) {
+	return Default.Publish(topic, data)
+}
+
+func Retain(pattern topics.Topic, policy Retention) error {
+	return Default.Retain(pattern, policy)
+}
+
+func Replay(wsc *types.WebSocketConnection, filter topics.Topic, since uint64) (int, error) {
+	return Default.Replay(wsc, filter, since)
+}
+
+func TrackPresence(pattern topics.Topic) error {
+	return Default.TrackPresence(pattern)
+}
+
+func HasPresence(topic topics.Topic) bool {
+	return Default.HasPresence(topic)
+}
+
+func Join(wsc *types.WebSocketConnection, topic topics.Topic, member Member) bool {
+	return Default.Join(wsc, topic, member)
+}
+
+func Members(topic topics.Topic) []Member {
+	return Default.Members(topic)
+}
+
+func SendPresence(wsc *types.WebSocketConnection, topic topics.Topic) error {
+	return Default.SendPresence(wsc, topic)
+}
+
+func Touch(wsc *types.WebSocketConnection) {
+	Default.Touch(wsc)
+}
+
+func StartSweeper(interval, timeout time.Duration) (stop func()) {
+	return Default.StartSweeper(interval, timeout)
+}
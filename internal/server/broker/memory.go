@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+// MemoryHub connects the backends of brokers living in the same process.
+// Useful for a single server and to simulate several nodes in tests.
+type MemoryHub struct {
+	mu    sync.RWMutex
+	nodes map[*MemoryBackend]bool
+}
+
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{
+		nodes: make(map[*MemoryBackend]bool),
+	}
+}
+
+// Backend creates a new node attached to the hub
+func (h *MemoryHub) Backend() *MemoryBackend {
+	m := &MemoryBackend{
+		hub:     h,
+		filters: topics.NewTrie[bool](),
+	}
+
+	h.mu.Lock()
+	h.nodes[m] = true
+	h.mu.Unlock()
+
+	return m
+}
+
+func (h *MemoryHub) publish(from *MemoryBackend, topic topics.Topic, payload []byte) {
+	h.mu.RLock()
+	nodes := make([]*MemoryBackend, 0, len(h.nodes))
+	for node := range h.nodes {
+		if node != from {
+			nodes = append(nodes, node)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, node := range nodes {
+		node.receive(topic, payload)
+	}
+}
+
+// MemoryBackend is a Backend delivering through a MemoryHub
+type MemoryBackend struct {
+	hub     *MemoryHub
+	mu      sync.RWMutex
+	deliver DeliverFunc
+	filters *topics.Trie[bool]
+}
+
+// NewMemoryBackend returns a backend on its own hub, the single node setup
+func NewMemoryBackend() *MemoryBackend {
+	return NewMemoryHub().Backend()
+}
+
+func (m *MemoryBackend) Start(deliver DeliverFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deliver = deliver
+	return nil
+}
+
+func (m *MemoryBackend) Publish(topic topics.Topic, payload []byte) error {
+	m.hub.publish(m, topic, payload)
+	return nil
+}
+
+func (m *MemoryBackend) Listen(topic topics.Topic) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filters.Set(topic, true)
+}
+
+func (m *MemoryBackend) Unlisten(topic topics.Topic) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.filters.Delete(topic)
+	return nil
+}
+
+func (m *MemoryBackend) Close() error {
+	m.hub.mu.Lock()
+	delete(m.hub.nodes, m)
+	m.hub.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryBackend) receive(topic topics.Topic, payload []byte) {
+	m.mu.RLock()
+	deliver := m.deliver
+	interested := len(m.filters.Match(topic)) > 0
+	m.mu.RUnlock()
+
+	if deliver != nil && interested {
+		deliver(topic, payload)
+	}
+}
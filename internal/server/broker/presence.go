@@ -0,0 +1,281 @@
+package broker
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+//
+// Presence diffs of a topic are published to PresenceTopic(topic):
+//
+//   {"j":[{"id":"ana","m":{"name":"Ana"}}],"l":["bob"]}
+//
+//   j: members that joined, with their metadata
+//   l: ids of the members that left
+//
+// A member connected from several tabs joins on the first connection and
+// leaves on the last one. Members are tracked per node.
+//
+
+const PresencePrefix = "$$/presence/"
+
+// Every "member.<key>" SUBSCRIBE header goes to the metadata of the member
+// joining the topic. The id is never taken from the client, see MemberId.
+const HeaderMemberMeta = "member."
+
+type Member struct {
+	Id   string            `json:"id"`
+	Meta map[string]string `json:"m,omitempty"`
+}
+
+type PresenceDiff struct {
+	Joins  []Member `json:"j,omitempty"`
+	Leaves []string `json:"l,omitempty"`
+}
+
+type presence struct {
+	mu       sync.Mutex
+	tracked  *topics.Trie[bool]
+	members  map[topics.Topic]map[*types.WebSocketConnection]Member
+	lastSeen map[*types.WebSocketConnection]time.Time
+}
+
+func newPresence() *presence {
+	return &presence{
+		tracked:  topics.NewTrie[bool](),
+		members:  make(map[topics.Topic]map[*types.WebSocketConnection]Member),
+		lastSeen: make(map[*types.WebSocketConnection]time.Time),
+	}
+}
+
+// PresenceTopic is where the presence diffs of the topic are published
+func PresenceTopic(topic topics.Topic) topics.Topic {
+	return PresencePrefix + topic
+}
+
+// IsPresenceTopic reports whether the topic carries presence diffs
+func IsPresenceTopic(topic topics.Topic) bool {
+	return strings.HasPrefix(string(topic), PresencePrefix)
+}
+
+// MemberId identifies the member behind the connection: the authenticated
+// user, or the connection address for anonymous connections
+func MemberId(wsc *types.WebSocketConnection) string {
+	if wsc.Id != "" {
+		return wsc.Id
+	}
+	return wsc.Conn.RemoteAddr().String()
+}
+
+// MemberFromHeader builds the member with the given id and the metadata
+// of the SUBSCRIBE headers
+func MemberFromHeader(header map[string]string, id string) Member {
+	m := Member{Id: id}
+	for k, v := range header {
+		if strings.HasPrefix(k, HeaderMemberMeta) {
+			if m.Meta == nil {
+				m.Meta = make(map[string]string)
+			}
+			m.Meta[strings.TrimPrefix(k, HeaderMemberMeta)] = v
+		}
+	}
+	return m
+}
+
+// TrackPresence enables presence on every topic matching the pattern
+func (b *Broker) TrackPresence(pattern topics.Topic) error {
+	b.presence.mu.Lock()
+	defer b.presence.mu.Unlock()
+
+	return b.presence.tracked.Set(pattern, true)
+}
+
+// HasPresence reports whether presence is tracked on the topic
+func (b *Broker) HasPresence(topic topics.Topic) bool {
+	b.presence.mu.Lock()
+	defer b.presence.mu.Unlock()
+
+	_, ok := b.presence.tracked.Lookup(topic)
+	return ok
+}
+
+// Join adds the member to the topic and publishes the diff when it is the
+// first connection of that member.
+func (b *Broker) Join(wsc *types.WebSocketConnection, topic topics.Topic, member Member) bool {
+	p := b.presence
+	p.mu.Lock()
+
+	if _, ok := p.tracked.Lookup(topic); !ok {
+		p.mu.Unlock()
+		return false
+	}
+
+	if p.members[topic] == nil {
+		p.members[topic] = make(map[*types.WebSocketConnection]Member)
+	}
+	first := p.count(topic, member.Id) == 0
+	p.members[topic][wsc] = member
+	p.lastSeen[wsc] = time.Now()
+	p.mu.Unlock()
+
+	if first {
+		b.publishPresence(topic, PresenceDiff{Joins: []Member{member}})
+	}
+	return first
+}
+
+// Leave removes the connection from the topic and publishes the diff when
+// it was the last connection of that member.
+func (b *Broker) Leave(wsc *types.WebSocketConnection, topic topics.Topic) bool {
+	p := b.presence
+	p.mu.Lock()
+	last, id := p.leave(wsc, topic)
+	p.mu.Unlock()
+
+	if last {
+		b.publishPresence(topic, PresenceDiff{Leaves: []string{id}})
+	}
+	return last
+}
+
+// Members lists the members currently on the topic, ordered by id
+func (b *Broker) Members(topic topics.Topic) []Member {
+	p := b.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	seen := make(map[string]bool)
+	var list []Member
+	for _, m := range p.members[topic] {
+		if !seen[m.Id] {
+			seen[m.Id] = true
+			list = append(list, m)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
+
+// SendPresence sends the current members of the topic to the connection,
+// as a diff joining all of them.
+func (b *Broker) SendPresence(wsc *types.WebSocketConnection, topic topics.Topic) error {
+	output := types.ClientOutput{
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: string(PresenceTopic(topic)),
+		Data:        PresenceDiff{Joins: b.Members(topic)},
+	}
+	_, err := b.send([]*types.WebSocketConnection{wsc}, output)
+	return err
+}
+
+// Touch records activity of the connection, like a pong or a message
+func (b *Broker) Touch(wsc *types.WebSocketConnection) {
+	p := b.presence
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.lastSeen[wsc]; ok {
+		p.lastSeen[wsc] = time.Now()
+	}
+}
+
+// ExpireIdle closes the connections with presence not seen for longer than
+// timeout, the sockets dropped without a close frame. It returns how many
+// connections were expired.
+func (b *Broker) ExpireIdle(timeout time.Duration) int {
+	p := b.presence
+	now := time.Now()
+
+	p.mu.Lock()
+	var idle []*types.WebSocketConnection
+	for wsc, seen := range p.lastSeen {
+		if now.Sub(seen) > timeout {
+			idle = append(idle, wsc)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, wsc := range idle {
+		b.RemoveConnection(wsc)
+		if wsc.Conn != nil {
+			wsc.Conn.Close()
+		}
+	}
+	return len(idle)
+}
+
+// StartSweeper calls ExpireIdle every interval until stop is called
+func (b *Broker) StartSweeper(interval, timeout time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if n := b.ExpireIdle(timeout); n > 0 {
+					log.Println("Expired idle connections:", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// leaveAll removes the connection from every topic, used on disconnect
+func (b *Broker) leaveAll(wsc *types.WebSocketConnection) {
+	p := b.presence
+	p.mu.Lock()
+	diffs := make(map[topics.Topic]string)
+	for topic := range p.members {
+		if last, id := p.leave(wsc, topic); last {
+			diffs[topic] = id
+		}
+	}
+	delete(p.lastSeen, wsc)
+	p.mu.Unlock()
+
+	for topic, id := range diffs {
+		b.publishPresence(topic, PresenceDiff{Leaves: []string{id}})
+	}
+}
+
+func (p *presence) leave(wsc *types.WebSocketConnection, topic topics.Topic) (bool, string) {
+	member, ok := p.members[topic][wsc]
+	if !ok {
+		return false, ""
+	}
+
+	delete(p.members[topic], wsc)
+	if len(p.members[topic]) == 0 {
+		delete(p.members, topic)
+	}
+	return p.count(topic, member.Id) == 0, member.Id
+}
+
+// count returns how many connections the member has on the topic
+func (p *presence) count(topic topics.Topic, id string) int {
+	n := 0
+	for _, m := range p.members[topic] {
+		if m.Id == id {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *Broker) publishPresence(topic topics.Topic, diff PresenceDiff) {
+	if _, err := b.Publish(PresenceTopic(topic), diff); err != nil {
+		log.Println("Error publishing presence diff:", err)
+	}
+}
@@ -0,0 +1,397 @@
+package redis
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+//
+// Every topic is mapped to the channel Prefix+topic. Concrete topics use
+// SUBSCRIBE, patterns use PSUBSCRIBE with a glob that may match more than
+// the pattern (the broker filters again when fanning out locally).
+//
+// Message envelope:
+//
+//   ┌──────────────┬──────────────┬─────────┐
+//   │ node         │ sequence     │ payload │
+//   │ 16 hex chars │ 16 hex chars │         │
+//   └──────────────┴──────────────┴─────────┘
+//
+// The node skips its own messages and the consecutive copies Redis sends
+// when a message matches more than one of its subscriptions.
+//
+// A broken connection is dialed again with an exponential backoff. The
+// subscribing one sends every channel and pattern again once it is back,
+// messages published in between are lost as usual with Redis pub/sub.
+//
+
+const (
+	DefaultPrefix = "goreactivehtml:"
+
+	nodeLen = 16
+	idLen   = nodeLen + 16
+
+	minBackoff = 100 * time.Millisecond
+)
+
+var ErrUnavailable = errors.New("redis: connection unavailable")
+
+// Backend is a broker.Backend on top of Redis pub/sub
+type Backend struct {
+	Prefix string
+	// MaxBackoff bounds the wait between two reconnection attempts
+	MaxBackoff time.Duration
+
+	addr string
+	node string
+	seq  uint64
+	done chan struct{}
+
+	pubMu      sync.Mutex
+	pub        net.Conn
+	pubR       *bufio.Reader
+	pubW       *bufio.Writer
+	pubBackoff time.Duration
+	pubRetry   time.Time
+
+	subMu     sync.Mutex
+	sub       net.Conn
+	subW      *bufio.Writer
+	listeners map[string]int
+
+	deliver broker.DeliverFunc
+	lastId  string
+}
+
+var _ broker.Backend = (*Backend)(nil)
+
+// Dial opens the publishing and the subscribing connections to Redis
+func Dial(addr string) (*Backend, error) {
+	node := make([]byte, nodeLen/2)
+	if _, err := rand.Read(node); err != nil {
+		return nil, err
+	}
+
+	pub, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := net.Dial("tcp", addr)
+	if err != nil {
+		pub.Close()
+		return nil, err
+	}
+
+	return &Backend{
+		Prefix:     DefaultPrefix,
+		MaxBackoff: 5 * time.Second,
+		addr:       addr,
+		node:       hex.EncodeToString(node),
+		done:       make(chan struct{}),
+		pub:        pub,
+		pubR:       bufio.NewReader(pub),
+		pubW:       bufio.NewWriter(pub),
+		sub:        sub,
+		subW:       bufio.NewWriter(sub),
+		listeners:  make(map[string]int),
+	}, nil
+}
+
+func (r *Backend) Start(deliver broker.DeliverFunc) error {
+	r.deliver = deliver
+	go r.read(bufio.NewReader(r.sub))
+	return nil
+}
+
+// Publish retries once on a new connection when the current one is broken
+func (r *Backend) Publish(topic topics.Topic, payload []byte) error {
+	r.pubMu.Lock()
+	defer r.pubMu.Unlock()
+
+	r.seq++
+	message := fmt.Sprintf("%s%016x%s", r.node, r.seq, payload)
+
+	reused := r.pub != nil
+	err := r.publish(r.Prefix+string(topic), message)
+	if err != nil && reused && r.pub == nil {
+		err = r.publish(r.Prefix+string(topic), message)
+	}
+	return err
+}
+
+// publish sends the message on the publishing connection, dialing it when
+// needed. A network error drops the connection.
+func (r *Backend) publish(channel, message string) error {
+	if r.pub == nil {
+		if err := r.dialPub(); err != nil {
+			return err
+		}
+	}
+
+	if err := writeCommand(r.pubW, "PUBLISH", channel, message); err != nil {
+		r.dropPub()
+		return err
+	}
+
+	reply, err := readValue(r.pubR)
+	if err != nil {
+		r.dropPub()
+		return err
+	}
+	if err, ok := reply.(error); ok {
+		return err
+	}
+	return nil
+}
+
+func (r *Backend) dialPub() error {
+	if r.closed() {
+		return net.ErrClosed
+	}
+	if time.Now().Before(r.pubRetry) {
+		return ErrUnavailable
+	}
+
+	pub, err := net.Dial("tcp", r.addr)
+	if err != nil {
+		r.pubBackoff = r.nextBackoff(r.pubBackoff)
+		r.pubRetry = time.Now().Add(r.pubBackoff)
+		return err
+	}
+
+	r.pub = pub
+	r.pubR = bufio.NewReader(pub)
+	r.pubW = bufio.NewWriter(pub)
+	r.pubBackoff = 0
+	return nil
+}
+
+func (r *Backend) dropPub() {
+	r.pub.Close()
+	r.pub = nil
+}
+
+func (r *Backend) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < minBackoff {
+		backoff = minBackoff
+	}
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	return backoff
+}
+
+func (r *Backend) Listen(topic topics.Topic) error {
+	command, channel := r.channel(topic)
+
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	key := command + " " + channel
+	r.listeners[key]++
+	if r.listeners[key] > 1 {
+		return nil
+	}
+	if err := writeCommand(r.subW, command, channel); err != nil {
+		// sent again by resubscribe once the connection is back
+		log.Println("Error subscribing on redis:", err)
+	}
+	return nil
+}
+
+func (r *Backend) Unlisten(topic topics.Topic) error {
+	command, channel := r.channel(topic)
+
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	key := command + " " + channel
+	if r.listeners[key] == 0 {
+		return nil
+	}
+	r.listeners[key]--
+	if r.listeners[key] > 0 {
+		return nil
+	}
+	delete(r.listeners, key)
+	if err := writeCommand(r.subW, unsubscribeCommand(command), channel); err != nil {
+		// a new connection starts without the subscription anyway
+		log.Println("Error unsubscribing on redis:", err)
+	}
+	return nil
+}
+
+func (r *Backend) Close() error {
+	close(r.done)
+
+	var errPub error
+	r.pubMu.Lock()
+	if r.pub != nil {
+		errPub = r.pub.Close()
+	}
+	r.pubMu.Unlock()
+
+	r.subMu.Lock()
+	errSub := r.sub.Close()
+	r.subMu.Unlock()
+
+	if errPub != nil {
+		return errPub
+	}
+	return errSub
+}
+
+// channel returns the subscribe command and the channel (or glob) to use
+func (r *Backend) channel(topic topics.Topic) (string, string) {
+	if !topics.IsPattern(topic) {
+		return "SUBSCRIBE", r.Prefix + string(topic)
+	}
+
+	segments := topics.Split(topic)
+	globs := make([]string, len(segments))
+	for i, s := range segments {
+		switch {
+		case s == topics.MultiWildcard:
+			// "a/#" also matches "a"
+			glob := strings.Join(globs[:i], topics.Separator)
+			return "PSUBSCRIBE", globEscape(r.Prefix) + glob + "*"
+		case s == topics.SingleWildcard || topics.IsPattern(topics.Topic(s)):
+			globs[i] = "*"
+		default:
+			globs[i] = globEscape(s)
+		}
+	}
+	return "PSUBSCRIBE", globEscape(r.Prefix) + strings.Join(globs, topics.Separator)
+}
+
+func unsubscribeCommand(command string) string {
+	if command == "PSUBSCRIBE" {
+		return "PUNSUBSCRIBE"
+	}
+	return "UNSUBSCRIBE"
+}
+
+// read delivers the messages received on the subscribing connection
+// until the backend is closed
+func (r *Backend) read(reader *bufio.Reader) {
+	for {
+		value, err := readValue(reader)
+		if err != nil {
+			if r.closed() {
+				return
+			}
+			log.Println("Error reading from redis:", err)
+
+			if reader = r.resubscribe(); reader == nil {
+				return
+			}
+			continue
+		}
+
+		reply, ok := value.([]interface{})
+		if !ok || len(reply) < 3 {
+			continue
+		}
+
+		var channel, message string
+		switch reply[0] {
+		case "message":
+			channel, _ = reply[1].(string)
+			message, _ = reply[2].(string)
+		case "pmessage":
+			if len(reply) < 4 {
+				continue
+			}
+			channel, _ = reply[2].(string)
+			message, _ = reply[3].(string)
+		default:
+			// subscribe and unsubscribe confirmations
+			continue
+		}
+
+		if len(message) < idLen || message[:nodeLen] == r.node || message[:idLen] == r.lastId {
+			continue
+		}
+		r.lastId = message[:idLen]
+
+		r.deliver(topics.Topic(strings.TrimPrefix(channel, r.Prefix)), []byte(message[idLen:]))
+	}
+}
+
+// resubscribe dials the subscribing connection again, waiting longer after
+// each failure, and sends every listened channel and pattern on it.
+// It returns nil once the backend is closed.
+func (r *Backend) resubscribe() *bufio.Reader {
+	var backoff time.Duration
+	for {
+		backoff = r.nextBackoff(backoff)
+		select {
+		case <-r.done:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		sub, err := net.Dial("tcp", r.addr)
+		if err != nil {
+			log.Println("Error reconnecting to redis:", err)
+			continue
+		}
+
+		r.subMu.Lock()
+		if r.closed() {
+			r.subMu.Unlock()
+			sub.Close()
+			return nil
+		}
+		r.sub.Close()
+		r.sub = sub
+		r.subW = bufio.NewWriter(sub)
+
+		for key := range r.listeners {
+			command, channel, _ := strings.Cut(key, " ")
+			if err = writeCommand(r.subW, command, channel); err != nil {
+				break
+			}
+		}
+		r.subMu.Unlock()
+
+		if err != nil {
+			log.Println("Error resubscribing on redis:", err)
+			continue
+		}
+		return bufio.NewReader(sub)
+	}
+}
+
+func (r *Backend) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func globEscape(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+// Header keys set on published frames of retained topics
+const (
+	HeaderSeq    = "seq"    // sequence number of the message in its topic
+	HeaderReplay = "replay" // "true" when the message comes from the cache
+	HeaderSince  = "since"  // SUBSCRIBE header: replay only seq greater than it
+)
+
+// Retention is how many messages are kept per topic and for how long.
+// Last 1 is the last value cache.
+type Retention struct {
+	Last int
+	TTL  time.Duration // zero keeps the messages until they are replaced
+}
+
+// Retained is a published message kept for replay
+type Retained struct {
+	Seq  uint64
+	Data any
+	At   time.Time
+}
+
+type history struct {
+	seq      uint64
+	messages []Retained
+}
+
+// retention keeps the messages of the topics with a Retention policy.
+// Sequence numbers are assigned by the node storing the message, so they
+// are only meaningful when reconnecting to the same node.
+type retention struct {
+	mu        sync.Mutex
+	policies  *topics.Trie[Retention]
+	histories map[topics.Topic]*history
+}
+
+func newRetention() *retention {
+	return &retention{
+		policies:  topics.NewTrie[Retention](),
+		histories: make(map[topics.Topic]*history),
+	}
+}
+
+// Retain sets the retention of every topic matching the pattern
+func (b *Broker) Retain(pattern topics.Topic, policy Retention) error {
+	b.retention.mu.Lock()
+	defer b.retention.mu.Unlock()
+
+	return b.retention.policies.Set(pattern, policy)
+}
+
+// Retained returns the messages kept for the topic
+func (b *Broker) Retained(topic topics.Topic) []Retained {
+	r := b.retention
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	h, ok := r.histories[topic]
+	if !ok {
+		return nil
+	}
+	r.expire(topic, h, time.Now())
+	return append([]Retained(nil), h.messages...)
+}
+
+// Replay sends to the connection the retained messages of every topic
+// matching the filter with sequence number greater than since.
+func (b *Broker) Replay(wsc *types.WebSocketConnection, filter topics.Topic, since uint64) (int, error) {
+	r := b.retention
+	now := time.Now()
+
+	r.mu.Lock()
+	var names []topics.Topic
+	for topic := range r.histories {
+		if topics.Matches(filter, topic) {
+			names = append(names, topic)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	var outputs []types.ClientOutput
+	for _, topic := range names {
+		h := r.histories[topic]
+		r.expire(topic, h, now)
+		for _, m := range h.messages {
+			if m.Seq <= since {
+				continue
+			}
+			outputs = append(outputs, types.ClientOutput{
+				MsgType:     types.WSTypeSuccessOutputMessage,
+				Destination: string(topic),
+				Data:        m.Data,
+				Header: map[string]string{
+					HeaderSeq:    strconv.FormatUint(m.Seq, 10),
+					HeaderReplay: "true",
+				},
+			})
+		}
+	}
+	r.mu.Unlock()
+
+	sent := 0
+	for _, output := range outputs {
+		n, err := b.send([]*types.WebSocketConnection{wsc}, output)
+		if err != nil {
+			return sent, err
+		}
+		if n == 0 {
+			break
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// store keeps the message when the topic has a retention policy and
+// returns its sequence number, zero when it is not retained.
+func (r *retention) store(topic topics.Topic, data any) uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match, ok := r.policies.Lookup(topic)
+	if !ok || match.Value.Last <= 0 {
+		return 0
+	}
+	policy := match.Value
+
+	h, ok := r.histories[topic]
+	if !ok {
+		h = &history{}
+		r.histories[topic] = h
+	}
+
+	h.seq++
+	now := time.Now()
+	h.messages = append(h.messages, Retained{Seq: h.seq, Data: data, At: now})
+	if len(h.messages) > policy.Last {
+		h.messages = append(h.messages[:0], h.messages[len(h.messages)-policy.Last:]...)
+	}
+	r.expire(topic, h, now)
+
+	return h.seq
+}
+
+// expire drops the messages older than the TTL. The history itself is
+// kept so the sequence keeps growing.
+func (r *retention) expire(topic topics.Topic, h *history, now time.Time) {
+	match, ok := r.policies.Lookup(topic)
+	if !ok || match.Value.TTL == 0 {
+		return
+	}
+
+	i := 0
+	for i < len(h.messages) && now.Sub(h.messages[i].At) > match.Value.TTL {
+		i++
+	}
+	if i > 0 {
+		h.messages = append(h.messages[:0], h.messages[i:]...)
+	}
+}
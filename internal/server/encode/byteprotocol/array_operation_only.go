@@ -1,9 +1,10 @@
 package byteprotocol
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
 )
 
 //
@@ -16,13 +17,24 @@ import (
 //   OpDelete (0)
 //   OpUpdate (1)
 //   OpInsert (3)
+//   OpMove   (2)
+//
+// OpMove has no payload: after the position (or range) comes the target
+// position, with the same posSize. The elements are removed first and
+// inserted at the target position of the shortened array.
 //
 // Flags (byte 1):
 //   bit 0: bulk operation
 //   bit 1: partial update
+//   bit 2: compressed, only on a bulk INSERT: after start and end the
+//          lengths and payloads are raw DEFLATE up to the end of the
+//          message, see package compress
 //
-// posSize (byte 2): size of position data in bytes (1, 2, or 3)
-// dataSize (byte 3): size of data length in bytes (1, 2, or 3)
+// posSize (byte 2): size of position data in bytes (1, 2, 3 or 4)
+// dataSize (byte 3): size of data length in bytes (1, 2, 3 or 4)
+//
+// Size 4 is only used above 0xFFFFFF, where clients without it never had
+// a message to read.
 //
 
 type OperationType byte
@@ -31,11 +43,13 @@ const (
 	OpDelete OperationType = 0b00
 	OpUpdate OperationType = 0b01
 	OpInsert OperationType = 0b11
+	OpMove   OperationType = 0b10
 )
 
 const (
-	FlagBulk    byte = 1 << 0
-	FlagPartial byte = 1 << 1
+	FlagBulk       byte = 1 << 0
+	FlagPartial    byte = 1 << 1
+	FlagCompressed byte = 1 << 2
 )
 
 type Encoder struct{}
@@ -46,41 +60,6 @@ type Encoder struct{}
 // ─────────────────────────────────────────────────────────────
 //
 
-// v encoded using sizeIndicator bytes: 0–3 bytes
-func encodeIntWithSize(v uint32, sizeIndicator uint8) ([]byte, error) {
-	switch sizeIndicator {
-	case 0:
-		return []byte{}, nil
-
-	case 1:
-		if v > 0xFF {
-			return nil, fmt.Errorf("value %d too large for 1 byte", v)
-		}
-		return []byte{byte(v)}, nil
-
-	case 2:
-		if v > 0xFFFF {
-			return nil, fmt.Errorf("value %d too large for 2 bytes", v)
-		}
-		out := make([]byte, 2)
-		binary.BigEndian.PutUint16(out, uint16(v))
-		return out, nil
-
-	case 3:
-		if v > 0xFFFFFF {
-			return nil, fmt.Errorf("value %d too large for 3 bytes", v)
-		}
-		return []byte{
-			byte((v >> 16) & 0xFF),
-			byte((v >> 8) & 0xFF),
-			byte(v & 0xFF),
-		}, nil
-
-	default:
-		return nil, fmt.Errorf("invalid size indicator %d", sizeIndicator)
-	}
-}
-
 // Minimal byte size for an integer
 func autoSizeIndicator(v uint32) uint8 {
 	switch {
@@ -88,8 +67,10 @@ func autoSizeIndicator(v uint32) uint8 {
 		return 1
 	case v <= 0xFFFF:
 		return 2
-	default:
+	case v <= 0xFFFFFF:
 		return 3
+	default:
+		return 4
 	}
 }
 
@@ -98,13 +79,28 @@ func autoLengthIndicator(v uint32) uint8 {
 	return autoSizeIndicator(v)
 }
 
+// v appended using sizeIndicator bytes: 0–4 bytes
+func appendIntWithSize(dst []byte, v uint32, sizeIndicator uint8) []byte {
+	switch sizeIndicator {
+	case 1:
+		return append(dst, byte(v))
+	case 2:
+		return binary.BigEndian.AppendUint16(dst, uint16(v))
+	case 3:
+		return append(dst, byte(v>>16), byte(v>>8), byte(v))
+	case 4:
+		return binary.BigEndian.AppendUint32(dst, v)
+	}
+	return dst
+}
+
 //
 // ─────────────────────────────────────────────────────────────
 //  HEADER BUILDER — byte-based layout
 // ─────────────────────────────────────────────────────────────
 //
 
-func buildHeader(op OperationType, bulk bool, partial bool, posSize, dataSize uint8) []byte {
+func appendHeader(dst []byte, op OperationType, bulk bool, partial bool, posSize, dataSize uint8) []byte {
 	var flags byte
 	if bulk {
 		flags |= FlagBulk
@@ -113,7 +109,7 @@ func buildHeader(op OperationType, bulk bool, partial bool, posSize, dataSize ui
 		flags |= FlagPartial
 	}
 
-	return []byte{byte(op), flags, posSize, dataSize}
+	return append(dst, byte(op), flags, posSize, dataSize)
 }
 
 //
@@ -121,182 +117,171 @@ func buildHeader(op OperationType, bulk bool, partial bool, posSize, dataSize ui
 //  PUBLIC API
 // ─────────────────────────────────────────────────────────────
 //
+// Every EncodeX returns a new message. AppendX appends the same message to
+// dst and returns the extended slice; reusing dst between calls encodes
+// without allocating. On error dst is returned unchanged.
+//
 
 // ─── Single DELETE ───────────────────────────────────────────
 func (e *Encoder) EncodeDelete(pos uint32) ([]byte, error) {
+	return e.AppendDelete(nil, pos)
+}
 
+func (e *Encoder) AppendDelete(dst []byte, pos uint32) ([]byte, error) {
 	posSize := autoSizeIndicator(pos)
-	dataSize := uint8(0)
-
-	header := buildHeader(OpDelete, false, false, posSize, dataSize)
 
-	buf := bytes.Buffer{}
-	buf.Write(header)
-
-	encodedPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encodedPos)
-
-	return buf.Bytes(), nil
+	dst = appendHeader(dst, OpDelete, false, false, posSize, 0)
+	return appendIntWithSize(dst, pos, posSize), nil
 }
 
 // ─── Bulk DELETE (dense) ─────────────────────────────────────
 func (e *Encoder) EncodeDeleteRange(start, end uint32) ([]byte, error) {
+	return e.AppendDeleteRange(nil, start, end)
+}
 
+func (e *Encoder) AppendDeleteRange(dst []byte, start, end uint32) ([]byte, error) {
 	posSize := autoSizeIndicator(max(start, end))
-	dataSize := uint8(0)
 
-	header := buildHeader(OpDelete, true, false, posSize, dataSize)
+	dst = appendHeader(dst, OpDelete, true, false, posSize, 0)
+	dst = appendIntWithSize(dst, start, posSize)
+	return appendIntWithSize(dst, end, posSize), nil
+}
+
+// ─── Single MOVE ─────────────────────────────────────────────
+func (e *Encoder) EncodeMove(from, to uint32) ([]byte, error) {
+	return e.AppendMove(nil, from, to)
+}
+
+func (e *Encoder) AppendMove(dst []byte, from, to uint32) ([]byte, error) {
+	posSize := autoSizeIndicator(max(from, to))
+
+	dst = appendHeader(dst, OpMove, false, false, posSize, 0)
+	dst = appendIntWithSize(dst, from, posSize)
+	return appendIntWithSize(dst, to, posSize), nil
+}
+
+// ─── Bulk MOVE (dense) ───────────────────────────────────────
+func (e *Encoder) EncodeMoveRange(start, end, to uint32) ([]byte, error) {
+	return e.AppendMoveRange(nil, start, end, to)
+}
 
-	buf := bytes.Buffer{}
-	buf.Write(header)
+func (e *Encoder) AppendMoveRange(dst []byte, start, end, to uint32) ([]byte, error) {
+	if end < start {
+		return dst, fmt.Errorf("range end %d before start %d", end, start)
+	}
 
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	posSize := autoSizeIndicator(max(max(start, end), to))
 
-	return buf.Bytes(), nil
+	dst = appendHeader(dst, OpMove, true, false, posSize, 0)
+	dst = appendIntWithSize(dst, start, posSize)
+	dst = appendIntWithSize(dst, end, posSize)
+	return appendIntWithSize(dst, to, posSize), nil
 }
 
 // ─── Single INSERT (full) ────────────────────────────────────
 func (e *Encoder) EncodeInsert(pos uint32, data []byte) ([]byte, error) {
+	return e.AppendInsert(nil, pos, data)
+}
 
-	posSize := autoSizeIndicator(pos)
-	dataSize := autoLengthIndicator(uint32(len(data)))
-
-	header := buildHeader(OpInsert, false, false, posSize, dataSize)
+func (e *Encoder) AppendInsert(dst []byte, pos uint32, data []byte) ([]byte, error) {
+	return appendSingle(dst, OpInsert, false, pos, data), nil
+}
 
-	buf := bytes.Buffer{}
-	buf.Write(header)
+// ─── Single UPDATE (full replace) ─────────────────────────────
+func (e *Encoder) EncodeUpdate(pos uint32, data []byte) ([]byte, error) {
+	return e.AppendUpdate(nil, pos, data)
+}
 
-	encPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encPos)
+func (e *Encoder) AppendUpdate(dst []byte, pos uint32, data []byte) ([]byte, error) {
+	return appendSingle(dst, OpUpdate, false, pos, data), nil
+}
 
-	encLen, err := encodeIntWithSize(uint32(len(data)), dataSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encLen)
+// ─── Bulk INSERT (dense) ─────────────────────────────────────
+func (e *Encoder) EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	return e.AppendInsertRange(nil, start, end, payloads)
+}
 
-	buf.Write(data)
+func (e *Encoder) AppendInsertRange(dst []byte, start, end uint32, payloads [][]byte) ([]byte, error) {
+	return appendDense(dst, OpInsert, start, end, payloads)
+}
 
-	return buf.Bytes(), nil
+// ─── Bulk UPDATE (dense) ─────────────────────────────────────
+func (e *Encoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	return e.AppendUpdateRange(nil, start, end, payloads)
 }
 
-// ─── Single UPDATE (full replace) ─────────────────────────────
-func (e *Encoder) EncodeUpdate(pos uint32, data []byte) ([]byte, error) {
+func (e *Encoder) AppendUpdateRange(dst []byte, start, end uint32, payloads [][]byte) ([]byte, error) {
+	return appendDense(dst, OpUpdate, start, end, payloads)
+}
 
+// single operation with one payload
+func appendSingle(dst []byte, op OperationType, partial bool, pos uint32, data []byte) []byte {
 	posSize := autoSizeIndicator(pos)
 	dataSize := autoLengthIndicator(uint32(len(data)))
 
-	header := buildHeader(OpUpdate, false, false, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.Write(header)
-
-	encPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encPos)
+	dst = appendHeader(dst, op, false, partial, posSize, dataSize)
+	dst = appendIntWithSize(dst, pos, posSize)
+	dst = appendIntWithSize(dst, uint32(len(data)), dataSize)
+	return append(dst, data...)
+}
 
-	encLen, err := encodeIntWithSize(uint32(len(data)), dataSize)
+// bulk operation with one payload per position of the range
+func appendDense(dst []byte, op OperationType, start, end uint32, payloads [][]byte) ([]byte, error) {
+	posSize, dataSize, err := denseSizes(start, end, payloads)
 	if err != nil {
-		return nil, err
+		return dst, err
 	}
-	buf.Write(encLen)
 
-	buf.Write(data)
-
-	return buf.Bytes(), nil
+	dst = appendHeader(dst, op, true, false, posSize, dataSize)
+	dst = appendIntWithSize(dst, start, posSize)
+	dst = appendIntWithSize(dst, end, posSize)
+	return appendPayloads(dst, payloads, dataSize), nil
 }
 
-// ─── Bulk INSERT (dense) ─────────────────────────────────────
-func (e *Encoder) EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error) {
-
+// size classes of a bulk operation
+func denseSizes(start, end uint32, payloads [][]byte) (posSize, dataSize uint8, err error) {
 	if int(end-start)+1 != len(payloads) {
-		return nil, fmt.Errorf("payload count must match range size")
+		return 0, 0, fmt.Errorf("payload count must match range size")
 	}
 
-	maxPos := max(start, end)
-	posSize := autoSizeIndicator(maxPos)
-
 	var maxLen uint32
 	for _, p := range payloads {
 		if uint32(len(p)) > maxLen {
 			maxLen = uint32(len(p))
 		}
 	}
-	dataSize := autoLengthIndicator(maxLen)
-
-	header := buildHeader(OpInsert, true, false, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.Write(header)
-
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	// the decoder wants a length byte per payload, even an empty one
+	return autoSizeIndicator(max(start, end)), autoLengthIndicator(max(maxLen, 1)), nil
+}
 
+func appendPayloads(dst []byte, payloads [][]byte, dataSize uint8) []byte {
 	for _, p := range payloads {
-		plen := uint32(len(p))
-		encLen, err := encodeIntWithSize(plen, dataSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encLen)
-		buf.Write(p)
+		dst = appendIntWithSize(dst, uint32(len(p)), dataSize)
+		dst = append(dst, p...)
 	}
-
-	return buf.Bytes(), nil
+	return dst
 }
 
-// ─── Bulk UPDATE (dense) ─────────────────────────────────────
-func (e *Encoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byte, error) {
-
-	if int(end-start)+1 != len(payloads) {
-		return nil, fmt.Errorf("payload count must match range size")
-	}
-
-	maxPos := max(start, end)
-	posSize := autoSizeIndicator(maxPos)
+// ─── Compressed bulk INSERT ──────────────────────────────────
+func (e *Encoder) EncodeCompressedInsertRange(start, end uint32, payloads [][]byte, d *compress.Deflater) ([]byte, error) {
+	return e.AppendCompressedInsertRange(nil, start, end, payloads, d)
+}
 
-	var maxLen uint32
-	for _, p := range payloads {
-		if uint32(len(p)) > maxLen {
-			maxLen = uint32(len(p))
-		}
+func (e *Encoder) AppendCompressedInsertRange(dst []byte, start, end uint32, payloads [][]byte, d *compress.Deflater) ([]byte, error) {
+	posSize, dataSize, err := denseSizes(start, end, payloads)
+	if err != nil {
+		return dst, err
 	}
-	dataSize := autoLengthIndicator(maxLen)
-
-	header := buildHeader(OpUpdate, true, false, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.Write(header)
 
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	n := len(dst)
+	dst = append(dst, byte(OpInsert), FlagBulk|FlagCompressed, posSize, dataSize)
+	dst = appendIntWithSize(dst, start, posSize)
+	dst = appendIntWithSize(dst, end, posSize)
 
-	for _, p := range payloads {
-		plen := uint32(len(p))
-		encLen, err := encodeIntWithSize(plen, dataSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encLen)
-		buf.Write(p)
+	if dst, err = d.AppendDeflate(dst, appendPayloads(nil, payloads, dataSize)); err != nil {
+		return dst[:n], err
 	}
-
-	return buf.Bytes(), nil
+	return dst, nil
 }
 
 //
@@ -307,30 +292,11 @@ func (e *Encoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byt
 
 // Sparse partial update
 func (e *Encoder) EncodePartialUpdate(pos uint32, patch []byte) ([]byte, error) {
+	return e.AppendPartialUpdate(nil, pos, patch)
+}
 
-	posSize := autoSizeIndicator(pos)
-	dataSize := autoLengthIndicator(uint32(len(patch)))
-
-	header := buildHeader(OpUpdate, false, true, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.Write(header)
-
-	encPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encPos)
-
-	encLen, err := encodeIntWithSize(uint32(len(patch)), dataSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encLen)
-
-	buf.Write(patch)
-
-	return buf.Bytes(), nil
+func (e *Encoder) AppendPartialUpdate(dst []byte, pos uint32, patch []byte) ([]byte, error) {
+	return appendSingle(dst, OpUpdate, true, pos, patch), nil
 }
 
 // Patch entry for bulk sparse updates
@@ -341,6 +307,10 @@ type PartialPatch struct {
 
 // Bulk sparse partial update
 func (e *Encoder) EncodePartialUpdateRange(start, end uint32, patches []PartialPatch) ([]byte, error) {
+	return e.AppendPartialUpdateRange(nil, start, end, patches)
+}
+
+func (e *Encoder) AppendPartialUpdateRange(dst []byte, start, end uint32, patches []PartialPatch) ([]byte, error) {
 
 	// posSize must support both range & patch positions
 	maxPos := max(start, end)
@@ -360,36 +330,19 @@ func (e *Encoder) EncodePartialUpdateRange(start, end uint32, patches []PartialP
 	}
 	dataSize := autoLengthIndicator(maxLen)
 
-	header := buildHeader(OpUpdate, true, true, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.Write(header)
+	dst = appendHeader(dst, OpUpdate, true, true, posSize, dataSize)
 
 	// bulk range
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	dst = appendIntWithSize(dst, start, posSize)
+	dst = appendIntWithSize(dst, end, posSize)
 
 	// sparse patches
 	for _, p := range patches {
-		encPos, err := encodeIntWithSize(p.Pos, posSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encPos)
-
-		plen := uint32(len(p.Data))
-		encLen, err := encodeIntWithSize(plen, dataSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encLen)
-
-		buf.Write(p.Data)
+		dst = appendIntWithSize(dst, p.Pos, posSize)
+		dst = appendIntWithSize(dst, uint32(len(p.Data)), dataSize)
+		dst = append(dst, p.Data...)
 	}
-
-	return buf.Bytes(), nil
+	return dst, nil
 }
 
 // Utility
@@ -0,0 +1,85 @@
+package byteprotocol
+
+import "io"
+
+//
+// ─────────────────────────────────────────────────────────────
+//  STREAM ENCODER
+// ─────────────────────────────────────────────────────────────
+//
+// StreamEncoder writes every operation to its writer with a single Write,
+// through a buffer it keeps, so encoding does not allocate once the buffer
+// has grown. The writer receives one message per call; with a WebSocket,
+// Reset it to the writer of each message, under the lock of the connection:
+//
+//   conn.WriteWith(websocket.BinaryMessage, func(w io.Writer) error {
+//       s.Reset(w)
+//       return s.Insert(3, data)
+//   })
+//
+// A StreamEncoder is not safe for concurrent use.
+//
+
+type StreamEncoder struct {
+	enc Encoder
+	w   io.Writer
+	buf []byte
+}
+
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{w: w}
+}
+
+// Reset points the encoder at w, keeping the buffer
+func (s *StreamEncoder) Reset(w io.Writer) {
+	s.w = w
+}
+
+func (s *StreamEncoder) write(buf []byte, err error) error {
+	s.buf = buf[:0]
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(buf)
+	return err
+}
+
+func (s *StreamEncoder) Delete(pos uint32) error {
+	return s.write(s.enc.AppendDelete(s.buf, pos))
+}
+
+func (s *StreamEncoder) DeleteRange(start, end uint32) error {
+	return s.write(s.enc.AppendDeleteRange(s.buf, start, end))
+}
+
+func (s *StreamEncoder) Move(from, to uint32) error {
+	return s.write(s.enc.AppendMove(s.buf, from, to))
+}
+
+func (s *StreamEncoder) MoveRange(start, end, to uint32) error {
+	return s.write(s.enc.AppendMoveRange(s.buf, start, end, to))
+}
+
+func (s *StreamEncoder) Insert(pos uint32, data []byte) error {
+	return s.write(s.enc.AppendInsert(s.buf, pos, data))
+}
+
+func (s *StreamEncoder) Update(pos uint32, data []byte) error {
+	return s.write(s.enc.AppendUpdate(s.buf, pos, data))
+}
+
+func (s *StreamEncoder) InsertRange(start, end uint32, payloads [][]byte) error {
+	return s.write(s.enc.AppendInsertRange(s.buf, start, end, payloads))
+}
+
+func (s *StreamEncoder) UpdateRange(start, end uint32, payloads [][]byte) error {
+	return s.write(s.enc.AppendUpdateRange(s.buf, start, end, payloads))
+}
+
+func (s *StreamEncoder) PartialUpdate(pos uint32, patch []byte) error {
+	return s.write(s.enc.AppendPartialUpdate(s.buf, pos, patch))
+}
+
+func (s *StreamEncoder) PartialUpdateRange(start, end uint32, patches []PartialPatch) error {
+	return s.write(s.enc.AppendPartialUpdateRange(s.buf, start, end, patches))
+}
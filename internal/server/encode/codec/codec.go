@@ -0,0 +1,306 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+//
+// A codec encodes the payloads of a connection: RPC params and results,
+// subscription data and the elements inside array operations. The frame
+// layouts, their headers and the path keys do not change with it.
+//
+// The client offers codecs as WebSocket subprotocols, in order of
+// preference:
+//
+//   new WebSocket(url, ["goreactivehtml.msgpack", "goreactivehtml.json"])
+//
+// and the server answers with the first one it knows. A client that offers
+// none gets JSON. Struct fields use their json tags in every codec.
+//
+
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+	CBOR    Codec = cborCodec{}
+)
+
+// All lists the codecs the server knows
+var All = []Codec{JSON, MsgPack, CBOR}
+
+const SubprotocolPrefix = "goreactivehtml."
+
+func ByName(name string) (Codec, bool) {
+	for _, c := range All {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Negotiate returns the first offered codec the server knows, JSON when
+// there is none
+func Negotiate(offered ...string) Codec {
+	for _, name := range offered {
+		if c, ok := ByName(strings.TrimSpace(name)); ok {
+			return c
+		}
+	}
+	return JSON
+}
+
+// Subprotocol is the WebSocket subprotocol naming the codec
+func Subprotocol(c Codec) string {
+	return SubprotocolPrefix + c.Name()
+}
+
+// FromSubprotocols picks the codec of a WebSocket upgrade. protocol is the
+// subprotocol to answer with, empty when the client offered no codec.
+func FromSubprotocols(offered []string) (c Codec, protocol string) {
+	for _, p := range offered {
+		name, ok := strings.CutPrefix(p, SubprotocolPrefix)
+		if !ok {
+			continue
+		}
+		if c, ok := ByName(name); ok {
+			return c, p
+		}
+	}
+	return JSON, ""
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  JSON
+// ─────────────────────────────────────────────────────────────
+//
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  MESSAGEPACK
+// ─────────────────────────────────────────────────────────────
+//
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(false)
+	enc.UseCompactInts(true)
+	enc.UseCompactFloats(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	if err := checkMsgpack(data); err != nil {
+		return err
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// checkMsgpack walks the value without decoding it. The decoder allocates
+// arrays and maps by their declared length, a length the data cannot hold
+// is rejected before it does.
+func checkMsgpack(data []byte) error {
+	errShort := errors.New("msgpack: unexpected end of data")
+	off, pending := 0, 1
+
+	// size reads an n byte big endian length
+	size := func(n int) (int, error) {
+		if off+n > len(data) {
+			return 0, errShort
+		}
+		var v uint64
+		for _, b := range data[off : off+n] {
+			v = v<<8 | uint64(b)
+		}
+		off += n
+		return int(v), nil
+	}
+	skip := func(n int, err error) error {
+		if err != nil {
+			return err
+		}
+		if n > len(data)-off {
+			return errShort
+		}
+		off += n
+		return nil
+	}
+	items := func(n int, err error) error {
+		if err != nil {
+			return err
+		}
+		// every element takes at least one byte
+		if n > len(data)-off {
+			return errShort
+		}
+		pending += n
+		return nil
+	}
+
+	for ; pending > 0; pending-- {
+		if off >= len(data) {
+			return errShort
+		}
+		c := data[off]
+		off++
+
+		var err error
+		switch {
+		case c <= 0x7f || c >= 0xe0 || c == 0xc0 || c == 0xc2 || c == 0xc3:
+		case c <= 0x8f:
+			err = items(2*int(c&0x0f), nil)
+		case c <= 0x9f:
+			err = items(int(c&0x0f), nil)
+		case c <= 0xbf:
+			err = skip(int(c&0x1f), nil)
+		case c == 0xc4 || c == 0xd9:
+			err = skip(size(1))
+		case c == 0xc5 || c == 0xda:
+			err = skip(size(2))
+		case c == 0xc6 || c == 0xdb:
+			err = skip(size(4))
+		case c >= 0xc7 && c <= 0xc9:
+			n, e := size(1 << (c - 0xc7))
+			err = skip(n+1, e)
+		case c == 0xcc || c == 0xd0:
+			err = skip(1, nil)
+		case c == 0xcd || c == 0xd1:
+			err = skip(2, nil)
+		case c == 0xca || c == 0xce || c == 0xd2:
+			err = skip(4, nil)
+		case c == 0xcb || c == 0xcf || c == 0xd3:
+			err = skip(8, nil)
+		case c >= 0xd4 && c <= 0xd8:
+			err = skip(1+1<<(c-0xd4), nil)
+		case c == 0xdc:
+			err = items(size(2))
+		case c == 0xdd:
+			err = items(size(4))
+		case c == 0xde:
+			n, e := size(2)
+			err = items(2*n, e)
+		case c == 0xdf:
+			n, e := size(4)
+			err = items(2*n, e)
+		default:
+			err = errors.New("msgpack: invalid code 0xc1")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  CBOR
+// ─────────────────────────────────────────────────────────────
+//
+
+type cborCodec struct{}
+
+var (
+	cborEnc, _ = cbor.EncOptions{}.EncMode()
+	cborDec, _ = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+)
+
+func (cborCodec) Name() string { return "cbor" }
+
+func (cborCodec) Marshal(v any) ([]byte, error) {
+	return cborEnc.Marshal(v)
+}
+
+func (cborCodec) Unmarshal(data []byte, v any) error {
+	return cborDec.Unmarshal(data, v)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  TRANSCODING
+// ─────────────────────────────────────────────────────────────
+//
+
+// FromJSON re-encodes a JSON document with the codec. Integers stay
+// integers when they fit an int64.
+func FromJSON(c Codec, data []byte) ([]byte, error) {
+	if c == JSON {
+		return data, nil
+	}
+	v, err := ParseJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return c.Marshal(v)
+}
+
+// ParseJSON decodes a JSON document into a value any codec can encode.
+// Integers stay integers when they fit an int64.
+func ParseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return numbers(v), nil
+}
+
+// numbers replaces the json.Number values of a decoded document
+func numbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = numbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = numbers(e)
+		}
+	}
+	return v
+}
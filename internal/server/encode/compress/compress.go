@@ -0,0 +1,118 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//
+// Two levels of compression, both optional:
+//
+//   permessage-deflate  the WebSocket extension, the whole frame is
+//                       compressed by the browser and gorilla. Only frames
+//                       of Threshold bytes or more are compressed, small
+//                       ones cost more CPU than they save.
+//
+//   bulk inserts        the payloads of a bulk INSERT are deflated inside
+//                       the array operation, flagged in its header. Useful
+//                       when the transport is not compressed (a proxy
+//                       strips the extension) and with a Dict of the keys
+//                       the payloads repeat.
+//
+// Both use raw DEFLATE (RFC 1951). A Dict must be the same on both sides,
+// the server announces its name in the handshake.
+//
+
+// Options of the compression of a server
+type Options struct {
+	// Frames and bulk inserts smaller than Threshold bytes are sent as is
+	Threshold int
+	// flate level, flate.BestSpeed to flate.BestCompression
+	Level int
+	// Preset dictionary of the bulk inserts, nil for none
+	Dict []byte
+	// Name of Dict announced to the clients
+	DictName string
+}
+
+// Default compresses anything of 1 KB or more as fast as flate can
+var Default = Options{
+	Threshold: 1024,
+	Level:     flate.BestSpeed,
+}
+
+// MaxInflated bounds what Inflate writes, a small frame must not expand
+// into an unbounded amount of memory
+const MaxInflated = 64 << 20
+
+var ErrTooLarge = errors.New("inflated data exceeds the limit")
+
+// Deflater compresses with the same level and dictionary every time,
+// reusing its flate writers
+type Deflater struct {
+	Level int
+	Dict  []byte
+
+	pool sync.Pool
+}
+
+// NewDeflater validates the level once, Deflate cannot fail on it after
+func NewDeflater(level int, dict []byte) (*Deflater, error) {
+	if _, err := flate.NewWriterDict(io.Discard, level, dict); err != nil {
+		return nil, err
+	}
+	return &Deflater{Level: level, Dict: dict}, nil
+}
+
+// Deflater of the options, nil when they compress nothing
+func (o Options) Deflater() (*Deflater, error) {
+	if o.Threshold <= 0 {
+		return nil, nil
+	}
+	return NewDeflater(o.Level, o.Dict)
+}
+
+// AppendDeflate appends src compressed to dst
+func (d *Deflater) AppendDeflate(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+
+	w, _ := d.pool.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriterDict(buf, d.Level, d.Dict); err != nil {
+			return dst, err
+		}
+	} else {
+		// Reset keeps the dictionary the writer was created with
+		w.Reset(buf)
+	}
+	defer d.pool.Put(w)
+
+	if _, err := w.Write(src); err != nil {
+		return dst, err
+	}
+	if err := w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Inflate decompresses src written with the same dictionary, failing with
+// ErrTooLarge past limit bytes
+func Inflate(src, dict []byte, limit int) ([]byte, error) {
+	r := flate.NewReaderDict(bytes.NewReader(src), dict)
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("inflating: %w", err)
+	}
+	if len(out) > limit {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,261 @@
+package conformance
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// Test vectors shared by the Go and the JS decoders of the array protocols.
+// Every case below is encoded by the Go encoders of each protocol and
+// written by cmd/conformance to tests/conformance/testdata:
+//
+//   {"version": 1, "vectors": [
+//     {"name": "insert", "protocol": "bit", "protocolVersion": 1,
+//      "operation": {"type": "insert", "pos": 1, "values": ["x"]},
+//      "input": ["a","b"], "encoded": "170103227822", "expected": ["a","x","b"]}
+//   ]}
+//
+// Both test suites apply "encoded" to "input" and compare with "expected",
+// so a change of the wire format fails on both sides until the corpus is
+// generated again, which is a visible diff in review.
+//
+// Version is bumped when the layout of the corpus itself changes, the file
+// name carries it.
+//
+
+const Version = 1
+
+// File is the corpus file name for Version
+func File() string {
+	return fmt.Sprintf("vectors.v%d.json", Version)
+}
+
+type Corpus struct {
+	Version int      `json:"version"`
+	Vectors []Vector `json:"vectors"`
+}
+
+type Vector struct {
+	Name string `json:"name"`
+	// "bit" (ArrayDecodeProtocol.js) or "byte" (ArrayDecodeProtocolByte.js)
+	Protocol        string            `json:"protocol"`
+	ProtocolVersion protocol.Version  `json:"protocolVersion,omitempty"`
+	Operation       Operation         `json:"operation"`
+	Input           []json.RawMessage `json:"input"`
+	Encoded         string            `json:"encoded"` // hex
+	Expected        []json.RawMessage `json:"expected"`
+}
+
+// Marshal writes the corpus the way it is committed, one vector per
+// indented object
+func (c Corpus) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Bytes returns the encoded operation
+func (v Vector) Bytes() ([]byte, error) {
+	return hex.DecodeString(v.Encoded)
+}
+
+// Operation describes what was encoded, End makes it a bulk operation
+type Operation struct {
+	Type    string            `json:"type"` // insert, update, partial, delete or move
+	Pos     uint32            `json:"pos"`
+	End     *uint32           `json:"end,omitempty"`
+	To      uint32            `json:"to,omitempty"`
+	Values  []json.RawMessage `json:"values,omitempty"`
+	Patches []Patch           `json:"patches,omitempty"`
+}
+
+// Patch is one patch of a sparse bulk partial update
+type Patch struct {
+	Pos  uint32          `json:"pos"`
+	Data json.RawMessage `json:"data"`
+}
+
+type testCase struct {
+	name     string
+	input    string
+	op       Operation
+	expected string
+}
+
+func end(v uint32) *uint32 {
+	return &v
+}
+
+func values(v ...string) []json.RawMessage {
+	out := make([]json.RawMessage, len(v))
+	for i, s := range v {
+		out[i] = json.RawMessage(s)
+	}
+	return out
+}
+
+func array(s string) ([]json.RawMessage, error) {
+	var out []json.RawMessage
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", s, err)
+	}
+	if out == nil {
+		out = []json.RawMessage{}
+	}
+	return out, nil
+}
+
+// long is a payload over 255 bytes, so lengths take 2 bytes
+var long = `"` + strings.Repeat("x", 300) + `"`
+
+const letters = `["a","b","c","d","e"]`
+
+var cases = []testCase{
+	{"insert", letters, Operation{Type: "insert", Pos: 1, Values: values(`"x"`)}, `["a","x","b","c","d","e"]`},
+	{"insert into empty", `[]`, Operation{Type: "insert", Pos: 0, Values: values(`{"id":1}`)}, `[{"id":1}]`},
+	{"insert long payload", `[1]`, Operation{Type: "insert", Pos: 1, Values: values(long)}, `[1,` + long + `]`},
+	{"update", letters, Operation{Type: "update", Pos: 4, Values: values(`1`)}, `["a","b","c","d",1]`},
+	{"update object", `[{"n":1},{"n":2}]`, Operation{Type: "update", Pos: 1, Values: values(`{"m":3}`)}, `[{"n":1},{"m":3}]`},
+	{"partial", `[{"n":1,"m":1}]`, Operation{Type: "partial", Pos: 0, Values: values(`{"n":2}`)}, `[{"n":2,"m":1}]`},
+	{"delete", letters, Operation{Type: "delete", Pos: 0}, `["b","c","d","e"]`},
+	{"delete last", letters, Operation{Type: "delete", Pos: 4}, `["a","b","c","d"]`},
+	{"move forward", letters, Operation{Type: "move", Pos: 0, To: 3}, `["b","c","d","a","e"]`},
+	{"move backward", letters, Operation{Type: "move", Pos: 4, To: 0}, `["e","a","b","c","d"]`},
+	{"move past end", letters, Operation{Type: "move", Pos: 1, To: 300}, `["a","c","d","e","b"]`},
+	{"move wide position", letters, Operation{Type: "move", Pos: 0, To: 70000}, `["b","c","d","e","a"]`},
+	{"move missing", letters, Operation{Type: "move", Pos: 9, To: 0}, letters},
+	{"move in place", letters, Operation{Type: "move", Pos: 2, To: 2}, letters},
+	{"bulk insert", `[1,4]`, Operation{Type: "insert", Pos: 1, End: end(2), Values: values(`2`, `3`)}, `[1,2,3,4]`},
+	{"bulk update", letters, Operation{Type: "update", Pos: 1, End: end(3), Values: values(`1`, `2`, `3`)}, `["a",1,2,3,"e"]`},
+	{"bulk delete", letters, Operation{Type: "delete", Pos: 1, End: end(3)}, `["a","e"]`},
+	{"bulk partial", `[{"n":9},{"n":9},{"n":9}]`, Operation{Type: "partial", Pos: 0, End: end(2), Patches: []Patch{{0, json.RawMessage(`{"n":0}`)}, {2, json.RawMessage(`{"n":2}`)}}}, `[{"n":0},{"n":9},{"n":2}]`},
+	{"bulk move forward", letters, Operation{Type: "move", Pos: 0, End: end(1), To: 3}, `["c","d","e","a","b"]`},
+	{"bulk move backward", letters, Operation{Type: "move", Pos: 3, End: end(4), To: 1}, `["a","d","e","b","c"]`},
+	{"bulk move clamps", letters, Operation{Type: "move", Pos: 3, End: end(9), To: 0}, `["d","e","a","b","c"]`},
+}
+
+// Generate encodes every case with the bit protocol, in each supported
+// version, and with the byte protocol
+func Generate() (Corpus, error) {
+	corpus := Corpus{Version: Version, Vectors: []Vector{}}
+
+	for _, c := range cases {
+		input, err := array(c.input)
+		if err != nil {
+			return Corpus{}, fmt.Errorf("%s: %w", c.name, err)
+		}
+		expected, err := array(c.expected)
+		if err != nil {
+			return Corpus{}, fmt.Errorf("%s: %w", c.name, err)
+		}
+
+		for _, version := range protocol.Supported {
+			data, err := encodeBit(&protocol.Encoder{Version: version}, c.op)
+			if err != nil {
+				return Corpus{}, fmt.Errorf("%s bit v%d: %w", c.name, version, err)
+			}
+			corpus.Vectors = append(corpus.Vectors, Vector{
+				Name: c.name, Protocol: "bit", ProtocolVersion: version, Operation: c.op,
+				Input: input, Encoded: hex.EncodeToString(data), Expected: expected,
+			})
+		}
+
+		data, err := encodeByte(&byteprotocol.Encoder{}, c.op)
+		if err != nil {
+			return Corpus{}, fmt.Errorf("%s byte: %w", c.name, err)
+		}
+		corpus.Vectors = append(corpus.Vectors, Vector{
+			Name: c.name, Protocol: "byte", Operation: c.op,
+			Input: input, Encoded: hex.EncodeToString(data), Expected: expected,
+		})
+	}
+	return corpus, nil
+}
+
+func payloads(values []json.RawMessage) [][]byte {
+	out := make([][]byte, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
+
+func encodeBit(e *protocol.Encoder, op Operation) ([]byte, error) {
+	if op.End != nil {
+		switch op.Type {
+		case "insert":
+			return e.EncodeInsertRange(op.Pos, *op.End, payloads(op.Values))
+		case "update":
+			return e.EncodeUpdateRange(op.Pos, *op.End, payloads(op.Values))
+		case "partial":
+			patches := make([]protocol.PartialPatch, len(op.Patches))
+			for i, p := range op.Patches {
+				patches[i] = protocol.PartialPatch{Pos: p.Pos, Data: p.Data}
+			}
+			return e.EncodePartialUpdateRange(op.Pos, *op.End, patches)
+		case "delete":
+			return e.EncodeDeleteRange(op.Pos, *op.End)
+		case "move":
+			return e.EncodeMoveRange(op.Pos, *op.End, op.To)
+		}
+		return nil, fmt.Errorf("unknown operation %q", op.Type)
+	}
+
+	switch op.Type {
+	case "insert":
+		return e.EncodeInsert(op.Pos, op.Values[0])
+	case "update":
+		return e.EncodeUpdate(op.Pos, op.Values[0])
+	case "partial":
+		return e.EncodePartialUpdate(op.Pos, op.Values[0])
+	case "delete":
+		return e.EncodeDelete(op.Pos)
+	case "move":
+		return e.EncodeMove(op.Pos, op.To)
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Type)
+}
+
+func encodeByte(e *byteprotocol.Encoder, op Operation) ([]byte, error) {
+	if op.End != nil {
+		switch op.Type {
+		case "insert":
+			return e.EncodeInsertRange(op.Pos, *op.End, payloads(op.Values))
+		case "update":
+			return e.EncodeUpdateRange(op.Pos, *op.End, payloads(op.Values))
+		case "partial":
+			patches := make([]byteprotocol.PartialPatch, len(op.Patches))
+			for i, p := range op.Patches {
+				patches[i] = byteprotocol.PartialPatch{Pos: p.Pos, Data: p.Data}
+			}
+			return e.EncodePartialUpdateRange(op.Pos, *op.End, patches)
+		case "delete":
+			return e.EncodeDeleteRange(op.Pos, *op.End)
+		case "move":
+			return e.EncodeMoveRange(op.Pos, *op.End, op.To)
+		}
+		return nil, fmt.Errorf("unknown operation %q", op.Type)
+	}
+
+	switch op.Type {
+	case "insert":
+		return e.EncodeInsert(op.Pos, op.Values[0])
+	case "update":
+		return e.EncodeUpdate(op.Pos, op.Values[0])
+	case "partial":
+		return e.EncodePartialUpdate(op.Pos, op.Values[0])
+	case "delete":
+		return e.EncodeDelete(op.Pos)
+	case "move":
+		return e.EncodeMove(op.Pos, op.To)
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Type)
+}
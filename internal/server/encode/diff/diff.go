@@ -0,0 +1,550 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// Diff turns two versions of a slice into the array operations that take the
+// client from the old version to the new one:
+//
+//   ops, err := diff.SliceFunc(diff.Bit, before, Tasks, func(t Task) int { return t.Id })
+//
+// Elements are matched by key (or by their JSON when there is no key) with
+// the longest common subsequence. What is left is sent as:
+//
+//   removed runs     DELETE / bulk DELETE
+//   added runs       INSERT / bulk INSERT
+//   reordered runs   MOVE / bulk MOVE, when the same key is found again
+//   changed values   UPDATE / bulk UPDATE, or a partial UPDATE with only
+//                    the changed fields when that is smaller
+//
+// A removed element followed by an added one becomes an UPDATE in place.
+// Deletes come first, then inserts and moves, and updates last using the
+// positions of the new slice.
+//
+
+// Patch is a partial update of the element at Pos
+type Patch struct {
+	Pos  uint32
+	Data []byte
+}
+
+// Encoder is the set of array operations the diff emits
+type Encoder interface {
+	EncodeDelete(pos uint32) ([]byte, error)
+	EncodeDeleteRange(start, end uint32) ([]byte, error)
+	EncodeInsert(pos uint32, data []byte) ([]byte, error)
+	EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error)
+	EncodeUpdate(pos uint32, data []byte) ([]byte, error)
+	EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byte, error)
+	EncodeMove(from, to uint32) ([]byte, error)
+	EncodeMoveRange(start, end, to uint32) ([]byte, error)
+	EncodePartialUpdate(pos uint32, patch []byte) ([]byte, error)
+	EncodePatches(start, end uint32, patches []Patch) ([]byte, error)
+}
+
+// Encoders of the 1 byte header and 4 byte header protocols, BitV2 for
+// connections that agreed on protocol.V2
+var (
+	Bit   Encoder = &bitEncoder{}
+	BitV2 Encoder = &bitEncoder{protocol.Encoder{Version: protocol.V2}}
+	Byte  Encoder = &byteEncoder{}
+)
+
+type bitEncoder struct{ protocol.Encoder }
+
+func (e *bitEncoder) EncodePatches(start, end uint32, patches []Patch) ([]byte, error) {
+	converted := make([]protocol.PartialPatch, len(patches))
+	for i, p := range patches {
+		converted[i] = protocol.PartialPatch(p)
+	}
+	return e.EncodePartialUpdateRange(start, end, converted)
+}
+
+type byteEncoder struct{ byteprotocol.Encoder }
+
+func (e *byteEncoder) EncodePatches(start, end uint32, patches []Patch) ([]byte, error) {
+	converted := make([]byteprotocol.PartialPatch, len(patches))
+	for i, p := range patches {
+		converted[i] = byteprotocol.PartialPatch(p)
+	}
+	return e.EncodePartialUpdateRange(start, end, converted)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  PUBLIC API
+// ─────────────────────────────────────────────────────────────
+//
+
+// Slice returns the operations turning old into new, matching elements by
+// their JSON encoding
+func Slice[T any](enc Encoder, old, new []T) ([][]byte, error) {
+	a, err := marshalAll(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := marshalAll(new)
+	if err != nil {
+		return nil, err
+	}
+	return encode(enc, a, b, asKeys(a), asKeys(b))
+}
+
+// SliceFunc returns the operations turning old into new, matching elements
+// by the key, so an element whose fields changed is updated in place
+func SliceFunc[T any, K comparable](enc Encoder, old, new []T, key func(T) K) ([][]byte, error) {
+	a, err := marshalAll(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := marshalAll(new)
+	if err != nil {
+		return nil, err
+	}
+	return encode(enc, a, b, keysOf(old, key), keysOf(new, key))
+}
+
+// SliceWith is Slice for a connection whose payloads use the codec
+func SliceWith[T any](enc Encoder, c codec.Codec, old, new []T) ([][]byte, error) {
+	return Slice(Transcoding(enc, c), old, new)
+}
+
+// SliceFuncWith is SliceFunc for a connection whose payloads use the codec
+func SliceFuncWith[T any, K comparable](enc Encoder, c codec.Codec, old, new []T, key func(T) K) ([][]byte, error) {
+	return SliceFunc(Transcoding(enc, c), old, new, key)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  CODECS
+// ─────────────────────────────────────────────────────────────
+//
+// The diff compares and patches JSON. Transcoding re-encodes every payload
+// and patch with the codec of the connection right before the message is
+// built, so the operations planned are the same for every codec.
+//
+
+// Transcoding returns an encoder writing its payloads with the codec
+func Transcoding(enc Encoder, c codec.Codec) Encoder {
+	if c == nil || c == codec.JSON {
+		return enc
+	}
+	return &transcoder{enc, c}
+}
+
+type transcoder struct {
+	Encoder
+	codec codec.Codec
+}
+
+func (t *transcoder) all(payloads [][]byte) ([][]byte, error) {
+	out := make([][]byte, len(payloads))
+	for i, p := range payloads {
+		data, err := codec.FromJSON(t.codec, p)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = data
+	}
+	return out, nil
+}
+
+func (t *transcoder) EncodeInsert(pos uint32, data []byte) ([]byte, error) {
+	data, err := codec.FromJSON(t.codec, data)
+	if err != nil {
+		return nil, err
+	}
+	return t.Encoder.EncodeInsert(pos, data)
+}
+
+func (t *transcoder) EncodeUpdate(pos uint32, data []byte) ([]byte, error) {
+	data, err := codec.FromJSON(t.codec, data)
+	if err != nil {
+		return nil, err
+	}
+	return t.Encoder.EncodeUpdate(pos, data)
+}
+
+func (t *transcoder) EncodePartialUpdate(pos uint32, patch []byte) ([]byte, error) {
+	patch, err := codec.FromJSON(t.codec, patch)
+	if err != nil {
+		return nil, err
+	}
+	return t.Encoder.EncodePartialUpdate(pos, patch)
+}
+
+func (t *transcoder) EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	payloads, err := t.all(payloads)
+	if err != nil {
+		return nil, err
+	}
+	return t.Encoder.EncodeInsertRange(start, end, payloads)
+}
+
+func (t *transcoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	payloads, err := t.all(payloads)
+	if err != nil {
+		return nil, err
+	}
+	return t.Encoder.EncodeUpdateRange(start, end, payloads)
+}
+
+func (t *transcoder) EncodePatches(start, end uint32, patches []Patch) ([]byte, error) {
+	converted := make([]Patch, len(patches))
+	for i, p := range patches {
+		data, err := codec.FromJSON(t.codec, p.Data)
+		if err != nil {
+			return nil, err
+		}
+		converted[i] = Patch{Pos: p.Pos, Data: data}
+	}
+	return t.Encoder.EncodePatches(start, end, converted)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  COMPRESSION
+// ─────────────────────────────────────────────────────────────
+//
+// Deflated sends the bulk inserts whose payloads add up to threshold bytes
+// or more compressed, for clients with the deflate capability. Wrap it
+// before Transcoding, so what is deflated is the payloads of the connection.
+//
+
+type compressedInserter interface {
+	EncodeCompressedInsertRange(start, end uint32, payloads [][]byte, d *compress.Deflater) ([]byte, error)
+}
+
+// Deflated returns an encoder compressing the large bulk inserts of enc,
+// enc itself when it has no compressed form
+func Deflated(enc Encoder, d *compress.Deflater, threshold int) Encoder {
+	c, ok := enc.(compressedInserter)
+	if !ok || d == nil {
+		return enc
+	}
+	return &deflater{Encoder: enc, compressed: c, d: d, threshold: threshold}
+}
+
+type deflater struct {
+	Encoder
+	compressed compressedInserter
+	d          *compress.Deflater
+	threshold  int
+}
+
+func (e *deflater) EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	size := 0
+	for _, p := range payloads {
+		size += len(p)
+	}
+	if size < e.threshold {
+		return e.Encoder.EncodeInsertRange(start, end, payloads)
+	}
+	return e.compressed.EncodeCompressedInsertRange(start, end, payloads, e.d)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  OPERATION PLANNING
+// ─────────────────────────────────────────────────────────────
+//
+
+type update struct {
+	pos  uint32
+	data []byte
+}
+
+// sources maps every element of new to the old element it comes from, or
+// -1 when it is inserted. Elements out of the common subsequence found again
+// by key are moved. Old elements that are not the source of anything are
+// deleted.
+func sources[K comparable](oldKeys, newKeys []K) (from []int, moved []bool, kept []bool) {
+	from = make([]int, len(newKeys))
+	moved = make([]bool, len(newKeys))
+	kept = make([]bool, len(oldKeys))
+	for j := range from {
+		from[j] = -1
+	}
+
+	pairs := match(oldKeys, newKeys)
+	for _, p := range pairs {
+		from[p[1]] = p[0]
+		kept[p[0]] = true
+	}
+
+	// ─── Moves: same key on both sides, out of order ───
+	free := make(map[K][]int)
+	for i, k := range oldKeys {
+		if !kept[i] {
+			free[k] = append(free[k], i)
+		}
+	}
+	for j, k := range newKeys {
+		if from[j] >= 0 || len(free[k]) == 0 {
+			continue
+		}
+		from[j], moved[j] = free[k][0], true
+		kept[free[k][0]] = true
+		free[k] = free[k][1:]
+	}
+
+	// ─── Replacements: a removed element followed by an added one ───
+	i, j := 0, 0
+	for _, p := range append(pairs, [2]int{len(oldKeys), len(newKeys)}) {
+		oi, nj := i, j
+		for {
+			for oi < p[0] && kept[oi] {
+				oi++
+			}
+			for nj < p[1] && from[nj] >= 0 {
+				nj++
+			}
+			if oi == p[0] || nj == p[1] {
+				break
+			}
+			from[nj] = oi
+			kept[oi] = true
+		}
+		i, j = p[0]+1, p[1]+1
+	}
+	return from, moved, kept
+}
+
+func encode[K comparable](enc Encoder, old, new [][]byte, oldKeys, newKeys []K) ([][]byte, error) {
+	var out [][]byte
+	emit := func(msg []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		out = append(out, msg)
+		return nil
+	}
+
+	from, moved, kept := sources(oldKeys, newKeys)
+
+	// cur mirrors the client: old elements by index, inserted ones as -1-j
+	cur := make([]int, len(old))
+	for i := range cur {
+		cur[i] = i
+	}
+	id := func(j int) int {
+		if from[j] >= 0 {
+			return from[j]
+		}
+		return -1 - j
+	}
+	indexOf := func(id int) int {
+		for k, v := range cur {
+			if v == id {
+				return k
+			}
+		}
+		return -1
+	}
+	after := func(j int) int {
+		if j == 0 {
+			return 0
+		}
+		return indexOf(id(j-1)) + 1
+	}
+
+	// ─── Deletes ───
+	for k := 0; k < len(cur); {
+		if kept[cur[k]] {
+			k++
+			continue
+		}
+		end := k + 1
+		for end < len(cur) && !kept[cur[end]] {
+			end++
+		}
+		var err error
+		if end-k == 1 {
+			err = emit(enc.EncodeDelete(uint32(k)))
+		} else {
+			err = emit(enc.EncodeDeleteRange(uint32(k), uint32(end-1)))
+		}
+		if err != nil {
+			return nil, err
+		}
+		cur = append(cur[:k], cur[end:]...)
+	}
+
+	// ─── Inserts and moves, each right after its new predecessor ───
+	for j := 0; j < len(new); {
+		if from[j] >= 0 && !moved[j] {
+			j++
+			continue
+		}
+
+		if from[j] < 0 {
+			end := j + 1
+			for end < len(new) && from[end] < 0 {
+				end++
+			}
+			to := after(j)
+			var err error
+			if end-j == 1 {
+				err = emit(enc.EncodeInsert(uint32(to), new[j]))
+			} else {
+				err = emit(enc.EncodeInsertRange(uint32(to), uint32(to+end-j-1), new[j:end]))
+			}
+			if err != nil {
+				return nil, err
+			}
+			ids := make([]int, 0, end-j)
+			for n := j; n < end; n++ {
+				ids = append(ids, id(n))
+			}
+			cur = append(cur[:to], append(ids, cur[to:]...)...)
+			j = end
+			continue
+		}
+
+		// a run of moved elements still next to each other moves at once
+		k := indexOf(from[j])
+		end := j + 1
+		for end < len(new) && moved[end] && k+end-j < len(cur) && cur[k+end-j] == from[end] {
+			end++
+		}
+		block := append([]int(nil), cur[k:k+end-j]...)
+		cur = append(cur[:k], cur[k+end-j:]...)
+		to := after(j)
+		cur = append(cur[:to], append(block, cur[to:]...)...)
+
+		if to != k {
+			var err error
+			if end-j == 1 {
+				err = emit(enc.EncodeMove(uint32(k), uint32(to)))
+			} else {
+				err = emit(enc.EncodeMoveRange(uint32(k), uint32(k+end-j-1), uint32(to)))
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		j = end
+	}
+
+	// ─── Changed values, at their final position ───
+	var full []update
+	var patches []Patch
+	for j, i := range from {
+		if i < 0 || string(old[i]) == string(new[j]) {
+			continue
+		}
+		if patch, ok := patchOf(old[i], new[j]); ok && len(patch) < len(new[j]) {
+			patches = append(patches, Patch{Pos: uint32(j), Data: patch})
+			continue
+		}
+		full = append(full, update{pos: uint32(j), data: new[j]})
+	}
+
+	// ─── Full updates, one bulk UPDATE per contiguous run ───
+	for start := 0; start < len(full); {
+		end := start + 1
+		for end < len(full) && full[end].pos == full[end-1].pos+1 {
+			end++
+		}
+		run := full[start:end]
+		if len(run) == 1 {
+			if err := emit(enc.EncodeUpdate(run[0].pos, run[0].data)); err != nil {
+				return nil, err
+			}
+		} else {
+			payloads := make([][]byte, len(run))
+			for k, u := range run {
+				payloads[k] = u.data
+			}
+			if err := emit(enc.EncodeUpdateRange(run[0].pos, run[len(run)-1].pos, payloads)); err != nil {
+				return nil, err
+			}
+		}
+		start = end
+	}
+
+	// ─── Partial updates, all in one message ───
+	switch len(patches) {
+	case 0:
+	case 1:
+		if err := emit(enc.EncodePartialUpdate(patches[0].Pos, patches[0].Data)); err != nil {
+			return nil, err
+		}
+	default:
+		if err := emit(enc.EncodePatches(patches[0].Pos, patches[len(patches)-1].Pos, patches)); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// patchOf returns the fields of after that differ from before, when both
+// are JSON objects and no field was removed
+func patchOf(before, after []byte) ([]byte, bool) {
+	var a, b map[string]json.RawMessage
+	if json.Unmarshal(before, &a) != nil || json.Unmarshal(after, &b) != nil || a == nil || b == nil {
+		return nil, false
+	}
+
+	patch := make(map[string]json.RawMessage)
+	for k, v := range a {
+		nv, ok := b[k]
+		if !ok {
+			return nil, false
+		}
+		if string(nv) != string(v) {
+			patch[k] = nv
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			patch[k] = v
+		}
+	}
+	if len(patch) == 0 {
+		return nil, false
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+func marshalAll[T any](values []T) ([][]byte, error) {
+	out := make([][]byte, len(values))
+	for i, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		out[i] = data
+	}
+	return out, nil
+}
+
+func asKeys(payloads [][]byte) []string {
+	keys := make([]string, len(payloads))
+	for i, p := range payloads {
+		keys[i] = string(p)
+	}
+	return keys
+}
+
+func keysOf[T any, K comparable](values []T, key func(T) K) []K {
+	keys := make([]K, len(values))
+	for i, v := range values {
+		keys[i] = key(v)
+	}
+	return keys
+}
@@ -0,0 +1,106 @@
+package pathprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//
+// ─────────────────────────────────────────────────────────────
+//  BATCH BUILDER
+// ─────────────────────────────────────────────────────────────
+//
+// A batch sends several operations as one message, so the client never
+// renders the state between them:
+//
+//   msg, err := enc.Batch(true).
+//       Set(total, []byte(`3`)).
+//       Array(tasks, insert).
+//       Encode()
+//
+// Keys are interned only by Encode, a batch that is dropped leaves the
+// connection tables untouched.
+//
+
+type batchOp struct {
+	op   OperationType
+	path Path
+	body []byte
+}
+
+// Batch collects operations until Encode, the first error is kept and
+// returned by Encode
+type Batch struct {
+	enc           *Encoder
+	transactional bool
+	ops           []batchOp
+	err           error
+}
+
+// Batch starts a batch. A transactional batch is applied completely or
+// not at all.
+func (e *Encoder) Batch(transactional bool) *Batch {
+	return &Batch{enc: e, transactional: transactional}
+}
+
+func (b *Batch) add(op OperationType, path Path, body []byte) *Batch {
+	if b.err == nil {
+		b.err = validPath(path)
+	}
+	b.ops = append(b.ops, batchOp{op, path, body})
+	return b
+}
+
+func (b *Batch) Set(path Path, value []byte) *Batch {
+	return b.add(OpSet, path, value)
+}
+
+func (b *Batch) Delete(path Path) *Batch {
+	if len(path) == 0 && b.err == nil {
+		b.err = errors.New("delete needs a path")
+	}
+	return b.add(OpDelete, path, nil)
+}
+
+func (b *Batch) Merge(path Path, patch []byte) *Batch {
+	return b.add(OpMerge, path, patch)
+}
+
+func (b *Batch) Array(path Path, op []byte) *Batch {
+	if len(op) == 0 && b.err == nil {
+		b.err = errors.New("empty array operation")
+	}
+	return b.add(OpArray, path, op)
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Encode returns the batch message, the operations in the order added
+func (b *Batch) Encode() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.ops) == 0 {
+		return nil, fmt.Errorf("empty batch")
+	}
+
+	header := byte(OpBatch)
+	if b.transactional {
+		header |= FlagTransactional
+	}
+
+	b.enc.mu.Lock()
+	defer b.enc.mu.Unlock()
+
+	buf := binary.AppendUvarint([]byte{header}, uint64(len(b.ops)))
+	var msg []byte
+	for _, op := range b.ops {
+		msg = b.enc.appendOp(msg[:0], op.op, op.path, op.body)
+		buf = binary.AppendUvarint(buf, uint64(len(msg)))
+		buf = append(buf, msg...)
+	}
+	return buf, nil
+}
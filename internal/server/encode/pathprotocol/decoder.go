@@ -0,0 +1,372 @@
+package pathprotocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// ─────────────────────────────────────────────────────────────
+//  DECODED OPERATION
+// ─────────────────────────────────────────────────────────────
+//
+
+// Operation is one decoded message, with the interned keys resolved.
+// A batch has no path and keeps its operations in Ops.
+type Operation struct {
+	Op            OperationType
+	Path          Path
+	Data          []byte
+	Ops           []Operation
+	Transactional bool
+}
+
+// Decoder resolves the keys interned by the Encoder of the same connection.
+// ArrayVersion is the array protocol version of the connection, V1 when 0.
+// Codec decodes the values of SET, MERGE and ARRAY, JSON when nil.
+type Decoder struct {
+	mu           sync.Mutex
+	keys         []string
+	ArrayVersion protocol.Version
+	Codec        codec.Codec
+}
+
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  PUBLIC API
+// ─────────────────────────────────────────────────────────────
+//
+
+// Decode parses one message. Keys introduced by the message are interned
+// even when it is never applied. A batch interns its keys only when every
+// operation in it decodes.
+func (d *Decoder) Decode(data []byte) (Operation, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var added []string
+	op, err := d.decode(data, &added, true)
+	if err != nil {
+		return op, err
+	}
+	d.keys = append(d.keys, added...)
+	return op, nil
+}
+
+// decode resolves the keys against the table plus the ones added earlier
+// in the same message
+func (d *Decoder) decode(data []byte, added *[]string, batch bool) (Operation, error) {
+	if len(data) == 0 {
+		return Operation{}, errors.New("empty data")
+	}
+
+	op := Operation{Op: OperationType(data[0] & opMask)}
+	flags := data[0] &^ opMask
+	if op.Op > OpBatch {
+		return op, fmt.Errorf("unknown operation %d", data[0])
+	}
+	if op.Op == OpBatch {
+		if !batch {
+			return op, errors.New("batch inside a batch")
+		}
+		if flags&^FlagTransactional != 0 {
+			return op, fmt.Errorf("reserved flags set in %08b", data[0])
+		}
+		op.Transactional = flags != 0
+		return op, d.decodeBatch(&op, data[1:], added)
+	}
+	if flags != 0 {
+		return op, fmt.Errorf("reserved flags set in %08b", data[0])
+	}
+
+	offset := 1
+	count, n := binary.Uvarint(data[offset:])
+	if n <= 0 {
+		return op, fmt.Errorf("invalid path length at %d", offset)
+	}
+	offset += n
+	if count > uint64(len(data)) {
+		return op, fmt.Errorf("path of %d segments exceeds data", count)
+	}
+
+	op.Path = make(Path, 0, count)
+	for i := uint64(0); i < count; i++ {
+		v, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return op, fmt.Errorf("invalid path segment at %d", offset)
+		}
+		offset += n
+
+		kind, value := v&(1<<segKindLen-1), v>>segKindLen
+		switch kind {
+		case segIndex:
+			if value > 0xFFFFFFFF {
+				return op, fmt.Errorf("index %d out of range", value)
+			}
+			op.Path = append(op.Path, Index(uint32(value)))
+
+		case segKeyId:
+			all := len(d.keys) + len(*added)
+			if value >= uint64(all) {
+				return op, fmt.Errorf("unknown key id %d", value)
+			}
+			if value < uint64(len(d.keys)) {
+				op.Path = append(op.Path, Key(d.keys[value]))
+			} else {
+				op.Path = append(op.Path, Key((*added)[value-uint64(len(d.keys))]))
+			}
+
+		case segKey, segKeyNew:
+			if value > uint64(len(data)-offset) {
+				return op, fmt.Errorf("key length %d exceeds data at %d", value, offset)
+			}
+			k := string(data[offset : offset+int(value)])
+			offset += int(value)
+			op.Path = append(op.Path, Key(k))
+			if kind == segKeyNew {
+				*added = append(*added, k)
+			}
+		}
+	}
+
+	op.Data = data[offset:]
+	if op.Op == OpDelete && len(op.Path) == 0 {
+		return op, errors.New("delete needs a path")
+	}
+	if op.Op == OpDelete && len(op.Data) > 0 {
+		return op, fmt.Errorf("%d trailing bytes", len(op.Data))
+	}
+	return op, nil
+}
+
+func (d *Decoder) decodeBatch(op *Operation, data []byte, added *[]string) error {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errors.New("invalid batch count")
+	}
+	if count == 0 {
+		return errors.New("empty batch")
+	}
+	if count > uint64(len(data)) {
+		return fmt.Errorf("batch of %d operations exceeds data", count)
+	}
+	offset := n
+
+	op.Ops = make([]Operation, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return fmt.Errorf("invalid length of operation %d", i)
+		}
+		offset += n
+		if size > uint64(len(data)-offset) {
+			return fmt.Errorf("operation %d of %d bytes exceeds data", i, size)
+		}
+
+		sub, err := d.decode(data[offset:offset+int(size)], added, false)
+		if err != nil {
+			return fmt.Errorf("operation %d: %w", i, err)
+		}
+		op.Ops = append(op.Ops, sub)
+		offset += int(size)
+	}
+
+	if offset != len(data) {
+		return fmt.Errorf("%d trailing bytes", len(data)-offset)
+	}
+	return nil
+}
+
+// DecodeApply decodes the data and applies it to the state
+func (d *Decoder) DecodeApply(data []byte, root any) (any, error) {
+	op, err := d.Decode(data)
+	if err != nil {
+		return root, err
+	}
+	payloads := d.Codec
+	if payloads == nil {
+		payloads = codec.JSON
+	}
+	return apply(op, root, protocol.Decoder{Version: d.ArrayVersion}, payloads)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  APPLY — same semantics as web/lib/PathDecodeProtocol.js
+// ─────────────────────────────────────────────────────────────
+//
+
+// Apply runs the operation on a state decoded from JSON (map[string]any,
+// []any, ...) and returns the new state. Missing containers along the path
+// are created, an object for a key and an array for an index. ARRAY bodies
+// are read as V1.
+func Apply(op Operation, root any) (any, error) {
+	return apply(op, root, protocol.Decoder{}, codec.JSON)
+}
+
+func apply(op Operation, root any, arrays protocol.Decoder, payloads codec.Codec) (any, error) {
+	switch op.Op {
+	case OpSet:
+		var value any
+		if err := payloads.Unmarshal(op.Data, &value); err != nil {
+			return root, err
+		}
+		return update(root, op.Path, func(any) (any, error) { return value, nil })
+
+	case OpDelete:
+		last := op.Path[len(op.Path)-1]
+		return update(root, op.Path[:len(op.Path)-1], func(parent any) (any, error) {
+			return remove(parent, last), nil
+		})
+
+	case OpMerge:
+		var patch any
+		if err := payloads.Unmarshal(op.Data, &patch); err != nil {
+			return root, err
+		}
+		return update(root, op.Path, func(current any) (any, error) {
+			return merge(current, patch), nil
+		})
+
+	case OpArray:
+		return update(root, op.Path, func(current any) (any, error) {
+			return applyArray(current, op.Data, arrays, payloads)
+		})
+
+	case OpBatch:
+		return applyBatch(op, root, arrays, payloads)
+	}
+	return root, fmt.Errorf("unknown operation %d", op.Op)
+}
+
+// applyBatch runs the operations in order. A transactional batch works on
+// a copy and returns root untouched when any operation fails, otherwise
+// the operations before the failing one stay applied.
+func applyBatch(op Operation, root any, arrays protocol.Decoder, payloads codec.Codec) (any, error) {
+	state := root
+	if op.Transactional {
+		state = deepCopy(root)
+	}
+	for i, sub := range op.Ops {
+		var err error
+		if state, err = apply(sub, state, arrays, payloads); err != nil {
+			if op.Transactional {
+				return root, fmt.Errorf("operation %d: %w", i, err)
+			}
+			return state, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return state, nil
+}
+
+func deepCopy(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for k, e := range v {
+			c[k] = deepCopy(e)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, e := range v {
+			c[i] = deepCopy(e)
+		}
+		return c
+	}
+	return v
+}
+
+// update replaces the value at the path with fn(value). An index past the
+// end grows the array with nulls, up to protocol.MaxGrowth.
+func update(node any, path Path, fn func(any) (any, error)) (any, error) {
+	if len(path) == 0 {
+		return fn(node)
+	}
+
+	s := path[0]
+	if s.IsIndex {
+		arr, ok := node.([]any)
+		if !ok {
+			arr = nil
+		}
+		if int(s.Index)-len(arr) >= protocol.MaxGrowth {
+			return node, fmt.Errorf("index %d beyond the end %d", s.Index, len(arr))
+		}
+		for len(arr) <= int(s.Index) {
+			arr = append(arr, nil)
+		}
+		child, err := update(arr[s.Index], path[1:], fn)
+		if err != nil {
+			return node, err
+		}
+		arr[s.Index] = child
+		return arr, nil
+	}
+
+	obj, ok := node.(map[string]any)
+	if !ok {
+		obj = make(map[string]any)
+	}
+	child, err := update(obj[s.Key], path[1:], fn)
+	if err != nil {
+		return node, err
+	}
+	obj[s.Key] = child
+	return obj, nil
+}
+
+func remove(parent any, s Segment) any {
+	if s.IsIndex {
+		arr, ok := parent.([]any)
+		if !ok || int(s.Index) >= len(arr) {
+			return parent
+		}
+		return append(arr[:s.Index], arr[s.Index+1:]...)
+	}
+	if obj, ok := parent.(map[string]any); ok {
+		delete(obj, s.Key)
+	}
+	return parent
+}
+
+// merge copies the keys of patch into current when both are objects,
+// otherwise the patch replaces the current value
+func merge(current, patch any) any {
+	c, ok := current.(map[string]any)
+	p, isObj := patch.(map[string]any)
+	if !ok || !isObj {
+		return patch
+	}
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
+func applyArray(current any, msg []byte, dec protocol.Decoder, payloads codec.Codec) (any, error) {
+	arr, _ := current.([]any)
+	arr = append([]any(nil), arr...)
+
+	op, err := dec.Decode(msg)
+	if err != nil {
+		return current, err
+	}
+	out, err := protocol.ApplyValues(op, arr, payloads)
+	if err != nil {
+		return current, err
+	}
+	if out == nil {
+		out = []any{}
+	}
+	return out, nil
+}
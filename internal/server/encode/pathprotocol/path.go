@@ -0,0 +1,90 @@
+package pathprotocol
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//
+// A path addresses a value inside the client state, starting from the
+// object holding the business variables:
+//
+//   Tasks[3].Tags[1]   key "Tasks", index 3, key "Tags", index 1
+//   Form.Email         key "Form", key "Email"
+//
+// Keys with '.' or '[' in them are built with Key instead of ParsePath.
+//
+
+type Segment struct {
+	Key     string
+	Index   uint32
+	IsIndex bool
+}
+
+type Path []Segment
+
+func Key(k string) Segment {
+	return Segment{Key: k}
+}
+
+func Index(i uint32) Segment {
+	return Segment{Index: i, IsIndex: true}
+}
+
+// ParsePath reads the dotted form, like "Tasks[3].Tags[1]"
+func ParsePath(s string) (Path, error) {
+	var path Path
+	if s == "" {
+		return path, nil
+	}
+
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: missing ]", s)
+			}
+			n, err := strconv.ParseUint(s[i+1:i+end], 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: bad index %q", s, s[i+1:i+end])
+			}
+			path = append(path, Index(uint32(n)))
+			i += end + 1
+
+		case '.':
+			if i == 0 || i == len(s)-1 || s[i+1] == '.' || s[i+1] == '[' {
+				return nil, fmt.Errorf("invalid path %q: empty key", s)
+			}
+			i++
+
+		default:
+			if i > 0 && s[i-1] != '.' {
+				return nil, fmt.Errorf("invalid path %q: missing . before %q", s, s[i:])
+			}
+			end := strings.IndexAny(s[i:], ".[")
+			if end < 0 {
+				end = len(s) - i
+			}
+			path = append(path, Key(s[i:i+end]))
+			i += end
+		}
+	}
+	return path, nil
+}
+
+func (p Path) String() string {
+	var sb strings.Builder
+	for i, s := range p {
+		if s.IsIndex {
+			fmt.Fprintf(&sb, "[%d]", s.Index)
+			continue
+		}
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(s.Key)
+	}
+	return sb.String()
+}
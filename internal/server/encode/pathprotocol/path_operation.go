@@ -0,0 +1,161 @@
+package pathprotocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+//
+// ┌──────────┬──────────┬─────────────────────┬──────────┐
+// │ bits 3-7 │ bits 0-2 │ path                │ body     │
+// │ flags    │ op       │ count + segments    │ the rest │
+// └──────────┴──────────┴─────────────────────┴──────────┘
+//
+// Operation:
+//   000 = SET     body: JSON value stored at the path
+//   001 = DELETE  body: empty, removes the key or the array element
+//   010 = MERGE   body: JSON object, its keys are merged into the object
+//   011 = ARRAY   body: an array protocol message (1 byte header) applied
+//                 to the array at the path
+//   100 = BATCH   no path, body: uvarint count, then every operation as
+//                 uvarint length + message. Bit 3 marks it transactional:
+//                 either every operation is applied or none is.
+//
+// Bits 3-7 are 0 in every other operation.
+//
+// Path: uvarint segment count, then one uvarint per segment with the kind
+// in the low 2 bits and n in the others:
+//   00 = index n
+//   01 = key interned as id n
+//   10 = key of n bytes, following
+//   11 = key of n bytes, following, interned with the next id
+//
+// Keys are interned per connection: the first use of a key sends it and
+// both sides give it the next id, later uses send only the id. Messages
+// must reach the client in the order they were encoded.
+//
+
+type OperationType byte
+
+const (
+	OpSet    OperationType = 0b000
+	OpDelete OperationType = 0b001
+	OpMerge  OperationType = 0b010
+	OpArray  OperationType = 0b011
+	OpBatch  OperationType = 0b100
+)
+
+const (
+	opMask            = 0b111
+	FlagTransactional = 0b1000
+)
+
+const (
+	segIndex   = 0b00
+	segKeyId   = 0b01
+	segKey     = 0b10
+	segKeyNew  = 0b11
+	segKindLen = 2
+)
+
+// MaxKeys is how many keys a connection interns, later keys are sent in full
+const MaxKeys = 4096
+
+// Encoder interns the keys of one connection
+type Encoder struct {
+	mu   sync.Mutex
+	keys map[string]uint64
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{keys: make(map[string]uint64)}
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  PATH ENCODING
+// ─────────────────────────────────────────────────────────────
+//
+
+func (e *Encoder) appendPath(buf []byte, path Path) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(path)))
+	for _, s := range path {
+		if s.IsIndex {
+			buf = binary.AppendUvarint(buf, uint64(s.Index)<<segKindLen|segIndex)
+			continue
+		}
+		if id, ok := e.keys[s.Key]; ok {
+			buf = binary.AppendUvarint(buf, id<<segKindLen|segKeyId)
+			continue
+		}
+
+		kind := uint64(segKey)
+		if len(e.keys) < MaxKeys {
+			kind = segKeyNew
+			e.keys[s.Key] = uint64(len(e.keys))
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(s.Key))<<segKindLen|kind)
+		buf = append(buf, s.Key...)
+	}
+	return buf
+}
+
+func validPath(path Path) error {
+	for _, s := range path {
+		if !s.IsIndex && s.Key == "" {
+			return fmt.Errorf("empty key in path %q", path)
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) appendOp(buf []byte, op OperationType, path Path, body []byte) []byte {
+	buf = append(buf, byte(op))
+	buf = e.appendPath(buf, path)
+	return append(buf, body...)
+}
+
+func (e *Encoder) encode(op OperationType, path Path, body []byte) ([]byte, error) {
+	if err := validPath(path); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.appendOp(make([]byte, 0, 1+len(body)+8*len(path)), op, path, body), nil
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  PUBLIC API
+// ─────────────────────────────────────────────────────────────
+//
+
+// ─── SET ─────────────────────────────────────────────────────
+func (e *Encoder) EncodeSet(path Path, value []byte) ([]byte, error) {
+	return e.encode(OpSet, path, value)
+}
+
+// ─── DELETE ──────────────────────────────────────────────────
+func (e *Encoder) EncodeDelete(path Path) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("delete needs a path")
+	}
+	return e.encode(OpDelete, path, nil)
+}
+
+// ─── MERGE ───────────────────────────────────────────────────
+func (e *Encoder) EncodeMerge(path Path, patch []byte) ([]byte, error) {
+	return e.encode(OpMerge, path, patch)
+}
+
+// ─── ARRAY ───────────────────────────────────────────────────
+// op is a message of the array protocol, like protocol.Encoder output
+func (e *Encoder) EncodeArray(path Path, op []byte) ([]byte, error) {
+	if len(op) == 0 {
+		return nil, fmt.Errorf("empty array operation")
+	}
+	return e.encode(OpArray, path, op)
+}
@@ -1,9 +1,10 @@
 package protocol
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
 )
 
 //
@@ -16,7 +17,24 @@ import (
 //   00 = DELETE
 //   01 = UPDATE
 //   11 = INSERT
-//   10 = reserved
+//   10 = MOVE
+//
+// MOVE has no payload: after the position (or range) comes the target
+// position, with the same posSize. The elements are removed first and
+// inserted at the target position of the shortened array.
+//
+// A bulk INSERT with the partial bit set is compressed: no partial update
+// is an INSERT, so the bit is free there. After start and end the lengths
+// and payloads are raw DEFLATE up to the end of the message, see package
+// compress. Only clients with the deflate capability receive it.
+//
+// posSize / dataSize:
+//   00 = 0 bytes   01 = 1 byte   10 = 2 bytes
+//   11 = 3 bytes in V1, up to 16 MB
+//        uvarint in V2, any uint32
+//
+// The header has no room for the version, both sides agree on it once per
+// connection (see Negotiate). A client that offers nothing gets V1.
 //
 
 type OperationType byte
@@ -25,51 +43,43 @@ const (
 	OpDelete OperationType = 0b00
 	OpUpdate OperationType = 0b01
 	OpInsert OperationType = 0b11
+	OpMove   OperationType = 0b10
 )
 
-type Encoder struct{}
+// Version of the layout, only the meaning of size class 11 changes
+type Version uint8
 
-//
-// ─────────────────────────────────────────────────────────────
-//  INT ENCODING HELPERS
-// ─────────────────────────────────────────────────────────────
-//
-
-// v encoded using sizeIndicator bytes: 0–3 bytes
-func encodeIntWithSize(v uint32, sizeIndicator uint8) ([]byte, error) {
-	switch sizeIndicator {
-	case 0:
-		return []byte{}, nil
-
-	case 1:
-		if v > 0xFF {
-			return nil, fmt.Errorf("value %d too large for 1 byte", v)
-		}
-		return []byte{byte(v)}, nil
+const (
+	V1 Version = 1
+	V2 Version = 2
+)
 
-	case 2:
-		if v > 0xFFFF {
-			return nil, fmt.Errorf("value %d too large for 2 bytes", v)
-		}
-		out := make([]byte, 2)
-		binary.BigEndian.PutUint16(out, uint16(v))
-		return out, nil
+// Supported lists the versions this package encodes and decodes
+var Supported = []Version{V1, V2}
 
-	case 3:
-		if v > 0xFFFFFF {
-			return nil, fmt.Errorf("value %d too large for 3 bytes", v)
+// Negotiate picks the highest version offered by the client that this
+// package supports, V1 when the client offers none
+func Negotiate(offered ...Version) Version {
+	best := V1
+	for _, v := range offered {
+		if v > best && v <= V2 {
+			best = v
 		}
-		return []byte{
-			byte((v >> 16) & 0xFF),
-			byte((v >> 8) & 0xFF),
-			byte(v & 0xFF),
-		}, nil
-
-	default:
-		return nil, fmt.Errorf("invalid size indicator %d", sizeIndicator)
 	}
+	return best
+}
+
+// Encoder writes the V1 layout unless Version says otherwise
+type Encoder struct {
+	Version Version
 }
 
+//
+// ─────────────────────────────────────────────────────────────
+//  INT ENCODING HELPERS
+// ─────────────────────────────────────────────────────────────
+//
+
 // Minimal byte size for an integer
 func autoSizeIndicator(v uint32) uint8 {
 	switch {
@@ -87,6 +97,34 @@ func autoLengthIndicator(v uint32) uint8 {
 	return autoSizeIndicator(v)
 }
 
+// check fails when v does not fit the size class picked for it, so the
+// append helpers below never fail halfway through a message
+func (e *Encoder) check(v uint32) error {
+	if e.Version > V2 {
+		return fmt.Errorf("unsupported version %d", e.Version)
+	}
+	if e.Version != V2 && v > 0xFFFFFF {
+		return fmt.Errorf("value %d too large for 3 bytes", v)
+	}
+	return nil
+}
+
+// v appended using sizeIndicator bytes: 0–3 bytes, or uvarint for 3 in V2
+func (e *Encoder) appendIntWithSize(dst []byte, v uint32, sizeIndicator uint8) []byte {
+	switch sizeIndicator {
+	case 1:
+		return append(dst, byte(v))
+	case 2:
+		return binary.BigEndian.AppendUint16(dst, uint16(v))
+	case 3:
+		if e.Version == V2 {
+			return binary.AppendUvarint(dst, uint64(v))
+		}
+		return append(dst, byte(v>>16), byte(v>>8), byte(v))
+	}
+	return dst
+}
+
 //
 // ─────────────────────────────────────────────────────────────
 //  HEADER BUILDER — fixed bit layout
@@ -123,182 +161,199 @@ func buildHeader(op OperationType, bulk bool, partial bool, posSize, dataSize ui
 //  PUBLIC API
 // ─────────────────────────────────────────────────────────────
 //
+// Every EncodeX returns a new message. AppendX appends the same message to
+// dst and returns the extended slice; reusing dst between calls encodes
+// without allocating. On error dst is returned unchanged.
+//
 
 // ─── Single DELETE ───────────────────────────────────────────
 func (e *Encoder) EncodeDelete(pos uint32) ([]byte, error) {
+	return e.AppendDelete(nil, pos)
+}
 
+func (e *Encoder) AppendDelete(dst []byte, pos uint32) ([]byte, error) {
+	if err := e.check(pos); err != nil {
+		return dst, err
+	}
 	posSize := autoSizeIndicator(pos)
-	dataSize := uint8(0)
 
-	header := buildHeader(OpDelete, false, false, posSize, dataSize)
+	dst = append(dst, buildHeader(OpDelete, false, false, posSize, 0))
+	return e.appendIntWithSize(dst, pos, posSize), nil
+}
 
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
+// ─── Bulk DELETE (dense) ─────────────────────────────────────
+func (e *Encoder) EncodeDeleteRange(start, end uint32) ([]byte, error) {
+	return e.AppendDeleteRange(nil, start, end)
+}
 
-	encodedPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
+func (e *Encoder) AppendDeleteRange(dst []byte, start, end uint32) ([]byte, error) {
+	maxPos := max(start, end)
+	if err := e.check(maxPos); err != nil {
+		return dst, err
 	}
-	buf.Write(encodedPos)
+	posSize := autoSizeIndicator(maxPos)
 
-	return buf.Bytes(), nil
+	dst = append(dst, buildHeader(OpDelete, true, false, posSize, 0))
+	dst = e.appendIntWithSize(dst, start, posSize)
+	return e.appendIntWithSize(dst, end, posSize), nil
 }
 
-// ─── Bulk DELETE (dense) ─────────────────────────────────────
-func (e *Encoder) EncodeDeleteRange(start, end uint32) ([]byte, error) {
+// ─── Single MOVE ─────────────────────────────────────────────
+func (e *Encoder) EncodeMove(from, to uint32) ([]byte, error) {
+	return e.AppendMove(nil, from, to)
+}
+
+func (e *Encoder) AppendMove(dst []byte, from, to uint32) ([]byte, error) {
+	maxPos := max(from, to)
+	if err := e.check(maxPos); err != nil {
+		return dst, err
+	}
+	posSize := autoSizeIndicator(maxPos)
 
-	posSize := autoSizeIndicator(max(start, end))
-	dataSize := uint8(0)
+	dst = append(dst, buildHeader(OpMove, false, false, posSize, 0))
+	dst = e.appendIntWithSize(dst, from, posSize)
+	return e.appendIntWithSize(dst, to, posSize), nil
+}
 
-	header := buildHeader(OpDelete, true, false, posSize, dataSize)
+// ─── Bulk MOVE (dense) ───────────────────────────────────────
+func (e *Encoder) EncodeMoveRange(start, end, to uint32) ([]byte, error) {
+	return e.AppendMoveRange(nil, start, end, to)
+}
 
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
+func (e *Encoder) AppendMoveRange(dst []byte, start, end, to uint32) ([]byte, error) {
+	if end < start {
+		return dst, fmt.Errorf("range end %d before start %d", end, start)
+	}
 
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	maxPos := max(max(start, end), to)
+	if err := e.check(maxPos); err != nil {
+		return dst, err
+	}
+	posSize := autoSizeIndicator(maxPos)
 
-	return buf.Bytes(), nil
+	dst = append(dst, buildHeader(OpMove, true, false, posSize, 0))
+	dst = e.appendIntWithSize(dst, start, posSize)
+	dst = e.appendIntWithSize(dst, end, posSize)
+	return e.appendIntWithSize(dst, to, posSize), nil
 }
 
 // ─── Single INSERT (full) ────────────────────────────────────
 func (e *Encoder) EncodeInsert(pos uint32, data []byte) ([]byte, error) {
+	return e.AppendInsert(nil, pos, data)
+}
 
-	posSize := autoSizeIndicator(pos)
-	dataSize := autoLengthIndicator(uint32(len(data)))
-
-	header := buildHeader(OpInsert, false, false, posSize, dataSize)
+func (e *Encoder) AppendInsert(dst []byte, pos uint32, data []byte) ([]byte, error) {
+	return e.appendSingle(dst, OpInsert, false, pos, data)
+}
 
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
+// ─── Single UPDATE (full replace) ─────────────────────────────
+func (e *Encoder) EncodeUpdate(pos uint32, data []byte) ([]byte, error) {
+	return e.AppendUpdate(nil, pos, data)
+}
 
-	encPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encPos)
+func (e *Encoder) AppendUpdate(dst []byte, pos uint32, data []byte) ([]byte, error) {
+	return e.appendSingle(dst, OpUpdate, false, pos, data)
+}
 
-	encLen, err := encodeIntWithSize(uint32(len(data)), dataSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encLen)
+// ─── Bulk INSERT (dense) ─────────────────────────────────────
+func (e *Encoder) EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	return e.AppendInsertRange(nil, start, end, payloads)
+}
 
-	buf.Write(data)
+func (e *Encoder) AppendInsertRange(dst []byte, start, end uint32, payloads [][]byte) ([]byte, error) {
+	return e.appendDense(dst, OpInsert, start, end, payloads)
+}
 
-	return buf.Bytes(), nil
+// ─── Bulk UPDATE (dense) ─────────────────────────────────────
+func (e *Encoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byte, error) {
+	return e.AppendUpdateRange(nil, start, end, payloads)
 }
 
-// ─── Single UPDATE (full replace) ─────────────────────────────
-func (e *Encoder) EncodeUpdate(pos uint32, data []byte) ([]byte, error) {
+func (e *Encoder) AppendUpdateRange(dst []byte, start, end uint32, payloads [][]byte) ([]byte, error) {
+	return e.appendDense(dst, OpUpdate, start, end, payloads)
+}
 
+// single operation with one payload
+func (e *Encoder) appendSingle(dst []byte, op OperationType, partial bool, pos uint32, data []byte) ([]byte, error) {
+	if err := e.check(pos); err != nil {
+		return dst, err
+	}
+	if err := e.check(uint32(len(data))); err != nil {
+		return dst, err
+	}
 	posSize := autoSizeIndicator(pos)
 	dataSize := autoLengthIndicator(uint32(len(data)))
 
-	header := buildHeader(OpUpdate, false, false, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
-
-	encPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encPos)
+	dst = append(dst, buildHeader(op, false, partial, posSize, dataSize))
+	dst = e.appendIntWithSize(dst, pos, posSize)
+	dst = e.appendIntWithSize(dst, uint32(len(data)), dataSize)
+	return append(dst, data...), nil
+}
 
-	encLen, err := encodeIntWithSize(uint32(len(data)), dataSize)
+// bulk operation with one payload per position of the range
+func (e *Encoder) appendDense(dst []byte, op OperationType, start, end uint32, payloads [][]byte) ([]byte, error) {
+	posSize, dataSize, err := e.denseSizes(start, end, payloads)
 	if err != nil {
-		return nil, err
+		return dst, err
 	}
-	buf.Write(encLen)
-
-	buf.Write(data)
 
-	return buf.Bytes(), nil
+	dst = append(dst, buildHeader(op, true, false, posSize, dataSize))
+	dst = e.appendIntWithSize(dst, start, posSize)
+	dst = e.appendIntWithSize(dst, end, posSize)
+	return e.appendPayloads(dst, payloads, dataSize), nil
 }
 
-// ─── Bulk INSERT (dense) ─────────────────────────────────────
-func (e *Encoder) EncodeInsertRange(start, end uint32, payloads [][]byte) ([]byte, error) {
-
+// size classes of a bulk operation, checked against the version
+func (e *Encoder) denseSizes(start, end uint32, payloads [][]byte) (posSize, dataSize uint8, err error) {
 	if int(end-start)+1 != len(payloads) {
-		return nil, fmt.Errorf("payload count must match range size")
+		return 0, 0, fmt.Errorf("payload count must match range size")
 	}
 
 	maxPos := max(start, end)
-	posSize := autoSizeIndicator(maxPos)
-
 	var maxLen uint32
 	for _, p := range payloads {
 		if uint32(len(p)) > maxLen {
 			maxLen = uint32(len(p))
 		}
 	}
-	dataSize := autoLengthIndicator(maxLen)
-
-	header := buildHeader(OpInsert, true, false, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
-
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
-
-	for _, p := range payloads {
-		plen := uint32(len(p))
-		encLen, err := encodeIntWithSize(plen, dataSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encLen)
-		buf.Write(p)
+	if err := e.check(maxPos); err != nil {
+		return 0, 0, err
 	}
-
-	return buf.Bytes(), nil
-}
-
-// ─── Bulk UPDATE (dense) ─────────────────────────────────────
-func (e *Encoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byte, error) {
-
-	if int(end-start)+1 != len(payloads) {
-		return nil, fmt.Errorf("payload count must match range size")
+	if err := e.check(maxLen); err != nil {
+		return 0, 0, err
 	}
+	// the decoder wants a length byte per payload, even an empty one
+	return autoSizeIndicator(maxPos), autoLengthIndicator(max(maxLen, 1)), nil
+}
 
-	maxPos := max(start, end)
-	posSize := autoSizeIndicator(maxPos)
-
-	var maxLen uint32
+func (e *Encoder) appendPayloads(dst []byte, payloads [][]byte, dataSize uint8) []byte {
 	for _, p := range payloads {
-		if uint32(len(p)) > maxLen {
-			maxLen = uint32(len(p))
-		}
+		dst = e.appendIntWithSize(dst, uint32(len(p)), dataSize)
+		dst = append(dst, p...)
 	}
-	dataSize := autoLengthIndicator(maxLen)
+	return dst
+}
 
-	header := buildHeader(OpUpdate, true, false, posSize, dataSize)
+// ─── Compressed bulk INSERT ──────────────────────────────────
+func (e *Encoder) EncodeCompressedInsertRange(start, end uint32, payloads [][]byte, d *compress.Deflater) ([]byte, error) {
+	return e.AppendCompressedInsertRange(nil, start, end, payloads, d)
+}
 
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
+func (e *Encoder) AppendCompressedInsertRange(dst []byte, start, end uint32, payloads [][]byte, d *compress.Deflater) ([]byte, error) {
+	posSize, dataSize, err := e.denseSizes(start, end, payloads)
+	if err != nil {
+		return dst, err
+	}
 
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	n := len(dst)
+	dst = append(dst, buildHeader(OpInsert, true, true, posSize, dataSize))
+	dst = e.appendIntWithSize(dst, start, posSize)
+	dst = e.appendIntWithSize(dst, end, posSize)
 
-	for _, p := range payloads {
-		plen := uint32(len(p))
-		encLen, err := encodeIntWithSize(plen, dataSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encLen)
-		buf.Write(p)
+	if dst, err = d.AppendDeflate(dst, e.appendPayloads(nil, payloads, dataSize)); err != nil {
+		return dst[:n], err
 	}
-
-	return buf.Bytes(), nil
+	return dst, nil
 }
 
 //
@@ -309,30 +364,11 @@ func (e *Encoder) EncodeUpdateRange(start, end uint32, payloads [][]byte) ([]byt
 
 // Sparse partial update
 func (e *Encoder) EncodePartialUpdate(pos uint32, patch []byte) ([]byte, error) {
+	return e.AppendPartialUpdate(nil, pos, patch)
+}
 
-	posSize := autoSizeIndicator(pos)
-	dataSize := autoLengthIndicator(uint32(len(patch)))
-
-	header := buildHeader(OpUpdate, false, true, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
-
-	encPos, err := encodeIntWithSize(pos, posSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encPos)
-
-	encLen, err := encodeIntWithSize(uint32(len(patch)), dataSize)
-	if err != nil {
-		return nil, err
-	}
-	buf.Write(encLen)
-
-	buf.Write(patch)
-
-	return buf.Bytes(), nil
+func (e *Encoder) AppendPartialUpdate(dst []byte, pos uint32, patch []byte) ([]byte, error) {
+	return e.appendSingle(dst, OpUpdate, true, pos, patch)
 }
 
 // Patch entry for bulk sparse updates
@@ -343,6 +379,10 @@ type PartialPatch struct {
 
 // Bulk sparse partial update
 func (e *Encoder) EncodePartialUpdateRange(start, end uint32, patches []PartialPatch) ([]byte, error) {
+	return e.AppendPartialUpdateRange(nil, start, end, patches)
+}
+
+func (e *Encoder) AppendPartialUpdateRange(dst []byte, start, end uint32, patches []PartialPatch) ([]byte, error) {
 
 	// posSize must support both range & patch positions
 	maxPos := max(start, end)
@@ -351,7 +391,6 @@ func (e *Encoder) EncodePartialUpdateRange(start, end uint32, patches []PartialP
 			maxPos = p.Pos
 		}
 	}
-	posSize := autoSizeIndicator(maxPos)
 
 	// data size is max of all patches
 	var maxLen uint32
@@ -360,38 +399,28 @@ func (e *Encoder) EncodePartialUpdateRange(start, end uint32, patches []PartialP
 			maxLen = uint32(len(p.Data))
 		}
 	}
+	if err := e.check(maxPos); err != nil {
+		return dst, err
+	}
+	if err := e.check(maxLen); err != nil {
+		return dst, err
+	}
+	posSize := autoSizeIndicator(maxPos)
 	dataSize := autoLengthIndicator(maxLen)
 
-	header := buildHeader(OpUpdate, true, true, posSize, dataSize)
-
-	buf := bytes.Buffer{}
-	buf.WriteByte(header)
+	dst = append(dst, buildHeader(OpUpdate, true, true, posSize, dataSize))
 
 	// bulk range
-	encA, _ := encodeIntWithSize(start, posSize)
-	encB, _ := encodeIntWithSize(end, posSize)
-	buf.Write(encA)
-	buf.Write(encB)
+	dst = e.appendIntWithSize(dst, start, posSize)
+	dst = e.appendIntWithSize(dst, end, posSize)
 
 	// sparse patches
 	for _, p := range patches {
-		encPos, err := encodeIntWithSize(p.Pos, posSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encPos)
-
-		plen := uint32(len(p.Data))
-		encLen, err := encodeIntWithSize(plen, dataSize)
-		if err != nil {
-			return nil, err
-		}
-		buf.Write(encLen)
-
-		buf.Write(p.Data)
+		dst = e.appendIntWithSize(dst, p.Pos, posSize)
+		dst = e.appendIntWithSize(dst, uint32(len(p.Data)), dataSize)
+		dst = append(dst, p.Data...)
 	}
-
-	return buf.Bytes(), nil
+	return dst, nil
 }
 
 // Utility
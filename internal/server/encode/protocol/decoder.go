@@ -0,0 +1,516 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
+)
+
+//
+// ─────────────────────────────────────────────────────────────
+//  DECODED OPERATION
+// ─────────────────────────────────────────────────────────────
+//
+
+// Operation is one decoded message. Which fields are set depends on the
+// header flags:
+//
+//	single          Pos, Data (To instead of Data for MOVE)
+//	bulk            Start, End, Payloads (none for DELETE, To for MOVE)
+//	partial         Pos, Data (Data is the patch)
+//	bulk + partial  Start, End, Patches
+//
+// A compressed bulk INSERT is returned inflated, as a bulk INSERT with
+// Compressed set.
+type Operation struct {
+	Op         OperationType
+	Bulk       bool
+	Partial    bool
+	Compressed bool
+
+	PosSize  uint8
+	DataSize uint8
+
+	Pos      uint32
+	Start    uint32
+	End      uint32
+	To       uint32
+	Data     []byte
+	Payloads [][]byte
+	Patches  []PartialPatch
+}
+
+// Decoder reads the V1 layout unless Version says otherwise, it must match
+// the Encoder of the connection. Dict is the dictionary of the compressed
+// bulk inserts, if the server uses one.
+type Decoder struct {
+	Version Version
+	Dict    []byte
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  INT DECODING HELPERS
+// ─────────────────────────────────────────────────────────────
+//
+
+type reader struct {
+	data    []byte
+	offset  int
+	version Version
+}
+
+// v decoded from sizeIndicator bytes: 0–3 bytes, or uvarint for 3 in V2
+func (r *reader) readIntWithSize(sizeIndicator uint8) (uint32, error) {
+	if sizeIndicator > 3 {
+		return 0, fmt.Errorf("invalid size indicator %d", sizeIndicator)
+	}
+	if sizeIndicator == 3 && r.version == V2 {
+		v, n := binary.Uvarint(r.data[r.offset:])
+		if n <= 0 || v > math.MaxUint32 {
+			return 0, fmt.Errorf("invalid uvarint at %d", r.offset)
+		}
+		r.offset += n
+		return uint32(v), nil
+	}
+	n := int(sizeIndicator)
+	if r.offset+n > len(r.data) {
+		return 0, fmt.Errorf("unexpected end of data reading %d bytes at %d", n, r.offset)
+	}
+
+	var v uint32
+	for i := 0; i < n; i++ {
+		v = v<<8 | uint32(r.data[r.offset+i])
+	}
+	r.offset += n
+	return v, nil
+}
+
+func (r *reader) readPayload(sizeIndicator uint8) ([]byte, error) {
+	l, err := r.readIntWithSize(sizeIndicator)
+	if err != nil {
+		return nil, err
+	}
+	if r.offset+int(l) > len(r.data) {
+		return nil, fmt.Errorf("payload length %d exceeds data at %d", l, r.offset)
+	}
+	p := r.data[r.offset : r.offset+int(l)]
+	r.offset += int(l)
+	return p, nil
+}
+
+func (r *reader) done() bool {
+	return r.offset >= len(r.data)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  HEADER PARSER — fixed bit layout
+// ─────────────────────────────────────────────────────────────
+//
+
+func parseHeader(header byte) (op OperationType, bulk bool, partial bool, posSize, dataSize uint8) {
+	op = OperationType(header & 0b11)
+	posSize = (header >> 2) & 0b11
+	dataSize = (header >> 4) & 0b11
+	partial = header&(1<<6) != 0
+	bulk = header&(1<<7) != 0
+	return
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  PUBLIC API
+// ─────────────────────────────────────────────────────────────
+//
+
+// Decode parses one encoded operation. Like the JS decoder, the partial
+// flag turns any operation into a partial update, except a bulk INSERT that
+// it marks as compressed.
+func (d *Decoder) Decode(data []byte) (Operation, error) {
+	if len(data) == 0 {
+		return Operation{}, errors.New("empty data")
+	}
+	if d.Version > V2 {
+		return Operation{}, fmt.Errorf("unsupported version %d", d.Version)
+	}
+
+	var op Operation
+	op.Op, op.Bulk, op.Partial, op.PosSize, op.DataSize = parseHeader(data[0])
+
+	r := &reader{data: data, offset: 1, version: d.Version}
+	var err error
+
+	// ─── Single operations ───────────────────────────────────
+	if !op.Bulk {
+		if op.Pos, err = r.readIntWithSize(op.PosSize); err != nil {
+			return op, err
+		}
+
+		if !op.Partial && op.Op == OpDelete {
+			return op, r.end()
+		}
+		if !op.Partial && op.Op == OpMove {
+			if op.To, err = r.readIntWithSize(op.PosSize); err != nil {
+				return op, err
+			}
+			return op, r.end()
+		}
+		if !op.Partial && op.Op != OpUpdate && op.Op != OpInsert {
+			return op, fmt.Errorf("reserved operation %02b", op.Op)
+		}
+
+		if op.Data, err = r.readPayload(op.DataSize); err != nil {
+			return op, err
+		}
+		return op, r.end()
+	}
+
+	// ─── Bulk operations ─────────────────────────────────────
+	if op.Start, err = r.readIntWithSize(op.PosSize); err != nil {
+		return op, err
+	}
+	if op.End, err = r.readIntWithSize(op.PosSize); err != nil {
+		return op, err
+	}
+
+	if op.Partial && op.Op == OpInsert {
+		if op.End < op.Start {
+			return op, fmt.Errorf("range end %d before start %d", op.End, op.Start)
+		}
+		plain, err := compress.Inflate(data[r.offset:], d.Dict, compress.MaxInflated)
+		if err != nil {
+			return op, err
+		}
+		op.Partial, op.Compressed = false, true
+		r = &reader{data: plain, version: d.Version}
+		return op, r.readPayloads(&op)
+	}
+
+	if op.Partial {
+		for !r.done() {
+			// Sizes of 0 read nothing, the loop would never end
+			start := r.offset
+			var p PartialPatch
+			if p.Pos, err = r.readIntWithSize(op.PosSize); err != nil {
+				return op, err
+			}
+			if p.Data, err = r.readPayload(op.DataSize); err != nil {
+				return op, err
+			}
+			if r.offset == start {
+				return op, fmt.Errorf("partial patch of 0 bytes at %d", start)
+			}
+			op.Patches = append(op.Patches, p)
+		}
+		return op, nil
+	}
+
+	if op.End < op.Start {
+		return op, fmt.Errorf("range end %d before start %d", op.End, op.Start)
+	}
+
+	switch op.Op {
+	case OpDelete:
+		return op, r.end()
+	case OpMove:
+		if op.To, err = r.readIntWithSize(op.PosSize); err != nil {
+			return op, err
+		}
+		return op, r.end()
+	case OpUpdate, OpInsert:
+	default:
+		return op, fmt.Errorf("reserved operation %02b", op.Op)
+	}
+	return op, r.readPayloads(&op)
+}
+
+// readPayloads reads one payload per position of the range, up to the end.
+// Every payload takes at least one byte of length, so the range can not
+// ask for more payloads than there are bytes left.
+func (r *reader) readPayloads(op *Operation) error {
+	count := int(op.End-op.Start) + 1
+	if op.DataSize == 0 {
+		return fmt.Errorf("bulk payloads without length at %d", r.offset)
+	}
+	if count > len(r.data)-r.offset {
+		return fmt.Errorf("%d payloads exceed the %d bytes left", count, len(r.data)-r.offset)
+	}
+	op.Payloads = make([][]byte, 0, min(count, len(r.data)))
+	for i := 0; i < count; i++ {
+		p, err := r.readPayload(op.DataSize)
+		if err != nil {
+			return err
+		}
+		op.Payloads = append(op.Payloads, p)
+	}
+	return r.end()
+}
+
+func (r *reader) end() error {
+	if !r.done() {
+		return fmt.Errorf("%d trailing bytes", len(r.data)-r.offset)
+	}
+	return nil
+}
+
+// DecodeApply decodes the data and applies it to the target
+func (d *Decoder) DecodeApply(data []byte, target []json.RawMessage) ([]json.RawMessage, error) {
+	op, err := d.Decode(data)
+	if err != nil {
+		return target, err
+	}
+	return Apply(op, target)
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  APPLY — same semantics as web/lib/ArrayDecodeProtocol.js
+// ─────────────────────────────────────────────────────────────
+//
+
+var jsonNull = json.RawMessage("null")
+
+// MaxGrowth is how far beyond the end of the slice a position may be, the
+// gap is filled with holes
+const MaxGrowth = 1 << 16
+
+// Apply runs the operation on a slice of JSON values. Positions beyond the
+// end grow the slice with nulls, like holes in a JS array, up to MaxGrowth. Partial patches
+// merge the keys of a JSON object into the current object and replace any
+// other value.
+func Apply(op Operation, target []json.RawMessage) ([]json.RawMessage, error) {
+	return apply(op, target, jsonNull,
+		func(data []byte) (json.RawMessage, error) {
+			if !json.Valid(data) {
+				return nil, fmt.Errorf("invalid JSON payload %q", data)
+			}
+			return json.RawMessage(data), nil
+		},
+		mergeJSON,
+	)
+}
+
+// ApplyTo runs the operation on a slice of Go values, unmarshaling every
+// payload into T. Partial patches are unmarshaled over the current value,
+// so only the fields present in the patch change.
+func ApplyTo[T any](op Operation, target []T) ([]T, error) {
+	return ApplyCodec(op, target, codec.JSON)
+}
+
+// ApplyCodec is ApplyTo for payloads encoded with the codec
+func ApplyCodec[T any](op Operation, target []T, c codec.Codec) ([]T, error) {
+	var zero T
+	return apply(op, target, zero,
+		func(data []byte) (T, error) {
+			var v T
+			err := c.Unmarshal(data, &v)
+			return v, err
+		},
+		func(current T, patch []byte) (T, error) {
+			err := c.Unmarshal(patch, &current)
+			return current, err
+		},
+	)
+}
+
+// ApplyValues runs the operation on decoded values (map[string]any, []any,
+// ...). Partial patches merge the keys of an object into the current object
+// and replace any other value, like Apply.
+func ApplyValues(op Operation, target []any, c codec.Codec) ([]any, error) {
+	return apply(op, target, nil,
+		func(data []byte) (any, error) {
+			var v any
+			err := c.Unmarshal(data, &v)
+			return v, err
+		},
+		func(current any, patch []byte) (any, error) {
+			var p any
+			if err := c.Unmarshal(patch, &p); err != nil {
+				return current, err
+			}
+			cur, ok := current.(map[string]any)
+			obj, isObj := p.(map[string]any)
+			if !ok || !isObj {
+				return p, nil
+			}
+			for k, v := range obj {
+				cur[k] = v
+			}
+			return cur, nil
+		},
+	)
+}
+
+func apply[T any](op Operation, target []T, hole T, decode func([]byte) (T, error), patch func(T, []byte) (T, error)) ([]T, error) {
+	grow := func(size int) error {
+		if size-len(target) > MaxGrowth {
+			return fmt.Errorf("position %d beyond the end %d", size-1, len(target))
+		}
+		if size > len(target) {
+			target = slices.Grow(target, size-len(target))
+			for len(target) < size {
+				target = append(target, hole)
+			}
+		}
+		return nil
+	}
+
+	patchAt := func(pos uint32, data []byte) error {
+		if err := grow(int(pos) + 1); err != nil {
+			return err
+		}
+		v, err := patch(target[pos], data)
+		if err != nil {
+			return err
+		}
+		target[pos] = v
+		return nil
+	}
+
+	// ─── Partial updates ─────────────────────────────────────
+	if op.Partial {
+		if !op.Bulk {
+			return target, patchAt(op.Pos, op.Data)
+		}
+		for _, p := range op.Patches {
+			if err := patchAt(p.Pos, p.Data); err != nil {
+				return target, err
+			}
+		}
+		return target, nil
+	}
+
+	// ─── Single operations ───────────────────────────────────
+	if !op.Bulk {
+		switch op.Op {
+		case OpDelete:
+			if int(op.Pos) < len(target) {
+				target = append(target[:op.Pos], target[op.Pos+1:]...)
+			}
+			return target, nil
+
+		case OpUpdate:
+			v, err := decode(op.Data)
+			if err != nil {
+				return target, err
+			}
+			if err := grow(int(op.Pos) + 1); err != nil {
+				return target, err
+			}
+			target[op.Pos] = v
+			return target, nil
+
+		case OpInsert:
+			v, err := decode(op.Data)
+			if err != nil {
+				return target, err
+			}
+			if err := grow(int(op.Pos)); err != nil {
+				return target, err
+			}
+			target = append(target, hole)
+			copy(target[op.Pos+1:], target[op.Pos:])
+			target[op.Pos] = v
+			return target, nil
+
+		case OpMove:
+			return move(target, int(op.Pos), int(op.Pos)+1, int(op.To)), nil
+		}
+		return target, fmt.Errorf("reserved operation %02b", op.Op)
+	}
+
+	// ─── Bulk operations ─────────────────────────────────────
+	switch op.Op {
+	case OpDelete:
+		start, end := int(op.Start), int(op.End)+1
+		if start >= len(target) {
+			return target, nil
+		}
+		end = min(end, len(target))
+		return append(target[:start], target[end:]...), nil
+
+	case OpMove:
+		return move(target, int(op.Start), int(op.End)+1, int(op.To)), nil
+
+	case OpUpdate:
+		values, err := decodeAll(op.Payloads, decode)
+		if err != nil {
+			return target, err
+		}
+		if err := grow(int(op.End) + 1); err != nil {
+			return target, err
+		}
+		copy(target[op.Start:], values)
+		return target, nil
+
+	case OpInsert:
+		values, err := decodeAll(op.Payloads, decode)
+		if err != nil {
+			return target, err
+		}
+		if err := grow(int(op.Start)); err != nil {
+			return target, err
+		}
+		tail := append([]T(nil), target[op.Start:]...)
+		target = append(append(target[:op.Start], values...), tail...)
+		return target, nil
+	}
+	return target, fmt.Errorf("reserved operation %02b", op.Op)
+}
+
+// move takes target[start:end] out and inserts it at to, clamped to the
+// end of the shortened slice. Elements past the end are ignored.
+func move[T any](target []T, start, end, to int) []T {
+	if start >= len(target) {
+		return target
+	}
+	end = min(end, len(target))
+
+	block := append([]T(nil), target[start:end]...)
+	target = append(target[:start], target[end:]...)
+	to = min(to, len(target))
+
+	tail := append([]T(nil), target[to:]...)
+	return append(append(target[:to], block...), tail...)
+}
+
+func decodeAll[T any](payloads [][]byte, decode func([]byte) (T, error)) ([]T, error) {
+	values := make([]T, len(payloads))
+	for i, p := range payloads {
+		v, err := decode(p)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// mergeJSON merges the keys of patch into current when both are objects,
+// otherwise the patch replaces the current value
+func mergeJSON(current json.RawMessage, patch []byte) (json.RawMessage, error) {
+	var patchObj map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &patchObj); err != nil || patchObj == nil {
+		if !json.Valid(patch) {
+			return nil, fmt.Errorf("invalid JSON patch %q", patch)
+		}
+		return json.RawMessage(patch), nil
+	}
+
+	var currentObj map[string]json.RawMessage
+	if err := json.Unmarshal(current, &currentObj); err != nil || currentObj == nil {
+		return json.RawMessage(patch), nil
+	}
+
+	for k, v := range patchObj {
+		currentObj[k] = v
+	}
+	return json.Marshal(currentObj)
+}
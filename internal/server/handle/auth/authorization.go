@@ -5,6 +5,12 @@ import (
 	"strings"
 )
 
+// Identify returns the id of the authenticated user, used as presence
+// member. The default knows no users: every connection is its own member.
+var Identify = func(r *http.Request) string {
+	return ""
+}
+
 func Check(r *http.Request) bool {
 	authHeader := r.Header.Get("Authorization")
 
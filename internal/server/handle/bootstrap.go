@@ -2,6 +2,7 @@ package handle
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
@@ -11,8 +12,21 @@ var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin:     func(r *http.Request) bool { return true }, // permitir qualquer origem
+	// permessage-deflate, used per message above types.Compression.Threshold
+	EnableCompression: true,
 }
 
+const (
+	// Time allowed to write a control message to the client
+	writeWait = 10 * time.Second
+
+	// Send pings to the client with this period
+	pingPeriod = 30 * time.Second
+
+	// Connections with presence and no pong for this long are closed
+	PresenceTimeout = 3 * pingPeriod
+)
+
 type PID uint8
 
 type ProcessorQueue map[PID]types.ClientOutput
@@ -0,0 +1,69 @@
+package handle
+
+import (
+	"log"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+//
+// A client shows a business by subscribing to state.ViewTopic(business).
+// It is sent the current values on subscription, and the shared values
+// changed by the other clients as they change. Only the connections of this
+// server are reached, the shared values live in its memory.
+//
+
+func init() {
+	if err := topics.Register(topics.Topic(state.ViewTopic("{business}")), viewState); err != nil {
+		panic(err)
+	}
+	state.Broadcast = broadcastState
+}
+
+// viewState pushes every value of the business to the subscribing client
+func viewState(in *types.ClientInputInterface, params topics.Params) *types.ClientOutput {
+	input := (*in).(*subscribe.ClientInputSubscription)
+	vars := state.Vars(params["business"])
+	if len(vars) == 0 {
+		return &types.ClientOutput{
+			MsgType:     types.WSTypeErrorOutputMessage,
+			Destination: input.Topic,
+			Data:        "business without state",
+		}
+	}
+
+	input.WSConn.Scope().MarkDirty(vars...)
+	if err := input.WSConn.FlushState(); err != nil {
+		log.Println("Error pushing the state:", err)
+	}
+	return nil
+}
+
+// broadcastState pushes shared values to the clients viewing their
+// business, but the one that wrote them, in one flush per client
+func broadcastState(vars []state.Var, origin *state.Scope) {
+	var clients []*types.WebSocketConnection
+	seen := make(map[*types.WebSocketConnection]bool)
+	for _, v := range vars {
+		for _, wsc := range broker.Default.Subscribers(topics.Topic(state.ViewTopic(v.Business()))) {
+			scope := wsc.Scope()
+			if scope == origin {
+				continue
+			}
+			scope.MarkDirty(v)
+			if !seen[wsc] {
+				seen[wsc] = true
+				clients = append(clients, wsc)
+			}
+		}
+	}
+	for _, wsc := range clients {
+		if err := wsc.FlushState(); err != nil {
+			log.Println("Error pushing the state:", err)
+		}
+	}
+}
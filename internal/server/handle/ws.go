@@ -1,46 +1,94 @@
 package handle
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle/auth"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/envelope"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc/procedures"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
 )
 
-var connections = make(map[*types.WebSocketConnection]bool)
+var (
+	connectionsMu sync.Mutex
+	connections   = make(map[*types.WebSocketConnection]bool)
+)
 
 func WS(w http.ResponseWriter, r *http.Request) {
 	if !auth.Check(r) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
-	c, err := upgrader.Upgrade(w, r, nil)
+	// The client offers its payload codecs, or the text transport, as
+	// subprotocols
+	transport, payloads, protocol := types.FromSubprotocols(websocket.Subprotocols(r))
+	var responseHeader http.Header
+	if protocol != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {protocol}}
+	}
+
+	c, err := upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 	defer c.Close()
+	if err := c.SetCompressionLevel(types.Compression.Level); err != nil {
+		log.Println("Invalid compression level:", err)
+	}
 
 	wsc := &types.WebSocketConnection{
-		Conn: c,
+		Conn:      c,
+		Id:        auth.Identify(r),
+		Codec:     payloads,
+		Transport: transport,
 	}
 
+	connectionsMu.Lock()
 	connections[wsc] = true
+	connectionsMu.Unlock()
+
+	defer func() {
+		connectionsMu.Lock()
+		delete(connections, wsc)
+		connectionsMu.Unlock()
+
+		broker.RemoveConnection(wsc)
+	}()
+
+	// Heartbeat: pongs keep the presence of the connection alive
+	c.SetPongHandler(func(string) error {
+		broker.Touch(wsc)
+		return nil
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go ping(wsc, done)
+
+	// Only the first frame may be a HELLO
+	first := true
 
-	var message string
 loop:
 	for {
 		msgType, msg, err := wsc.Conn.ReadMessage()
 		log.Println("Msg received...")
+		broker.Touch(wsc)
 		if err != nil {
 			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
-				delete(connections, wsc)
-
 				log.Println("Client closed the connection")
 				break
 			} else {
@@ -50,21 +98,22 @@ loop:
 		}
 
 		switch msgType {
-		//Method not supported
 		case websocket.TextMessage:
-			message = string(msg)
-
-			output := shared.ClientOutput{
-				MsgType:     shared.WSTypeErrorOutputMessage,
-				Destination: string(shared.WSDestinationUnknown),
-				Data:        "Websocket TextMessage not yeat supported. Use websocket BinaryMessage mode",
+			if wsc.Transport != types.TransportText {
+				sendError(wsc, 0, string(types.WSDestinationUnknown), "text frames need the "+types.TextSubprotocol+" subprotocol")
+				continue
 			}
-			//we need a specific binary unmarshal code
-			wsc.Write(websocket.TextMessage, []byte(output.Marshal()))
-			continue
+			// The JSON envelope is handled as the binary frame it stands for
+			frame, err := textFrame(wsc, msg)
+			if err != nil {
+				continue
+			}
+			msg = frame
 		case websocket.BinaryMessage:
-			// Handle the message in a separate goroutine
-			go handleMessage(wsc, msg)
+			if wsc.Transport == types.TransportText {
+				sendError(wsc, requestId(msg), string(types.WSDestinationUnknown), "binary frames are not read on a text connection")
+				continue
+			}
 		case websocket.CloseMessage:
 			//TODO
 			//ADD CONTEXT AND SEND KILL SIGNAL TO GO ROUTINE
@@ -75,37 +124,68 @@ loop:
 		case websocket.PongMessage:
 			continue
 		}
+
+		// The handshake is read in line, every frame after it is handled
+		// with the session it agreed on
+		if len(msg) > 0 && types.WSConnType(msg[0]) == types.WSConnHello {
+			if !handleHello(wsc, msg, first) {
+				return
+			}
+			first = false
+			continue
+		}
+		first = false
+		// Handle the message in a separate goroutine
+		go handleMessage(wsc, msg)
 	}
 	log.Println("End of EntryConnections...")
 }
 
+// ping sends a ping every pingPeriod until done is closed
+func ping(wsc *types.WebSocketConnection, done chan struct{}) {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := wsc.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
 func handleMessage(wsc *types.WebSocketConnection, message []byte) {
 
 	log.Println("Start of handleMessage...")
 
-	switch message[0] {
+	switch types.WSConnType(message[0]) {
 	//SUBSCRIBE
-	case 1:
+	case types.WSConnSubscribe:
 		log.Println("Received message type SUBSCRIBE")
 		input := subscribe.ClientInputSubscription{
 			WSConn: wsc,
 		}
-		input.Unmarshal(message)
-		//
+		handleSubscription(&input, message)
+	//UNSUBSCRIBE
+	case types.WSConnUnsubscribe:
+		log.Println("Received message type UNSUBSCRIBE")
+		input := subscribe.ClientInputSubscription{
+			WSConn: wsc,
+		}
+		handleUnsubscription(&input, message)
 	//RPC
-	case 2:
+	case types.WSConnRPC:
 		log.Println("Received message type RPC")
 		input := rpc.ClientInputRPC{
 			WSConn: wsc,
 		}
-		input.Unmarshal(message)
-		//Check if class exists
-		//Check if method exists
-		//Execute
-		//Get the result
-		//Return the response
+		handleRPC(&input, message)
 	//ENDPOINT
-	case 3:
+	case types.WSConnEndpoint:
 		log.Println("Received message type ENDPOINT")
 		input := rest.ClientInputRest{
 			WSConn: wsc,
@@ -118,19 +198,273 @@ func handleMessage(wsc *types.WebSocketConnection, message []byte) {
 	default:
 		msg := "Unknown message type"
 		log.Println(msg)
-		output := types.ClientOutput{
+		writeOutput(wsc, types.ClientOutput{
 			ReqId:   message[0],
 			MsgType: types.WSTypeErrorOutputMessage,
 			Data:    msg,
+		})
+		return
+	}
+	log.Println("End of handleMessage...")
+}
+
+// handleHello agrees on the session of the connection. A client that
+// cannot be served is told why in the close frame, false is returned once
+// the connection is closed.
+func handleHello(wsc *types.WebSocketConnection, message []byte, first bool) bool {
+	input := hello.ClientInputHello{WSConn: wsc}
+	if err := input.Unmarshal(message); err != nil {
+		return refuse(wsc, "invalid hello: "+err.Error())
+	}
+	if !first {
+		sendError(wsc, *input.ReqId, hello.Destination, "hello must be the first frame")
+		return true
+	}
+
+	session, err := input.Negotiate(types.ServerCapabilities)
+	if err != nil {
+		return refuse(wsc, err.Error())
+	}
+	wsc.Session = session
+	writeOutput(wsc, input.WelcomeOutput(session))
+	return true
+}
+
+// refuse closes the connection with a protocol error and the reason
+func refuse(wsc *types.WebSocketConnection, reason string) bool {
+	log.Println("Refusing client:", reason)
+	// A close frame carries at most 123 bytes of reason
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseProtocolError, reason)
+	wsc.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	return false
+}
+
+func handleSubscription(input *subscribe.ClientInputSubscription, message []byte) {
+	if err := input.Unmarshal(message); err != nil {
+		sendError(input.WSConn, requestId(message), string(types.WSDestinationUnknown), err.Error())
+		return
+	}
+
+	topic := topics.Topic(input.Topic)
+	if topics.IsPattern(topic) {
+		sendError(input.WSConn, *input.ReqId, input.Topic, "wildcards are not allowed in subscriptions")
+		return
+	}
+	if broker.IsPresenceTopic(topic) {
+		handlePresenceSubscription(input, topic)
+		return
+	}
+
+	if err := input.IsValidMessage(); err != nil {
+		sendError(input.WSConn, *input.ReqId, input.Topic, err.Error())
+		return
+	}
+
+	var clientInput types.ClientInputInterface = input
+	output := topics.Exec(topic, &clientInput)
+	if output != nil && output.MsgType == types.WSTypeErrorOutputMessage {
+		output.ReqId = *input.ReqId
+		writeOutput(input.WSConn, *output)
+		return
+	}
+
+	if _, err := broker.Subscribe(input.WSConn, topics.Topic(input.Topic)); err != nil {
+		sendError(input.WSConn, *input.ReqId, input.Topic, err.Error())
+		return
+	}
+
+	if output == nil {
+		output = &types.ClientOutput{
+			MsgType:     types.WSTypeSuccessOutputMessage,
+			Destination: input.Topic,
 		}
+	}
+	output.ReqId = *input.ReqId
+	writeOutput(input.WSConn, *output)
 
-		data, err := output.Marshal()
-		if err != nil {
-			log.Println("Error to marshal the output unknown message type error")
+	var since uint64
+	if v, ok := input.Header[broker.HeaderSince]; ok {
+		since, _ = strconv.ParseUint(v, 10, 64)
+	}
+	if _, err := broker.Replay(input.WSConn, topic, since); err != nil {
+		log.Println("Error replaying retained messages:", err)
+	}
+
+	if broker.HasPresence(topic) {
+		member := broker.MemberFromHeader(input.Header, broker.MemberId(input.WSConn))
+		broker.Join(input.WSConn, topic, member)
+	}
+}
+
+// handlePresenceSubscription subscribes to the presence diffs of a topic
+// and sends its current members
+func handlePresenceSubscription(input *subscribe.ClientInputSubscription, topic topics.Topic) {
+	tracked := topics.Topic(strings.TrimPrefix(string(topic), broker.PresencePrefix))
+	if !broker.HasPresence(tracked) {
+		sendError(input.WSConn, *input.ReqId, input.Topic, "presence not tracked on topic")
+		return
+	}
+
+	if _, err := broker.Subscribe(input.WSConn, topic); err != nil {
+		sendError(input.WSConn, *input.ReqId, input.Topic, err.Error())
+		return
+	}
+
+	writeOutput(input.WSConn, types.ClientOutput{
+		ReqId:       *input.ReqId,
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: input.Topic,
+	})
+
+	if err := broker.SendPresence(input.WSConn, tracked); err != nil {
+		log.Println("Error sending presence:", err)
+	}
+}
+
+// handleRPC runs the procedure, pushes the state it changed and then
+// answers, so the client has the new values when the call resolves
+func handleRPC(input *rpc.ClientInputRPC, message []byte) {
+	if err := input.Unmarshal(message); err != nil {
+		sendError(input.WSConn, requestId(message), string(types.WSDestinationUnknown), err.Error())
+		return
+	}
+	if input.Method == state.SyncMethod {
+		handleSync(input)
+		return
+	}
+	if input.Method == state.OpsMethod {
+		handleOps(input)
+		return
+	}
+	if err := input.IsValidMessage(); err != nil {
+		sendError(input.WSConn, *input.ReqId, input.Class, err.Error())
+		return
+	}
+
+	var clientInput types.ClientInputInterface = input
+	output := procedures.Exec(procedures.Class(input.Class), procedures.Method(input.Method), &clientInput)
+
+	if err := input.WSConn.FlushState(); err != nil {
+		log.Println("Error pushing the state:", err)
+	}
+
+	if output == nil {
+		output = &types.ClientOutput{
+			MsgType:     types.WSTypeSuccessOutputMessage,
+			Destination: input.Class,
 		}
+	}
+	output.ReqId = *input.ReqId
+	writeOutput(input.WSConn, *output)
+}
 
-		wsc.Write(websocket.BinaryMessage, data)
+// handleSync applies the global values written by the client and answers
+// their new versions. The error tells it to roll them back, a conflict
+// carries the current values to show instead.
+func handleSync(input *rpc.ClientInputRPC) {
+	wsc := input.WSConn
+	versions, err := wsc.Scope().Sync(input.Class, input.Params, wsc.PayloadCodec())
+	var conflict *state.ConflictError
+	if errors.As(err, &conflict) {
+		log.Println(err)
+		writeOutput(wsc, types.ClientOutput{
+			ReqId:       *input.ReqId,
+			MsgType:     types.WSTypeErrorOutputMessage,
+			Destination: input.Class,
+			Data: map[string]any{
+				"error":    err.Error(),
+				"values":   conflict.Values,
+				"versions": conflict.Versions,
+			},
+		})
 		return
 	}
-	log.Println("End of handleMessage...")
+	if err != nil {
+		sendError(wsc, *input.ReqId, input.Class, err.Error())
+		return
+	}
+	writeOutput(wsc, types.ClientOutput{
+		ReqId:       *input.ReqId,
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: input.Class,
+		Data:        map[string]any{"versions": versions},
+	})
+}
+
+// handleOps merges the operations of the replicated values edited by the
+// client. The client gets them back with the push before the answer, with
+// those of the others it did not have.
+func handleOps(input *rpc.ClientInputRPC) {
+	wsc := input.WSConn
+	if err := wsc.Scope().ApplyOps(input.Class, input.Params, wsc.PayloadCodec()); err != nil {
+		sendError(wsc, *input.ReqId, input.Class, err.Error())
+		return
+	}
+	if err := wsc.FlushState(); err != nil {
+		log.Println("Error pushing the state:", err)
+	}
+	writeOutput(wsc, types.ClientOutput{
+		ReqId:       *input.ReqId,
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: input.Class,
+	})
+}
+
+func handleUnsubscription(input *subscribe.ClientInputSubscription, message []byte) {
+	if err := input.Unmarshal(message); err != nil {
+		sendError(input.WSConn, requestId(message), string(types.WSDestinationUnknown), err.Error())
+		return
+	}
+
+	if !broker.Unsubscribe(input.WSConn, topics.Topic(input.Topic)) {
+		sendError(input.WSConn, *input.ReqId, input.Topic, "not subscribed")
+		return
+	}
+
+	writeOutput(input.WSConn, types.ClientOutput{
+		ReqId:       *input.ReqId,
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: input.Topic,
+	})
+}
+
+// requestId reads the reqId byte of a frame that could not be unmarshaled
+func requestId(message []byte) uint8 {
+	if len(message) < 2 {
+		return 0
+	}
+	return message[1]
+}
+
+func sendError(wsc *types.WebSocketConnection, reqId uint8, destination string, msg string) {
+	log.Println(msg)
+	writeOutput(wsc, types.ClientOutput{
+		ReqId:       reqId,
+		MsgType:     types.WSTypeErrorOutputMessage,
+		Destination: destination,
+		Data:        msg,
+	})
+}
+
+func writeOutput(wsc *types.WebSocketConnection, output types.ClientOutput) {
+	if err := wsc.WriteOutput(output); err != nil {
+		log.Println("Error to write the output:", err)
+	}
+}
+
+// textFrame converts the JSON envelope of a text frame into its binary
+// frame, answering the error when it cannot
+func textFrame(wsc *types.WebSocketConnection, message []byte) ([]byte, error) {
+	e, err := envelope.Unmarshal(message)
+	if err == nil {
+		var frame []byte
+		if frame, err = e.Frame(); err == nil {
+			return frame, nil
+		}
+	}
+	sendError(wsc, e.ReqId, string(types.WSDestinationUnknown), err.Error())
+	return nil, err
 }
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"errors"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+)
+
+//
+// A computed value is derived from other values of the scope:
+//
+//   var PasswordsMatch = state.NewComputed("signup", "PasswordsMatch", func(s *state.Scope) bool {
+//       return Password.Get(s) == ConfirmPassword.Get(s)
+//   })
+//
+// The values read by fn are its dependencies, recorded on every run. The
+// result is cached in the scope and computed again only when read after
+// one of them changed. A scope pushes a computed value once its client has
+// it (read in the scope, or sent on view), and only when the result is
+// different from what the client has.
+//
+
+// computedEntry is the cached result in a scope, with the versions of the
+// values it was computed from
+type computedEntry struct {
+	value any
+	deps  map[Var]uint64
+}
+
+// Computed is a read only value derived from other values. fn must only
+// read values through the scope it is given, writes panic.
+type Computed[T any] struct {
+	business string
+	name     string
+	fn       func(*Scope) T
+}
+
+// NewComputed registers a value of the business computed by fn. It panics
+// when the name is already registered.
+func NewComputed[T any](business, name string, fn func(*Scope) T) *Computed[T] {
+	c := &Computed[T]{business: business, name: name, fn: fn}
+	register(c)
+	return c
+}
+
+func (v *Computed[T]) Business() string { return v.business }
+func (v *Computed[T]) Name() string     { return v.name }
+
+func (v *Computed[T]) Path() pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(v.business), pathprotocol.Key(v.name)}
+}
+
+func (v *Computed[T]) isShared() bool { return false }
+
+func (v *Computed[T]) initial() any {
+	var zero T
+	return zero
+}
+
+// Get returns the value for the scope, computed again when a dependency
+// changed since the last run
+func (v *Computed[T]) Get(s *Scope) T {
+	return s.computed(v, func(view *Scope) any { return v.fn(view) }).(T)
+}
+
+func (v *Computed[T]) read(s *Scope) (any, uint64, bool) {
+	return s.computed(v, func(view *Scope) any { return v.fn(view) }), 0, false
+}
+
+func (v *Computed[T]) convert(codec.Codec, any) (any, error) {
+	return nil, errors.New("computed values are read only")
+}
+
+func (v *Computed[T]) appendTo(b *pathprotocol.Batch, _ diff.Encoder, c codec.Codec, sent, current any) error {
+	if sent != nil && !changed(sent, current) {
+		return nil
+	}
+	data, err := c.Marshal(current)
+	if err != nil {
+		return err
+	}
+	b.Set(v.Path(), data)
+	return nil
+}
+
+// computed returns the cached result of v in the scope, running compute
+// with a recording scope when it is missing or a dependency changed. Read
+// from a computed value, the dependencies of v become its dependencies.
+func (s *Scope) computed(v Var, compute func(*Scope) any) any {
+	base := s
+	if s.base != nil {
+		base = s.base
+	}
+
+	base.mu.Lock()
+	e, _ := base.values[v].(*computedEntry)
+	base.mu.Unlock()
+
+	if e == nil || !base.fresh(e) {
+		view := &Scope{base: base, deps: make(map[Var]uint64)}
+		e = &computedEntry{value: compute(view), deps: view.deps}
+
+		base.mu.Lock()
+		base.values[v] = e
+		base.mu.Unlock()
+	}
+
+	if s.base != nil {
+		for dep, version := range e.deps {
+			s.deps[dep] = version
+		}
+	}
+	return e.value
+}
+
+// fresh reports whether none of the dependencies changed since the entry
+// was computed
+func (s *Scope) fresh(e *computedEntry) bool {
+	for dep, version := range e.deps {
+		if s.owner(dep).version(dep) != version {
+			return false
+		}
+	}
+	return true
+}
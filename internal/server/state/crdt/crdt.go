@@ -0,0 +1,59 @@
+// Package crdt holds the replicated data types of the state edited by
+// several users at once: Sequence for lists and text, and Map, a map of
+// last-writer-wins registers. Every replica (the server, each client)
+// makes operations with IDs of its own and applies the operations of the
+// others; replicas that applied the same operations hold the same value,
+// whatever order they received them in.
+package crdt
+
+import (
+	"cmp"
+	"errors"
+)
+
+// ID identifies an operation: the Lamport clock of the replica when it made
+// it, and the replica. Replicas are ASCII strings unique to each writer.
+type ID struct {
+	Counter uint64 `json:"counter"`
+	Replica string `json:"replica"`
+}
+
+// Compare orders the IDs by counter then replica, the greater one wins
+func (id ID) Compare(other ID) int {
+	if c := cmp.Compare(id.Counter, other.Counter); c != 0 {
+		return c
+	}
+	return cmp.Compare(id.Replica, other.Replica)
+}
+
+func (id ID) IsZero() bool {
+	return id.Counter == 0 && id.Replica == ""
+}
+
+func (id ID) valid() bool {
+	return id.Counter > 0 && id.Replica != ""
+}
+
+// MaxPending is the number of operations a Sequence keeps while waiting for
+// the ones they depend on
+const MaxPending = 1 << 16
+
+var (
+	ErrInvalidID  = errors.New("crdt: operation without a valid id")
+	ErrTooPending = errors.New("crdt: too many operations waiting for their dependencies")
+)
+
+// clock is the Lamport clock of a replica
+type clock struct {
+	replica string
+	counter uint64
+}
+
+func (c *clock) next() ID {
+	c.counter++
+	return ID{Counter: c.counter, Replica: c.replica}
+}
+
+func (c *clock) observe(id ID) {
+	c.counter = max(c.counter, id.Counter)
+}
@@ -0,0 +1,103 @@
+package crdt
+
+//
+// Map is a map of last-writer-wins registers: each key holds the value of
+// the operation with the greatest ID that set or deleted it.
+//
+
+// MapOp sets the key to Value, or with Delete removes it
+type MapOp[T any] struct {
+	Key    string `json:"key"`
+	Id     ID     `json:"id"`
+	Value  T      `json:"value"`
+	Delete bool   `json:"delete"`
+}
+
+type register[T any] struct {
+	id      ID
+	value   T
+	deleted bool
+}
+
+type Map[T any] struct {
+	clock     clock
+	registers map[string]register[T]
+	log       []MapOp[T]
+}
+
+// NewMap returns an empty map, replica names the operations it makes
+func NewMap[T any](replica string) *Map[T] {
+	return &Map[T]{clock: clock{replica: replica}, registers: make(map[string]register[T])}
+}
+
+// Get returns the value of the key
+func (m *Map[T]) Get(key string) (T, bool) {
+	r, ok := m.registers[key]
+	if !ok || r.deleted {
+		var zero T
+		return zero, false
+	}
+	return r.value, true
+}
+
+// Values returns the keys not deleted and their values
+func (m *Map[T]) Values() map[string]T {
+	values := make(map[string]T, len(m.registers))
+	for key, r := range m.registers {
+		if !r.deleted {
+			values[key] = r.value
+		}
+	}
+	return values
+}
+
+// Log returns the operations that won when applied, in the order they were.
+// It only grows, the returned slice is not modified by later operations.
+func (m *Map[T]) Log() []MapOp[T] {
+	return m.log[:len(m.log):len(m.log)]
+}
+
+// Set stores the value of the key and returns the operation to send to the
+// other replicas
+func (m *Map[T]) Set(key string, value T) MapOp[T] {
+	op := MapOp[T]{Key: key, Id: m.clock.next(), Value: value}
+	m.apply(op)
+	return op
+}
+
+// Delete removes the key and returns the operation to send to the other
+// replicas
+func (m *Map[T]) Delete(key string) MapOp[T] {
+	op := MapOp[T]{Key: key, Id: m.clock.next(), Delete: true}
+	m.apply(op)
+	return op
+}
+
+// Apply merges the operations of another replica and returns the ones that
+// won. Nothing is applied when an operation has no valid ID.
+func (m *Map[T]) Apply(ops ...MapOp[T]) ([]MapOp[T], error) {
+	for _, op := range ops {
+		if !op.Id.valid() {
+			return nil, ErrInvalidID
+		}
+	}
+	start := len(m.log)
+	for _, op := range ops {
+		m.apply(op)
+	}
+	return m.log[start:len(m.log):len(m.log)], nil
+}
+
+func (m *Map[T]) apply(op MapOp[T]) bool {
+	m.clock.observe(op.Id)
+	if r, ok := m.registers[op.Key]; ok && r.id.Compare(op.Id) >= 0 {
+		return false
+	}
+	if op.Delete {
+		var zero T
+		op.Value = zero
+	}
+	m.registers[op.Key] = register[T]{id: op.Id, value: op.Value, deleted: op.Delete}
+	m.log = append(m.log, op)
+	return true
+}
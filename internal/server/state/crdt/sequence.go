@@ -0,0 +1,200 @@
+package crdt
+
+//
+// Sequence is a replicated growable array (RGA). Each element keeps the ID
+// of its insertion and follows the element it was inserted after; elements
+// inserted after the same one are ordered by descending ID, so concurrent
+// inserts at the same place keep each other and interleave the same way
+// everywhere. Deleted elements stay as tombstones, later inserts can still
+// refer to them.
+//
+
+// SeqOp inserts Value after the element After (zero for the start) with
+// the ID Id, or with Delete removes the element Id
+type SeqOp[T any] struct {
+	Id     ID   `json:"id"`
+	After  ID   `json:"after"`
+	Value  T    `json:"value"`
+	Delete bool `json:"delete"`
+}
+
+type element[T any] struct {
+	id      ID
+	value   T
+	deleted bool
+}
+
+type Sequence[T any] struct {
+	clock   clock
+	elems   []element[T]
+	known   map[ID]bool
+	pending []SeqOp[T]
+	log     []SeqOp[T]
+}
+
+// NewSequence returns an empty sequence, replica names the operations it
+// makes
+func NewSequence[T any](replica string) *Sequence[T] {
+	return &Sequence[T]{clock: clock{replica: replica}, known: make(map[ID]bool)}
+}
+
+// Values returns the elements not deleted
+func (s *Sequence[T]) Values() []T {
+	values := make([]T, 0, len(s.elems))
+	for _, e := range s.elems {
+		if !e.deleted {
+			values = append(values, e.value)
+		}
+	}
+	return values
+}
+
+// Len returns the number of elements not deleted
+func (s *Sequence[T]) Len() int {
+	n := 0
+	for _, e := range s.elems {
+		if !e.deleted {
+			n++
+		}
+	}
+	return n
+}
+
+// Log returns the operations applied, in the order they were. It only
+// grows, the returned slice is not modified by later operations.
+func (s *Sequence[T]) Log() []SeqOp[T] {
+	return s.log[:len(s.log):len(s.log)]
+}
+
+// Insert adds the values at the index of the elements not deleted, and
+// returns the operations to send to the other replicas
+func (s *Sequence[T]) Insert(index int, values ...T) []SeqOp[T] {
+	var after ID
+	if index > 0 {
+		after = s.elems[s.position(index-1)].id
+	}
+	ops := make([]SeqOp[T], 0, len(values))
+	for _, value := range values {
+		op := SeqOp[T]{Id: s.clock.next(), After: after, Value: value}
+		s.apply(op)
+		ops = append(ops, op)
+		after = op.Id
+	}
+	return ops
+}
+
+// Delete removes n elements from the index of the elements not deleted,
+// and returns the operations to send to the other replicas
+func (s *Sequence[T]) Delete(index, n int) []SeqOp[T] {
+	ids := make([]ID, 0, n)
+	for i := 0; i < n; i++ {
+		ids = append(ids, s.elems[s.position(index+i)].id)
+	}
+	ops := make([]SeqOp[T], 0, n)
+	for _, id := range ids {
+		op := SeqOp[T]{Id: id, Delete: true}
+		s.apply(op)
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// Apply merges the operations of another replica and returns the ones that
+// changed the sequence, the operations waiting for them included. An
+// operation already applied is ignored, one that refers to an element not
+// received yet waits for it. Nothing is applied when an operation has no
+// valid ID.
+func (s *Sequence[T]) Apply(ops ...SeqOp[T]) ([]SeqOp[T], error) {
+	for _, op := range ops {
+		if !op.Id.valid() || !(op.After.IsZero() || op.After.valid()) {
+			return nil, ErrInvalidID
+		}
+	}
+	if len(s.pending)+len(ops) > MaxPending {
+		return nil, ErrTooPending
+	}
+
+	start := len(s.log)
+	waiting := append(s.pending, ops...)
+	s.pending = nil
+	for progress := true; progress; {
+		progress = false
+		rest := waiting[:0]
+		for _, op := range waiting {
+			switch {
+			case !s.ready(op):
+				rest = append(rest, op)
+			case s.apply(op):
+				progress = true
+			}
+		}
+		waiting = rest
+	}
+	s.pending = waiting
+	return s.log[start:len(s.log):len(s.log)], nil
+}
+
+// ready reports whether the element the operation refers to was received
+func (s *Sequence[T]) ready(op SeqOp[T]) bool {
+	if op.Delete {
+		return s.known[op.Id]
+	}
+	return op.After.IsZero() || s.known[op.After]
+}
+
+// apply applies an operation that is ready, false when it was already
+func (s *Sequence[T]) apply(op SeqOp[T]) bool {
+	s.clock.observe(op.Id)
+	if op.Delete {
+		i := s.find(op.Id)
+		if s.elems[i].deleted {
+			return false
+		}
+		s.elems[i].deleted = true
+		s.log = append(s.log, op)
+		return true
+	}
+	if s.known[op.Id] {
+		return false
+	}
+
+	i := 0
+	if !op.After.IsZero() {
+		i = s.find(op.After) + 1
+	}
+	// Skip the elements inserted after the same one with a greater ID, and
+	// theirs: they were made after them so their IDs are greater too
+	for i < len(s.elems) && s.elems[i].id.Compare(op.Id) > 0 {
+		i++
+	}
+	s.elems = append(s.elems, element[T]{})
+	copy(s.elems[i+1:], s.elems[i:])
+	s.elems[i] = element[T]{id: op.Id, value: op.Value}
+	s.known[op.Id] = true
+	s.log = append(s.log, op)
+	return true
+}
+
+func (s *Sequence[T]) find(id ID) int {
+	for i, e := range s.elems {
+		if e.id == id {
+			return i
+		}
+	}
+	panic("crdt: element not found")
+}
+
+// position returns the position in elems of the element not deleted at
+// the index, it panics when out of range like a slice
+func (s *Sequence[T]) position(index int) int {
+	for i, e := range s.elems {
+		if e.deleted {
+			continue
+		}
+		if index == 0 {
+			return i
+		}
+		index--
+	}
+	panic("crdt: index out of range")
+}
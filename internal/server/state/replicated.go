@@ -0,0 +1,432 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state/crdt"
+)
+
+//
+// ─────────────────────────────────────────────────────────────
+//  REPLICATED VALUES
+// ─────────────────────────────────────────────────────────────
+//
+// Text, List and Map are edited by several users at once without losing
+// any edit: they hold a CRDT (see package crdt) and are changed by merging
+// operations, not by replacing the value.
+//
+//   var Notes = state.NewText("board", "Notes").Shared()
+//
+// The client keeps a replica of its own. It edits it at once and sends the
+// operations with the reserved RPC method "$$ops" of the business, params
+// {name: [operations]}, applied with Scope.ApplyOps. The server merges them
+// in any order and pushes the operations the client does not have, in the
+// state BATCH at $$crdt.business.name:
+//
+//   {"type": "text", "ops": [{"id": {...}, "after": {...}, "value": "a", "delete": false}]}
+//
+// The client merges them into its replica the same way, and shows its
+// value at business.name. A writer receives its own operations back,
+// merging them again changes nothing.
+//
+// A failed Transaction does not undo the operations merged in it.
+//
+
+// OpsMethod is the reserved RPC method of the operations of the client
+const OpsMethod = "$$ops"
+
+// ServerReplica names the operations made by the server
+const ServerReplica = "server"
+
+const crdtKey = "$$crdt"
+
+// OpsPath is where the client finds the operations of a replicated value
+func OpsPath(v Var) pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(crdtKey), pathprotocol.Key(v.Business()), pathprotocol.Key(v.Name())}
+}
+
+// replicated is a Text, a List or a Map
+type replicated interface {
+	Var
+	// decodeOps decodes the operations of a client, apply merges them
+	decodeOps(c codec.Codec, raw any) (apply func(s *Scope) error, err error)
+}
+
+// opsPush is the value pushed at OpsPath
+type opsPush struct {
+	Type string `json:"type"`
+	Ops  any    `json:"ops"`
+}
+
+func appendOps(b *pathprotocol.Batch, c codec.Codec, v Var, kind string, ops any) error {
+	data, err := c.Marshal(opsPush{Type: kind, Ops: ops})
+	if err != nil {
+		return err
+	}
+	b.Set(OpsPath(v), data)
+	return nil
+}
+
+// ApplyOps merges the operations sent by the client, params of a $$ops call
+// decoded with the codec c. Every name must be a replicated value of the
+// business, otherwise nothing is merged. Shared values are broadcast to
+// the others.
+func (s *Scope) ApplyOps(business string, params map[string]any, c codec.Codec) error {
+	if len(params) == 0 {
+		return errors.New("no operations")
+	}
+	if c == nil {
+		c = codec.JSON
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	applies := make([]func(*Scope) error, 0, len(names))
+	for _, name := range names {
+		v, ok := Lookup(business, name)
+		if !ok {
+			return fmt.Errorf("unknown variable %s.%s", business, name)
+		}
+		r, ok := v.(replicated)
+		if !ok {
+			return fmt.Errorf("%s.%s is not replicated", business, name)
+		}
+		apply, err := r.decodeOps(c, params[name])
+		if err != nil {
+			return fmt.Errorf("invalid operations of %s.%s: %w", business, name, err)
+		}
+		applies = append(applies, apply)
+	}
+	for i, apply := range applies {
+		if err := apply(s); err != nil {
+			return fmt.Errorf("%s.%s: %w", business, names[i], err)
+		}
+	}
+	return nil
+}
+
+// merge runs fn on the CRDT of v in its owner, marking v dirty when fn
+// added operations to log
+func merge[D any](s *Scope, v Var, log func(D) int, fn func(D) error) error {
+	var err error
+	added := false
+	s.update(v, func(current any) any {
+		doc := current.(D)
+		n := log(doc)
+		err = fn(doc)
+		added = log(doc) > n
+		return doc
+	}, func(_, _ any) bool { return added })
+	return err
+}
+
+//
+// --- Sequences ---
+//
+
+// sequence is the part of Text and List over a crdt.Sequence, self is the
+// variable registered
+type sequence[T any] struct {
+	self     Var
+	business string
+	name     string
+	kind     string
+	shared   bool
+}
+
+func (v *sequence[T]) Business() string { return v.business }
+func (v *sequence[T]) Name() string     { return v.name }
+
+func (v *sequence[T]) Path() pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(v.business), pathprotocol.Key(v.name)}
+}
+
+func (v *sequence[T]) isShared() bool { return v.shared }
+func (v *sequence[T]) initial() any   { return crdt.NewSequence[T](ServerReplica) }
+
+func (v *sequence[T]) read(s *Scope) (any, uint64, bool) {
+	log, version := s.owner(v.self).inspectVersion(v.self, func(doc any) any {
+		return doc.(*crdt.Sequence[T]).Log()
+	})
+	return log, version, true
+}
+
+func (v *sequence[T]) convert(codec.Codec, any) (any, error) {
+	return nil, fmt.Errorf("replicated values are written with %s", OpsMethod)
+}
+
+// appendTo sends the operations logged since the last push
+func (v *sequence[T]) appendTo(b *pathprotocol.Batch, _ diff.Encoder, c codec.Codec, sent, current any) error {
+	ops := current.([]crdt.SeqOp[T])
+	if sent != nil {
+		ops = ops[len(sent.([]crdt.SeqOp[T])):]
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return appendOps(b, c, v.self, v.kind, ops)
+}
+
+func (v *sequence[T]) decodeOps(c codec.Codec, raw any) (func(*Scope) error, error) {
+	ops, err := convert[[]crdt.SeqOp[T]](c, raw)
+	if err != nil {
+		return nil, err
+	}
+	return func(s *Scope) error {
+		return v.edit(s, func(doc *crdt.Sequence[T]) error {
+			_, err := doc.Apply(ops...)
+			return err
+		})
+	}, nil
+}
+
+func (v *sequence[T]) values(s *Scope) []T {
+	return s.inspect(v.self, func(doc any) any { return doc.(*crdt.Sequence[T]).Values() }).([]T)
+}
+
+func (v *sequence[T]) len(s *Scope) int {
+	return s.inspect(v.self, func(doc any) any { return doc.(*crdt.Sequence[T]).Len() }).(int)
+}
+
+func (v *sequence[T]) edit(s *Scope, fn func(*crdt.Sequence[T]) error) error {
+	return merge(s, v.self, func(doc *crdt.Sequence[T]) int { return len(doc.Log()) }, fn)
+}
+
+func (v *sequence[T]) insert(s *Scope, index int, items []T) {
+	v.edit(s, func(doc *crdt.Sequence[T]) error {
+		if index < 0 {
+			index = doc.Len()
+		}
+		doc.Insert(index, items...)
+		return nil
+	})
+}
+
+func (v *sequence[T]) delete(s *Scope, index, n int) {
+	v.edit(s, func(doc *crdt.Sequence[T]) error {
+		doc.Delete(index, n)
+		return nil
+	})
+}
+
+// Text is a string edited by several users at once, by characters
+type Text struct {
+	sequence[string]
+}
+
+// NewText registers an empty text of the business. It panics when the name
+// is already registered.
+func NewText(business, name string) *Text {
+	v := &Text{sequence[string]{business: business, name: name, kind: "text"}}
+	v.self = v
+	register(v)
+	return v
+}
+
+// Shared makes the value one for every connection, see Broadcast. It is
+// called on the declaration, before any use.
+func (v *Text) Shared() *Text {
+	v.shared = true
+	return v
+}
+
+// Get returns the text in the scope
+func (v *Text) Get(s *Scope) string {
+	return strings.Join(v.values(s), "")
+}
+
+// Len returns the number of characters of the text
+func (v *Text) Len(s *Scope) int {
+	return v.len(s)
+}
+
+// Insert adds the text at the index, in characters
+func (v *Text) Insert(s *Scope, index int, text string) {
+	if text == "" {
+		return
+	}
+	chars := make([]string, 0, len(text))
+	for _, r := range text {
+		chars = append(chars, string(r))
+	}
+	v.insert(s, index, chars)
+}
+
+// Delete removes n characters from the index, it panics when they are out
+// of range
+func (v *Text) Delete(s *Scope, index, n int) {
+	v.delete(s, index, n)
+}
+
+// List is a list edited by several users at once. Unlike a Slice, two
+// users inserting at the same time both keep their items.
+type List[T any] struct {
+	sequence[T]
+}
+
+// NewList registers an empty list of the business. It panics when the name
+// is already registered.
+func NewList[T any](business, name string) *List[T] {
+	v := &List[T]{sequence[T]{business: business, name: name, kind: "list"}}
+	v.self = v
+	register(v)
+	return v
+}
+
+// Shared makes the value one for every connection, see Broadcast. It is
+// called on the declaration, before any use.
+func (v *List[T]) Shared() *List[T] {
+	v.shared = true
+	return v
+}
+
+// Get returns the items of the list in the scope
+func (v *List[T]) Get(s *Scope) []T {
+	return v.values(s)
+}
+
+// Len returns the length of the list in the scope
+func (v *List[T]) Len(s *Scope) int {
+	return v.len(s)
+}
+
+// Insert adds the items at the index
+func (v *List[T]) Insert(s *Scope, index int, items ...T) {
+	if len(items) > 0 {
+		v.insert(s, index, items)
+	}
+}
+
+// Append adds the items at the end of the list
+func (v *List[T]) Append(s *Scope, items ...T) {
+	if len(items) > 0 {
+		v.insert(s, -1, items)
+	}
+}
+
+// Delete removes n items from the index, it panics when they are out of
+// range
+func (v *List[T]) Delete(s *Scope, index, n int) {
+	v.delete(s, index, n)
+}
+
+//
+// --- Maps ---
+//
+
+// Map is a map edited by several users at once, the last write of a key
+// wins
+type Map[T any] struct {
+	business string
+	name     string
+	shared   bool
+}
+
+// NewMap registers an empty map of the business. It panics when the name
+// is already registered.
+func NewMap[T any](business, name string) *Map[T] {
+	v := &Map[T]{business: business, name: name}
+	register(v)
+	return v
+}
+
+// Shared makes the value one for every connection, see Broadcast. It is
+// called on the declaration, before any use.
+func (v *Map[T]) Shared() *Map[T] {
+	v.shared = true
+	return v
+}
+
+func (v *Map[T]) Business() string { return v.business }
+func (v *Map[T]) Name() string     { return v.name }
+
+func (v *Map[T]) Path() pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(v.business), pathprotocol.Key(v.name)}
+}
+
+func (v *Map[T]) isShared() bool { return v.shared }
+func (v *Map[T]) initial() any   { return crdt.NewMap[T](ServerReplica) }
+
+func (v *Map[T]) read(s *Scope) (any, uint64, bool) {
+	log, version := s.owner(v).inspectVersion(v, func(doc any) any {
+		return doc.(*crdt.Map[T]).Log()
+	})
+	return log, version, true
+}
+
+func (v *Map[T]) convert(codec.Codec, any) (any, error) {
+	return nil, fmt.Errorf("replicated values are written with %s", OpsMethod)
+}
+
+// Get returns a copy of the map in the scope
+func (v *Map[T]) Get(s *Scope) map[string]T {
+	return s.inspect(v, func(doc any) any { return doc.(*crdt.Map[T]).Values() }).(map[string]T)
+}
+
+// Value returns the value of the key
+func (v *Map[T]) Value(s *Scope, key string) (T, bool) {
+	var found bool
+	value := s.inspect(v, func(doc any) any {
+		value, ok := doc.(*crdt.Map[T]).Get(key)
+		found = ok
+		return value
+	})
+	typed, _ := value.(T)
+	return typed, found
+}
+
+// Set stores the value of the key
+func (v *Map[T]) Set(s *Scope, key string, value T) {
+	v.edit(s, func(doc *crdt.Map[T]) error {
+		doc.Set(key, value)
+		return nil
+	})
+}
+
+// Delete removes the key
+func (v *Map[T]) Delete(s *Scope, key string) {
+	v.edit(s, func(doc *crdt.Map[T]) error {
+		if _, ok := doc.Get(key); ok {
+			doc.Delete(key)
+		}
+		return nil
+	})
+}
+
+func (v *Map[T]) edit(s *Scope, fn func(*crdt.Map[T]) error) error {
+	return merge(s, v, func(doc *crdt.Map[T]) int { return len(doc.Log()) }, fn)
+}
+
+func (v *Map[T]) appendTo(b *pathprotocol.Batch, _ diff.Encoder, c codec.Codec, sent, current any) error {
+	ops := current.([]crdt.MapOp[T])
+	if sent != nil {
+		ops = ops[len(sent.([]crdt.MapOp[T])):]
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return appendOps(b, c, v, "map", ops)
+}
+
+func (v *Map[T]) decodeOps(c codec.Codec, raw any) (func(*Scope) error, error) {
+	ops, err := convert[[]crdt.MapOp[T]](c, raw)
+	if err != nil {
+		return nil, err
+	}
+	return func(s *Scope) error {
+		return v.edit(s, func(doc *crdt.Map[T]) error {
+			_, err := doc.Apply(ops...)
+			return err
+		})
+	}, nil
+}
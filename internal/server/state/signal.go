@@ -0,0 +1,103 @@
+package state
+
+import (
+	"reflect"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+)
+
+// Signal is a value sent whole to the client when it changes. Values with
+// maps or slices in them must be replaced, not modified in place, or the
+// change is not seen.
+type Signal[T any] struct {
+	business string
+	name     string
+	shared   bool
+	init     T
+}
+
+// NewSignal registers a value of the business, read as init in a scope
+// that never wrote it. It panics when the name is already registered.
+func NewSignal[T any](business, name string, init T) *Signal[T] {
+	s := &Signal[T]{business: business, name: name, init: init}
+	register(s)
+	return s
+}
+
+// Shared makes the value one for every connection, see Broadcast. It is
+// called on the declaration, before any use.
+func (v *Signal[T]) Shared() *Signal[T] {
+	v.shared = true
+	return v
+}
+
+func (v *Signal[T]) Business() string { return v.business }
+func (v *Signal[T]) Name() string     { return v.name }
+
+func (v *Signal[T]) Path() pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(v.business), pathprotocol.Key(v.name)}
+}
+
+func (v *Signal[T]) isShared() bool { return v.shared }
+func (v *Signal[T]) read(s *Scope) (any, uint64, bool) {
+	value, version := s.owner(v).loadVersion(v)
+	return value, version, true
+}
+func (v *Signal[T]) initial() any { return v.init }
+
+// Version returns the version of the value in the scope, incremented on
+// every change
+func (v *Signal[T]) Version(s *Scope) uint64 {
+	return s.owner(v).version(v)
+}
+
+// Get returns the value in the scope
+func (v *Signal[T]) Get(s *Scope) T {
+	return s.get(v).(T)
+}
+
+// Set stores the value in the scope, it is marked dirty when it differs
+// from the current one
+func (v *Signal[T]) Set(s *Scope, value T) {
+	s.update(v, func(any) any { return value }, changed)
+}
+
+// Update replaces the value with fn applied to it, atomically in the scope
+func (v *Signal[T]) Update(s *Scope, fn func(T) T) {
+	s.update(v, func(current any) any { return fn(current.(T)) }, changed)
+}
+
+func (v *Signal[T]) appendTo(b *pathprotocol.Batch, _ diff.Encoder, c codec.Codec, sent, current any) error {
+	// The client has it already, like after a broadcast of its own write
+	if sent != nil && !changed(sent, current) {
+		return nil
+	}
+	data, err := c.Marshal(current)
+	if err != nil {
+		return err
+	}
+	b.Set(v.Path(), data)
+	return nil
+}
+
+func (v *Signal[T]) convert(c codec.Codec, value any) (any, error) {
+	return convert[T](c, value)
+}
+
+// convert re-encodes a generic value with the codec and decodes it into T,
+// so the rules of the codec decide what fits the type
+func convert[T any](c codec.Codec, value any) (T, error) {
+	var out T
+	data, err := c.Marshal(value)
+	if err != nil {
+		return out, err
+	}
+	err = c.Unmarshal(data, &out)
+	return out, err
+}
+
+func changed(old, new any) bool {
+	return !reflect.DeepEqual(old, new)
+}
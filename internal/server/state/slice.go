@@ -0,0 +1,134 @@
+package state
+
+import (
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+)
+
+// Slice is a list sent to the client as the array operations of what
+// changed since the last push. The scope keeps its own copy, the slices
+// passed in and returned can be modified freely.
+type Slice[T any] struct {
+	business string
+	name     string
+	shared   bool
+	key      func(T) any
+}
+
+// NewSlice registers a list of the business, empty in a new scope. key
+// matches the elements in the diff (see diff.SliceFunc), nil matches them
+// by their encoding. It panics when the name is already registered.
+func NewSlice[T any](business, name string, key func(T) any) *Slice[T] {
+	s := &Slice[T]{business: business, name: name, key: key}
+	register(s)
+	return s
+}
+
+// Shared makes the value one for every connection, see Broadcast. It is
+// called on the declaration, before any use.
+func (v *Slice[T]) Shared() *Slice[T] {
+	v.shared = true
+	return v
+}
+
+func (v *Slice[T]) Business() string { return v.business }
+func (v *Slice[T]) Name() string     { return v.name }
+
+func (v *Slice[T]) Path() pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(v.business), pathprotocol.Key(v.name)}
+}
+
+func (v *Slice[T]) isShared() bool { return v.shared }
+func (v *Slice[T]) read(s *Scope) (any, uint64, bool) {
+	value, version := s.owner(v).loadVersion(v)
+	return value, version, true
+}
+func (v *Slice[T]) initial() any { return []T(nil) }
+
+// Version returns the version of the value in the scope, incremented on
+// every change
+func (v *Slice[T]) Version(s *Scope) uint64 {
+	return s.owner(v).version(v)
+}
+
+// Get returns a copy of the list in the scope
+func (v *Slice[T]) Get(s *Scope) []T {
+	return clone(s.get(v).([]T))
+}
+
+// Len returns the length of the list in the scope
+func (v *Slice[T]) Len(s *Scope) int {
+	return len(s.get(v).([]T))
+}
+
+// Set replaces the list in the scope
+func (v *Slice[T]) Set(s *Scope, items []T) {
+	items = clone(items)
+	s.update(v, func(any) any { return items }, changed)
+}
+
+// Append adds the items at the end of the list
+func (v *Slice[T]) Append(s *Scope, items ...T) {
+	if len(items) == 0 {
+		return
+	}
+	s.update(v, func(current any) any {
+		return append(clone(current.([]T)), items...)
+	}, always)
+}
+
+// Update replaces the list with fn applied to a copy of it, atomically in
+// the scope
+func (v *Slice[T]) Update(s *Scope, fn func([]T) []T) {
+	s.update(v, func(current any) any {
+		return clone(fn(clone(current.([]T))))
+	}, changed)
+}
+
+func (v *Slice[T]) appendTo(b *pathprotocol.Batch, enc diff.Encoder, c codec.Codec, sent, current any) error {
+	// The first push sends the whole list, and every push when the client
+	// decodes no array operations
+	if sent == nil || enc == nil {
+		items := current.([]T)
+		if items == nil {
+			items = []T{}
+		}
+		data, err := c.Marshal(items)
+		if err != nil {
+			return err
+		}
+		b.Set(v.Path(), data)
+		return nil
+	}
+
+	var ops [][]byte
+	var err error
+	if v.key != nil {
+		ops, err = diff.SliceFuncWith(enc, c, sent.([]T), current.([]T), v.key)
+	} else {
+		ops, err = diff.SliceWith(enc, c, sent.([]T), current.([]T))
+	}
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		b.Array(v.Path(), op)
+	}
+	return nil
+}
+
+func (v *Slice[T]) convert(c codec.Codec, value any) (any, error) {
+	return convert[[]T](c, value)
+}
+
+func clone[T any](items []T) []T {
+	if items == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(items)), items...)
+}
+
+func always(_, _ any) bool {
+	return true
+}
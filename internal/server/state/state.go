@@ -0,0 +1,396 @@
+package state
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+)
+
+//
+// The reactive state of the business packages. A package declares its
+// globals as Signal and Slice values instead of plain variables:
+//
+//   var Email = state.NewSignal("signup", "Email", "")
+//   var Tasks = state.NewSlice("todo", "Tasks", func(t Task) any { return t.Id })
+//
+// and reads and writes them in the Scope of the connection that called it:
+//
+//   Email.Set(scope, "")
+//   Tasks.Append(scope, task)
+//
+// Every write that changes a value marks it dirty. After each RPC the
+// server flushes the scope: the dirty values are sent to the client as one
+// transactional BATCH of the path protocol, on Destination "$$state",
+// each value at the path business.name. Signals are sent with SET, slices
+// with the ARRAY operations of the diff from what the client last received.
+//
+// The client writes the values back with the reserved RPC method "$$sync"
+// of the business, params {name: value}, applied with Scope.Sync.
+//
+
+// Destination of the state pushed to the client
+const Destination = "$$state"
+
+// SyncMethod is the reserved RPC method of the writes of the client
+const SyncMethod = "$$sync"
+
+// Var is a value of the business state (Signal, Slice, Computed, Text, List
+// or Map), registered under its business and name
+type Var interface {
+	Business() string
+	Name() string
+	// Path of the value in the state of the client
+	Path() pathprotocol.Path
+
+	// appendTo adds the change of the value to the batch, sent is what the
+	// client has (nil before the first push)
+	appendTo(b *pathprotocol.Batch, enc diff.Encoder, c codec.Codec, sent, current any) error
+	// convert turns a value decoded with the codec into the Go type
+	convert(c codec.Codec, value any) (any, error)
+	// read returns the current value in the scope with its version,
+	// versioned is false for the values without one
+	read(s *Scope) (value any, version uint64, versioned bool)
+	initial() any
+	isShared() bool
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  REGISTRY
+// ─────────────────────────────────────────────────────────────
+//
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]map[string]Var)
+)
+
+func register(v Var) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	vars := registry[v.Business()]
+	if vars == nil {
+		vars = make(map[string]Var)
+		registry[v.Business()] = vars
+	}
+	if _, ok := vars[v.Name()]; ok {
+		panic(fmt.Sprintf("state: %s.%s registered twice", v.Business(), v.Name()))
+	}
+	vars[v.Name()] = v
+}
+
+// Lookup returns the variable registered under the business and name
+func Lookup(business, name string) (Var, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	v, ok := registry[business][name]
+	return v, ok
+}
+
+// Vars returns the variables of a business, sorted by name
+func Vars(business string) []Var {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	vars := make([]Var, 0, len(registry[business]))
+	for _, v := range registry[business] {
+		vars = append(vars, v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name() < vars[j].Name() })
+	return vars
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  SCOPE
+// ─────────────────────────────────────────────────────────────
+//
+
+// Scope holds the values of one connection. Variables never written in it
+// read their initial value.
+type Scope struct {
+	mu     sync.Mutex
+	values map[Var]any
+	sent   map[Var]any
+	dirty  []Var
+	// Incremented on every change of a value, computed values remember the
+	// versions they were computed from and clients write against them
+	versions     map[Var]uint64
+	sentVersions map[Var]uint64
+
+	// Open transaction, see Transaction
+	tx *transaction
+
+	// Set on the scopes given to the functions of computed values: reads
+	// are recorded in deps and served by base, writes panic
+	base *Scope
+	deps map[Var]uint64
+
+	// Serializes the flushes, the batches must reach the client in the
+	// order their keys were interned
+	flushMu sync.Mutex
+	paths   *pathprotocol.Encoder
+}
+
+func NewScope() *Scope {
+	return &Scope{
+		values:       make(map[Var]any),
+		sent:         make(map[Var]any),
+		versions:     make(map[Var]uint64),
+		sentVersions: make(map[Var]uint64),
+		paths:        pathprotocol.NewEncoder(),
+	}
+}
+
+// owner is the scope holding the value of v: shared for the variables
+// declared Shared, s otherwise
+func (s *Scope) owner(v Var) *Scope {
+	if v.isShared() {
+		return shared
+	}
+	return s
+}
+
+func (s *Scope) get(v Var) any {
+	return s.inspect(v, func(value any) any { return value })
+}
+
+// inspect returns fn of the value of v, run with the lock of its owner held
+// for the values modified in place. On the scope of a computed value the
+// read is recorded.
+func (s *Scope) inspect(v Var, fn func(value any) any) any {
+	if s.base != nil {
+		value, version := s.base.owner(v).inspectVersion(v, fn)
+		s.deps[v] = version
+		return value
+	}
+	value, _ := s.owner(v).inspectVersion(v, fn)
+	return value
+}
+
+func (s *Scope) inspectVersion(v Var, fn func(value any) any) (any, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.value(v)), s.versions[v]
+}
+
+func (s *Scope) loadVersion(v Var) (any, uint64) {
+	return s.inspectVersion(v, func(value any) any { return value })
+}
+
+func (s *Scope) version(v Var) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versions[v]
+}
+
+// value must be called with mu held
+func (s *Scope) value(v Var) any {
+	if value, ok := s.values[v]; ok {
+		return value
+	}
+	return v.initial()
+}
+
+// update replaces the value with fn(current) and marks it dirty when
+// changed reports a difference. A shared value is changed for everyone and
+// broadcast to the other scopes, when the transaction ends if one is open.
+func (s *Scope) update(v Var, fn func(current any) any, changed func(old, new any) bool) {
+	if s.base != nil {
+		panic(fmt.Sprintf("state: %s written by a computed value", v.Path()))
+	}
+	o := s.owner(v)
+	old, version, ok := o.store(v, fn, changed)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.markDirty(v)
+	tx := s.tx
+	if tx != nil {
+		tx.record(v, old, version)
+	}
+	s.mu.Unlock()
+
+	if o == shared && tx == nil {
+		Broadcast([]Var{v}, s)
+	}
+}
+
+// store replaces the value with fn(current), atomically in the scope. It
+// returns the value replaced and the new version, ok is false when the
+// value did not change.
+func (s *Scope) store(v Var, fn func(current any) any, changed func(old, new any) bool) (old any, version uint64, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old = s.value(v)
+	next := fn(old)
+	s.values[v] = next
+	if !changed(old, next) {
+		return old, s.versions[v], false
+	}
+	s.versions[v]++
+	return old, s.versions[v], true
+}
+
+func (s *Scope) unmarkDirty(v Var) {
+	for i, d := range s.dirty {
+		if d == v {
+			s.dirty = append(s.dirty[:i], s.dirty[i+1:]...)
+			return
+		}
+	}
+}
+
+// markDirty must be called with mu held. The computed values of the scope
+// that read v are marked too, they are pushed if their result changed.
+func (s *Scope) markDirty(v Var) {
+	for _, d := range s.dirty {
+		if d == v {
+			return
+		}
+	}
+	s.dirty = append(s.dirty, v)
+
+	for c, value := range s.values {
+		if e, ok := value.(*computedEntry); ok {
+			if _, ok := e.deps[v]; ok {
+				s.markDirty(c)
+			}
+		}
+	}
+}
+
+// MarkDirty sends the variables with the next flush, changed or not, like
+// their current values to a client that starts viewing them
+func (s *Scope) MarkDirty(vars ...Var) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range vars {
+		s.markDirty(v)
+	}
+}
+
+// Dirty reports whether a value changed since the last flush
+func (s *Scope) Dirty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.dirty) > 0
+}
+
+// Flush encodes the dirty values as one transactional path BATCH and hands
+// it to send, the array operations encoded with enc. Lists are sent whole
+// when enc is nil. The version of a value goes with it, at
+// $$version.business.name. Nothing is
+// sent when no value changed, or while a transaction is open. The values
+// stay dirty when encoding or send fails.
+func (s *Scope) Flush(enc diff.Encoder, c codec.Codec, send func([]byte) error) error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if s.tx != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	dirty := s.dirty
+	s.dirty = nil
+	sent := make([]any, len(dirty))
+	sentVersions := make([]uint64, len(dirty))
+	for i, v := range dirty {
+		sent[i] = s.sent[v]
+		sentVersions[i] = s.sentVersions[v]
+	}
+	s.mu.Unlock()
+
+	if len(dirty) == 0 {
+		return nil
+	}
+	current := make([]any, len(dirty))
+	versions := make([]uint64, len(dirty))
+	for i, v := range dirty {
+		current[i], versions[i], _ = v.read(s)
+	}
+	if c == nil {
+		c = codec.JSON
+	}
+	b := s.paths.Batch(true)
+	err := func() error {
+		for i, v := range dirty {
+			if err := v.appendTo(b, enc, c, sent[i], current[i]); err != nil {
+				return fmt.Errorf("%s: %w", v.Path(), err)
+			}
+			if versions[i] != sentVersions[i] {
+				data, err := c.Marshal(versions[i])
+				if err != nil {
+					return err
+				}
+				b.Set(VersionPath(v), data)
+			}
+		}
+		if b.Len() == 0 {
+			return nil
+		}
+		msg, err := b.Encode()
+		if err != nil {
+			return err
+		}
+		return send(msg)
+	}()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		for _, v := range dirty {
+			s.markDirty(v)
+		}
+		return err
+	}
+	for i, v := range dirty {
+		s.sent[v] = current[i]
+		s.sentVersions[v] = versions[i]
+	}
+	return nil
+}
+
+// VersionPath is where the client finds the version of a value
+func VersionPath(v Var) pathprotocol.Path {
+	return pathprotocol.Path{pathprotocol.Key(versionKey), pathprotocol.Key(v.Business()), pathprotocol.Key(v.Name())}
+}
+
+const versionKey = "$$version"
+
+//
+// ─────────────────────────────────────────────────────────────
+//  SHARED STATE
+// ─────────────────────────────────────────────────────────────
+//
+// Variables declared Shared have one value for every connection, kept in
+// the shared scope. Writes to it are serialized by its lock, so an Update
+// or an Append of two users never loses one of them. Every connection
+// still diffs against what its own client received.
+//
+
+// shared holds the values of the variables declared Shared
+var shared = NewScope()
+
+// Broadcast is called after shared values changed, without locks held,
+// with every value of a transaction at once. origin is the scope that
+// wrote them, its client gets them with its own flush. The server sets it
+// to push the values to every other client viewing their business (see
+// ViewTopic), in one flush per client.
+var Broadcast = func(vars []Var, origin *Scope) {}
+
+// ViewTopic is the topic a client subscribes to while it shows the
+// business, to receive the shared values changed by the others
+func ViewTopic(business string) string {
+	return Destination + "/" + business
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+)
+
+//
+// ─────────────────────────────────────────────────────────────
+//  CLIENT WRITES
+// ─────────────────────────────────────────────────────────────
+//
+// The client writes optimistically: it shows its value right away and
+// sends it with the version of the value it changed, read from the pushed
+// $$version.business.name, under the reserved param VersionsKey:
+//
+//   {"Title": "b", "$$versions": {"Title": 3}}
+//
+// The write is a compare-and-set: when a value changed on the server since
+// that version, nothing is applied and the client is answered the current
+// values and versions to show instead (see ConflictError). A value without
+// an expected version is written whatever its version.
+//
+
+// VersionsKey is the reserved param of a $$sync call with the versions the
+// client wrote against
+const VersionsKey = "$$versions"
+
+// ConflictError rejects a write made against an outdated version. It holds
+// the current values and versions of the names in conflict.
+type ConflictError struct {
+	Business string
+	Values   map[string]any
+	Versions map[string]uint64
+}
+
+func (e *ConflictError) Error() string {
+	names := make([]string, 0, len(e.Versions))
+	for name := range e.Versions {
+		names = append(names, e.Business+"."+name)
+	}
+	sort.Strings(names)
+	return "conflicting write of " + strings.Join(names, ", ")
+}
+
+// Sync applies the values written by the client, params of a $$sync call
+// decoded with the codec c, and returns their new versions. Every name must
+// be a variable of the business and every value must convert to its Go
+// type, otherwise nothing is applied and the error tells the client to roll
+// back; a *ConflictError when a version in VersionsKey is outdated. The
+// client already has the values, they are not pushed back to it; shared
+// ones are broadcast to the others.
+func (s *Scope) Sync(business string, params map[string]any, c codec.Codec) (map[string]uint64, error) {
+	if c == nil {
+		c = codec.JSON
+	}
+	var expected map[string]uint64
+	if raw, ok := params[VersionsKey]; ok {
+		var err error
+		if expected, err = convert[map[string]uint64](c, raw); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", VersionsKey, err)
+		}
+	}
+
+	vars := make([]Var, 0, len(params))
+	converted := make([]any, 0, len(params))
+	withShared := false
+	for name, value := range params {
+		if name == VersionsKey {
+			continue
+		}
+		v, ok := Lookup(business, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %s.%s", business, name)
+		}
+		value, err := v.convert(c, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %s.%s: %w", business, name, err)
+		}
+		vars = append(vars, v)
+		converted = append(converted, value)
+		withShared = withShared || v.isShared()
+	}
+	if len(vars) == 0 {
+		return nil, errors.New("nothing to sync")
+	}
+
+	// Not while a flush is between its snapshot and its send. The shared
+	// scope is locked for the whole check and write, like a single value.
+	s.flushMu.Lock()
+	if withShared {
+		shared.mu.Lock()
+	}
+	s.mu.Lock()
+
+	conflict := &ConflictError{Business: business, Values: map[string]any{}, Versions: map[string]uint64{}}
+	for _, v := range vars {
+		o := s.owner(v)
+		if version, ok := expected[v.Name()]; ok && o.versions[v] != version {
+			conflict.Values[v.Name()] = o.value(v)
+			conflict.Versions[v.Name()] = o.versions[v]
+		}
+	}
+
+	versions := make(map[string]uint64, len(vars))
+	var broadcast []Var
+	if len(conflict.Versions) > 0 {
+		// The client shows the values of the rejection
+		for _, v := range vars {
+			if version, ok := conflict.Versions[v.Name()]; ok {
+				s.sent[v] = conflict.Values[v.Name()]
+				s.sentVersions[v] = version
+			}
+		}
+	} else {
+		for i, v := range vars {
+			o := s.owner(v)
+			old := o.value(v)
+			o.values[v] = converted[i]
+			if changed(old, converted[i]) {
+				o.versions[v]++
+				// Only the computed values that read it are pushed
+				s.markDirty(v)
+				s.unmarkDirty(v)
+				if o == shared {
+					broadcast = append(broadcast, v)
+				}
+			}
+			s.sent[v] = converted[i]
+			s.sentVersions[v] = o.versions[v]
+			versions[v.Name()] = o.versions[v]
+		}
+	}
+
+	s.mu.Unlock()
+	if withShared {
+		shared.mu.Unlock()
+	}
+	s.flushMu.Unlock()
+
+	if len(conflict.Versions) > 0 {
+		return nil, conflict
+	}
+	if len(broadcast) > 0 {
+		Broadcast(broadcast, s)
+	}
+	return versions, nil
+}
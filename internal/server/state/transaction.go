@@ -0,0 +1,109 @@
+package state
+
+//
+// ─────────────────────────────────────────────────────────────
+//  TRANSACTIONS
+// ─────────────────────────────────────────────────────────────
+//
+// A transaction groups the writes of a function into one push: the client
+// sees all of them in a single BATCH, or none when the function fails.
+//
+//   err := scope.Transaction(func() error {
+//       Balance.Update(scope, func(b int) int { return b - amount })
+//       History.Append(scope, entry)
+//       return check(scope)
+//   })
+//
+
+type transaction struct {
+	undo  map[Var]*undo
+	order []Var
+}
+
+// undo is the value of a variable before the transaction, and the version
+// of the last write to it in the transaction
+type undo struct {
+	old     any
+	version uint64
+}
+
+// record must be called with the mu of the scope held
+func (tx *transaction) record(v Var, old any, version uint64) {
+	if u, ok := tx.undo[v]; ok {
+		u.version = version
+		return
+	}
+	tx.undo[v] = &undo{old: old, version: version}
+	tx.order = append(tx.order, v)
+}
+
+// Transaction runs fn and pushes the values it changed with the first flush
+// after it returns, as one BATCH. Flush sends nothing while it runs. When
+// fn returns an error or panics the values are restored, and the error is
+// returned. A shared value written by another connection meanwhile keeps
+// that write. The other connections are pushed the shared values at the
+// end, but read them as soon as they are written. A Transaction in fn, or
+// a write from another goroutine while it runs, joins it.
+func (s *Scope) Transaction(fn func() error) (err error) {
+	s.mu.Lock()
+	if s.tx != nil {
+		s.mu.Unlock()
+		return fn()
+	}
+	tx := &transaction{undo: make(map[Var]*undo)}
+	s.tx = tx
+	s.mu.Unlock()
+
+	committed := false
+	defer func() {
+		s.mu.Lock()
+		s.tx = nil
+		s.mu.Unlock()
+		if !committed {
+			s.rollback(tx)
+		}
+		var broadcast []Var
+		for _, v := range tx.order {
+			if v.isShared() {
+				broadcast = append(broadcast, v)
+			}
+		}
+		if len(broadcast) > 0 {
+			Broadcast(broadcast, s)
+		}
+	}()
+
+	err = fn()
+	committed = err == nil
+	return err
+}
+
+// rollback restores the values written in the transaction, but the
+// replicated ones: their operations may have reached other replicas
+func (s *Scope) rollback(tx *transaction) {
+	for _, v := range tx.order {
+		if _, ok := v.(replicated); ok {
+			continue
+		}
+		u := tx.undo[v]
+		if !s.owner(v).restore(v, u) {
+			continue
+		}
+		s.mu.Lock()
+		s.markDirty(v)
+		s.mu.Unlock()
+	}
+}
+
+// restore puts back the value before the transaction, unless it was
+// written again since. The version is incremented, it is a new change.
+func (s *Scope) restore(v Var, u *undo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.versions[v] != u.version {
+		return false
+	}
+	s.values[v] = u.old
+	s.versions[v]++
+	return true
+}
@@ -4,7 +4,5 @@ type ClientInputInterface interface {
 	SendToClient(ClientOutput ClientOutput) bool
 	IsValidMessage() error
 	Close() error
-	IsValidExecutor(string, string) bool
-	IsValidOperation(operation string) bool
-	Unmarshal(message byte)
+	Unmarshal(message []byte) error
 }
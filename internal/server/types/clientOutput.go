@@ -2,58 +2,214 @@ package types
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
 )
 
 type ClientOutput struct {
 	ReqId       uint8
 	MsgType     WSTypeOutputMessage
 	Destination string
-	Data        string
-	Header      map[string]string
+	// Data is encoded once with the codec of the connection. A string is
+	// sent as a string, a struct or map as the value itself.
+	Data   any
+	Header map[string]string
+}
+
+// Marshal serializes the ClientOutput with JSON payloads
+func (c ClientOutput) Marshal() ([]byte, error) {
+	return c.MarshalWith(codec.JSON)
 }
 
-// Marshal serializes the ClientOutput struct into a custom binary format.
+// MarshalWith serializes the ClientOutput struct into a custom binary format.
 /*
 Steps for Marshalling to byte:
         Write ReqId (1 byte).
         Write MsgType (1 byte for length, followed by the ascii).
         Write Destination (2 bytes for length, followed by the string).
-        Write Data (4 bytes for length, followed by the JSON string).
+        Write Data (4 bytes for length, followed by the data encoded with the codec).
         Write Header (2 bytes for length, followed by the JSON string).
+
+A nil Data is sent as an empty string.
 */
-func (c ClientOutput) Marshal() ([]byte, error) {
+func (c ClientOutput) MarshalWith(payloads codec.Codec) ([]byte, error) {
 	var buf bytes.Buffer
+	if err := c.EncodeTo(&buf, payloads); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	// ReqId (1 byte) - zero is a subscribed events
-	buf.WriteByte(c.ReqId)
+// EncodeTo writes the format of MarshalWith to w. Data and Header are
+// marshaled first, so nothing is written when they fail.
+func (c ClientOutput) EncodeTo(w io.Writer, payloads codec.Codec) error {
+	if len(c.Destination) > 0xFFFF {
+		return fmt.Errorf("destination of %d bytes exceeds 65535", len(c.Destination))
+	}
 
-	// MsgType (1 byte) - write the length of MsgType followed by the MsgType itself
-	buf.WriteByte(byte(c.MsgType)) // Length of MsgType string (1 byte)
+	data := c.Data
+	if data == nil {
+		data = ""
+	}
+	dataJSON, err := payloads.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %v", err)
+	}
+
+	headerJSON, err := json.Marshal(c.Header)
+	if err != nil {
+		return fmt.Errorf("failed to marshal header: %v", err)
+	}
+	if len(headerJSON) > 0xFFFF {
+		return fmt.Errorf("header of %d bytes exceeds 65535", len(headerJSON))
+	}
+
+	// ReqId (1 byte) - zero is a subscribed events, then MsgType (1 byte)
+	head := []byte{c.ReqId, byte(c.MsgType)}
 
 	// Destination (2 bytes for length + N bytes for content)
-	destLen := uint16(len(c.Destination))
-	buf.Write([]byte{byte(destLen >> 8), byte(destLen & 0xFF)}) // 2 bytes length
-	buf.WriteString(c.Destination)
+	head = binary.BigEndian.AppendUint16(head, uint16(len(c.Destination)))
+	head = append(head, c.Destination...)
 
-	// Data (4 bytes for length + N bytes for JSON serialized content)
-	dataJSON, err := json.Marshal(c.Data)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal data: %v", err)
+	// Data (4 bytes for length + N bytes for serialized content)
+	head = binary.BigEndian.AppendUint32(head, uint32(len(dataJSON)))
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	if _, err := w.Write(dataJSON); err != nil {
+		return err
 	}
-	dataLen := uint32(len(dataJSON))
-	buf.Write([]byte{byte(dataLen >> 24), byte(dataLen >> 16), byte(dataLen >> 8), byte(dataLen & 0xFF)}) // 4 bytes length
-	buf.Write(dataJSON)
 
-	// Header (3 bytes for length + N bytes for JSON serialized content)
-	headerJSON, err := json.Marshal(c.Header)
+	// Header (2 bytes for length + N bytes for JSON serialized content)
+	tail := binary.BigEndian.AppendUint16(nil, uint16(len(headerJSON)))
+	_, err = w.Write(append(tail, headerJSON...))
+	return err
+}
+
+// Unmarshal parses a ClientOutput with JSON payloads
+func (c *ClientOutput) Unmarshal(message []byte) error {
+	return c.UnmarshalWith(codec.JSON, message)
+}
+
+// UnmarshalWith parses the format written by MarshalWith. Data is decoded
+// with the codec into a generic value: a string, a number, a bool,
+// []any or map[string]any.
+func (c *ClientOutput) UnmarshalWith(payloads codec.Codec, message []byte) error {
+	offset := 0
+	if len(message) < 1+1+2+4+2 {
+		return errors.New("message too short")
+	}
+
+	// --- 1. reqId (1 byte)
+	c.ReqId = message[offset]
+	offset++
+
+	// --- 2. msgType (1 byte)
+	c.MsgType = WSTypeOutputMessage(message[offset])
+	offset++
+
+	// --- 3. destination length (2 bytes, big endian)
+	destLen := int(binary.BigEndian.Uint16(message[offset : offset+2]))
+	offset += 2
+
+	if offset+destLen > len(message) {
+		return errors.New("invalid destination length")
+	}
+	c.Destination = string(message[offset : offset+destLen])
+	offset += destLen
+
+	// --- 4. data length (4 bytes, big endian)
+	if offset+4 > len(message) {
+		return errors.New("missing data length")
+	}
+	dataLen := int(binary.BigEndian.Uint32(message[offset : offset+4]))
+	offset += 4
+
+	if dataLen > len(message)-offset {
+		return errors.New("invalid data length")
+	}
+	var data any
+	if err := payloads.Unmarshal(message[offset:offset+dataLen], &data); err != nil {
+		return fmt.Errorf("invalid data %s: %w", payloads.Name(), err)
+	}
+	c.Data = data
+	offset += dataLen
+
+	// --- 5. header length (2 bytes, big endian)
+	if offset+2 > len(message) {
+		return errors.New("missing header length")
+	}
+	headerLen := int(binary.BigEndian.Uint16(message[offset : offset+2]))
+	offset += 2
+
+	if offset+headerLen != len(message) {
+		return errors.New("invalid header length")
+	}
+
+	// --- 6. parse header JSON
+	var header map[string]string
+	if headerLen > 0 {
+		if err := json.Unmarshal(message[offset:], &header); err != nil {
+			return fmt.Errorf("invalid header JSON: %w", err)
+		}
+	}
+	c.Header = header
+
+	return nil
+}
+
+// outputEnvelope is the JSON form of a ClientOutput, the text frame sent on
+// connections with TransportText:
+//
+//	{"reqId":3,"msgType":"S","destination":"tasks","data":{"id":1},"header":{"seq":"4"}}
+type outputEnvelope struct {
+	ReqId       uint8             `json:"reqId"`
+	MsgType     string            `json:"msgType"`
+	Destination string            `json:"destination"`
+	Data        any               `json:"data"`
+	Header      map[string]string `json:"header,omitempty"`
+}
+
+// MarshalEnvelope serializes the ClientOutput as a JSON envelope. A nil Data
+// is sent as an empty string, like in MarshalWith.
+func (c ClientOutput) MarshalEnvelope() ([]byte, error) {
+	data := c.Data
+	if data == nil {
+		data = ""
+	}
+	message, err := json.Marshal(outputEnvelope{
+		ReqId:       c.ReqId,
+		MsgType:     string(c.MsgType),
+		Destination: c.Destination,
+		Data:        data,
+		Header:      c.Header,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal header: %v", err)
+		return nil, fmt.Errorf("failed to marshal envelope: %v", err)
 	}
-	headerLen := uint16(len(headerJSON))
-	buf.Write([]byte{byte(headerLen >> 8), byte(headerLen & 0xFF)}) // 2 bytes length
-	buf.Write(headerJSON)
+	return message, nil
+}
 
-	return buf.Bytes(), nil
+// UnmarshalEnvelope parses the format written by MarshalEnvelope, Data is
+// decoded into a generic value like in UnmarshalWith
+func (c *ClientOutput) UnmarshalEnvelope(message []byte) error {
+	var e outputEnvelope
+	if err := json.Unmarshal(message, &e); err != nil {
+		return fmt.Errorf("invalid envelope: %w", err)
+	}
+	if len(e.MsgType) != 1 {
+		return fmt.Errorf("invalid msgType %q", e.MsgType)
+	}
+
+	c.ReqId = e.ReqId
+	c.MsgType = WSTypeOutputMessage(e.MsgType[0])
+	c.Destination = e.Destination
+	c.Data = e.Data
+	c.Header = e.Header
+	return nil
 }
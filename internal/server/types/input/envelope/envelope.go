@@ -0,0 +1,135 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+)
+
+//
+// The JSON envelope is the text frame equivalent of every client frame, on
+// connections that offered the types.TextSubprotocol subprotocol. It is
+// meant for browser devtools and for clients without the binary layouts:
+//
+//   {"type":"hello","reqId":1,"version":1,"reqIdSize":1,"capabilities":7,"arrayVersions":[1,2]}
+//   {"type":"subscribe","reqId":2,"topic":"tasks/+","data":{"all":true},"header":{"since":"3"}}
+//   {"type":"unsubscribe","reqId":3,"topic":"tasks/+"}
+//   {"type":"rpc","reqId":4,"class":"tasks","method":"add","params":{"title":"x"}}
+//   {"type":"endpoint","reqId":5,"method":"GET","endpoint":"/tasks","data":null}
+//
+// data is any JSON value, sent to the handlers as its JSON text like the
+// data of the binary frames. The server converts every envelope into the
+// binary frame it stands for, so both transports are handled the same way,
+// and answers with the JSON form of ClientOutput (see MarshalEnvelope).
+//
+
+const (
+	TypeHello       = "hello"
+	TypeSubscribe   = "subscribe"
+	TypeUnsubscribe = "unsubscribe"
+	TypeRPC         = "rpc"
+	TypeEndpoint    = "endpoint"
+)
+
+type Envelope struct {
+	Type   string            `json:"type"`
+	ReqId  uint8             `json:"reqId"`
+	Header map[string]string `json:"header,omitempty"`
+
+	// subscribe, unsubscribe
+	Topic string `json:"topic,omitempty"`
+	// subscribe, endpoint
+	Data json.RawMessage `json:"data,omitempty"`
+
+	// rpc: the method of the class. endpoint: the REST method.
+	Method string `json:"method,omitempty"`
+	// rpc
+	Class  string         `json:"class,omitempty"`
+	Params map[string]any `json:"params,omitempty"`
+	// endpoint
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// hello
+	Version       uint8              `json:"version,omitempty"`
+	ReqIdSize     uint8              `json:"reqIdSize,omitempty"`
+	Capabilities  types.Capability   `json:"capabilities,omitempty"`
+	ArrayVersions []protocol.Version `json:"arrayVersions,omitempty"`
+}
+
+// Unmarshal parses a text frame. The ReqId is set whenever the JSON could
+// be read, so the error can be answered on it.
+func Unmarshal(message []byte) (Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(message, &e); err != nil {
+		return e, fmt.Errorf("invalid envelope: %w", err)
+	}
+	if e.Type == "" {
+		return e, errors.New("envelope without type")
+	}
+	return e, nil
+}
+
+// Frame returns the binary frame the envelope stands for
+func (e Envelope) Frame() ([]byte, error) {
+	reqId := e.ReqId
+
+	switch e.Type {
+	case TypeHello:
+		return hello.ClientInputHello{
+			ReqId:         &reqId,
+			Version:       e.Version,
+			ReqIdSize:     e.ReqIdSize,
+			Capabilities:  e.Capabilities,
+			ArrayVersions: e.ArrayVersions,
+		}.Marshal()
+	case TypeSubscribe, TypeUnsubscribe:
+		if e.Topic == "" {
+			return nil, fmt.Errorf("%s without topic", e.Type)
+		}
+		input := subscribe.ClientInputSubscription{
+			ReqId:  &reqId,
+			Topic:  e.Topic,
+			Data:   string(e.Data),
+			Header: e.Header,
+		}
+		if e.Type == TypeUnsubscribe {
+			return input.MarshalUnsubscribe()
+		}
+		return input.Marshal()
+	case TypeRPC:
+		if e.Class == "" || e.Method == "" {
+			return nil, errors.New("rpc without class or method")
+		}
+		return rpc.ClientInputRPC{
+			ReqId:  &reqId,
+			Class:  e.Class,
+			Method: e.Method,
+			Params: e.Params,
+			Header: e.Header,
+		}.Marshal()
+	case TypeEndpoint:
+		if e.Endpoint == "" || e.Method == "" {
+			return nil, errors.New("endpoint without endpoint or method")
+		}
+		return rest.ClientInputRest{
+			ReqId:    &reqId,
+			Method:   rest.RESTMethod(e.Method),
+			Endpoint: e.Endpoint,
+			Data:     string(e.Data),
+			Header:   e.Header,
+		}.Marshal()
+	}
+	return nil, fmt.Errorf("unknown envelope type %q", e.Type)
+}
+
+// Marshal writes the envelope as the text frame read by Unmarshal
+func (e Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
@@ -0,0 +1,155 @@
+package hello
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+)
+
+//
+// HELLO, the optional first frame of a connection:
+//
+// ┌──────┬───────┬──────────────┬───────────┬──────────────┬───────┬───────────────┐
+// │ 5    │ reqId │ hello version│ reqIdSize │ capabilities │ count │ versions...   │
+// │ 1 B  │ 1 B   │ 1 B          │ 1 B       │ 4 B          │ 1 B   │ count × 1 B   │
+// └──────┴───────┴──────────────┴───────────┴──────────────┴───────┴───────────────┘
+//
+// capabilities are the types.Capability flags the client understands and
+// versions the bit protocol versions it decodes. The payload codec is
+// agreed before, with the subprotocol of the upgrade.
+//
+// The server answers with a ClientOutput on the same reqId, Destination
+// "$$hello" and Welcome as Data, or closes the connection with a
+// CloseProtocolError giving the reason the client is refused.
+//
+
+const Destination = "$$hello"
+
+type ClientInputHello struct {
+	ReqId         *uint8
+	Version       uint8
+	ReqIdSize     uint8
+	Capabilities  types.Capability
+	ArrayVersions []protocol.Version
+	WSConn        *types.WebSocketConnection
+}
+
+// Welcome is what the server selected, sent back as the Data of the answer
+type Welcome struct {
+	Version      uint8            `json:"version"`
+	Capabilities types.Capability `json:"capabilities"`
+	Array        string           `json:"array"`
+	ArrayVersion protocol.Version `json:"arrayVersion"`
+	ReqIdSize    uint8            `json:"reqIdSize"`
+	Codec        string           `json:"codec"`
+	// Name of the dictionary of the compressed bulk inserts, empty when
+	// there is none
+	Dictionary string `json:"dictionary,omitempty"`
+}
+
+// Marshal writes the frame read by Unmarshal. A nil ReqId is sent as 0.
+func (c ClientInputHello) Marshal() ([]byte, error) {
+	if len(c.ArrayVersions) > 0xFF {
+		return nil, fmt.Errorf("%d array versions exceed 255", len(c.ArrayVersions))
+	}
+
+	var reqId uint8
+	if c.ReqId != nil {
+		reqId = *c.ReqId
+	}
+
+	message := make([]byte, 0, 1+1+1+1+4+1+len(c.ArrayVersions))
+	message = append(message, byte(types.WSConnHello), reqId, c.Version, c.ReqIdSize)
+	message = binary.BigEndian.AppendUint32(message, uint32(c.Capabilities))
+	message = append(message, byte(len(c.ArrayVersions)))
+	for _, v := range c.ArrayVersions {
+		message = append(message, byte(v))
+	}
+	return message, nil
+}
+
+func (c *ClientInputHello) Unmarshal(message []byte) error {
+	if len(message) < 1+1+1+1+4+1 {
+		return errors.New("message too short")
+	}
+	if types.WSConnType(message[0]) != types.WSConnHello {
+		return errors.New("not a hello frame")
+	}
+
+	reqId := message[1]
+	c.ReqId = &reqId
+	c.Version = message[2]
+	c.ReqIdSize = message[3]
+	c.Capabilities = types.Capability(binary.BigEndian.Uint32(message[4:8]))
+
+	count := int(message[8])
+	if len(message) != 9+count {
+		return errors.New("invalid array versions length")
+	}
+	c.ArrayVersions = make([]protocol.Version, count)
+	for i := range c.ArrayVersions {
+		c.ArrayVersions[i] = protocol.Version(message[9+i])
+	}
+	return nil
+}
+
+// Negotiate selects what the connection uses among what the client and
+// the server both support. The error says why the client is refused.
+func (c ClientInputHello) Negotiate(server types.Capability) (types.Session, error) {
+	if c.Version != types.HelloVersion {
+		return types.Session{}, fmt.Errorf("hello version %d not supported, the server speaks %d", c.Version, types.HelloVersion)
+	}
+	if c.ReqIdSize != 1 {
+		return types.Session{}, fmt.Errorf("request ids of %d bytes not supported, the server uses 1", c.ReqIdSize)
+	}
+
+	common := c.Capabilities & server
+	session := types.Session{Capabilities: common, ReqIdSize: 1}
+	switch {
+	case common.Has(types.CapBitProtocol):
+		session.Array = types.CapBitProtocol
+		session.ArrayVersion = protocol.Negotiate(c.ArrayVersions...)
+	case common.Has(types.CapByteProtocol):
+		session.Array = types.CapByteProtocol
+	}
+
+	if common&(types.CapBitProtocol|types.CapByteProtocol|types.CapPathProtocol) == 0 {
+		return types.Session{}, fmt.Errorf("no common encoding, the client decodes %s and the server sends %s", c.Capabilities, server)
+	}
+	return session, nil
+}
+
+// WelcomeOutput is the answer to the HELLO
+func (c ClientInputHello) WelcomeOutput(session types.Session) types.ClientOutput {
+	var reqId uint8
+	if c.ReqId != nil {
+		reqId = *c.ReqId
+	}
+
+	welcome := Welcome{
+		Version:      types.HelloVersion,
+		Capabilities: session.Capabilities,
+		ArrayVersion: session.ArrayVersion,
+		ReqIdSize:    session.ReqIdSize,
+		Codec:        c.WSConn.PayloadCodec().Name(),
+	}
+	if session.Capabilities.Has(types.CapDeflate) {
+		welcome.Dictionary = types.Compression.DictName
+	}
+	switch session.Array {
+	case types.CapBitProtocol:
+		welcome.Array = "bit"
+	case types.CapByteProtocol:
+		welcome.Array = "byte"
+	}
+
+	return types.ClientOutput{
+		ReqId:       reqId,
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: Destination,
+		Data:        welcome,
+	}
+}
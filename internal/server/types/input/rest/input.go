@@ -22,12 +22,7 @@ type ClientInputRest struct {
 }
 
 func (c ClientInputRest) SendToClient(ClientOutput types.ClientOutput) bool {
-	message, err := ClientOutput.Marshal()
-	if err != nil {
-		log.Println("Could not marshal client output")
-		return false
-	}
-	err = c.WSConn.Write(websocket.BinaryMessage, message)
+	err := c.WSConn.WriteOutput(ClientOutput)
 	if err != nil {
 		if websocket.IsCloseError(err) {
 			log.Println("Client closed the connection")
@@ -76,6 +71,40 @@ func (c ClientInputRest) IsValidOperation(operation string) bool {
 	}
 }
 
+// Marshal writes the frame read by Unmarshal. Data is sent as is, it is
+// already encoded. A nil ReqId is sent as 0.
+func (c ClientInputRest) Marshal() ([]byte, error) {
+	if len(c.Method) > 0xFF {
+		return nil, fmt.Errorf("method of %d bytes exceeds 255", len(c.Method))
+	}
+	if len(c.Endpoint) > 0xFFFF {
+		return nil, fmt.Errorf("endpoint of %d bytes exceeds 65535", len(c.Endpoint))
+	}
+	headerBytes, err := json.Marshal(c.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+	if len(headerBytes) > 0xFFFF {
+		return nil, fmt.Errorf("header of %d bytes exceeds 65535", len(headerBytes))
+	}
+
+	var reqId uint8
+	if c.ReqId != nil {
+		reqId = *c.ReqId
+	}
+
+	message := make([]byte, 0, 1+1+1+len(c.Method)+2+len(c.Endpoint)+4+len(c.Data)+2+len(headerBytes))
+	message = append(message, byte(types.WSConnEndpoint), reqId)
+	message = append(message, byte(len(c.Method)))
+	message = append(message, c.Method...)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(c.Endpoint)))
+	message = append(message, c.Endpoint...)
+	message = binary.BigEndian.AppendUint32(message, uint32(len(c.Data)))
+	message = append(message, c.Data...)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(headerBytes)))
+	return append(message, headerBytes...), nil
+}
+
 func (c *ClientInputRest) Unmarshal(message []byte) error {
 	offset := 0
 	if len(message) < 1+1+1 {
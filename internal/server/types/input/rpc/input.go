@@ -9,24 +9,20 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc/procedures"
 )
 
 type ClientInputRPC struct {
 	ReqId  *uint8                     // Unique request ID
 	Class  string                     // What bff to process and receive the requests. 2 bytes for lenght, followed by the string
 	Method string                     // RPC method name (e.g., "getUser", "updateData"). 1 byte for length, followed by the ascii
-	Params map[string]interface{}     // Parameters for the RPC call. 4 bytes for lenght, followed by the JSON string
+	Params map[string]interface{}     // Parameters for the RPC call. 4 bytes for lenght, followed by the params encoded with the codec of the connection
 	Header map[string]string          // Optional headers (for metadata or authentication). 2 bytes for lenght, followed by the JSON string
 	WSConn *types.WebSocketConnection // WebSocket connection for communication
 }
 
 func (c ClientInputRPC) SendToClient(ClientOutput types.ClientOutput) bool {
-	message, err := ClientOutput.Marshal()
-	if err != nil {
-		log.Println("Could not marshal Client Output to send to client")
-		return false
-	}
-	err = c.WSConn.Write(websocket.TextMessage, message)
+	err := c.WSConn.WriteOutput(ClientOutput)
 	if err != nil {
 		if websocket.IsCloseError(err) {
 			log.Println("Client closed the connection")
@@ -56,10 +52,54 @@ func (c *ClientInputRPC) Close() error {
 }
 
 func (c ClientInputRPC) IsValidExecutor() bool {
+	return procedures.IsValidClass(procedures.Class(c.Class))
 }
 
 func (c ClientInputRPC) IsValidOperation(operation string) bool {
+	return procedures.IsValid(procedures.Class(c.Class), procedures.Method(operation))
+}
+
+// Marshal writes the frame read by Unmarshal, the params encoded with the
+// codec of WSConn (JSON without a connection). A nil ReqId is sent as 0.
+func (c ClientInputRPC) Marshal() ([]byte, error) {
+	if len(c.Class) > 0xFFFF {
+		return nil, fmt.Errorf("bff of %d bytes exceeds 65535", len(c.Class))
+	}
+	if len(c.Method) > 0xFF {
+		return nil, fmt.Errorf("method of %d bytes exceeds 255", len(c.Method))
+	}
+
+	params := c.Params
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	paramsBytes, err := c.WSConn.PayloadCodec().Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+	headerBytes, err := json.Marshal(c.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+	if len(headerBytes) > 0xFFFF {
+		return nil, fmt.Errorf("header of %d bytes exceeds 65535", len(headerBytes))
+	}
+
+	var reqId uint8
+	if c.ReqId != nil {
+		reqId = *c.ReqId
+	}
 
+	message := make([]byte, 0, 1+1+2+len(c.Class)+1+len(c.Method)+4+len(paramsBytes)+2+len(headerBytes))
+	message = append(message, byte(types.WSConnRPC), reqId)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(c.Class)))
+	message = append(message, c.Class...)
+	message = append(message, byte(len(c.Method)))
+	message = append(message, c.Method...)
+	message = binary.BigEndian.AppendUint32(message, uint32(len(paramsBytes)))
+	message = append(message, paramsBytes...)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(headerBytes)))
+	return append(message, headerBytes...), nil
 }
 
 func (c *ClientInputRPC) Unmarshal(message []byte) error {
@@ -115,11 +155,11 @@ func (c *ClientInputRPC) Unmarshal(message []byte) error {
 	paramsBytes := message[offset : offset+paramsLen]
 	offset += paramsLen
 
-	// --- 6. Unmarshal params JSON into map
+	// --- 6. Unmarshal params into map, with the codec of the connection
 	if len(paramsBytes) > 0 {
 		var params map[string]interface{}
-		if err := json.Unmarshal(paramsBytes, &params); err != nil {
-			return fmt.Errorf("invalid params JSON: %w", err)
+		if err := c.WSConn.PayloadCodec().Unmarshal(paramsBytes, &params); err != nil {
+			return fmt.Errorf("invalid params %s: %w", c.WSConn.PayloadCodec().Name(), err)
 		}
 		c.Params = params
 	} else {
@@ -19,7 +19,9 @@ type Procedure map[Class]map[Method]HandleFunc
 var routes = make(Procedure)
 
 func Register(class Class, method Method, handler HandleFunc) {
-	routes[class] = make(map[Method]HandleFunc)
+	if routes[class] == nil {
+		routes[class] = make(map[Method]HandleFunc)
+	}
 	routes[class][method] = handler
 }
 
@@ -33,13 +35,6 @@ func IsValid(class Class, method Method) bool {
 	return ok
 }
 
-func Exec(ClientInput types.ClientInputInterface) *types.ClientOutput {
-	// Type assertion to access fields on the concrete type
-	clientInputRPC, ok := ClientInput.(*types.ClientInputRPC)
-	if !ok {
-		// If ClientInput is not of type *types.ClientInputRPC, return nil or handle the error
-		return nil // or handle the error appropriately
-	}
-
-	return routes[Class(clientInputRPC.Class)][Method(clientInputRPC.Method)](clientInputRPC)
+func Exec(class Class, method Method, ClientInput *types.ClientInputInterface) *types.ClientOutput {
+	return routes[class][method](ClientInput)
 }
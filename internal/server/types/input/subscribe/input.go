@@ -1,16 +1,19 @@
 package subscribe
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
-	"strings"
 
 	"github.com/gorilla/websocket"
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
 )
 
 type ClientInputSubscription struct {
-	ReqId  string
+	ReqId  *uint8
 	Topic  string
 	Data   string
 	Header map[string]string
@@ -18,12 +21,7 @@ type ClientInputSubscription struct {
 }
 
 func (c ClientInputSubscription) SendToClient(ClientOutput types.ClientOutput) bool {
-	message, err := ClientOutput.Marshal()
-	if err != nil {
-		log.Println("Could not marshal client output")
-		return false
-	}
-	err = c.WSConn.Write(websocket.TextMessage, []byte(message))
+	err := c.WSConn.WriteOutput(ClientOutput)
 	if err != nil {
 		if websocket.IsCloseError(err) {
 			log.Println("Client closed the connection")
@@ -38,7 +36,7 @@ func (c ClientInputSubscription) SendToClient(ClientOutput types.ClientOutput) b
 func (c ClientInputSubscription) IsValidMessage() error {
 
 	if !c.IsValidTopic() {
-		return errors.New("invalid endpoint")
+		return errors.New("invalid topic")
 	}
 
 	return nil
@@ -48,56 +46,113 @@ func (c *ClientInputSubscription) Close() error {
 	return c.WSConn.Conn.Close()
 }
 
-func (c ClientInputSubscription) IsValidEndpoint() bool {
-	validsEndpoints := c.GetValidEndpoints()
+func (c ClientInputSubscription) IsValidTopic() bool {
+	return topics.IsValid(topics.Topic(c.Topic))
+}
 
-	_, found := validsEndpoints[c.Endpoint]
-	return found
+// Marshal writes a SUBSCRIBE frame, Data is sent as is. A nil ReqId is
+// sent as 0.
+func (c ClientInputSubscription) Marshal() ([]byte, error) {
+	return c.marshal(types.WSConnSubscribe)
 }
 
-func (c ClientInputSubscription) IsValidOperation(operation WSOperation) bool {
-	return GetValidOperations()[operation]
+// MarshalUnsubscribe writes the UNSUBSCRIBE frame of the same topic
+func (c ClientInputSubscription) MarshalUnsubscribe() ([]byte, error) {
+	return c.marshal(types.WSConnUnsubscribe)
 }
 
-func (c *ClientInputSubscription) Unmarshal(message string) {
-	validsEndpoints := c.GetValidEndpoints()
+func (c ClientInputSubscription) marshal(connType types.WSConnType) ([]byte, error) {
+	if len(c.Topic) > 0xFFFF {
+		return nil, fmt.Errorf("topic of %d bytes exceeds 65535", len(c.Topic))
+	}
+	headerBytes, err := json.Marshal(c.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal header: %w", err)
+	}
+	if len(headerBytes) > 0xFFFF {
+		return nil, fmt.Errorf("header of %d bytes exceeds 65535", len(headerBytes))
+	}
+
+	var reqId uint8
+	if c.ReqId != nil {
+		reqId = *c.ReqId
+	}
 
-	if message[0] != '/' {
-		c.ReqId = new(uint8)
-		*c.ReqId = uint8(message[0])
-		message = message[1:]
+	message := make([]byte, 0, 1+1+2+len(c.Topic)+4+len(c.Data)+2+len(headerBytes))
+	message = append(message, byte(connType), reqId)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(c.Topic)))
+	message = append(message, c.Topic...)
+	message = binary.BigEndian.AppendUint32(message, uint32(len(c.Data)))
+	message = append(message, c.Data...)
+	message = binary.BigEndian.AppendUint16(message, uint16(len(headerBytes)))
+	return append(message, headerBytes...), nil
+}
+
+// Unmarshal parses SUBSCRIBE (1) and UNSUBSCRIBE (4) frames. Both share the
+// same layout:
+//
+//	conn_type (1 byte)
+//	reqId     (1 byte)
+//	topic     (2 bytes for length, followed by the string)
+//	data      (4 bytes for length, followed by the JSON string)
+//	header    (2 bytes for length, followed by the JSON string)
+func (c *ClientInputSubscription) Unmarshal(message []byte) error {
+	offset := 0
+	if len(message) < 1+1+2+4+2 {
+		return errors.New("message too short")
 	}
 
-	endpoint, found := validsEndpoints[c.Endpoint]
-	var operation string
-	var origin string
-	var data string
-
-	for _, endpoint := range validsEndpoints {
-		if strings.HasPrefix(message, endpoint) {
-			body := strings.TrimPrefix(message, endpoint)
-
-			if len(body) > 0 {
-				if c.IsValidOperation(WSOperation(body[0])) {
-					operation = string(body[0])
-				}
-				parts := strings.SplitN(body[1:], ";", 2)
-
-				if len(parts) == 2 {
-					origin = parts[0]
-					body = parts[1]
-				} else {
-					return
-				}
-
-				if len(body) > 1 {
-					data = body
-				}
-			}
-			c.Endpoint = endpoint
-			c.Operation = WSOperation(operation)
-			c.Origin = origin
-			c.Data = data
+	// --- 1. Skip conn_type (1 byte)
+	offset++
+
+	// --- 2. reqId (1 byte)
+	reqId := message[offset]
+	c.ReqId = &reqId
+	offset++
+
+	// --- 3. topic length (2 bytes, big endian)
+	topicLen := int(binary.BigEndian.Uint16(message[offset : offset+2]))
+	offset += 2
+
+	if offset+topicLen > len(message) {
+		return errors.New("invalid topic length")
+	}
+	c.Topic = string(message[offset : offset+topicLen])
+	offset += topicLen
+
+	// --- 4. data length (4 bytes, big endian)
+	if offset+4 > len(message) {
+		return errors.New("missing data length")
+	}
+	dataLen := int(binary.BigEndian.Uint32(message[offset : offset+4]))
+	offset += 4
+
+	if offset+dataLen > len(message) {
+		return errors.New("invalid data length")
+	}
+	c.Data = string(message[offset : offset+dataLen])
+	offset += dataLen
+
+	// --- 5. header length (2 bytes, big endian)
+	if offset+2 > len(message) {
+		return errors.New("missing header length")
+	}
+	headerLen := int(binary.BigEndian.Uint16(message[offset : offset+2]))
+	offset += 2
+
+	if offset+headerLen > len(message) {
+		return errors.New("invalid header length")
+	}
+	headerBytes := message[offset : offset+headerLen]
+
+	// --- 6. parse header JSON
+	var header map[string]string
+	if len(headerBytes) > 0 {
+		if err := json.Unmarshal(headerBytes, &header); err != nil {
+			return fmt.Errorf("invalid header JSON: %w", err)
 		}
 	}
+	c.Header = header
+
+	return nil
 }
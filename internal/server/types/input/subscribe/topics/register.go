@@ -4,28 +4,39 @@ import (
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
 )
 
-// ClientInput and ClientOutput
-type HandleFunc func(*types.ClientInputInterface) *types.ClientOutput
+// ClientInput and ClientOutput. Params holds the values captured by the
+// {name} and <name> segments of the registered topic.
+type HandleFunc func(*types.ClientInputInterface, Params) *types.ClientOutput
 
 type Topic string
 
-type Topics map[Topic]HandleFunc
+var topics = NewTrie[HandleFunc]()
 
-var topics = make(Topics)
-
-func Register(topic Topic, handler HandleFunc) {
-	topics[topic] = handler
+// Register accepts exact topics ("chat/lobby") and patterns
+// ("chat/room/{id}", "$$/listen/var/<varname>", "news/#")
+func Register(topic Topic, handler HandleFunc) error {
+	return topics.Set(topic, handler)
 }
 
 func IsValidEndpoint(topic Topic) bool {
-	_, ok := topics[topic]
+	_, ok := topics.Lookup(topic)
 	return ok
 }
 
+// IsValid reports whether a client may subscribe to the topic: a concrete
+// topic matching a registered one. Wildcard and param segments are
+// rejected, they would match every topic of the pattern.
 func IsValid(topic Topic) bool {
-	return IsValidEndpoint(topic)
+	return !IsPattern(topic) && IsValidEndpoint(topic)
 }
 
 func Exec(topic Topic, ClientInput *types.ClientInputInterface) *types.ClientOutput {
-	return topics[topic](ClientInput)
+	if IsPattern(topic) {
+		return nil
+	}
+	match, ok := topics.Lookup(topic)
+	if !ok {
+		return nil
+	}
+	return match.Value(ClientInput, match.Params)
 }
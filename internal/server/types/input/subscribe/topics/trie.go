@@ -0,0 +1,327 @@
+package topics
+
+import (
+	"fmt"
+	"strings"
+)
+
+//
+// Topics are hierarchical names split by '/':
+//
+//   chat/room/42
+//   $$/listen/var/Email
+//
+// Patterns may use wildcards on any segment:
+//
+//   +        any single segment
+//   {name}   any single segment, captured as param "name"
+//   <name>   same as {name}
+//   #        any remaining segments (must be the last one)
+//
+
+const (
+	Separator      = "/"
+	SingleWildcard = "+"
+	MultiWildcard  = "#"
+)
+
+type Params map[string]string
+
+// Match is a pattern stored in the Trie that matched a topic
+type Match[V any] struct {
+	Pattern Topic
+	Value   V
+	Params  Params
+}
+
+type entry[V any] struct {
+	pattern Topic
+	value   V
+	names   []string // param name of each single wildcard, "" when anonymous
+}
+
+type node[V any] struct {
+	children map[string]*node[V]
+	single   *node[V]
+	multi    *entry[V]
+	leaf     *entry[V]
+}
+
+// Trie stores one value per topic pattern and finds every pattern matching
+// a concrete topic walking only the branches that can match it.
+type Trie[V any] struct {
+	root node[V]
+	size int
+}
+
+func NewTrie[V any]() *Trie[V] {
+	return &Trie[V]{}
+}
+
+// Len returns how many patterns are stored
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Set stores the value for the pattern, replacing any previous value
+func (t *Trie[V]) Set(pattern Topic, value V) error {
+	segments, names, multi, err := parsePattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	n := &t.root
+	for _, s := range segments {
+		if s == SingleWildcard {
+			if n.single == nil {
+				n.single = &node[V]{}
+			}
+			n = n.single
+			continue
+		}
+		if n.children == nil {
+			n.children = make(map[string]*node[V])
+		}
+		child, ok := n.children[s]
+		if !ok {
+			child = &node[V]{}
+			n.children[s] = child
+		}
+		n = child
+	}
+
+	e := &entry[V]{pattern: pattern, value: value, names: names}
+	if multi {
+		if n.multi == nil {
+			t.size++
+		}
+		n.multi = e
+	} else {
+		if n.leaf == nil {
+			t.size++
+		}
+		n.leaf = e
+	}
+	return nil
+}
+
+// Get returns the value stored for the exact pattern
+func (t *Trie[V]) Get(pattern Topic) (V, bool) {
+	var zero V
+
+	n, multi := t.find(pattern)
+	if n == nil {
+		return zero, false
+	}
+	e := n.leaf
+	if multi {
+		e = n.multi
+	}
+	if e == nil {
+		return zero, false
+	}
+	return e.value, true
+}
+
+// Delete removes the pattern. It returns false when it was not stored.
+func (t *Trie[V]) Delete(pattern Topic) bool {
+	n, multi := t.find(pattern)
+	if n == nil {
+		return false
+	}
+	if multi {
+		if n.multi == nil {
+			return false
+		}
+		n.multi = nil
+	} else {
+		if n.leaf == nil {
+			return false
+		}
+		n.leaf = nil
+	}
+	t.size--
+	t.prune(pattern)
+	return true
+}
+
+// Match returns every stored pattern matching the topic. Literal segments
+// are visited before wildcards, so the first match is the most specific.
+func (t *Trie[V]) Match(topic Topic) []Match[V] {
+	var matches []Match[V]
+	segments := Split(topic)
+	captured := make([]string, 0, len(segments))
+	t.match(&t.root, segments, captured, &matches)
+	return matches
+}
+
+// Lookup returns the most specific pattern matching the topic
+func (t *Trie[V]) Lookup(topic Topic) (Match[V], bool) {
+	matches := t.Match(topic)
+	if len(matches) == 0 {
+		return Match[V]{}, false
+	}
+	return matches[0], true
+}
+
+func (t *Trie[V]) match(n *node[V], segments []string, captured []string, matches *[]Match[V]) {
+	if len(segments) == 0 {
+		if n.leaf != nil {
+			*matches = append(*matches, n.leaf.match(captured, ""))
+		}
+	} else {
+		if child, ok := n.children[segments[0]]; ok {
+			t.match(child, segments[1:], captured, matches)
+		}
+		if n.single != nil {
+			t.match(n.single, segments[1:], append(captured, segments[0]), matches)
+		}
+	}
+	if n.multi != nil {
+		*matches = append(*matches, n.multi.match(captured, strings.Join(segments, Separator)))
+	}
+}
+
+func (e *entry[V]) match(captured []string, rest string) Match[V] {
+	m := Match[V]{Pattern: e.pattern, Value: e.value}
+	for i, name := range e.names {
+		if name == "" {
+			continue
+		}
+		if m.Params == nil {
+			m.Params = make(Params)
+		}
+		m.Params[name] = captured[i]
+	}
+	if rest != "" {
+		if m.Params == nil {
+			m.Params = make(Params)
+		}
+		m.Params[MultiWildcard] = rest
+	}
+	return m
+}
+
+func (t *Trie[V]) find(pattern Topic) (*node[V], bool) {
+	segments, _, multi, err := parsePattern(pattern)
+	if err != nil {
+		return nil, false
+	}
+
+	n := &t.root
+	for _, s := range segments {
+		if s == SingleWildcard {
+			n = n.single
+		} else {
+			n = n.children[s]
+		}
+		if n == nil {
+			return nil, false
+		}
+	}
+	return n, multi
+}
+
+// prune removes the empty nodes left behind by Delete
+func (t *Trie[V]) prune(pattern Topic) {
+	segments, _, _, _ := parsePattern(pattern)
+
+	path := make([]*node[V], 0, len(segments)+1)
+	n := &t.root
+	path = append(path, n)
+	for _, s := range segments {
+		if s == SingleWildcard {
+			n = n.single
+		} else {
+			n = n.children[s]
+		}
+		path = append(path, n)
+	}
+
+	for i := len(segments); i > 0; i-- {
+		n := path[i]
+		if n.leaf != nil || n.multi != nil || n.single != nil || len(n.children) > 0 {
+			return
+		}
+		parent := path[i-1]
+		if segments[i-1] == SingleWildcard {
+			parent.single = nil
+		} else {
+			delete(parent.children, segments[i-1])
+		}
+	}
+}
+
+//
+// ─────────────────────────────────────────────────────────────
+//  TOPIC PARSING
+// ─────────────────────────────────────────────────────────────
+//
+
+// Split breaks a topic in its segments
+func Split(topic Topic) []string {
+	return strings.Split(string(topic), Separator)
+}
+
+// IsPattern reports whether the topic has any wildcard segment
+func IsPattern(topic Topic) bool {
+	for _, s := range Split(topic) {
+		if s == SingleWildcard || s == MultiWildcard || paramName(s) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePattern normalizes every single wildcard to "+" and returns the
+// param names in order of appearance. A trailing "#" is removed from the
+// segments and reported by multi.
+func parsePattern(pattern Topic) (segments []string, names []string, multi bool, err error) {
+	segments = Split(pattern)
+	for i, s := range segments {
+		if s == MultiWildcard {
+			if i != len(segments)-1 {
+				return nil, nil, false, fmt.Errorf("invalid topic %q: %s must be the last segment", pattern, MultiWildcard)
+			}
+			return segments[:i], names, true, nil
+		}
+		if s == SingleWildcard {
+			names = append(names, "")
+			continue
+		}
+		if name := paramName(s); name != "" {
+			segments[i] = SingleWildcard
+			names = append(names, name)
+		}
+	}
+	return segments, names, false, nil
+}
+
+// paramName returns "name" for "{name}" and "<name>" segments
+func paramName(segment string) string {
+	if len(segment) < 3 {
+		return ""
+	}
+	first, last := segment[0], segment[len(segment)-1]
+	if (first == '{' && last == '}') || (first == '<' && last == '>') {
+		return segment[1 : len(segment)-1]
+	}
+	return ""
+}
+
+// Matches reports whether the concrete topic matches the pattern
+func Matches(pattern Topic, topic Topic) bool {
+	p, t := Split(pattern), Split(topic)
+	for i, s := range p {
+		if s == MultiWildcard && i == len(p)-1 {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if s != SingleWildcard && paramName(s) == "" && s != t[i] {
+			return false
+		}
+	}
+	return len(p) == len(t)
+}
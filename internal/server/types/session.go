@@ -0,0 +1,120 @@
+package types
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// What a connection agreed on with the HELLO frame (see input/hello). A
+// client that sends no HELLO gets DefaultSession, what every client
+// understood before the handshake existed.
+//
+
+// HelloVersion is the version of the handshake itself
+const HelloVersion uint8 = 1
+
+// Capability flags exchanged in the handshake
+type Capability uint32
+
+const (
+	// Decodes the array operations of protocol (1 byte header)
+	CapBitProtocol Capability = 1 << iota
+	// Decodes the array operations of byteprotocol (4 byte header)
+	CapByteProtocol
+	// Decodes the path operations of pathprotocol
+	CapPathProtocol
+	// Reads compressed bulk inserts in the array operations
+	CapDeflate
+)
+
+var capabilityNames = []struct {
+	flag Capability
+	name string
+}{
+	{CapBitProtocol, "bit"},
+	{CapByteProtocol, "byte"},
+	{CapPathProtocol, "path"},
+	{CapDeflate, "deflate"},
+}
+
+// Has reports whether every flag of want is set
+func (c Capability) Has(want Capability) bool {
+	return c&want == want
+}
+
+func (c Capability) String() string {
+	var names []string
+	for _, n := range capabilityNames {
+		if c.Has(n.flag) {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ServerCapabilities is what this server can send
+var ServerCapabilities = CapBitProtocol | CapByteProtocol | CapPathProtocol | CapDeflate
+
+// Compression of what is sent to the clients, set before serving. A zero
+// Threshold sends everything uncompressed.
+var Compression = compress.Default
+
+// deflater of the bulk inserts, built from Compression on first use
+var deflater = sync.OnceValue(func() *compress.Deflater {
+	d, err := Compression.Deflater()
+	if err != nil {
+		log.Println("Bulk inserts sent uncompressed:", err)
+		return nil
+	}
+	return d
+})
+
+type Session struct {
+	// Capabilities both sides have
+	Capabilities Capability
+	// Array encoding sent to the client, CapBitProtocol or CapByteProtocol.
+	// Zero when the client decodes neither.
+	Array        Capability
+	ArrayVersion protocol.Version
+	// Bytes of the request ids, only 1 exists today
+	ReqIdSize uint8
+}
+
+var DefaultSession = Session{
+	Capabilities: CapBitProtocol | CapByteProtocol | CapPathProtocol,
+	Array:        CapBitProtocol,
+	ArrayVersion: protocol.V1,
+	ReqIdSize:    1,
+}
+
+// ArrayEncoder returns the encoder of the array operations agreed on, nil
+// when the client decodes none. Bulk inserts are compressed when the client
+// reads them.
+func (s Session) ArrayEncoder() diff.Encoder {
+	var enc diff.Encoder
+	switch s.Array {
+	case CapBitProtocol:
+		enc = diff.Bit
+		if s.ArrayVersion == protocol.V2 {
+			enc = diff.BitV2
+		}
+	case CapByteProtocol:
+		enc = diff.Byte
+	default:
+		return nil
+	}
+
+	if d := deflater(); d != nil && s.Capabilities.Has(CapDeflate) {
+		return diff.Deflated(enc, d, Compression.Threshold)
+	}
+	return enc
+}
@@ -1,28 +1,181 @@
 package types
 
 import (
+	"io"
 	"sync"
 
 	"github.com/gorilla/websocket"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
 )
 
 type WebSocketConnection struct {
 	Conn *websocket.Conn
 	mu   sync.Mutex
+
+	// Id of the authenticated user, empty when the connection is anonymous
+	Id string
+
+	// Codec of the payloads, agreed at the upgrade. Nil means JSON.
+	Codec codec.Codec
+
+	// Transport of the frames, agreed at the upgrade with the codec
+	Transport Transport
+
+	// Session agreed with the HELLO frame, set before any other frame is
+	// handled. The zero value means the client sent none.
+	Session Session
+
+	// State of the business packages for this connection, see Scope
+	scope     *state.Scope
+	scopeOnce sync.Once
+}
+
+// Scope returns the state of the business packages for the connection,
+// created on first use
+func (wsc *WebSocketConnection) Scope() *state.Scope {
+	wsc.scopeOnce.Do(func() {
+		wsc.scope = state.NewScope()
+	})
+	return wsc.scope
+}
+
+// FlushState pushes the state values changed since the last flush to the
+// client, on Destination state.Destination. Clients that do not decode the
+// path protocol are sent nothing.
+func (wsc *WebSocketConnection) FlushState() error {
+	session := wsc.Negotiated()
+	if !session.Capabilities.Has(CapPathProtocol) {
+		return nil
+	}
+	return wsc.Scope().Flush(session.ArrayEncoder(), wsc.PayloadCodec(), func(msg []byte) error {
+		output := ClientOutput{
+			MsgType:     WSTypeSuccessOutputMessage,
+			Destination: state.Destination,
+			Data:        msg,
+		}
+		// Without compression the frame goes straight into the connection
+		if wsc.Transport == TransportText || Compression.Threshold > 0 {
+			return wsc.WriteOutput(output)
+		}
+		return wsc.WriteWith(websocket.BinaryMessage, func(w io.Writer) error {
+			return output.EncodeTo(w, wsc.PayloadCodec())
+		})
+	})
+}
+
+// Negotiated returns the session of the connection, DefaultSession when
+// the client sent no HELLO
+func (wsc *WebSocketConnection) Negotiated() Session {
+	if wsc == nil || wsc.Session.ReqIdSize == 0 {
+		return DefaultSession
+	}
+	return wsc.Session
+}
+
+// PayloadCodec returns the codec of the connection, JSON when there is none
+func (wsc *WebSocketConnection) PayloadCodec() codec.Codec {
+	if wsc == nil || wsc.Codec == nil {
+		return codec.JSON
+	}
+	return wsc.Codec
+}
+
+// MarshalOutput encodes the output for the transport and the codec of the
+// connection, messageType is the frame to send it in
+func (wsc *WebSocketConnection) MarshalOutput(output ClientOutput) (messageType int, data []byte, err error) {
+	if wsc.Transport == TransportText {
+		data, err = output.MarshalEnvelope()
+		return websocket.TextMessage, data, err
+	}
+	data, err = output.MarshalWith(wsc.PayloadCodec())
+	return websocket.BinaryMessage, data, err
 }
 
+// Format names how the outputs are encoded, connections with the same
+// format are sent the same bytes
+func (wsc *WebSocketConnection) Format() string {
+	if wsc.Transport == TransportText {
+		return TextSubprotocol
+	}
+	return wsc.PayloadCodec().Name()
+}
+
+// WriteOutput encodes the output for the connection and sends it
+func (wsc *WebSocketConnection) WriteOutput(output ClientOutput) error {
+	messageType, data, err := wsc.MarshalOutput(output)
+	if err != nil {
+		return err
+	}
+	return wsc.Write(messageType, data)
+}
+
+// Write sends one message, compressed with permessage-deflate when the
+// client agreed on it and the message reaches Compression.Threshold
 func (wsc *WebSocketConnection) Write(messageType int, data []byte) error {
 	wsc.mu.Lock()
 	defer wsc.mu.Unlock()
+	wsc.Conn.EnableWriteCompression(Compression.Threshold > 0 && len(data) >= Compression.Threshold)
 	return wsc.Conn.WriteMessage(messageType, data)
 }
 
+// WriteWith sends one message written by fn straight into the connection,
+// without building it in a separate buffer first. Its size is not known
+// upfront, it is sent uncompressed.
+func (wsc *WebSocketConnection) WriteWith(messageType int, fn func(io.Writer) error) error {
+	wsc.mu.Lock()
+	defer wsc.mu.Unlock()
+	wsc.Conn.EnableWriteCompression(false)
+	w, err := wsc.Conn.NextWriter(messageType)
+	if err != nil {
+		return err
+	}
+	if err := fn(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Transport of the frames of a connection
+type Transport uint8
+
+const (
+	// Binary frames, the layouts documented on each input and ClientOutput
+	TransportBinary Transport = iota
+	// Text frames holding the JSON envelope of each frame (see
+	// input/envelope and ClientOutput.MarshalEnvelope). The payloads are
+	// JSON.
+	TransportText
+)
+
+// TextSubprotocol selects TransportText at the upgrade
+const TextSubprotocol = codec.SubprotocolPrefix + "text"
+
+// FromSubprotocols picks the transport and the codec of a WebSocket upgrade
+// from the subprotocols offered, the first one known wins. protocol is the
+// subprotocol to answer with, empty when the client offered none.
+func FromSubprotocols(offered []string) (transport Transport, payloads codec.Codec, protocol string) {
+	for _, p := range offered {
+		if p == TextSubprotocol {
+			return TransportText, codec.JSON, TextSubprotocol
+		}
+		if c, protocol := codec.FromSubprotocols([]string{p}); protocol != "" {
+			return TransportBinary, c, protocol
+		}
+	}
+	return TransportBinary, codec.JSON, ""
+}
+
 type PID uint8
 
 type ProcessorQueue map[PID]ClientOutput
 
 type WSTypeOutputMessage byte
 
+// WSConnType is the first byte of every client frame
+type WSConnType byte
+
 type WSOperation string
 
 type WSDestination string
@@ -37,6 +190,12 @@ const (
 	WSTypeSuccessOutputMessage WSTypeOutputMessage = 'S'
 
 	WSDestinationUnknown WSDestination = "*unknown"
+
+	WSConnSubscribe   WSConnType = 1
+	WSConnRPC         WSConnType = 2
+	WSConnEndpoint    WSConnType = 3
+	WSConnUnsubscribe WSConnType = 4
+	WSConnHello       WSConnType = 5
 )
 
 func GetValidOperations() map[WSOperation]bool {
@@ -54,18 +213,54 @@ type Subscription struct {
 	Callback    func(ClientOutput) // The function to execute
 }
 
+// WebSocketClient dispatches the messages received by a client to the
+// callbacks subscribed to them. Match compares a subscribed destination with
+// the one of a message, exact equality when nil, so wildcard topics can be
+// matched by the caller's rules.
 type WebSocketClient struct {
+	mu            sync.RWMutex
 	Subscriptions []Subscription
+	Match         func(subscribed, destination string) bool
 }
 
 func (client *WebSocketClient) Subscribe(subscription Subscription) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 	client.Subscriptions = append(client.Subscriptions, subscription)
 }
 
+// Unsubscribe removes every callback subscribed to the destination
+func (client *WebSocketClient) Unsubscribe(destination string) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	kept := client.Subscriptions[:0]
+	for _, sub := range client.Subscriptions {
+		if sub.Destination != destination {
+			kept = append(kept, sub)
+		}
+	}
+	client.Subscriptions = kept
+}
+
 func (client *WebSocketClient) ProcessMessage(msg ClientOutput) {
+	client.mu.RLock()
+	var callbacks []func(ClientOutput)
 	for _, sub := range client.Subscriptions {
-		if sub.MsgType == msg.MsgType && sub.Destination == msg.Destination {
-			sub.Callback(msg) // Execute the callback
+		if sub.MsgType == msg.MsgType && client.matches(sub.Destination, msg.Destination) {
+			callbacks = append(callbacks, sub.Callback)
 		}
 	}
+	client.mu.RUnlock()
+
+	// callbacks run unlocked, they may subscribe or unsubscribe
+	for _, callback := range callbacks {
+		callback(msg) // Execute the callback
+	}
+}
+
+func (client *WebSocketClient) matches(subscribed, destination string) bool {
+	if client.Match == nil {
+		return subscribed == destination
+	}
+	return client.Match(subscribed, destination)
 }
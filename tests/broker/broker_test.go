@@ -0,0 +1,305 @@
+package broker
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// pair holds both ends of a websocket: the server side, as the broker sees
+// it, and the client side used to read what was published.
+type pair struct {
+	server *types.WebSocketConnection
+	client *websocket.Conn
+}
+
+func newPair(t *testing.T) pair {
+	t.Helper()
+
+	serverSide := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverSide <- c
+	}))
+	t.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return pair{
+		server: &types.WebSocketConnection{Conn: <-serverSide},
+		client: client,
+	}
+}
+
+type frame struct {
+	reqId       uint8
+	destination string
+	data        string // set when the payload is a string
+	raw         []byte // the payload as encoded by the codec
+	header      map[string]string
+}
+
+// readFrame reads one ClientOutput frame from the client side
+func readFrame(t *testing.T, c *websocket.Conn) frame {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := frame{reqId: msg[0]}
+	offset := 2
+	destLen := int(binary.BigEndian.Uint16(msg[offset:]))
+	offset += 2
+	f.destination = string(msg[offset : offset+destLen])
+	offset += destLen
+	dataLen := int(binary.BigEndian.Uint32(msg[offset:]))
+	offset += 4
+
+	f.raw = msg[offset : offset+dataLen]
+	if len(f.raw) > 0 && f.raw[0] == '"' {
+		if err := json.Unmarshal(f.raw, &f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offset += dataLen
+	headerLen := int(binary.BigEndian.Uint16(msg[offset:]))
+	offset += 2
+
+	if err := json.Unmarshal(msg[offset:offset+headerLen], &f.header); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// readOutput reads one ClientOutput frame from the client side
+func readOutput(t *testing.T, c *websocket.Conn) (uint8, string, string) {
+	t.Helper()
+
+	f := readFrame(t, c)
+	return f.reqId, f.destination, f.data
+}
+
+func mustSubscribe(t *testing.T, b *broker.Broker, wsc *types.WebSocketConnection, topic topics.Topic) {
+	t.Helper()
+
+	if _, err := b.Subscribe(wsc, topic); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectNothing(t *testing.T, c *websocket.Conn) {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, msg, err := c.ReadMessage(); err == nil {
+		t.Fatalf("expected no message, got %v", msg)
+	}
+}
+
+//
+// --- Tests ---
+//
+
+func TestPublishFanOut(t *testing.T) {
+	b := broker.New()
+	a, c := newPair(t), newPair(t)
+
+	mustSubscribe(t, b, a.server, "chat")
+	mustSubscribe(t, b, c.server, "chat")
+
+	n, err := b.Publish("chat", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", n)
+	}
+
+	for _, p := range []pair{a, c} {
+		reqId, dest, data := readOutput(t, p.client)
+		if reqId != 0 || dest != "chat" || data != "hello" {
+			t.Fatalf("unexpected frame %d %q %q", reqId, dest, data)
+		}
+	}
+}
+
+func TestPublishOnlyToTopicSubscribers(t *testing.T) {
+	b := broker.New()
+	a, c := newPair(t), newPair(t)
+
+	mustSubscribe(t, b, a.server, "chat")
+	mustSubscribe(t, b, c.server, "news")
+
+	if n, _ := b.Publish("chat", "hello"); n != 1 {
+		t.Fatalf("expected 1 delivery, got %d", n)
+	}
+	readOutput(t, a.client)
+	expectNothing(t, c.client)
+}
+
+func TestSubscribeTwice(t *testing.T) {
+	b := broker.New()
+	a := newPair(t)
+
+	if ok, _ := b.Subscribe(a.server, "chat"); !ok {
+		t.Fatal("first subscription must be accepted")
+	}
+	if ok, _ := b.Subscribe(a.server, "chat"); ok {
+		t.Fatal("second subscription must be reported as duplicated")
+	}
+	if n, _ := b.Publish("chat", "x"); n != 1 {
+		t.Fatalf("expected 1 delivery, got %d", n)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	b := broker.New()
+	a := newPair(t)
+
+	mustSubscribe(t, b, a.server, "chat")
+	if !b.Unsubscribe(a.server, "chat") {
+		t.Fatal("unsubscribe must succeed")
+	}
+	if b.Unsubscribe(a.server, "chat") {
+		t.Fatal("unsubscribe of a missing subscription must fail")
+	}
+	if n, _ := b.Publish("chat", "x"); n != 0 {
+		t.Fatalf("expected no delivery, got %d", n)
+	}
+	expectNothing(t, a.client)
+}
+
+func TestRemoveConnection(t *testing.T) {
+	b := broker.New()
+	a := newPair(t)
+
+	mustSubscribe(t, b, a.server, "chat")
+	mustSubscribe(t, b, a.server, "news")
+	b.RemoveConnection(a.server)
+
+	if len(b.Topics(a.server)) != 0 {
+		t.Fatalf("expected no topics, got %v", b.Topics(a.server))
+	}
+	for _, topic := range []topics.Topic{"chat", "news"} {
+		if len(b.Subscribers(topic)) != 0 {
+			t.Fatalf("expected no subscribers on %s", topic)
+		}
+	}
+}
+
+func TestPublishDropsClosedConnections(t *testing.T) {
+	b := broker.New()
+	a := newPair(t)
+
+	mustSubscribe(t, b, a.server, "chat")
+	a.server.Conn.Close()
+
+	if n, _ := b.Publish("chat", "x"); n != 0 {
+		t.Fatalf("expected no delivery, got %d", n)
+	}
+	if len(b.Subscribers("chat")) != 0 {
+		t.Fatal("closed connection must be unsubscribed")
+	}
+}
+
+func TestPublishToWildcardSubscribers(t *testing.T) {
+	b := broker.New()
+	single, multi, exact, other := newPair(t), newPair(t), newPair(t), newPair(t)
+
+	mustSubscribe(t, b, single.server, "chat/room/+")
+	mustSubscribe(t, b, multi.server, "chat/#")
+	mustSubscribe(t, b, exact.server, "chat/room/42")
+	mustSubscribe(t, b, other.server, "chat/room/43")
+
+	if n, _ := b.Publish("chat/room/42", "hi"); n != 3 {
+		t.Fatalf("expected 3 deliveries, got %d", n)
+	}
+	for _, p := range []pair{single, multi, exact} {
+		if _, dest, _ := readOutput(t, p.client); dest != "chat/room/42" {
+			t.Fatalf("expected the published topic as destination, got %q", dest)
+		}
+	}
+	expectNothing(t, other.client)
+}
+
+func TestOverlappingSubscriptionsDeliverOnce(t *testing.T) {
+	b := broker.New()
+	a := newPair(t)
+
+	mustSubscribe(t, b, a.server, "chat/#")
+	mustSubscribe(t, b, a.server, "chat/room/42")
+
+	if n, _ := b.Publish("chat/room/42", "hi"); n != 1 {
+		t.Fatalf("expected 1 delivery, got %d", n)
+	}
+}
+
+func TestPublishToPatternFails(t *testing.T) {
+	b := broker.New()
+
+	if _, err := b.Publish("chat/+", "x"); err == nil {
+		t.Fatal("expected error publishing to a pattern")
+	}
+}
+
+func TestSubscribeInvalidPattern(t *testing.T) {
+	b := broker.New()
+	a := newPair(t)
+
+	if _, err := b.Subscribe(a.server, "chat/#/room"); err == nil {
+		t.Fatal("expected error for # in the middle of the topic")
+	}
+}
+
+func TestPublishEncodesWithEachCodec(t *testing.T) {
+	b := broker.New()
+	plain, packed := newPair(t), newPair(t)
+	packed.server.Codec = codec.MsgPack
+
+	mustSubscribe(t, b, plain.server, "score")
+	mustSubscribe(t, b, packed.server, "score")
+
+	if n, _ := b.Publish("score", map[string]int{"n": 1}); n != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", n)
+	}
+
+	if f := readFrame(t, plain.client); string(f.raw) != `{"n":1}` {
+		t.Fatalf("expected a JSON object, got %s", f.raw)
+	}
+
+	var v map[string]int
+	if err := codec.MsgPack.Unmarshal(readFrame(t, packed.client).raw, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v["n"] != 1 {
+		t.Fatalf("expected a MessagePack map, got %v", v)
+	}
+}
@@ -0,0 +1,441 @@
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker/redis"
+)
+
+//
+// --- Redis stand-in ---
+//
+
+// fakeRedis speaks the subset of RESP used by the redis backend:
+// PUBLISH, SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE and PUNSUBSCRIBE.
+type fakeRedis struct {
+	ln        net.Listener
+	mu        sync.Mutex
+	clients   map[*fakeClient]bool
+	published int
+}
+
+type fakeClient struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	w        *bufio.Writer
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, clients: make(map[*fakeClient]bool)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	c := &fakeClient{
+		conn:     conn,
+		w:        bufio.NewWriter(conn),
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+	f.mu.Lock()
+	f.clients[c] = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.clients, c)
+		f.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "PUBLISH":
+			n := f.publish(args[1], args[2])
+			c.write(fmt.Sprintf(":%d\r\n", n))
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+			c.mu.Lock()
+			set := c.channels
+			if args[0][0] == 'P' {
+				set = c.patterns
+			}
+			for _, ch := range args[1:] {
+				if strings.Contains(args[0], "UNSUB") {
+					delete(set, ch)
+				} else {
+					set[ch] = true
+				}
+			}
+			c.mu.Unlock()
+			for _, ch := range args[1:] {
+				c.write(bulkArray(strings.ToLower(args[0]), ch))
+			}
+		}
+	}
+}
+
+func (f *fakeRedis) publish(channel, message string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published++
+
+	n := 0
+	for c := range f.clients {
+		c.mu.Lock()
+		subscribed := c.channels[channel]
+		var patterns []string
+		for p := range c.patterns {
+			if globMatch(p, channel) {
+				patterns = append(patterns, p)
+			}
+		}
+		c.mu.Unlock()
+
+		if subscribed {
+			c.write(bulkArray("message", channel, message))
+			n++
+		}
+		for _, p := range patterns {
+			c.write(bulkArray("pmessage", p, channel, message))
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) publishCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.published
+}
+
+// drop closes every client connection, as a Redis restart would
+func (f *fakeRedis) drop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		c.conn.Close()
+		delete(f.clients, c)
+	}
+}
+
+// listening counts the clients holding at least one subscription
+func (f *fakeRedis) listening() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for c := range f.clients {
+		c.mu.Lock()
+		if len(c.channels)+len(c.patterns) > 0 {
+			n++
+		}
+		c.mu.Unlock()
+	}
+	return n
+}
+
+func (c *fakeClient) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.WriteString(s)
+	c.w.Flush()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulkArray(values ...string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%d\r\n", len(values))
+	for _, v := range values {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(v), v)
+	}
+	return sb.String()
+}
+
+// globMatch implements the '*', '?' and '\' rules of Redis patterns
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return s == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(s); i++ {
+			if globMatch(pattern[1:], s[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return s != "" && globMatch(pattern[1:], s[1:])
+	case '\\':
+		if len(pattern) > 1 {
+			pattern = pattern[1:]
+		}
+	}
+	return s != "" && pattern[0] == s[0] && globMatch(pattern[1:], s[1:])
+}
+
+//
+// --- Test Helpers ---
+//
+
+// redisAddr uses REDIS_ADDR when set, otherwise the stand-in
+func redisAddr(t *testing.T) (string, *fakeRedis) {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		return addr, nil
+	}
+	f := newFakeRedis(t)
+	return f.ln.Addr().String(), f
+}
+
+func newRedisBroker(t *testing.T, addr string) *broker.Broker {
+	t.Helper()
+
+	backend, err := redis.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend.Prefix = "test:" + t.Name() + ":"
+
+	b, err := broker.NewWithBackend(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+// settle gives the backend time to process subscribe commands
+func settle() {
+	time.Sleep(50 * time.Millisecond)
+}
+
+//
+// --- Tests ---
+//
+
+func TestMemoryHubAcrossNodes(t *testing.T) {
+	hub := broker.NewMemoryHub()
+	node1, err := broker.NewWithBackend(hub.Backend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node2, err := broker.NewWithBackend(hub.Backend())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, remote := newPair(t), newPair(t)
+	mustSubscribe(t, node1, local.server, "chat/room/+")
+	mustSubscribe(t, node2, remote.server, "chat/room/42")
+
+	if n, err := node1.Publish("chat/room/42", "hi"); err != nil || n != 1 {
+		t.Fatalf("expected 1 local delivery, got %d %v", n, err)
+	}
+
+	for _, p := range []pair{local, remote} {
+		if _, dest, data := readOutput(t, p.client); dest != "chat/room/42" || data != "hi" {
+			t.Fatalf("unexpected frame %q %q", dest, data)
+		}
+	}
+	expectNothing(t, local.client)
+}
+
+func TestMemoryHubCarriesValues(t *testing.T) {
+	hub := broker.NewMemoryHub()
+	node1, err := broker.NewWithBackend(hub.Backend())
+	if err != nil {
+		t.Fatal(err)
+	}
+	node2, err := broker.NewWithBackend(hub.Backend())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := newPair(t)
+	mustSubscribe(t, node2, remote.server, "score")
+
+	node1.Publish("score", map[string]any{"n": 1, "who": "ana"})
+	if f := readFrame(t, remote.client); string(f.raw) != `{"n":1,"who":"ana"}` {
+		t.Fatalf("expected the value itself, got %s", f.raw)
+	}
+}
+
+func TestMemoryHubSkipsNodesWithoutInterest(t *testing.T) {
+	hub := broker.NewMemoryHub()
+	node1, _ := broker.NewWithBackend(hub.Backend())
+	node2, _ := broker.NewWithBackend(hub.Backend())
+
+	remote := newPair(t)
+	mustSubscribe(t, node2, remote.server, "news")
+	node2.Unsubscribe(remote.server, "news")
+
+	node1.Publish("news", "x")
+	expectNothing(t, remote.client)
+}
+
+func TestRedisAcrossNodes(t *testing.T) {
+	addr, _ := redisAddr(t)
+	node1 := newRedisBroker(t, addr)
+	node2 := newRedisBroker(t, addr)
+
+	local, remote := newPair(t), newPair(t)
+	mustSubscribe(t, node1, local.server, "chat/room/42")
+	mustSubscribe(t, node2, remote.server, "chat/room/{id}")
+	settle()
+
+	if _, err := node1.Publish("chat/room/42", "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []pair{local, remote} {
+		if _, dest, data := readOutput(t, p.client); dest != "chat/room/42" || data != "hi" {
+			t.Fatalf("unexpected frame %q %q", dest, data)
+		}
+	}
+	// own message coming back from redis must not be delivered again
+	expectNothing(t, local.client)
+}
+
+func TestRedisOverlappingPatternsDeliverOnce(t *testing.T) {
+	addr, _ := redisAddr(t)
+	node1 := newRedisBroker(t, addr)
+	node2 := newRedisBroker(t, addr)
+
+	a, b := newPair(t), newPair(t)
+	mustSubscribe(t, node2, a.server, "chat/#")
+	mustSubscribe(t, node2, b.server, "chat/room/42")
+	settle()
+
+	node1.Publish("chat/room/42", "hi")
+
+	readOutput(t, a.client)
+	readOutput(t, b.client)
+	expectNothing(t, a.client)
+	expectNothing(t, b.client)
+}
+
+func TestRedisPatternFilteredLocally(t *testing.T) {
+	addr, _ := redisAddr(t)
+	node1 := newRedisBroker(t, addr)
+	node2 := newRedisBroker(t, addr)
+
+	a := newPair(t)
+	mustSubscribe(t, node2, a.server, "chat/+")
+	settle()
+
+	// "chat/*" glob also matches deeper topics, the broker must drop them
+	node1.Publish("chat/room/42", "x")
+	expectNothing(t, a.client)
+}
+
+func TestRedisPublishesOncePerNode(t *testing.T) {
+	addr, fake := redisAddr(t)
+	if fake == nil {
+		t.Skip("needs the stand-in to count publications")
+	}
+	node1 := newRedisBroker(t, addr)
+	node2 := newRedisBroker(t, addr)
+
+	clients := []pair{newPair(t), newPair(t), newPair(t)}
+	for _, p := range clients {
+		mustSubscribe(t, node2, p.server, "chat")
+	}
+	settle()
+
+	node1.Publish("chat", "hi")
+
+	for _, p := range clients {
+		readOutput(t, p.client)
+	}
+	if fake.publishCount() != 1 {
+		t.Fatalf("expected 1 publication on the bus, got %d", fake.publishCount())
+	}
+}
+
+func TestRedisReconnects(t *testing.T) {
+	addr, fake := redisAddr(t)
+	if fake == nil {
+		t.Skip("needs the stand-in to drop connections")
+	}
+	node1 := newRedisBroker(t, addr)
+	node2 := newRedisBroker(t, addr)
+
+	remote := newPair(t)
+	mustSubscribe(t, node2, remote.server, "chat/room/+")
+	settle()
+
+	fake.drop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.listening() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriptions were not sent again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the publishing connection is dialed again on the way
+	if _, err := node1.Publish("chat/room/42", "back"); err != nil {
+		t.Fatal(err)
+	}
+	if _, dest, data := readOutput(t, remote.client); dest != "chat/room/42" || data != "back" {
+		t.Fatalf("unexpected frame %q %q", dest, data)
+	}
+}
@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+func readDiff(t *testing.T, p pair) broker.PresenceDiff {
+	t.Helper()
+
+	f := readFrame(t, p.client)
+	if !broker.IsPresenceTopic(topics.Topic(f.destination)) {
+		t.Fatalf("expected presence frame, got %+v", f)
+	}
+	var diff broker.PresenceDiff
+	if err := json.Unmarshal(f.raw, &diff); err != nil {
+		t.Fatal(err)
+	}
+	return diff
+}
+
+func TestJoinAndLeaveDiffs(t *testing.T) {
+	b := broker.New()
+	b.TrackPresence("todo/+")
+
+	watcher, ana := newPair(t), newPair(t)
+	mustSubscribe(t, b, watcher.server, broker.PresenceTopic("todo/1"))
+
+	mustSubscribe(t, b, ana.server, "todo/1")
+	b.Join(ana.server, "todo/1", broker.Member{Id: "ana", Meta: map[string]string{"name": "Ana"}})
+
+	diff := readDiff(t, watcher)
+	if len(diff.Joins) != 1 || diff.Joins[0].Id != "ana" || diff.Joins[0].Meta["name"] != "Ana" {
+		t.Fatalf("unexpected join diff %+v", diff)
+	}
+
+	b.Unsubscribe(ana.server, "todo/1")
+	diff = readDiff(t, watcher)
+	if len(diff.Leaves) != 1 || diff.Leaves[0] != "ana" {
+		t.Fatalf("unexpected leave diff %+v", diff)
+	}
+}
+
+func TestMemberWithSeveralConnections(t *testing.T) {
+	b := broker.New()
+	b.TrackPresence("todo/+")
+
+	tab1, tab2 := newPair(t), newPair(t)
+	if !b.Join(tab1.server, "todo/1", broker.Member{Id: "ana"}) {
+		t.Fatal("first connection must join")
+	}
+	if b.Join(tab2.server, "todo/1", broker.Member{Id: "ana"}) {
+		t.Fatal("second connection of the same member must not join again")
+	}
+	if len(b.Members("todo/1")) != 1 {
+		t.Fatalf("expected 1 member, got %v", b.Members("todo/1"))
+	}
+
+	if b.Leave(tab1.server, "todo/1") {
+		t.Fatal("member still has a connection")
+	}
+	if !b.Leave(tab2.server, "todo/1") {
+		t.Fatal("last connection must leave")
+	}
+	if len(b.Members("todo/1")) != 0 {
+		t.Fatal("expected no members")
+	}
+}
+
+func TestMembersQuery(t *testing.T) {
+	b := broker.New()
+	b.TrackPresence("todo/#")
+
+	for _, id := range []string{"carla", "ana", "bob"} {
+		b.Join(newPair(t).server, "todo/1", broker.Member{Id: id})
+	}
+
+	members := b.Members("todo/1")
+	if len(members) != 3 || members[0].Id != "ana" || members[2].Id != "carla" {
+		t.Fatalf("unexpected members %+v", members)
+	}
+}
+
+func TestUntrackedTopic(t *testing.T) {
+	b := broker.New()
+
+	if b.Join(newPair(t).server, "chat", broker.Member{Id: "ana"}) {
+		t.Fatal("presence must only be tracked on enabled topics")
+	}
+}
+
+func TestDisconnectLeaves(t *testing.T) {
+	b := broker.New()
+	b.TrackPresence("todo/+")
+
+	watcher, ana := newPair(t), newPair(t)
+	mustSubscribe(t, b, watcher.server, broker.PresenceTopic("todo/1"))
+	b.Join(ana.server, "todo/1", broker.Member{Id: "ana"})
+	readDiff(t, watcher)
+
+	b.RemoveConnection(ana.server)
+	if diff := readDiff(t, watcher); len(diff.Leaves) != 1 {
+		t.Fatalf("expected leave diff, got %+v", diff)
+	}
+}
+
+func TestHeartbeatExpiry(t *testing.T) {
+	b := broker.New()
+	b.TrackPresence("todo/+")
+
+	alive, dropped := newPair(t), newPair(t)
+	b.Join(alive.server, "todo/1", broker.Member{Id: "alive"})
+	b.Join(dropped.server, "todo/1", broker.Member{Id: "dropped"})
+
+	time.Sleep(30 * time.Millisecond)
+	b.Touch(alive.server)
+
+	if n := b.ExpireIdle(20 * time.Millisecond); n != 1 {
+		t.Fatalf("expected 1 expired connection, got %d", n)
+	}
+	members := b.Members("todo/1")
+	if len(members) != 1 || members[0].Id != "alive" {
+		t.Fatalf("unexpected members %+v", members)
+	}
+}
+
+func TestSendPresenceSnapshot(t *testing.T) {
+	b := broker.New()
+	b.TrackPresence("todo/+")
+	b.Join(newPair(t).server, "todo/1", broker.Member{Id: "ana"})
+
+	watcher := newPair(t)
+	if err := b.SendPresence(watcher.server, "todo/1"); err != nil {
+		t.Fatal(err)
+	}
+	if diff := readDiff(t, watcher); len(diff.Joins) != 1 || diff.Joins[0].Id != "ana" {
+		t.Fatalf("unexpected snapshot %+v", diff)
+	}
+}
+
+func TestMemberFromHeader(t *testing.T) {
+	m := broker.MemberFromHeader(map[string]string{"member": "bob", "member.name": "Ana", "since": "3"}, "ana")
+	if m.Id != "ana" || len(m.Meta) != 1 || m.Meta["name"] != "Ana" {
+		t.Fatalf("unexpected member %+v", m)
+	}
+}
+
+func TestMemberId(t *testing.T) {
+	p := newPair(t)
+	if id := broker.MemberId(p.server); id != p.server.Conn.RemoteAddr().String() {
+		t.Fatalf("expected the connection address, got %q", id)
+	}
+
+	p.server.Id = "ana"
+	if id := broker.MemberId(p.server); id != "ana" {
+		t.Fatalf("expected the authenticated id, got %q", id)
+	}
+}
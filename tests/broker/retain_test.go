@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+)
+
+func TestLastValueReplay(t *testing.T) {
+	b := broker.New()
+	b.Retain("price/+", broker.Retention{Last: 1})
+
+	b.Publish("price/btc", "1")
+	b.Publish("price/btc", "2")
+
+	a := newPair(t)
+	mustSubscribe(t, b, a.server, "price/btc")
+	if n, err := b.Replay(a.server, "price/btc", 0); err != nil || n != 1 {
+		t.Fatalf("expected 1 replayed message, got %d %v", n, err)
+	}
+
+	f := readFrame(t, a.client)
+	if f.data != "2" || f.header[broker.HeaderSeq] != "2" || f.header[broker.HeaderReplay] != "true" {
+		t.Fatalf("unexpected replay %+v", f)
+	}
+	expectNothing(t, a.client)
+}
+
+func TestLiveMessagesCarrySeq(t *testing.T) {
+	b := broker.New()
+	b.Retain("price/+", broker.Retention{Last: 1})
+
+	a := newPair(t)
+	mustSubscribe(t, b, a.server, "price/btc")
+	b.Publish("price/btc", "1")
+	b.Publish("price/btc", "2")
+
+	for _, seq := range []string{"1", "2"} {
+		f := readFrame(t, a.client)
+		if f.header[broker.HeaderSeq] != seq || f.header[broker.HeaderReplay] != "" {
+			t.Fatalf("expected live seq %s, got %+v", seq, f)
+		}
+	}
+}
+
+func TestReplaySince(t *testing.T) {
+	b := broker.New()
+	b.Retain("chat/#", broker.Retention{Last: 10})
+
+	for _, msg := range []string{"a", "b", "c", "d"} {
+		b.Publish("chat/lobby", msg)
+	}
+
+	a := newPair(t)
+	mustSubscribe(t, b, a.server, "chat/lobby")
+	if n, _ := b.Replay(a.server, "chat/lobby", 2); n != 2 {
+		t.Fatalf("expected 2 replayed messages, got %d", n)
+	}
+	for _, want := range []string{"c", "d"} {
+		if f := readFrame(t, a.client); f.data != want {
+			t.Fatalf("expected %s, got %+v", want, f)
+		}
+	}
+}
+
+func TestReplayWildcard(t *testing.T) {
+	b := broker.New()
+	b.Retain("price/+", broker.Retention{Last: 1})
+	b.Publish("price/btc", "1")
+	b.Publish("price/eth", "2")
+	b.Publish("other", "3")
+
+	a := newPair(t)
+	mustSubscribe(t, b, a.server, "price/+")
+	if n, _ := b.Replay(a.server, "price/+", 0); n != 2 {
+		t.Fatalf("expected 2 replayed messages, got %d", n)
+	}
+	if f := readFrame(t, a.client); f.destination != "price/btc" {
+		t.Fatalf("expected price/btc first, got %+v", f)
+	}
+	if f := readFrame(t, a.client); f.destination != "price/eth" {
+		t.Fatalf("expected price/eth second, got %+v", f)
+	}
+}
+
+func TestRetentionKeepsLastN(t *testing.T) {
+	b := broker.New()
+	b.Retain("log", broker.Retention{Last: 3})
+
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		b.Publish("log", msg)
+	}
+
+	kept := b.Retained("log")
+	if len(kept) != 3 || kept[0].Seq != 3 || kept[2].Data != "5" {
+		t.Fatalf("unexpected retained messages %+v", kept)
+	}
+}
+
+func TestRetentionTTL(t *testing.T) {
+	b := broker.New()
+	b.Retain("log", broker.Retention{Last: 10, TTL: 20 * time.Millisecond})
+
+	b.Publish("log", "old")
+	time.Sleep(40 * time.Millisecond)
+	b.Publish("log", "new")
+
+	kept := b.Retained("log")
+	if len(kept) != 1 || kept[0].Data != "new" || kept[0].Seq != 2 {
+		t.Fatalf("expected only the new message, got %+v", kept)
+	}
+}
+
+func TestNotRetainedTopics(t *testing.T) {
+	b := broker.New()
+	b.Publish("chat", "x")
+
+	if len(b.Retained("chat")) != 0 {
+		t.Fatal("topics without retention must not be kept")
+	}
+}
@@ -0,0 +1,389 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/milton-alvarenga/goreactivehtml/client"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/broker"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// listener remembers the accepted connections so a test can drop them
+type listener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, c)
+		l.mu.Unlock()
+	}
+	return c, err
+}
+
+func (l *listener) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.conns {
+		c.Close()
+	}
+	l.conns = nil
+}
+
+var register sync.Once
+
+// newServer serves handle.WS, the real server side of the protocol, with
+// every topic under client/ accepted
+func newServer(t *testing.T) (string, *listener) {
+	t.Helper()
+
+	register.Do(func() {
+		err := topics.Register("client/#", func(*types.ClientInputInterface, topics.Params) *types.ClientOutput {
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handle.WS))
+	l := &listener{Listener: srv.Listener}
+	srv.Listener = l
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), l
+}
+
+// newFakeServer only sends the byte protocol, answers every RPC with its
+// params and every endpoint request with an error
+func newFakeServer(t *testing.T) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{codec.Subprotocol(codec.CBOR)}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		wsc := &types.WebSocketConnection{Conn: c, Codec: codec.CBOR}
+
+		for {
+			_, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			var output types.ClientOutput
+			switch types.WSConnType(msg[0]) {
+			case types.WSConnHello:
+				input := hello.ClientInputHello{WSConn: wsc}
+				if err := input.Unmarshal(msg); err != nil {
+					t.Error(err)
+					return
+				}
+				// Only the byte protocol, to see the client follow it
+				session, err := input.Negotiate(types.CapByteProtocol)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				output = input.WelcomeOutput(session)
+			case types.WSConnRPC:
+				input := rpc.ClientInputRPC{WSConn: wsc}
+				if err := input.Unmarshal(msg); err != nil {
+					t.Error(err)
+					return
+				}
+				if input.Method == "hangup" {
+					return
+				}
+				output = types.ClientOutput{ReqId: *input.ReqId, MsgType: types.WSTypeSuccessOutputMessage, Destination: input.Class, Data: input.Params}
+			case types.WSConnEndpoint:
+				var input rest.ClientInputRest
+				if err := input.Unmarshal(msg); err != nil {
+					t.Error(err)
+					return
+				}
+				output = types.ClientOutput{ReqId: *input.ReqId, MsgType: types.WSTypeErrorOutputMessage, Destination: input.Endpoint, Data: "not found"}
+			}
+
+			data, err := output.MarshalWith(codec.CBOR)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			wsc.Write(websocket.BinaryMessage, data)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string, opts client.Options) *client.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := client.Dial(ctx, url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func wait(t *testing.T, f *client.Future) client.Output {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	output, err := f.Wait(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+func receive(t *testing.T, events chan client.Output) client.Output {
+	t.Helper()
+
+	select {
+	case output := <-events:
+		return output
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected an event")
+		return client.Output{}
+	}
+}
+
+//
+// --- Tests ---
+//
+
+func TestDialNeedsToken(t *testing.T) {
+	url, _ := newServer(t)
+
+	if _, err := client.Dial(context.Background(), url, client.Options{}); err == nil {
+		t.Fatal("expected the server to refuse a client without token")
+	}
+}
+
+func TestSubscribeReceivesEvents(t *testing.T) {
+	url, _ := newServer(t)
+	c := dial(t, url, client.Options{Token: "secret", Codecs: []string{"msgpack"}})
+
+	if c.Codec().Name() != "msgpack" {
+		t.Fatalf("expected msgpack, got %s", c.Codec().Name())
+	}
+	if w := c.Welcome(); w.Array != "bit" || w.ArrayVersion != client.ArrayV2 || w.Codec != "msgpack" {
+		t.Fatalf("expected bit V2 over msgpack, got %+v", w)
+	}
+
+	// A wildcard would receive every room
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := c.Subscribe("client/room/+", func(client.Output) {}, nil, nil).Wait(ctx); err == nil {
+		t.Fatal("expected a wildcard subscription to be refused")
+	}
+
+	events := make(chan client.Output, 4)
+	ack := wait(t, c.Subscribe("client/room/1", func(out client.Output) { events <- out }, nil, nil))
+	if ack.Destination != "client/room/1" || ack.MsgType != client.MsgSuccess {
+		t.Fatalf("unexpected ack %+v", ack)
+	}
+
+	if _, err := broker.Publish("client/room/1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	event := receive(t, events)
+	if event.Destination != "client/room/1" || event.Data != "hello" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	wait(t, c.Unsubscribe("client/room/1"))
+	broker.Publish("client/room/1", "ignored")
+	select {
+	case event := <-events:
+		t.Fatalf("expected no event after unsubscribe, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReconnectResubscribes(t *testing.T) {
+	// A topic of its own, retained messages outlive the test
+	topic := fmt.Sprintf("client/resume/%d", time.Now().UnixNano())
+	if err := broker.Retain(topics.Topic(topic), broker.Retention{Last: 10}); err != nil {
+		t.Fatal(err)
+	}
+	url, l := newServer(t)
+	c := dial(t, url, client.Options{Token: "secret", ReconnectDelay: 10 * time.Millisecond})
+
+	events := make(chan client.Output, 4)
+	wait(t, c.Subscribe(topic, func(out client.Output) { events <- out }, nil, nil))
+	broker.Publish(topics.Topic(topic), "first")
+	if event := receive(t, events); event.Data != "first" {
+		t.Fatalf("expected first, got %+v", event)
+	}
+
+	// Only messages published after the last one seen are replayed
+	l.drop()
+	broker.Publish(topics.Topic(topic), "second")
+	if event := receive(t, events); event.Data != "second" {
+		t.Fatalf("expected second, got %+v", event)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("expected no replay of seen messages, got %+v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	broker.Publish(topics.Topic(topic), "third")
+	if event := receive(t, events); event.Data != "third" {
+		t.Fatalf("expected third, got %+v", event)
+	}
+}
+
+func TestRequestFutures(t *testing.T) {
+	c := dial(t, newFakeServer(t), client.Options{Codecs: []string{"cbor"}, NoReconnect: true})
+
+	if w := c.Welcome(); w.Array != "byte" || w.Capabilities != client.CapByteProtocol {
+		t.Fatalf("expected the byte protocol only, got %+v", w)
+	}
+	// The array reads the byte protocol it selected
+	payload, err := codec.CBOR.Marshal(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, err := (&byteprotocol.Encoder{}).EncodeInsert(0, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	array := client.NewSessionArray[int](c)
+	if err := array.Apply(op); err != nil {
+		t.Fatal(err)
+	}
+	if got := array.Items(); !reflect.DeepEqual(got, []int{7}) {
+		t.Fatalf("expected [7], got %v", got)
+	}
+
+	// Concurrent requests are matched by request id
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			output := wait(t, c.RPC("tasks", "add", map[string]interface{}{"n": i}, nil))
+
+			var params struct{ N int }
+			if err := c.Decode(output, &params); err != nil {
+				t.Error(err)
+			}
+			if output.Destination != "tasks" || params.N != i {
+				t.Errorf("request %d got %+v", i, output)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = c.Endpoint(client.GET, "/missing", nil, nil).Wait(context.Background())
+	var e *client.Error
+	if !errors.As(err, &e) || e.Destination != "/missing" || e.Message != "not found" {
+		t.Fatalf("expected the error output, got %v", err)
+	}
+
+	_, err = c.RPC("tasks", "hangup", nil, nil).Wait(context.Background())
+	if !errors.Is(err, client.ErrDisconnected) {
+		t.Fatalf("expected ErrDisconnected, got %v", err)
+	}
+
+	c.Close()
+	if _, err := c.RPC("tasks", "add", nil, nil).Wait(context.Background()); !errors.Is(err, client.ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestArrayApply(t *testing.T) {
+	type task struct {
+		Title string `json:"title" msgpack:"title" cbor:"title"`
+	}
+	for _, c := range codec.All {
+		encode := func(v any) []byte {
+			data, err := c.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		}
+
+		bit := &protocol.Encoder{Version: protocol.V2}
+		byt := &byteprotocol.Encoder{}
+		arrays := map[*client.Array[task]][]func() ([]byte, error){
+			client.NewArray[task](client.ArrayV2, c): {
+				func() ([]byte, error) { return bit.EncodeInsert(0, encode(task{"a"})) },
+				func() ([]byte, error) { return bit.EncodeInsert(1, encode(task{"b"})) },
+				func() ([]byte, error) { return bit.EncodeMove(1, 0) },
+				func() ([]byte, error) { return bit.EncodeDelete(1) },
+			},
+			client.NewByteArray[task](c): {
+				func() ([]byte, error) { return byt.EncodeInsert(0, encode(task{"a"})) },
+				func() ([]byte, error) { return byt.EncodeInsert(1, encode(task{"b"})) },
+				func() ([]byte, error) { return byt.EncodeMove(1, 0) },
+				func() ([]byte, error) { return byt.EncodeDelete(1) },
+			},
+		}
+
+		for array, ops := range arrays {
+			for _, op := range ops {
+				msg, err := op()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := array.Apply(msg); err != nil {
+					t.Fatalf("%s: %v", c.Name(), err)
+				}
+			}
+			if got := array.Items(); !reflect.DeepEqual(got, []task{{"b"}}) {
+				t.Fatalf("%s: expected [b], got %v", c.Name(), got)
+			}
+
+			if err := array.Apply([]byte{0xFF}); err == nil {
+				t.Fatalf("%s: expected a bad operation to fail", c.Name())
+			}
+			if got := array.Items(); len(got) != 1 {
+				t.Fatalf("%s: a failed operation changed the items: %v", c.Name(), got)
+			}
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+)
+
+type Task struct {
+	Id    int     `json:"id"`
+	Title string  `json:"title"`
+	Done  bool    `json:"done"`
+	Score float64 `json:"score"`
+	Tags  []string
+}
+
+//
+// --- Test Helpers ---
+//
+
+func encoded(t *testing.T) func([]byte, error) []byte {
+	return func(data []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+func asJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+type nodeInput struct {
+	Codec    string   `json:"codec"`
+	Mode     string   `json:"mode"`
+	Initial  any      `json:"initial"`
+	Messages [][]byte `json:"messages"`
+	Value    any      `json:"value"`
+}
+
+// runNode feeds the input to node.js and returns its JSON output
+func runNode(t *testing.T, in nodeInput) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	stdin, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("node", "node.js")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(stdin), stdout, stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("node: %v\n%s", err, stderr.String())
+	}
+	return normalize(t, stdout.String())
+}
+
+// normalize sorts the object keys of a JSON document
+func normalize(t *testing.T, s string) string {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return asJSON(t, v)
+}
+
+var (
+	before = []Task{
+		{Id: 1, Title: "write", Score: 1.5, Tags: []string{"a"}},
+		{Id: 2, Title: "review"},
+		{Id: 3, Title: "merge", Score: -2},
+	}
+	after = []Task{
+		{Id: 3, Title: "merge", Score: -2, Done: true},
+		{Id: 1, Title: "write", Score: 1.5, Tags: []string{"a", "b"}},
+		{Id: 4, Title: "release", Score: 300000},
+	}
+)
+
+//
+// --- Codecs ---
+//
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range codec.All {
+		t.Run(c.Name(), func(t *testing.T) {
+			data, err := c.Marshal(before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []Task
+			if err := c.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, before) {
+				t.Fatalf("expected %+v, got %+v", before, got)
+			}
+
+			// untyped values decode to JSON like maps
+			var v any
+			if err := c.Unmarshal(data, &v); err != nil {
+				t.Fatal(err)
+			}
+			first, ok := v.([]any)[0].(map[string]any)
+			if !ok || first["title"] != "write" {
+				t.Fatalf("expected a map with the json field names, got %#v", v)
+			}
+
+			// a patch only changes its own fields
+			patch, err := c.Marshal(map[string]any{"done": true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			task := before[0]
+			if err := c.Unmarshal(patch, &task); err != nil {
+				t.Fatal(err)
+			}
+			if !task.Done || task.Title != "write" || task.Score != 1.5 {
+				t.Fatalf("expected only done to change, got %+v", task)
+			}
+		})
+	}
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	cases := []struct {
+		offered []string
+		want    codec.Codec
+	}{
+		{nil, codec.JSON},
+		{[]string{"msgpack"}, codec.MsgPack},
+		{[]string{"yaml", " cbor", "json"}, codec.CBOR},
+		{[]string{"yaml"}, codec.JSON},
+	}
+	for _, c := range cases {
+		if got := codec.Negotiate(c.offered...); got != c.want {
+			t.Fatalf("offered %v: expected %s, got %s", c.offered, c.want.Name(), got.Name())
+		}
+	}
+}
+
+func TestFromSubprotocols(t *testing.T) {
+	cases := []struct {
+		offered  []string
+		want     codec.Codec
+		protocol string
+	}{
+		{nil, codec.JSON, ""},
+		{[]string{"chat"}, codec.JSON, ""},
+		{[]string{"goreactivehtml.yaml", "goreactivehtml.cbor"}, codec.CBOR, "goreactivehtml.cbor"},
+		{[]string{"goreactivehtml.msgpack", "goreactivehtml.json"}, codec.MsgPack, "goreactivehtml.msgpack"},
+		{[]string{codec.Subprotocol(codec.JSON)}, codec.JSON, "goreactivehtml.json"},
+	}
+	for _, c := range cases {
+		got, protocol := codec.FromSubprotocols(c.offered)
+		if got != c.want || protocol != c.protocol {
+			t.Fatalf("offered %v: expected %s %q, got %s %q", c.offered, c.want.Name(), c.protocol, got.Name(), protocol)
+		}
+	}
+}
+
+// Integers of a JSON document stay integers in the other codecs
+func TestFromJSON(t *testing.T) {
+	doc := []byte(`{"id":7,"score":1.5,"big":9007199254740993,"tags":[1,"a",null]}`)
+	for _, c := range codec.All {
+		data, err := codec.FromJSON(c, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v struct {
+			Id    int     `json:"id"`
+			Score float64 `json:"score"`
+			Big   int64   `json:"big"`
+			Tags  []any   `json:"tags"`
+		}
+		if err := c.Unmarshal(data, &v); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if v.Id != 7 || v.Score != 1.5 || v.Big != 9007199254740993 || len(v.Tags) != 3 {
+			t.Fatalf("%s: unexpected %+v", c.Name(), v)
+		}
+	}
+	if _, err := codec.FromJSON(codec.MsgPack, []byte(`{`)); err == nil {
+		t.Fatal("expected invalid JSON to fail")
+	}
+}
+
+//
+// --- Frames ---
+//
+
+func TestClientOutputMarshalWith(t *testing.T) {
+	for _, c := range codec.All {
+		output := types.ClientOutput{
+			ReqId:       3,
+			MsgType:     types.WSTypeSuccessOutputMessage,
+			Destination: "tasks",
+			Data:        before,
+			Header:      map[string]string{"seq": "1"},
+		}
+		msg, err := output.MarshalWith(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// reqId, msgType, destination, data, header
+		offset := 2
+		destLen := int(binary.BigEndian.Uint16(msg[offset:]))
+		offset += 2 + destLen
+		dataLen := int(binary.BigEndian.Uint32(msg[offset:]))
+		offset += 4
+		var got []Task
+		if err := c.Unmarshal(msg[offset:offset+dataLen], &got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(got, before) {
+			t.Fatalf("%s: expected %+v, got %+v", c.Name(), before, got)
+		}
+		offset += dataLen + 2
+		if string(msg[offset:]) != `{"seq":"1"}` {
+			t.Fatalf("%s: expected the header to stay JSON, got %q", c.Name(), msg[offset:])
+		}
+	}
+
+	jsonMsg, err := types.ClientOutput{Data: "x"}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	withJSON, err := types.ClientOutput{Data: "x"}.MarshalWith(codec.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(jsonMsg, withJSON) {
+		t.Fatal("expected Marshal to use JSON")
+	}
+}
+
+func rpcMessage(params []byte) []byte {
+	msg := []byte{2, 9}
+	msg = binary.BigEndian.AppendUint16(msg, 5)
+	msg = append(msg, "tasks"...)
+	msg = append(msg, 3)
+	msg = append(msg, "add"...)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(params)))
+	msg = append(msg, params...)
+	msg = binary.BigEndian.AppendUint16(msg, 2)
+	return append(msg, "{}"...)
+}
+
+func TestRPCParams(t *testing.T) {
+	for _, c := range codec.All {
+		params, err := c.Marshal(map[string]any{"title": "write", "count": 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		input := rpc.ClientInputRPC{WSConn: &types.WebSocketConnection{Codec: c}}
+		if err := input.Unmarshal(rpcMessage(params)); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if input.Class != "tasks" || input.Method != "add" || input.Params["title"] != "write" {
+			t.Fatalf("%s: unexpected %+v", c.Name(), input)
+		}
+	}
+
+	// a connection without a codec reads JSON
+	input := rpc.ClientInputRPC{WSConn: &types.WebSocketConnection{}}
+	if err := input.Unmarshal(rpcMessage([]byte(`{"title":"write"}`))); err != nil {
+		t.Fatal(err)
+	}
+	msgpackParams, _ := codec.MsgPack.Marshal(map[string]any{"title": "write"})
+	if err := input.Unmarshal(rpcMessage(msgpackParams)); err == nil {
+		t.Fatal("expected msgpack params to fail on a JSON connection")
+	}
+}
+
+//
+// --- Array and path operations ---
+//
+
+func TestDiffWithCodec(t *testing.T) {
+	for _, c := range codec.All {
+		t.Run(c.Name(), func(t *testing.T) {
+			for _, enc := range []diff.Encoder{diff.Bit, diff.Byte} {
+				ops, err := diff.SliceFuncWith(enc, c, before, after, func(t Task) int { return t.Id })
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := append([]Task(nil), before...)
+				for _, msg := range ops {
+					if enc == diff.Bit {
+						op, err := (&protocol.Decoder{}).Decode(msg)
+						if err != nil {
+							t.Fatal(err)
+						}
+						got, err = protocol.ApplyCodec(op, got, c)
+					} else {
+						op, err := (&byteprotocol.Decoder{}).Decode(msg)
+						if err != nil {
+							t.Fatal(err)
+						}
+						got, err = byteprotocol.ApplyCodec(op, got, c)
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				if !reflect.DeepEqual(got, after) {
+					t.Fatalf("expected %+v, got %+v", after, got)
+				}
+			}
+		})
+	}
+
+	// JSON keeps the messages of SliceFunc
+	plain, err := diff.SliceFunc(diff.Bit, before, after, func(t Task) int { return t.Id })
+	if err != nil {
+		t.Fatal(err)
+	}
+	withJSON, err := diff.SliceFuncWith(diff.Bit, codec.JSON, before, after, func(t Task) int { return t.Id })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plain, withJSON) {
+		t.Fatal("expected JSON to produce the same messages")
+	}
+}
+
+func TestDiffWithCodecNode(t *testing.T) {
+	want := normalize(t, asJSON(t, after))
+	for _, c := range codec.All {
+		for mode, enc := range map[string]diff.Encoder{"array": diff.Bit, "byte": diff.Byte} {
+			ops, err := diff.SliceFuncWith(enc, c, before, after, func(t Task) int { return t.Id })
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := runNode(t, nodeInput{Codec: c.Name(), Mode: mode, Initial: before, Messages: ops})
+			if got != want {
+				t.Fatalf("%s %s: expected %s, got %s", c.Name(), mode, want, got)
+			}
+		}
+	}
+}
+
+func TestPathWithCodec(t *testing.T) {
+	for _, c := range codec.All {
+		t.Run(c.Name(), func(t *testing.T) {
+			b := encoded(t)
+			enc := pathprotocol.NewEncoder()
+			value := b(c.Marshal(map[string]any{"name": "board", "size": 2}))
+			patch := b(c.Marshal(map[string]any{"size": 3}))
+			insert := b((&protocol.Encoder{}).EncodeInsert(0, b(c.Marshal(before[1]))))
+
+			boardPath, err := pathprotocol.ParsePath("board")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tasksPath, err := pathprotocol.ParsePath("board.tasks")
+			if err != nil {
+				t.Fatal(err)
+			}
+			msgs := [][]byte{
+				b(enc.EncodeSet(boardPath, value)),
+				b(enc.EncodeMerge(boardPath, patch)),
+				b(enc.EncodeArray(tasksPath, insert)),
+				b(enc.Batch(true).Merge(boardPath, patch).Encode()),
+			}
+
+			dec := pathprotocol.NewDecoder()
+			dec.Codec = c
+			var root any = map[string]any{}
+			for _, msg := range msgs {
+				if root, err = dec.DecodeApply(msg, root); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := `{"board":{"name":"board","size":3,"tasks":[{"Tags":null,"done":false,"id":2,"score":0,"title":"review"}]}}`
+			if got := asJSON(t, root); got != want {
+				t.Fatalf("expected %s, got %s", want, got)
+			}
+			if got := runNode(t, nodeInput{Codec: c.Name(), Mode: "path", Initial: map[string]any{}, Messages: msgs}); got != want {
+				t.Fatalf("node: expected %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+// Values encoded by the client decode in Go and the other way around
+func TestCodecNode(t *testing.T) {
+	value := map[string]any{"title": "write", "count": int64(-300), "ratio": 0.25, "tags": []any{"a", nil, true}}
+	want := asJSON(t, value)
+	for _, c := range codec.All {
+		var fromNode string
+		if err := json.Unmarshal([]byte(runNode(t, nodeInput{Codec: c.Name(), Mode: "encode", Value: value})), &fromNode); err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		if err := json.Unmarshal([]byte(`"`+fromNode+`"`), &data); err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := c.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if asJSON(t, got) != want {
+			t.Fatalf("%s: expected %s, got %s", c.Name(), want, asJSON(t, got))
+		}
+
+		data, err := c.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := runNode(t, nodeInput{Codec: c.Name(), Mode: "decode", Messages: [][]byte{data}}); got != want {
+			t.Fatalf("%s: node expected %s, got %s", c.Name(), want, got)
+		}
+	}
+}
+
+// Declared lengths the data cannot hold fail before anything is allocated
+func TestMsgpackDeclaredLengths(t *testing.T) {
+	for _, data := range [][]byte{
+		{0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0xdf, 0x7f, 0xff, 0xff, 0xff, 0xa1, 'a'},
+		{0x91, 0xdc, 0xff, 0xff, 0x01},
+		{0xdb, 0xff, 0xff, 0xff, 0xff},
+		{0xc1},
+	} {
+		var v any
+		if err := codec.MsgPack.Unmarshal(data, &v); err == nil {
+			t.Fatalf("expected % x to fail", data)
+		}
+	}
+}
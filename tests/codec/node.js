@@ -0,0 +1,51 @@
+import { applyBinaryOperation } from "./../../web/lib/ArrayDecodeProtocol.js";
+import { applyBinaryOperationByte } from "./../../web/lib/ArrayDecodeProtocolByte.js";
+import { createPathDecoder } from "./../../web/lib/PathDecodeProtocol.js";
+import { CODECS } from "./../../web/lib/Codec.js";
+import fs from 'fs';
+
+// STDIN: {"codec", "mode", "initial", "messages": [base64], "value"}
+//   array, byte  every message applied to the initial array
+//   path         every message applied to the initial state, one decoder
+//   encode       value encoded with the codec, base64
+//   decode       first message decoded with the codec
+// STDOUT: the resulting JSON
+try {
+    const input = JSON.parse(fs.readFileSync(0).toString());
+    const codec = CODECS[input.codec];
+    if (!codec) {
+        throw new Error("unknown codec " + input.codec);
+    }
+    const messages = (input.messages || []).map((m) => new Uint8Array(Buffer.from(m, "base64")));
+
+    let out;
+    switch (input.mode) {
+        case "array":
+            out = input.initial || [];
+            messages.forEach((msg) => applyBinaryOperation(msg, out, false, 1, codec));
+            break;
+        case "byte":
+            out = input.initial || [];
+            messages.forEach((msg) => applyBinaryOperationByte(msg, out, false, codec));
+            break;
+        case "path": {
+            const decoder = createPathDecoder(false, 1, codec);
+            out = input.initial;
+            messages.forEach((msg) => { out = decoder.apply(msg, out); });
+            break;
+        }
+        case "encode":
+            out = Buffer.from(codec.encode(input.value)).toString("base64");
+            break;
+        case "decode":
+            out = codec.decode(messages[0]);
+            break;
+        default:
+            throw new Error("unknown mode " + input.mode);
+    }
+
+    process.stdout.write(JSON.stringify(out) + "\n");
+} catch (err) {
+    console.error("Error processing input:", err);
+    process.exit(1);
+}
@@ -0,0 +1,102 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/conformance"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// The same corpus is read by web/lib/conformance.test.js
+//
+
+func readCorpus(t *testing.T) ([]byte, conformance.Corpus) {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", conformance.File()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corpus conformance.Corpus
+	if err := json.Unmarshal(data, &corpus); err != nil {
+		t.Fatal(err)
+	}
+	return data, corpus
+}
+
+// normalize compacts a JSON array so key order and spacing do not matter
+func normalize(t *testing.T, values []json.RawMessage) string {
+	t.Helper()
+
+	data, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// The committed corpus is what the encoders write today, a change of the
+// wire format must come with the generated corpus
+func TestCorpusUpToDate(t *testing.T) {
+	committed, corpus := readCorpus(t)
+	if corpus.Version != conformance.Version {
+		t.Fatalf("expected corpus version %d, got %d", conformance.Version, corpus.Version)
+	}
+
+	generated, err := conformance.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := generated.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, committed) {
+		t.Fatal("the encoders no longer match the corpus, run go run ./cmd/conformance and review the diff")
+	}
+}
+
+func TestCorpusGo(t *testing.T) {
+	_, corpus := readCorpus(t)
+	if len(corpus.Vectors) == 0 {
+		t.Fatal("empty corpus")
+	}
+
+	for _, v := range corpus.Vectors {
+		data, err := v.Bytes()
+		if err != nil {
+			t.Fatalf("%s %s: %v", v.Name, v.Protocol, err)
+		}
+		input := append([]json.RawMessage(nil), v.Input...)
+
+		var got []json.RawMessage
+		switch v.Protocol {
+		case "bit":
+			got, err = (&protocol.Decoder{Version: v.ProtocolVersion}).DecodeApply(data, input)
+		case "byte":
+			got, err = (&byteprotocol.Decoder{}).DecodeApply(data, input)
+		default:
+			t.Fatalf("%s: unknown protocol %q", v.Name, v.Protocol)
+		}
+		if err != nil {
+			t.Fatalf("%s %s v%d: %v", v.Name, v.Protocol, v.ProtocolVersion, err)
+		}
+		if normalize(t, got) != normalize(t, v.Expected) {
+			t.Fatalf("%s %s v%d: expected %s, got %s", v.Name, v.Protocol, v.ProtocolVersion, normalize(t, v.Expected), normalize(t, got))
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package crdt
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state/crdt"
+)
+
+//
+// --- Test Helpers ---
+//
+
+func text(s *crdt.Sequence[string]) string {
+	return strings.Join(s.Values(), "")
+}
+
+func chars(s string) []string {
+	return strings.Split(s, "")
+}
+
+func apply[T any](t *testing.T, s *crdt.Sequence[T], ops []crdt.SeqOp[T]) {
+	t.Helper()
+	if _, err := s.Apply(ops...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//
+// --- Tests ---
+//
+
+func TestSequenceEdits(t *testing.T) {
+	s := crdt.NewSequence[string]("a")
+	s.Insert(0, chars("helo")...)
+	s.Insert(3, "l")
+	s.Insert(5, chars(" world")...)
+	s.Delete(0, 1)
+	s.Insert(0, "H")
+	if got := text(s); got != "Hello world" || s.Len() != 11 {
+		t.Fatalf("unexpected text %q", got)
+	}
+}
+
+// Both users insert at the same place at the same time: both words are
+// kept, in the same order on every replica
+func TestConcurrentInserts(t *testing.T) {
+	a, b := crdt.NewSequence[string]("alice"), crdt.NewSequence[string]("bob")
+	apply(t, b, a.Insert(0, chars("ac")...))
+
+	fromA := a.Insert(1, chars("XX")...)
+	fromB := b.Insert(1, chars("yy")...)
+	apply(t, a, fromB)
+	apply(t, b, fromA)
+
+	if text(a) != text(b) {
+		t.Fatalf("replicas differ: %q and %q", text(a), text(b))
+	}
+	if got := text(a); got != "ayyXXc" {
+		t.Fatalf("unexpected merge %q", got)
+	}
+}
+
+func TestConcurrentInsertAndDelete(t *testing.T) {
+	a, b := crdt.NewSequence[string]("a"), crdt.NewSequence[string]("b")
+	apply(t, b, a.Insert(0, chars("abc")...))
+
+	fromA := a.Delete(1, 1)
+	fromB := b.Insert(2, "X")
+	fromB = append(fromB, b.Delete(1, 1)...)
+	apply(t, a, fromB)
+	apply(t, b, fromA)
+
+	if text(a) != "aXc" || text(b) != "aXc" {
+		t.Fatalf("unexpected merge %q and %q", text(a), text(b))
+	}
+}
+
+// Operations received before the ones they depend on wait for them,
+// duplicates are ignored
+func TestOutOfOrderOperations(t *testing.T) {
+	a, b := crdt.NewSequence[string]("a"), crdt.NewSequence[string]("b")
+	first := a.Insert(0, chars("ab")...)
+	second := a.Insert(2, "c")
+	second = append(second, a.Delete(0, 1)...)
+
+	applied, err := b.Apply(second...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 || b.Len() != 0 {
+		t.Fatal("operations applied before their dependencies")
+	}
+	if applied, _ = b.Apply(first...); len(applied) != 4 {
+		t.Fatalf("expected the waiting operations to be applied, got %d", len(applied))
+	}
+	if applied, _ = b.Apply(first...); len(applied) != 0 {
+		t.Fatal("a duplicate was applied")
+	}
+	if text(b) != text(a) || text(b) != "bc" {
+		t.Fatalf("unexpected text %q", text(b))
+	}
+}
+
+// Replicas that received the same operations in any causal order hold the
+// same value
+func TestSequenceConverges(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		replicas := []*crdt.Sequence[string]{crdt.NewSequence[string]("a"), crdt.NewSequence[string]("b"), crdt.NewSequence[string]("c")}
+		var ops [][]crdt.SeqOp[string]
+		for step := 0; step < 30; step++ {
+			i := rnd.Intn(len(replicas))
+			r := replicas[i]
+			// Sometimes receive the operations made so far
+			if rnd.Intn(3) == 0 {
+				for _, batch := range ops {
+					apply(t, r, batch)
+				}
+			}
+			if n := r.Len(); n > 0 && rnd.Intn(3) == 0 {
+				ops = append(ops, r.Delete(rnd.Intn(n), 1))
+			} else {
+				ops = append(ops, r.Insert(rnd.Intn(n+1), string(rune('a'+rnd.Intn(26)))))
+			}
+		}
+		// Every replica receives everything, in a random order
+		for _, r := range replicas {
+			for _, j := range rnd.Perm(len(ops)) {
+				apply(t, r, ops[j])
+			}
+		}
+		for _, r := range replicas[1:] {
+			if text(r) != text(replicas[0]) {
+				t.Fatalf("round %d: replicas differ: %q and %q", round, text(r), text(replicas[0]))
+			}
+		}
+	}
+}
+
+func TestInvalidOperations(t *testing.T) {
+	s := crdt.NewSequence[int]("a")
+	if _, err := s.Apply(crdt.SeqOp[int]{Value: 1}); err == nil {
+		t.Fatal("expected an operation without id to be rejected")
+	}
+	if _, err := s.Apply(crdt.SeqOp[int]{Id: crdt.ID{Counter: 1, Replica: "b"}, After: crdt.ID{Counter: 1}}); err == nil {
+		t.Fatal("expected an invalid after to be rejected")
+	}
+	m := crdt.NewMap[int]("a")
+	if _, err := m.Apply(crdt.MapOp[int]{Key: "k"}); err == nil {
+		t.Fatal("expected an operation without id to be rejected")
+	}
+}
+
+// The log replayed on a new replica gives the same value
+func TestLogReplay(t *testing.T) {
+	s := crdt.NewSequence[int]("a")
+	s.Insert(0, 1, 2, 3)
+	log := s.Log()
+	s.Delete(1, 1)
+	if len(log) != 3 {
+		t.Fatal("the log returned was modified")
+	}
+
+	r := crdt.NewSequence[int]("b")
+	if _, err := r.Apply(s.Log()...); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Values(), []int{1, 3}) {
+		t.Fatalf("unexpected values %v", r.Values())
+	}
+}
+
+func TestMapLastWriterWins(t *testing.T) {
+	a, b := crdt.NewMap[string]("alice"), crdt.NewMap[string]("bob")
+	fromA := a.Set("color", "red")
+	fromB := b.Set("color", "blue")
+	a.Apply(fromB)
+	b.Apply(fromA)
+
+	// Same counter, the greater replica wins everywhere
+	if v, _ := a.Get("color"); v != "blue" {
+		t.Fatalf("unexpected value %q", v)
+	}
+	if !reflect.DeepEqual(a.Values(), b.Values()) {
+		t.Fatalf("replicas differ: %v and %v", a.Values(), b.Values())
+	}
+
+	// A later write wins, whatever the replica
+	del := a.Delete("color")
+	set := a.Set("size", "L")
+	b.Apply(set, del)
+	if _, ok := b.Get("color"); ok || !reflect.DeepEqual(b.Values(), map[string]string{"size": "L"}) {
+		t.Fatalf("unexpected values %v", b.Values())
+	}
+	if applied, _ := b.Apply(fromA); len(applied) != 0 {
+		t.Fatal("an old write won")
+	}
+}
+
+// Operations keep their fields through every codec
+func TestOperationsEncoding(t *testing.T) {
+	s := crdt.NewSequence[string]("a")
+	ops := s.Insert(0, "x", "y")
+	ops = append(ops, s.Delete(0, 1)...)
+	for _, c := range codec.All {
+		data, err := c.Marshal(ops)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []crdt.SeqOp[string]
+		if err := c.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, ops) {
+			t.Fatalf("%s: expected %+v, got %+v", c.Name(), ops, out)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package decoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+)
+
+func TestByteDecodeSingleOps(t *testing.T) {
+	e := byteprotocol.Encoder{}
+	b := encoded(t)
+
+	op := decodeByte(t, b(e.EncodeInsert(300, []byte(`"A"`))))
+	if op.Op != byteprotocol.OpInsert || op.Bulk || op.Partial || op.Pos != 300 || string(op.Data) != `"A"` || op.PosSize != 2 {
+		t.Fatalf("unexpected insert %+v", op)
+	}
+
+	op = decodeByte(t, b(e.EncodeUpdate(7, []byte(`123`))))
+	if op.Op != byteprotocol.OpUpdate || op.Pos != 7 || string(op.Data) != `123` {
+		t.Fatalf("unexpected update %+v", op)
+	}
+
+	op = decodeByte(t, b(e.EncodeDelete(70000)))
+	if op.Op != byteprotocol.OpDelete || op.Pos != 70000 || op.PosSize != 3 || op.Data != nil {
+		t.Fatalf("unexpected delete %+v", op)
+	}
+
+	op = decodeByte(t, b(e.EncodeMove(2, 300)))
+	if op.Op != byteprotocol.OpMove || op.Bulk || op.Pos != 2 || op.To != 300 || op.PosSize != 2 || op.Data != nil {
+		t.Fatalf("unexpected move %+v", op)
+	}
+
+	op = decodeByte(t, b(e.EncodePartialUpdate(1, []byte(`{"Done":true}`))))
+	if !op.Partial || op.Pos != 1 || string(op.Data) != `{"Done":true}` {
+		t.Fatalf("unexpected partial %+v", op)
+	}
+}
+
+func TestByteDecodeBulkOps(t *testing.T) {
+	e := byteprotocol.Encoder{}
+	b := encoded(t)
+
+	op := decodeByte(t, b(e.EncodeInsertRange(2, 4, [][]byte{[]byte(`1`), []byte(`2`), []byte(`3`)})))
+	if op.Op != byteprotocol.OpInsert || !op.Bulk || op.Start != 2 || op.End != 4 || len(op.Payloads) != 3 || string(op.Payloads[2]) != `3` {
+		t.Fatalf("unexpected bulk insert %+v", op)
+	}
+
+	op = decodeByte(t, b(e.EncodeDeleteRange(1, 3)))
+	if op.Op != byteprotocol.OpDelete || !op.Bulk || op.Start != 1 || op.End != 3 {
+		t.Fatalf("unexpected bulk delete %+v", op)
+	}
+
+	op = decodeByte(t, b(e.EncodeMoveRange(4, 5, 1)))
+	if op.Op != byteprotocol.OpMove || !op.Bulk || op.Start != 4 || op.End != 5 || op.To != 1 {
+		t.Fatalf("unexpected bulk move %+v", op)
+	}
+
+	patches := []byteprotocol.PartialPatch{{Pos: 1, Data: []byte(`"x"`)}, {Pos: 300, Data: []byte(`"y"`)}}
+	op = decodeByte(t, b(e.EncodePartialUpdateRange(0, 400, patches)))
+	if !op.Bulk || !op.Partial || len(op.Patches) != 2 || op.Patches[1].Pos != 300 || string(op.Patches[1].Data) != `"y"` {
+		t.Fatalf("unexpected bulk partial %+v", op)
+	}
+}
+
+// Every op, flag and size combination the 4 byte header can describe
+func TestByteDecodeEveryHeader(t *testing.T) {
+	dec := byteprotocol.Decoder{}
+
+	for _, op := range []byteprotocol.OperationType{0, 1, 2, 3, 0xff} {
+		for flags := byte(0); flags < 4; flags++ {
+			for posSize := uint8(0); posSize < 4; posSize++ {
+				for dataSize := uint8(0); dataSize < 4; dataSize++ {
+					bulk := flags&byteprotocol.FlagBulk != 0
+					partial := flags&byteprotocol.FlagPartial != 0
+					known := op <= byteprotocol.OpInsert
+
+					payload := []byte(`1`)
+					if dataSize == 0 {
+						payload = nil
+					}
+
+					msg := []byte{byte(op), flags, posSize, dataSize}
+					msg = append(msg, sized(0, posSize)...)
+					if bulk {
+						msg = append(msg, sized(0, posSize)...)
+					}
+					if bulk && partial {
+						msg = append(msg, sized(0, posSize)...)
+					}
+					switch {
+					case !partial && op == byteprotocol.OpMove:
+						msg = append(msg, sized(0, posSize)...)
+					case partial || op != byteprotocol.OpDelete:
+						msg = append(msg, sized(uint32(len(payload)), dataSize)...)
+						msg = append(msg, payload...)
+					}
+					// bulk payloads need a length each
+					noLength := bulk && !partial && dataSize == 0 && (op == byteprotocol.OpInsert || op == byteprotocol.OpUpdate)
+					wantErr := !partial && !known || noLength
+
+					decoded, err := dec.Decode(msg)
+					if (err != nil) != wantErr {
+						t.Fatalf("header % x: unexpected error %v", msg[:4], err)
+					}
+					if err != nil {
+						continue
+					}
+					if decoded.Op != op || decoded.Bulk != bulk || decoded.Partial != partial || decoded.PosSize != posSize || decoded.DataSize != dataSize {
+						t.Fatalf("header % x: decoded %+v", msg[:4], decoded)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestByteDecodeErrors(t *testing.T) {
+	dec := byteprotocol.Decoder{}
+
+	cases := map[string][]byte{
+		"short header":       {3, 0, 1},
+		"invalid size":       {0, 0, 5, 0, 1, 2, 3, 4, 5},
+		"missing position":   {0, 0, 2, 0, 1},
+		"short payload":      {3, 0, 1, 1, 0, 5, '"'},
+		"trailing bytes":     {0, 0, 1, 0, 0, 9},
+		"reversed range":     {0, byteprotocol.FlagBulk, 1, 0, 5, 1},
+		"unknown single op":  {4, 0, 1, 1, 0, 1, '1'},
+		"unknown bulk op":    {9, byteprotocol.FlagBulk, 1, 1, 0, 0, 1, '1'},
+		"missing patch data": {1, byteprotocol.FlagBulk | byteprotocol.FlagPartial, 1, 1, 0, 2, 1},
+	}
+	for name, msg := range cases {
+		if _, err := dec.Decode(msg); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestByteApply(t *testing.T) {
+	e := byteprotocol.Encoder{}
+	b := encoded(t)
+	dec := byteprotocol.Decoder{}
+
+	cases := []struct {
+		name    string
+		encoded []byte
+		initial []json.RawMessage
+		want    string
+	}{
+		{"insert", b(e.EncodeInsert(1, []byte(`"B"`))), raw(`"A"`, `"C"`), `["A","B","C"]`},
+		{"insert past end", b(e.EncodeInsert(2, []byte(`"B"`))), raw(), `[null,null,"B"]`},
+		{"update", b(e.EncodeUpdate(0, []byte(`9`))), raw(`1`, `2`), `[9,2]`},
+		{"delete", b(e.EncodeDelete(0)), raw(`1`, `2`), `[2]`},
+		{"bulk insert", b(e.EncodeInsertRange(1, 2, [][]byte{[]byte(`2`), []byte(`3`)})), raw(`1`, `4`), `[1,2,3,4]`},
+		{"bulk update", b(e.EncodeUpdateRange(1, 2, [][]byte{[]byte(`8`), []byte(`9`)})), raw(`1`), `[1,8,9]`},
+		{"bulk delete clamps", b(e.EncodeDeleteRange(1, 10)), raw(`1`, `2`), `[1]`},
+		{"partial merges objects", b(e.EncodePartialUpdate(0, []byte(`{"Done":true}`))), raw(`{"Id":1,"Done":false}`), `[{"Done":true,"Id":1}]`},
+		{"bulk partial", b(e.EncodePartialUpdateRange(0, 2, []byteprotocol.PartialPatch{{Pos: 0, Data: []byte(`{"n":0}`)}, {Pos: 2, Data: []byte(`{"n":2}`)}})), raw(`{"n":9}`, `{"n":9}`, `{"n":9}`), `[{"n":0},{"n":9},{"n":2}]`},
+	}
+
+	for _, c := range cases {
+		out, err := dec.DecodeApply(c.encoded, c.initial)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := asString(out); got != c.want {
+			t.Fatalf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+
+	if _, err := dec.DecodeApply(b(e.EncodeInsert(0, []byte(`{bad`))), raw()); err == nil {
+		t.Fatal("expected error on invalid JSON payload")
+	}
+}
+
+func TestByteApplyTo(t *testing.T) {
+	e := byteprotocol.Encoder{}
+	b := encoded(t)
+
+	var err error
+	tasks := []task{{Id: 1, Description: "a"}, {Id: 2, Description: "b"}, {Id: 3, Description: "c"}}
+
+	op := decodeByte(t, b(e.EncodeDelete(1)))
+	if tasks, err = byteprotocol.ApplyTo(op, tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	op = decodeByte(t, b(e.EncodePartialUpdate(1, []byte(`{"Description":"C"}`))))
+	if tasks, err = byteprotocol.ApplyTo(op, tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []task{{Id: 1, Description: "a"}, {Id: 3, Description: "C"}}
+	if fmt.Sprint(tasks) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, tasks)
+	}
+}
@@ -0,0 +1,130 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// applyWithNode runs the payload through the JS decoder of the script, with
+// the same stdin format as the encoder tests: 4 byte length, initial JSON
+// array, payload. env is added to the environment of node.
+func applyWithNode(t *testing.T, script string, payload []byte, initial []json.RawMessage, env ...string) string {
+	t.Helper()
+
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	initialJSON, err := json.Marshal(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if initial == nil {
+		initialJSON = []byte("[]")
+	}
+
+	stdin := &bytes.Buffer{}
+	binary.Write(stdin, binary.BigEndian, uint32(len(initialJSON)))
+	stdin.Write(initialJSON)
+	stdin.Write(payload)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("node", script)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("node %s: %v\n%s", script, err, stderr.String())
+	}
+	return string(bytes.TrimSpace(stdout.Bytes()))
+}
+
+type conformanceCase struct {
+	name    string
+	bit     []byte
+	byte    []byte
+	initial []json.RawMessage
+	want    string
+}
+
+func conformanceCases(t *testing.T) []conformanceCase {
+	bit, byt := protocol.Encoder{}, byteprotocol.Encoder{}
+	b := encoded(t)
+
+	letters := raw(`"a"`, `"b"`, `"c"`, `"d"`, `"e"`)
+
+	return []conformanceCase{
+		{"insert", b(bit.EncodeInsert(1, []byte(`"x"`))), b(byt.EncodeInsert(1, []byte(`"x"`))), letters, `["a","x","b","c","d","e"]`},
+		{"update", b(bit.EncodeUpdate(4, []byte(`1`))), b(byt.EncodeUpdate(4, []byte(`1`))), letters, `["a","b","c","d",1]`},
+		{"delete", b(bit.EncodeDelete(0)), b(byt.EncodeDelete(0)), letters, `["b","c","d","e"]`},
+		{"bulk delete", b(bit.EncodeDeleteRange(1, 3)), b(byt.EncodeDeleteRange(1, 3)), letters, `["a","e"]`},
+		{"partial", b(bit.EncodePartialUpdate(0, []byte(`{"n":2}`))), b(byt.EncodePartialUpdate(0, []byte(`{"n":2}`))), raw(`{"n":1,"m":1}`), `[{"n":2,"m":1}]`},
+		{"move forward", b(bit.EncodeMove(0, 3)), b(byt.EncodeMove(0, 3)), letters, `["b","c","d","a","e"]`},
+		{"move backward", b(bit.EncodeMove(4, 0)), b(byt.EncodeMove(4, 0)), letters, `["e","a","b","c","d"]`},
+		{"move to end", b(bit.EncodeMove(1, 4)), b(byt.EncodeMove(1, 4)), letters, `["a","c","d","e","b"]`},
+		{"move past end", b(bit.EncodeMove(1, 300)), b(byt.EncodeMove(1, 300)), letters, `["a","c","d","e","b"]`},
+		{"move missing", b(bit.EncodeMove(9, 0)), b(byt.EncodeMove(9, 0)), letters, `["a","b","c","d","e"]`},
+		{"move in place", b(bit.EncodeMove(2, 2)), b(byt.EncodeMove(2, 2)), letters, `["a","b","c","d","e"]`},
+		{"bulk move forward", b(bit.EncodeMoveRange(0, 1, 3)), b(byt.EncodeMoveRange(0, 1, 3)), letters, `["c","d","e","a","b"]`},
+		{"bulk move backward", b(bit.EncodeMoveRange(3, 4, 1)), b(byt.EncodeMoveRange(3, 4, 1)), letters, `["a","d","e","b","c"]`},
+		{"bulk move clamps", b(bit.EncodeMoveRange(3, 9, 0)), b(byt.EncodeMoveRange(3, 9, 0)), letters, `["d","e","a","b","c"]`},
+		{"bulk move wide positions", b(bit.EncodeMoveRange(0, 0, 70000)), b(byt.EncodeMoveRange(0, 0, 70000)), letters, `["b","c","d","e","a"]`},
+	}
+}
+
+//
+// --- Tests ---
+//
+
+// The Go decoders must leave the array exactly like the JS decoders
+func TestConformanceGo(t *testing.T) {
+	for _, c := range conformanceCases(t) {
+		bitOut, err := (&protocol.Decoder{}).DecodeApply(c.bit, append([]json.RawMessage(nil), c.initial...))
+		if err != nil {
+			t.Fatalf("%s bit: %v", c.name, err)
+		}
+		if got := normalizeJSON(t, asString(bitOut)); got != normalizeJSON(t, c.want) {
+			t.Fatalf("%s bit: expected %s, got %s", c.name, c.want, got)
+		}
+
+		byteOut, err := (&byteprotocol.Decoder{}).DecodeApply(c.byte, append([]json.RawMessage(nil), c.initial...))
+		if err != nil {
+			t.Fatalf("%s byte: %v", c.name, err)
+		}
+		if got := normalizeJSON(t, asString(byteOut)); got != normalizeJSON(t, c.want) {
+			t.Fatalf("%s byte: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestConformanceNode(t *testing.T) {
+	for _, c := range conformanceCases(t) {
+		if got := normalizeJSON(t, applyWithNode(t, "node.js", c.bit, c.initial)); got != normalizeJSON(t, c.want) {
+			t.Fatalf("%s bit: expected %s, got %s", c.name, c.want, got)
+		}
+		if got := normalizeJSON(t, applyWithNode(t, "node_byte.js", c.byte, c.initial)); got != normalizeJSON(t, c.want) {
+			t.Fatalf("%s byte: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+// normalizeJSON sorts object keys so both sides compare equal
+func normalizeJSON(t *testing.T, s string) string {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid JSON %q: %v", s, err)
+	}
+	out, _ := json.Marshal(v)
+	return string(out)
+}
@@ -0,0 +1,98 @@
+package decoder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// Arbitrary frames must be decoded and applied quickly, or rejected
+//
+
+// quick fails when f takes longer than a frame should
+func quick(t *testing.T, data []byte, f func()) {
+	t.Helper()
+	start := time.Now()
+	f()
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("%x took %v", data, d)
+	}
+}
+
+// Bulk inserts of 0..0xFFFFFF (bit) and 0..0xFFFFFFFF (byte) whose
+// payloads have no length, so they take no bytes
+var (
+	bulkWithoutLengths     = []byte{0x8F, 0, 0, 0, 0xFF, 0xFF, 0xFF}
+	bulkWithoutLengthsByte = []byte{byte(byteprotocol.OpInsert), byteprotocol.FlagBulk, 4, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF}
+)
+
+func TestBulkPayloadsBoundedByData(t *testing.T) {
+	for _, v := range []protocol.Version{protocol.V1, protocol.V2} {
+		quick(t, bulkWithoutLengths, func() {
+			if _, err := (&protocol.Decoder{Version: v}).Decode(bulkWithoutLengths); err == nil {
+				t.Fatalf("V%d: expected payloads without length rejected", v)
+			}
+		})
+	}
+	quick(t, bulkWithoutLengthsByte, func() {
+		if _, err := (&byteprotocol.Decoder{}).Decode(bulkWithoutLengthsByte); err == nil {
+			t.Fatal("byte: expected payloads without length rejected")
+		}
+	})
+
+	// more payloads than bytes left
+	if _, err := (&protocol.Decoder{}).Decode([]byte{0x9F, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0}); err == nil {
+		t.Fatal("expected a range larger than the data rejected")
+	}
+
+	// empty payloads still go through
+	bit, err := (&protocol.Encoder{}).EncodeInsertRange(0, 1, [][]byte{{}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op, err := (&protocol.Decoder{}).Decode(bit); err != nil || len(op.Payloads) != 2 {
+		t.Fatalf("bit: expected 2 empty payloads, got %+v %v", op, err)
+	}
+	byt, err := (&byteprotocol.Encoder{}).EncodeInsertRange(0, 1, [][]byte{{}, {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op, err := (&byteprotocol.Decoder{}).Decode(byt); err != nil || len(op.Payloads) != 2 {
+		t.Fatalf("byte: expected 2 empty payloads, got %+v %v", op, err)
+	}
+}
+
+func FuzzDecodeBitProtocol(f *testing.F) {
+	f.Add([]byte{0xC0, 0xAA}, false)
+	f.Add([]byte{0b00001100, 0xFF, 0xFF, 0xFF, 1, '1'}, false)
+	f.Add([]byte{0b00001111, 0xFF, 0xFF, 0xFF, 0x0F, 1, '1'}, true)
+	f.Add([]byte{0b11010100, 0, 0, 0, 1, 1, '1'}, false)
+	f.Add(bulkWithoutLengths, false)
+
+	f.Fuzz(func(t *testing.T, data []byte, v2 bool) {
+		d := protocol.Decoder{Version: protocol.V1}
+		if v2 {
+			d.Version = protocol.V2
+		}
+		quick(t, data, func() {
+			d.DecodeApply(data, []json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`2`)})
+		})
+	})
+}
+
+func FuzzDecodeByteProtocol(f *testing.F) {
+	f.Add([]byte{2, byteprotocol.FlagBulk | byteprotocol.FlagPartial, 0, 0, 0xAA})
+	f.Add([]byte{1, 0, 3, 1, 0xFF, 0xFF, 0xFF, 1, '1'})
+	f.Add(bulkWithoutLengthsByte)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := byteprotocol.Decoder{}
+		quick(t, data, func() {
+			d.DecodeApply(data, []json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`2`)})
+		})
+	})
+}
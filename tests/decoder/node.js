@@ -1,7 +1,10 @@
 import { applyBinaryOperation } from "./../../web/lib/ArrayDecodeProtocol.js";
+import { inflateOperation } from "./../../web/lib/Compress.js";
 import fs from 'fs';
 
 let debug = false
+// array protocol version agreed with the server, 1 when unset
+const version = Number(process.env.PROTOCOL_VERSION || 1)
 
 if(debug){
     process.stdout.write("Starting node\n");
@@ -38,7 +41,8 @@ if(debug){
             console.log("Payload buffer:", payloadBuffer);
         }
 
-        applyBinaryOperation(payloadBuffer, target, debug);
+        const plain = await inflateOperation(payloadBuffer, version);
+        applyBinaryOperation(plain, target, debug, version);
         
         process.stdout.write(JSON.stringify(target) + "\n");
     } catch (err) {
@@ -1,4 +1,5 @@
 import { applyBinaryOperationByte } from "./../../web/lib/ArrayDecodeProtocolByte.js";
+import { inflateOperationByte } from "./../../web/lib/Compress.js";
 import fs from 'fs';
 
 let debug = false
@@ -38,7 +39,8 @@ if(debug){
             console.log("Payload buffer:", payloadBuffer);
         }
 
-        applyBinaryOperationByte(payloadBuffer, target, debug); 
+        const plain = await inflateOperationByte(payloadBuffer);
+        applyBinaryOperationByte(plain, target, debug); 
         
         process.stdout.write(JSON.stringify(target) + "\n");
     } catch (err) {
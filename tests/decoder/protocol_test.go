@@ -0,0 +1,281 @@
+package decoder
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// encoded fails the test when the encoder returns an error
+func encoded(t *testing.T) func([]byte, error) []byte {
+	return func(data []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+func decodeBit(t *testing.T, data []byte) protocol.Operation {
+	t.Helper()
+	dec := protocol.Decoder{}
+	op, err := dec.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func decodeByte(t *testing.T, data []byte) byteprotocol.Operation {
+	t.Helper()
+	dec := byteprotocol.Decoder{}
+	op, err := dec.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return op
+}
+
+func raw(values ...string) []json.RawMessage {
+	out := make([]json.RawMessage, len(values))
+	for i, v := range values {
+		out[i] = json.RawMessage(v)
+	}
+	return out
+}
+
+func asString(values []json.RawMessage) string {
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+// sized encodes v in n bytes, big endian
+func sized(v uint32, n uint8) []byte {
+	out := make([]byte, n)
+	for i := int(n) - 1; i >= 0; i-- {
+		out[i] = byte(v)
+		v >>= 8
+	}
+	return out
+}
+
+//
+// --- Tests ---
+//
+
+func TestBitDecodeSingleOps(t *testing.T) {
+	e := protocol.Encoder{}
+	b := encoded(t)
+
+	op := decodeBit(t, b(e.EncodeInsert(300, []byte(`"A"`))))
+	if op.Op != protocol.OpInsert || op.Bulk || op.Partial || op.Pos != 300 || string(op.Data) != `"A"` || op.PosSize != 2 {
+		t.Fatalf("unexpected insert %+v", op)
+	}
+
+	op = decodeBit(t, b(e.EncodeUpdate(7, []byte(`123`))))
+	if op.Op != protocol.OpUpdate || op.Pos != 7 || string(op.Data) != `123` {
+		t.Fatalf("unexpected update %+v", op)
+	}
+
+	op = decodeBit(t, b(e.EncodeDelete(70000)))
+	if op.Op != protocol.OpDelete || op.Pos != 70000 || op.PosSize != 3 || op.Data != nil {
+		t.Fatalf("unexpected delete %+v", op)
+	}
+
+	op = decodeBit(t, b(e.EncodeMove(2, 300)))
+	if op.Op != protocol.OpMove || op.Bulk || op.Pos != 2 || op.To != 300 || op.PosSize != 2 || op.Data != nil {
+		t.Fatalf("unexpected move %+v", op)
+	}
+
+	op = decodeBit(t, b(e.EncodePartialUpdate(1, []byte(`{"Done":true}`))))
+	if !op.Partial || op.Pos != 1 || string(op.Data) != `{"Done":true}` {
+		t.Fatalf("unexpected partial %+v", op)
+	}
+}
+
+func TestBitDecodeBulkOps(t *testing.T) {
+	e := protocol.Encoder{}
+	b := encoded(t)
+
+	op := decodeBit(t, b(e.EncodeInsertRange(2, 4, [][]byte{[]byte(`1`), []byte(`2`), []byte(`3`)})))
+	if op.Op != protocol.OpInsert || !op.Bulk || op.Start != 2 || op.End != 4 || len(op.Payloads) != 3 || string(op.Payloads[2]) != `3` {
+		t.Fatalf("unexpected bulk insert %+v", op)
+	}
+
+	op = decodeBit(t, b(e.EncodeDeleteRange(1, 3)))
+	if op.Op != protocol.OpDelete || !op.Bulk || op.Start != 1 || op.End != 3 {
+		t.Fatalf("unexpected bulk delete %+v", op)
+	}
+
+	op = decodeBit(t, b(e.EncodeMoveRange(4, 5, 1)))
+	if op.Op != protocol.OpMove || !op.Bulk || op.Start != 4 || op.End != 5 || op.To != 1 {
+		t.Fatalf("unexpected bulk move %+v", op)
+	}
+
+	patches := []protocol.PartialPatch{{Pos: 1, Data: []byte(`"x"`)}, {Pos: 300, Data: []byte(`"y"`)}}
+	op = decodeBit(t, b(e.EncodePartialUpdateRange(0, 400, patches)))
+	if !op.Bulk || !op.Partial || len(op.Patches) != 2 || op.Patches[1].Pos != 300 || string(op.Patches[1].Data) != `"y"` {
+		t.Fatalf("unexpected bulk partial %+v", op)
+	}
+}
+
+// Every header value, with a body built from the header fields
+func TestBitDecodeEveryHeader(t *testing.T) {
+	dec := protocol.Decoder{}
+	d, err := compress.NewDeflater(flate.BestSpeed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for h := 0; h < 256; h++ {
+		header := byte(h)
+		op := protocol.OperationType(header & 0b11)
+		posSize := (header >> 2) & 0b11
+		dataSize := (header >> 4) & 0b11
+		partial := header&(1<<6) != 0
+		bulk := header&(1<<7) != 0
+
+		payload := []byte(`1`)
+		if dataSize == 0 {
+			payload = nil
+		}
+
+		// A bulk INSERT with the partial bit is compressed
+		compressed := bulk && partial && op == protocol.OpInsert
+
+		msg := []byte{header}
+		msg = append(msg, sized(0, posSize)...)
+		if bulk {
+			msg = append(msg, sized(0, posSize)...)
+		}
+		switch {
+		case compressed:
+			block := append(sized(uint32(len(payload)), dataSize), payload...)
+			deflated, err := d.AppendDeflate(msg, block)
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg = deflated
+		case !partial && op == protocol.OpMove:
+			msg = append(msg, sized(0, posSize)...)
+		case partial || op != protocol.OpDelete:
+			if bulk && partial {
+				msg = append(msg, sized(0, posSize)...)
+			}
+			msg = append(msg, sized(uint32(len(payload)), dataSize)...)
+			msg = append(msg, payload...)
+		}
+
+		// bulk payloads need a length each
+		noLength := bulk && (!partial || compressed) && dataSize == 0 && (op == protocol.OpInsert || op == protocol.OpUpdate)
+
+		decoded, err := dec.Decode(msg)
+		if (err != nil) != noLength {
+			t.Fatalf("header %08b: unexpected error %v", header, err)
+		}
+		if err != nil {
+			continue
+		}
+		if decoded.Op != op || decoded.Bulk != bulk || decoded.Partial != (partial && !compressed) || decoded.Compressed != compressed || decoded.PosSize != posSize || decoded.DataSize != dataSize {
+			t.Fatalf("header %08b: decoded %+v", header, decoded)
+		}
+	}
+}
+
+func TestBitDecodeErrors(t *testing.T) {
+	dec := protocol.Decoder{}
+
+	cases := map[string][]byte{
+		"empty":            {},
+		"missing position": {0b00001000, 1},
+		"short payload":    {0b00010111, 0, 5, '"'},
+		"trailing bytes":   {0b00000100, 0, 9},
+		"reversed range":   {0b10000100, 5, 1},
+	}
+	for name, msg := range cases {
+		if _, err := dec.Decode(msg); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestBitApply(t *testing.T) {
+	e := protocol.Encoder{}
+	b := encoded(t)
+	dec := protocol.Decoder{}
+
+	cases := []struct {
+		name    string
+		encoded []byte
+		initial []json.RawMessage
+		want    string
+	}{
+		{"insert", b(e.EncodeInsert(1, []byte(`"B"`))), raw(`"A"`, `"C"`), `["A","B","C"]`},
+		{"insert past end", b(e.EncodeInsert(2, []byte(`"B"`))), raw(), `[null,null,"B"]`},
+		{"update", b(e.EncodeUpdate(0, []byte(`9`))), raw(`1`, `2`), `[9,2]`},
+		{"update past end", b(e.EncodeUpdate(2, []byte(`9`))), raw(`1`), `[1,null,9]`},
+		{"delete", b(e.EncodeDelete(0)), raw(`1`, `2`), `[2]`},
+		{"delete past end", b(e.EncodeDelete(5)), raw(`1`), `[1]`},
+		{"bulk insert", b(e.EncodeInsertRange(1, 2, [][]byte{[]byte(`2`), []byte(`3`)})), raw(`1`, `4`), `[1,2,3,4]`},
+		{"bulk update", b(e.EncodeUpdateRange(1, 2, [][]byte{[]byte(`8`), []byte(`9`)})), raw(`1`), `[1,8,9]`},
+		{"bulk delete", b(e.EncodeDeleteRange(1, 3)), raw(`1`, `2`, `3`, `4`, `5`), `[1,5]`},
+		{"bulk delete clamps", b(e.EncodeDeleteRange(1, 10)), raw(`1`, `2`), `[1]`},
+		{"partial merges objects", b(e.EncodePartialUpdate(0, []byte(`{"Done":true}`))), raw(`{"Id":1,"Done":false}`), `[{"Done":true,"Id":1}]`},
+		{"partial replaces values", b(e.EncodePartialUpdate(0, []byte(`"x"`))), raw(`{"Id":1}`), `["x"]`},
+		{"bulk partial", b(e.EncodePartialUpdateRange(0, 2, []protocol.PartialPatch{{Pos: 0, Data: []byte(`{"n":0}`)}, {Pos: 2, Data: []byte(`{"n":2}`)}})), raw(`{"n":9}`, `{"n":9}`, `{"n":9}`), `[{"n":0},{"n":9},{"n":2}]`},
+	}
+
+	for _, c := range cases {
+		out, err := dec.DecodeApply(c.encoded, c.initial)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := asString(out); got != c.want {
+			t.Fatalf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+type task struct {
+	Id          int
+	Description string
+	Done        bool
+}
+
+func TestBitApplyTo(t *testing.T) {
+	e := protocol.Encoder{}
+	b := encoded(t)
+
+	var err error
+	tasks := []task{{Id: 1, Description: "a"}}
+
+	op := decodeBit(t, b(e.EncodeInsert(1, []byte(`{"Id":2,"Description":"b"}`))))
+	if tasks, err = protocol.ApplyTo(op, tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	op = decodeBit(t, b(e.EncodePartialUpdate(0, []byte(`{"Done":true}`))))
+	if tasks, err = protocol.ApplyTo(op, tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []task{{Id: 1, Description: "a", Done: true}, {Id: 2, Description: "b"}}
+	if fmt.Sprint(tasks) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, tasks)
+	}
+
+	op = decodeBit(t, b(e.EncodeInsert(0, []byte(`"not a task"`))))
+	if _, err := protocol.ApplyTo(op, tasks); err == nil {
+		t.Fatal("expected error decoding a string into a struct")
+	}
+}
@@ -0,0 +1,137 @@
+package decoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+const beyond3Bytes = 0x1000000
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		offered []protocol.Version
+		want    protocol.Version
+	}{
+		{nil, protocol.V1},
+		{[]protocol.Version{protocol.V1}, protocol.V1},
+		{[]protocol.Version{protocol.V1, protocol.V2}, protocol.V2},
+		{[]protocol.Version{protocol.V2, 9}, protocol.V2},
+		{[]protocol.Version{9}, protocol.V1},
+	}
+	for _, c := range cases {
+		if got := protocol.Negotiate(c.offered...); got != c.want {
+			t.Fatalf("offered %v: expected %d, got %d", c.offered, c.want, got)
+		}
+	}
+}
+
+// Below 3 byte values both versions write the same bytes
+func TestBitV2SmallValuesUnchanged(t *testing.T) {
+	v1, v2 := protocol.Encoder{}, protocol.Encoder{Version: protocol.V2}
+	b := encoded(t)
+
+	for _, pos := range []uint32{0, 0xFF, 0x100, 0xFFFF} {
+		if !bytes.Equal(b(v1.EncodeInsert(pos, []byte(`1`))), b(v2.EncodeInsert(pos, []byte(`1`)))) {
+			t.Fatalf("insert at %d differs between versions", pos)
+		}
+		if !bytes.Equal(b(v1.EncodeMoveRange(0, pos, 1)), b(v2.EncodeMoveRange(0, pos, 1))) {
+			t.Fatalf("move of %d differs between versions", pos)
+		}
+	}
+}
+
+func TestBitV2LargeValues(t *testing.T) {
+	v1, v2 := protocol.Encoder{}, protocol.Encoder{Version: protocol.V2}
+	dec := protocol.Decoder{Version: protocol.V2}
+	b := encoded(t)
+
+	if _, err := v1.EncodeDelete(beyond3Bytes); err == nil {
+		t.Fatal("expected V1 to reject a 4 byte position")
+	}
+	if _, err := v1.EncodeDeleteRange(0, beyond3Bytes); err == nil {
+		t.Fatal("expected V1 to reject a 4 byte range")
+	}
+
+	for _, pos := range []uint32{0x10000, 0xFFFFFF, beyond3Bytes, 0xFFFFFFFF} {
+		op, err := dec.Decode(b(v2.EncodeMoveRange(pos-1, pos, 3)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op.Start != pos-1 || op.End != pos || op.To != 3 {
+			t.Fatalf("expected %d-%d to 3, got %+v", pos-1, pos, op)
+		}
+	}
+
+	big := append(append([]byte(`"`), bytes.Repeat([]byte("x"), beyond3Bytes)...), '"')
+	if _, err := v1.EncodeInsert(0, big); err == nil {
+		t.Fatal("expected V1 to reject a payload over 16 MB")
+	}
+	out, err := dec.DecodeApply(b(v2.EncodeInsert(1, big)), raw(`"a"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || !bytes.Equal(out[1], big) {
+		t.Fatalf("expected the large payload at 1, got %d values", len(out))
+	}
+}
+
+// The header does not carry the version, a mismatch is only caught when
+// the lengths do not add up, like this 4 byte uvarint read as 3 bytes
+func TestBitVersionMismatch(t *testing.T) {
+	msg := encoded(t)((&protocol.Encoder{Version: protocol.V2}).EncodeDelete(beyond3Bytes))
+	if _, err := (&protocol.Decoder{}).Decode(msg); err == nil {
+		t.Fatal("expected a V1 decoder to reject the uvarint position")
+	}
+	if _, err := (&protocol.Encoder{Version: 3}).EncodeDelete(0); err == nil {
+		t.Fatal("expected unsupported version error")
+	}
+	if _, err := (&protocol.Decoder{Version: 3}).Decode(msg); err == nil {
+		t.Fatal("expected unsupported version error")
+	}
+}
+
+func TestByteLargeValues(t *testing.T) {
+	enc, dec := byteprotocol.Encoder{}, byteprotocol.Decoder{}
+	b := encoded(t)
+
+	op, err := dec.Decode(b(enc.EncodeMoveRange(0, 1, 0xFFFFFFFF)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.PosSize != 4 || op.To != 0xFFFFFFFF {
+		t.Fatalf("expected a 4 byte target, got %+v", op)
+	}
+
+	// below 3 byte values the header is unchanged
+	if op := decodeByte(t, b(enc.EncodeDelete(0xFFFFFF))); op.PosSize != 3 {
+		t.Fatalf("expected 3 byte position, got %d", op.PosSize)
+	}
+}
+
+// Large positions land at the same place in Go and JS
+func TestLargeValuesNode(t *testing.T) {
+	letters := raw(`"a"`, `"b"`, `"c"`)
+	want := `["b","c","a"]`
+	b := encoded(t)
+
+	bit := b((&protocol.Encoder{Version: protocol.V2}).EncodeMove(0, beyond3Bytes))
+	goOut, err := (&protocol.Decoder{Version: protocol.V2}).DecodeApply(bit, append([]json.RawMessage(nil), letters...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := asString(goOut); got != want {
+		t.Fatalf("go bit: expected %s, got %s", want, got)
+	}
+	if got := normalizeJSON(t, applyWithNode(t, "node.js", bit, letters, "PROTOCOL_VERSION=2")); got != want {
+		t.Fatalf("node bit: expected %s, got %s", want, got)
+	}
+
+	byt := b((&byteprotocol.Encoder{}).EncodeMove(0, beyond3Bytes))
+	if got := normalizeJSON(t, applyWithNode(t, "node_byte.js", byt, letters)); got != want {
+		t.Fatalf("node byte: expected %s, got %s", want, got)
+	}
+}
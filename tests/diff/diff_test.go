@@ -0,0 +1,343 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+type Task struct {
+	Id          int
+	Description string
+	Done        bool
+}
+
+func taskId(t Task) int { return t.Id }
+
+//
+// --- Test Helpers ---
+//
+
+// applyBit runs the operations on a copy of old with the Go decoder
+func applyBit(t *testing.T, ops [][]byte, old []Task) []Task {
+	t.Helper()
+	dec := protocol.Decoder{}
+	out := append([]Task(nil), old...)
+	for _, msg := range ops {
+		op, err := dec.Decode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err = protocol.ApplyTo(op, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+func applyByte(t *testing.T, ops [][]byte, old []Task) []Task {
+	t.Helper()
+	dec := byteprotocol.Decoder{}
+	out := append([]Task(nil), old...)
+	for _, msg := range ops {
+		op, err := dec.Decode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err = byteprotocol.ApplyTo(op, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+// applyJSON runs the operations like the JS client, on plain JSON values
+func applyJSON(t *testing.T, ops [][]byte, old []Task) string {
+	t.Helper()
+	dec := protocol.Decoder{}
+	var out []json.RawMessage
+	for _, task := range old {
+		data, _ := json.Marshal(task)
+		out = append(out, data)
+	}
+	for _, msg := range ops {
+		var err error
+		if out, err = dec.DecodeApply(msg, out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return normalize(t, out)
+}
+
+func normalize(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic any
+	json.Unmarshal(data, &generic)
+	data, _ = json.Marshal(generic)
+	if string(data) == "null" {
+		return "[]"
+	}
+	return string(data)
+}
+
+func tasks(ids ...int) []Task {
+	out := make([]Task, len(ids))
+	for i, id := range ids {
+		out[i] = Task{Id: id, Description: fmt.Sprint("task ", id)}
+	}
+	return out
+}
+
+func headers(ops [][]byte) []byte {
+	out := make([]byte, len(ops))
+	for i, op := range ops {
+		out[i] = op[0]
+	}
+	return out
+}
+
+//
+// --- Tests ---
+//
+
+func TestDiffSingleOperations(t *testing.T) {
+	cases := []struct {
+		name   string
+		old    []Task
+		new    []Task
+		header []byte
+	}{
+		{"unchanged", tasks(1, 2, 3), tasks(1, 2, 3), []byte{}},
+		{"append", tasks(1, 2), tasks(1, 2, 3), []byte{0b00010111}},
+		{"insert at start", tasks(2, 3), tasks(1, 2, 3), []byte{0b00010111}},
+		{"delete middle", tasks(1, 2, 3), tasks(1, 3), []byte{0b00000100}},
+		{"delete run", tasks(1, 2, 3, 4, 5), tasks(1, 5), []byte{0b10000100}},
+		{"insert run", tasks(1, 5), tasks(1, 2, 3, 4, 5), []byte{0b10010111}},
+		{"replace", tasks(1, 2, 3), tasks(1, 9, 3), []byte{0b01010101}},
+		{"clear", tasks(1, 2, 3), nil, []byte{0b10000100}},
+		{"fill", nil, tasks(1, 2), []byte{0b10010111}},
+	}
+
+	for _, c := range cases {
+		ops, err := diff.SliceFunc(diff.Bit, c.old, c.new, taskId)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := headers(ops); !reflect.DeepEqual(got, c.header) {
+			t.Fatalf("%s: expected headers %08b, got %08b", c.name, c.header, got)
+		}
+		if got := applyBit(t, ops, c.old); normalize(t, got) != normalize(t, c.new) {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.new, got)
+		}
+	}
+}
+
+func TestDiffPartialUpdate(t *testing.T) {
+	old := tasks(1, 2, 3)
+	new := tasks(1, 2, 3)
+	new[1].Done = true
+
+	ops, err := diff.SliceFunc(diff.Bit, old, new, taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 {
+		t.Fatalf("expected 1 operation, got %d", len(ops))
+	}
+
+	op, err := (&protocol.Decoder{}).Decode(ops[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !op.Partial || op.Pos != 1 || string(op.Data) != `{"Done":true}` {
+		t.Fatalf("expected a partial update of Done, got %+v", op)
+	}
+}
+
+func TestDiffPatchesInOneMessage(t *testing.T) {
+	old := tasks(1, 2, 3, 4)
+	new := tasks(1, 2, 3, 4)
+	new[0].Done = true
+	new[3].Done = true
+
+	ops, err := diff.SliceFunc(diff.Bit, old, new, taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0][0]&0b11000000 != 0b11000000 {
+		t.Fatalf("expected one bulk partial update, got %08b", headers(ops))
+	}
+	if got := applyBit(t, ops, old); !reflect.DeepEqual(got, new) {
+		t.Fatalf("expected %v, got %v", new, got)
+	}
+}
+
+func TestDiffFullUpdatesGrouped(t *testing.T) {
+	ops, err := diff.Slice(diff.Bit, []string{"a", "b", "c", "d"}, []string{"a", "x", "y", "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0][0] != 0b10010101 {
+		t.Fatalf("expected one bulk update, got %08b", headers(ops))
+	}
+}
+
+func TestDiffMovedElement(t *testing.T) {
+	old := tasks(1, 2, 3, 4)
+	new := tasks(2, 3, 4, 1)
+
+	ops, err := diff.SliceFunc(diff.Bit, old, new, taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0][0] != 0b00000110 {
+		t.Fatalf("expected a single move, got %08b", headers(ops))
+	}
+	if got := applyBit(t, ops, old); !reflect.DeepEqual(got, new) {
+		t.Fatalf("expected %v, got %v", new, got)
+	}
+}
+
+func TestDiffMovedBlock(t *testing.T) {
+	old := tasks(1, 2, 3, 4, 5, 6)
+	new := tasks(1, 5, 6, 2, 3, 4)
+
+	ops, err := diff.SliceFunc(diff.Byte, old, new, taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0][0] != byte(byteprotocol.OpMove) || ops[0][1] != byteprotocol.FlagBulk {
+		t.Fatalf("expected a single bulk move, got %v", ops)
+	}
+	if got := applyByte(t, ops, old); !reflect.DeepEqual(got, new) {
+		t.Fatalf("expected %v, got %v", new, got)
+	}
+}
+
+func TestDiffMovedAndChanged(t *testing.T) {
+	old := tasks(1, 2, 3)
+	new := tasks(3, 1, 2)
+	new[0].Done = true
+
+	ops, err := diff.SliceFunc(diff.Bit, old, new, taskId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := headers(ops); !reflect.DeepEqual(got, []byte{0b00000110, 0b01010101}) {
+		t.Fatalf("expected a move and a partial update, got %08b", got)
+	}
+	if got := applyBit(t, ops, old); !reflect.DeepEqual(got, new) {
+		t.Fatalf("expected %v, got %v", new, got)
+	}
+}
+
+func TestDiffUnkeyedPatchesObjects(t *testing.T) {
+	old := tasks(1, 2)
+	new := tasks(1, 2)
+	new[1].Description = "changed"
+
+	ops, err := diff.Slice(diff.Byte, old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0][1] != byteprotocol.FlagPartial {
+		t.Fatalf("expected one partial update, got %v", ops)
+	}
+	if got := applyByte(t, ops, old); !reflect.DeepEqual(got, new) {
+		t.Fatalf("expected %v, got %v", new, got)
+	}
+}
+
+// Random edits of a task list must always reach the new version, on both
+// protocols and on plain JSON like the browser does
+func TestDiffRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	nextId := 1000
+
+	for round := 0; round < 1000; round++ {
+		old := tasks(rnd.Perm(rnd.Intn(20))...)
+		new := append([]Task(nil), old...)
+
+		for edits := rnd.Intn(6); edits > 0; edits-- {
+			switch rnd.Intn(5) {
+			case 0:
+				pos := rnd.Intn(len(new) + 1)
+				nextId++
+				new = append(new[:pos], append([]Task{{Id: nextId, Description: "new"}}, new[pos:]...)...)
+			case 1:
+				if len(new) > 0 {
+					pos := rnd.Intn(len(new))
+					new = append(new[:pos], new[pos+1:]...)
+				}
+			case 2:
+				if len(new) > 0 {
+					new[rnd.Intn(len(new))].Done = true
+				}
+			case 3:
+				if len(new) > 1 {
+					a, b := rnd.Intn(len(new)), rnd.Intn(len(new))
+					new[a], new[b] = new[b], new[a]
+				}
+			case 4:
+				if len(new) > 2 {
+					start := rnd.Intn(len(new) - 1)
+					end := start + 1 + rnd.Intn(len(new)-start-1)
+					block := append([]Task(nil), new[start:end]...)
+					rest := append(append([]Task(nil), new[:start]...), new[end:]...)
+					to := rnd.Intn(len(rest) + 1)
+					new = append(append(rest[:to:to], block...), rest[to:]...)
+				}
+			}
+		}
+
+		for _, keyed := range []bool{true, false} {
+			var bitOps, byteOps [][]byte
+			var err error
+			if keyed {
+				bitOps, err = diff.SliceFunc(diff.Bit, old, new, taskId)
+				if err == nil {
+					byteOps, err = diff.SliceFunc(diff.Byte, old, new, taskId)
+				}
+			} else {
+				bitOps, err = diff.Slice(diff.Bit, old, new)
+				if err == nil {
+					byteOps, err = diff.Slice(diff.Byte, old, new)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := normalize(t, new)
+			if got := normalize(t, applyBit(t, bitOps, old)); got != want {
+				t.Fatalf("round %d keyed=%v bit: expected %s, got %s", round, keyed, want, got)
+			}
+			if got := normalize(t, applyByte(t, byteOps, old)); got != want {
+				t.Fatalf("round %d keyed=%v byte: expected %s, got %s", round, keyed, want, got)
+			}
+			if got := applyJSON(t, bitOps, old); got != want {
+				t.Fatalf("round %d keyed=%v json: expected %s, got %s", round, keyed, want, got)
+			}
+		}
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	old := tasks(rand.New(rand.NewSource(1)).Perm(1000)...)
+	new := append(append([]Task(nil), old[:300]...), old[310:]...)
+	new[500].Done = true
+
+	for i := 0; i < b.N; i++ {
+		diff.SliceFunc(diff.Bit, old, new, taskId)
+	}
+}
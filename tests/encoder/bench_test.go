@@ -142,3 +142,76 @@ func BenchmarkByteProtocol_EncodeDeleteRange(b *testing.B) {
 		_, _ = enc.EncodeDeleteRange(0, 100)
 	}
 }
+
+// --- Compression Benchmarks ---
+//
+// A bulk insert of 200 tasks sent plain, compressed in the operation with
+// and without a dictionary, and compressed as a whole frame the way
+// permessage-deflate does. wire-bytes is the size on the wire.
+
+func benchmarkBulkInsert(b *testing.B, encode func(dst []byte, payloads [][]byte) ([]byte, error)) {
+	payloads := taskPayloads(200)
+	var buf []byte
+	var err error
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if buf, err = encode(buf[:0], payloads); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(buf)), "wire-bytes")
+}
+
+func BenchmarkBulkInsert_Plain(b *testing.B) {
+	enc := protocol.Encoder{}
+	benchmarkBulkInsert(b, func(dst []byte, payloads [][]byte) ([]byte, error) {
+		return enc.AppendInsertRange(dst, 0, 199, payloads)
+	})
+}
+
+func BenchmarkBulkInsert_Compressed(b *testing.B) {
+	enc := protocol.Encoder{}
+	d := deflater(b, nil)
+	benchmarkBulkInsert(b, func(dst []byte, payloads [][]byte) ([]byte, error) {
+		return enc.AppendCompressedInsertRange(dst, 0, 199, payloads, d)
+	})
+}
+
+func BenchmarkBulkInsert_CompressedDict(b *testing.B) {
+	enc := protocol.Encoder{}
+	d := deflater(b, taskDict)
+	benchmarkBulkInsert(b, func(dst []byte, payloads [][]byte) ([]byte, error) {
+		return enc.AppendCompressedInsertRange(dst, 0, 199, payloads, d)
+	})
+}
+
+func BenchmarkBulkInsert_PerMessageDeflate(b *testing.B) {
+	enc := protocol.Encoder{}
+	d := deflater(b, nil)
+	var plain []byte
+	benchmarkBulkInsert(b, func(dst []byte, payloads [][]byte) ([]byte, error) {
+		var err error
+		if plain, err = enc.AppendInsertRange(plain[:0], 0, 199, payloads); err != nil {
+			return dst, err
+		}
+		return d.AppendDeflate(dst, plain)
+	})
+}
+
+func BenchmarkBulkInsert_Inflate(b *testing.B) {
+	enc := protocol.Encoder{}
+	bin, err := enc.EncodeCompressedInsertRange(0, 199, taskPayloads(200), deflater(b, nil))
+	if err != nil {
+		b.Fatal(err)
+	}
+	dec := protocol.Decoder{}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := dec.Decode(bin); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(bin)), "wire-bytes")
+}
@@ -0,0 +1,188 @@
+package encoder
+
+import (
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/compress"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+type task struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+	Owner string `json:"owner"`
+}
+
+// taskDict holds what every task payload repeats
+var taskDict = []byte(`{"id":,"title":"Task number ","done":false,"owner":"team-"}`)
+
+func taskPayloads(n int) [][]byte {
+	payloads := make([][]byte, n)
+	for i := range payloads {
+		payloads[i], _ = json.Marshal(task{Id: i, Title: fmt.Sprintf("Task number %d", i), Done: i%3 == 0, Owner: fmt.Sprintf("team-%d", i%4)})
+	}
+	return payloads
+}
+
+func deflater(t testing.TB, dict []byte) *compress.Deflater {
+	d, err := compress.NewDeflater(flate.BestSpeed, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCompressedInsertRangeRoundTrip(t *testing.T) {
+	payloads := taskPayloads(50)
+
+	for _, dict := range [][]byte{nil, taskDict} {
+		d := deflater(t, dict)
+
+		for _, version := range protocol.Supported {
+			enc := protocol.Encoder{Version: version}
+			plain, err := enc.EncodeInsertRange(300, 349, payloads)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bin, err := enc.EncodeCompressedInsertRange(300, 349, payloads, d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bin) >= len(plain) {
+				t.Fatalf("V%d: compressed %d bytes, plain %d", version, len(bin), len(plain))
+			}
+
+			dec := protocol.Decoder{Version: version, Dict: dict}
+			op, err := dec.Decode(bin)
+			if err != nil {
+				t.Fatalf("V%d: %v", version, err)
+			}
+			if !op.Compressed || op.Partial || !op.Bulk || op.Op != protocol.OpInsert || op.Start != 300 || op.End != 349 {
+				t.Fatalf("V%d: unexpected operation %+v", version, op)
+			}
+			if !reflect.DeepEqual(op.Payloads, payloads) {
+				t.Fatalf("V%d: payloads differ", version)
+			}
+		}
+
+		enc := byteprotocol.Encoder{}
+		bin, err := enc.EncodeCompressedInsertRange(70000, 70049, payloads, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bin[1] != byteprotocol.FlagBulk|byteprotocol.FlagCompressed {
+			t.Fatalf("unexpected flags %08b", bin[1])
+		}
+		dec := byteprotocol.Decoder{Dict: dict}
+		op, err := dec.Decode(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !op.Compressed || op.Start != 70000 || op.End != 70049 || !reflect.DeepEqual(op.Payloads, payloads) {
+			t.Fatalf("unexpected operation %+v", op)
+		}
+	}
+}
+
+func TestCompressedInsertRangeNeedsTheDictionary(t *testing.T) {
+	enc := protocol.Encoder{}
+	bin, err := enc.EncodeCompressedInsertRange(0, 9, taskPayloads(10), deflater(t, taskDict))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := protocol.Decoder{}
+	if _, err := dec.Decode(bin); err == nil {
+		t.Fatal("inflated without the dictionary")
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	data, err := deflater(t, nil).AppendDeflate(nil, make([]byte, 4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := compress.Inflate(data, nil, 4095); err != compress.ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	out, err := compress.Inflate(data, nil, 4096)
+	if err != nil || len(out) != 4096 {
+		t.Fatalf("expected 4096 bytes, got %d, %v", len(out), err)
+	}
+}
+
+func TestDeflatedThreshold(t *testing.T) {
+	enc := diff.Deflated(diff.Bit, deflater(t, nil), 200)
+
+	small, err := enc.EncodeInsertRange(0, 1, [][]byte{[]byte(`"a"`), []byte(`"b"`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small[0]&(1<<6) != 0 {
+		t.Fatalf("small bulk insert compressed: %08b", small[0])
+	}
+
+	large, err := enc.EncodeInsertRange(0, 19, taskPayloads(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if large[0]&(1<<6) == 0 {
+		t.Fatalf("large bulk insert not compressed: %08b", large[0])
+	}
+
+	// Only bulk inserts are compressed
+	update, err := enc.EncodeUpdateRange(0, 19, taskPayloads(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if update[0]&(1<<6) != 0 {
+		t.Fatalf("bulk update compressed: %08b", update[0])
+	}
+}
+
+func TestCompressedInsertRangeWithNode(t *testing.T) {
+	payloads := taskPayloads(20)
+	d := deflater(t, nil)
+
+	want := []interface{}{"first"}
+	for _, p := range payloads {
+		var v interface{}
+		json.Unmarshal(p, &v)
+		want = append(want, v)
+	}
+	want = append(want, "last")
+
+	enc := protocol.Encoder{}
+	bin, err := enc.EncodeCompressedInsertRange(1, 20, payloads, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := decodeWithNode(bin, []interface{}{"first", "last"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("expected %v, got %v", want, out)
+	}
+
+	byteEnc := byteprotocol.Encoder{}
+	bin, err = byteEnc.EncodeCompressedInsertRange(1, 20, payloads, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = decodeWithNode_byte(bin, []interface{}{"first", "last"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("expected %v, got %v", want, out)
+	}
+}
@@ -31,7 +31,7 @@ func FuzzBinaryProtocol(f *testing.F) {
 			return // encoder correctly rejected invalid input
 		}
 
-		_, err = decodeWithNode(bin)
+		_, err = decodeWithNode(bin, nil)
 		if err != nil {
 			t.Fatalf("decoder crashed on fuzz input: %v", err)
 		}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/leanovate/gopter"
 	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
 
 	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
 )
@@ -16,17 +17,21 @@ func TestInsertUpdateDeleteProperties(t *testing.T) {
 
 	enc := protocol.Encoder{}
 
+	// Positions past the end leave holes, decoders accept at most
+	// MaxGrowth of them
+	position := gen.UInt32Range(0, protocol.MaxGrowth)
+
 	properties.Property("Insert followed by Update produces updated value", prop.ForAll(
 		func(pos uint32, value int, updated int) bool {
 			bin1, _ := enc.EncodeInsert(pos, []byte(fmt.Sprintf("%d", value)))
-			arr1, _ := decodeWithNode(bin1)
+			arr1, _ := decodeWithNode(bin1, nil)
 
 			bin2, _ := enc.EncodeUpdate(pos, []byte(fmt.Sprintf("%d", updated)))
-			arr2, _ := decodeWithNode(bin2)
+			arr2, _ := decodeWithNode(bin2, arr1)
 
-			return int(arr2[pos].(float64)) == updated
+			return len(arr2) > int(pos) && int(arr2[pos].(float64)) == updated
 		},
-		gen.UInt32(),
+		position,
 		gen.Int(),
 		gen.Int(),
 	))
@@ -34,14 +39,14 @@ func TestInsertUpdateDeleteProperties(t *testing.T) {
 	properties.Property("Delete removes element or has no effect", prop.ForAll(
 		func(pos uint32, value int) bool {
 			bin1, _ := enc.EncodeInsert(pos, []byte("1"))
-			arr1, _ := decodeWithNode(bin1)
+			arr1, _ := decodeWithNode(bin1, nil)
 
 			bin2, _ := enc.EncodeDelete(pos)
-			arr2, _ := decodeWithNode(bin2)
+			arr2, _ := decodeWithNode(bin2, arr1)
 
 			return len(arr2) <= len(arr1)
 		},
-		gen.UInt32(),
+		position,
 		gen.Int(),
 	))
 
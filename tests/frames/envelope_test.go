@@ -0,0 +1,113 @@
+package frames
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/envelope"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+)
+
+//
+// A JSON envelope must stand for the same frame as its binary layout
+//
+
+func TestEnvelopeFrames(t *testing.T) {
+	conn := &types.WebSocketConnection{Codec: codec.JSON}
+
+	e, err := envelope.Unmarshal([]byte(`{"type":"subscribe","reqId":2,"topic":"tasks/+","data":{"all":true},"header":{"since":"3"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := e.Frame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := subscribe.ClientInputSubscription{WSConn: conn}
+	if err := sub.Unmarshal(frame); err != nil {
+		t.Fatal(err)
+	}
+	if *sub.ReqId != 2 || sub.Topic != "tasks/+" || sub.Data != `{"all":true}` || sub.Header["since"] != "3" {
+		t.Fatalf("unexpected subscription %+v", sub)
+	}
+
+	e, err = envelope.Unmarshal([]byte(`{"type":"rpc","reqId":4,"class":"tasks","method":"add","params":{"title":"x"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame, err = e.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	call := rpc.ClientInputRPC{WSConn: conn}
+	if err := call.Unmarshal(frame); err != nil {
+		t.Fatal(err)
+	}
+	if *call.ReqId != 4 || call.Class != "tasks" || call.Method != "add" || call.Params["title"] != "x" {
+		t.Fatalf("unexpected rpc %+v", call)
+	}
+
+	e, err = envelope.Unmarshal([]byte(`{"type":"hello","reqId":1,"version":1,"reqIdSize":1,"capabilities":7,"arrayVersions":[1,2]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame, err = e.Frame(); err != nil {
+		t.Fatal(err)
+	}
+	var h hello.ClientInputHello
+	if err := h.Unmarshal(frame); err != nil {
+		t.Fatal(err)
+	}
+	reqId := uint8(1)
+	want := hello.ClientInputHello{ReqId: &reqId, Version: 1, ReqIdSize: 1, Capabilities: 7, ArrayVersions: []protocol.Version{1, 2}}
+	if !reflect.DeepEqual(h, want) {
+		t.Fatalf("expected %+v, got %+v", want, h)
+	}
+}
+
+func TestEnvelopeRejected(t *testing.T) {
+	for _, text := range []string{
+		``,
+		`[]`,
+		`{"reqId":1}`,
+		`{"type":"subscribe","reqId":1}`,
+		`{"type":"rpc","reqId":1,"class":"tasks"}`,
+		`{"type":"endpoint","reqId":1,"method":"GET"}`,
+		`{"type":"publish","reqId":1}`,
+	} {
+		e, err := envelope.Unmarshal([]byte(text))
+		if err == nil {
+			_, err = e.Frame()
+		}
+		if err == nil {
+			t.Fatalf("expected %q to fail", text)
+		}
+	}
+}
+
+func TestClientOutputEnvelopeRoundTrip(t *testing.T) {
+	in := types.ClientOutput{ReqId: 3, MsgType: types.WSTypeSuccessOutputMessage, Destination: "tasks", Data: map[string]any{"id": float64(1)}, Header: map[string]string{"seq": "4"}}
+
+	msg, err := in.MarshalEnvelope()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != `{"reqId":3,"msgType":"S","destination":"tasks","data":{"id":1},"header":{"seq":"4"}}` {
+		t.Fatalf("unexpected envelope %s", msg)
+	}
+	var out types.ClientOutput
+	if err := out.UnmarshalEnvelope(msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+
+	if err := out.UnmarshalEnvelope([]byte(`{"reqId":3,"msgType":"SE"}`)); err == nil {
+		t.Fatal("expected an invalid msgType to fail")
+	}
+}
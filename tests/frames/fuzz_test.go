@@ -0,0 +1,163 @@
+package frames
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+)
+
+//
+// Every unmarshaler must reject bad frames without panicking, and a frame
+// it accepts must read the same after a Marshal round trip.
+//
+
+// seeds returns a function adding the marshaled frame to the corpus
+func seeds(f *testing.F) func([]byte, error) {
+	return func(data []byte, err error) {
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+}
+
+func FuzzClientOutputUnmarshal(f *testing.F) {
+	seed := seeds(f)
+	seed(types.ClientOutput{ReqId: 1, MsgType: types.WSTypeSuccessOutputMessage, Destination: "tasks", Data: "hello"}.Marshal())
+	seed(types.ClientOutput{Data: map[string]int{"a": 1}, Header: map[string]string{"seq": "2"}}.Marshal())
+	f.Add([]byte{})
+	f.Add([]byte{0, 'S', 0xFF, 0xFF, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, c := range codec.All {
+			var first types.ClientOutput
+			if err := first.UnmarshalWith(c, data); err != nil {
+				continue
+			}
+			msg, err := first.MarshalWith(c)
+			if err != nil {
+				t.Fatalf("%s: accepted frame does not marshal: %v", c.Name(), err)
+			}
+			var second types.ClientOutput
+			if err := second.UnmarshalWith(c, msg); err != nil {
+				t.Fatalf("%s: marshaled frame does not unmarshal: %v", c.Name(), err)
+			}
+			if !reflect.DeepEqual(first, second) {
+				t.Fatalf("%s: expected %+v, got %+v", c.Name(), first, second)
+			}
+		}
+	})
+}
+
+func FuzzRPCUnmarshal(f *testing.F) {
+	seed := seeds(f)
+	reqId := uint8(7)
+	seed(rpc.ClientInputRPC{ReqId: &reqId, Class: "tasks", Method: "add", Params: map[string]interface{}{"title": "x"}}.Marshal())
+	f.Add([]byte{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{2, 0, 0xFF, 0xFF, 0, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, c := range codec.All {
+			conn := &types.WebSocketConnection{Codec: c}
+			first := rpc.ClientInputRPC{WSConn: conn}
+			if err := first.Unmarshal(data); err != nil {
+				continue
+			}
+			msg, err := first.Marshal()
+			if err != nil {
+				t.Fatalf("%s: accepted frame does not marshal: %v", c.Name(), err)
+			}
+			second := rpc.ClientInputRPC{WSConn: conn}
+			if err := second.Unmarshal(msg); err != nil {
+				t.Fatalf("%s: marshaled frame does not unmarshal: %v", c.Name(), err)
+			}
+			if !reflect.DeepEqual(first, second) {
+				t.Fatalf("%s: expected %+v, got %+v", c.Name(), first, second)
+			}
+		}
+	})
+}
+
+func FuzzRestUnmarshal(f *testing.F) {
+	seed := seeds(f)
+	reqId := uint8(3)
+	seed(rest.ClientInputRest{ReqId: &reqId, Method: rest.POST, Endpoint: "/tasks", Data: `{"title":"x"}`}.Marshal())
+	f.Add([]byte{3, 0, 0xFF})
+	f.Add([]byte{3, 0, 0, 0xFF, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var first rest.ClientInputRest
+		if err := first.Unmarshal(data); err != nil {
+			return
+		}
+		msg, err := first.Marshal()
+		if err != nil {
+			t.Fatalf("accepted frame does not marshal: %v", err)
+		}
+		var second rest.ClientInputRest
+		if err := second.Unmarshal(msg); err != nil {
+			t.Fatalf("marshaled frame does not unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("expected %+v, got %+v", first, second)
+		}
+	})
+}
+
+func FuzzSubscribeUnmarshal(f *testing.F) {
+	seed := seeds(f)
+	reqId := uint8(1)
+	seed(subscribe.ClientInputSubscription{ReqId: &reqId, Topic: "tasks", Data: `""`, Header: map[string]string{"since": "4"}}.Marshal())
+	seed(subscribe.ClientInputSubscription{Topic: "tasks"}.MarshalUnsubscribe())
+	f.Add([]byte{1, 0, 0xFF, 0xFF, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var first subscribe.ClientInputSubscription
+		if err := first.Unmarshal(data); err != nil {
+			return
+		}
+		msg, err := first.Marshal()
+		if err != nil {
+			t.Fatalf("accepted frame does not marshal: %v", err)
+		}
+		var second subscribe.ClientInputSubscription
+		if err := second.Unmarshal(msg); err != nil {
+			t.Fatalf("marshaled frame does not unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("expected %+v, got %+v", first, second)
+		}
+	})
+}
+
+func FuzzHelloUnmarshal(f *testing.F) {
+	seed := seeds(f)
+	reqId := uint8(1)
+	seed(hello.ClientInputHello{ReqId: &reqId, Version: 1, ReqIdSize: 1, Capabilities: types.CapBitProtocol, ArrayVersions: []protocol.Version{1, 2}}.Marshal())
+	f.Add([]byte{5, 0, 1, 1, 0, 0, 0, 0, 0xFF})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var first hello.ClientInputHello
+		if err := first.Unmarshal(data); err != nil {
+			return
+		}
+		msg, err := first.Marshal()
+		if err != nil {
+			t.Fatalf("accepted frame does not marshal: %v", err)
+		}
+		var second hello.ClientInputHello
+		if err := second.Unmarshal(msg); err != nil {
+			t.Fatalf("marshaled frame does not unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Fatalf("expected %+v, got %+v", first, second)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package frames
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rest"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// sameHeader treats a nil and an empty header as equal
+func sameHeader(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func asAny(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func genHeader() gopter.Gen {
+	return gen.MapOf(gen.AlphaString(), gen.AnyString())
+}
+
+func genMsgType() gopter.Gen {
+	return gen.OneConstOf(types.WSTypeSuccessOutputMessage, types.WSTypeErrorOutputMessage)
+}
+
+func genMethod() gopter.Gen {
+	return gen.OneConstOf(rest.GET, rest.POST, rest.PUT, rest.PATCH, rest.DELETE)
+}
+
+//
+// --- Properties ---
+//
+
+func TestClientOutputRoundTripProperties(t *testing.T) {
+	properties := gopter.NewProperties(gopter.DefaultTestParameters())
+
+	for _, c := range codec.All {
+		c := c
+		properties.Property("ClientOutput with string data survives "+c.Name(), prop.ForAll(
+			func(reqId uint8, msgType types.WSTypeOutputMessage, destination, data string, header map[string]string) bool {
+				in := types.ClientOutput{ReqId: reqId, MsgType: msgType, Destination: destination, Data: data, Header: header}
+				msg, err := in.MarshalWith(c)
+				if err != nil {
+					return false
+				}
+				var out types.ClientOutput
+				if err := out.UnmarshalWith(c, msg); err != nil {
+					return false
+				}
+				return out.ReqId == reqId && out.MsgType == msgType && out.Destination == destination &&
+					out.Data == data && sameHeader(out.Header, header)
+			},
+			gen.UInt8(),
+			genMsgType(),
+			gen.AnyString(),
+			gen.AnyString(),
+			genHeader(),
+		))
+
+		properties.Property("ClientOutput with object data survives "+c.Name(), prop.ForAll(
+			func(data map[string]string) bool {
+				msg, err := types.ClientOutput{Data: data}.MarshalWith(c)
+				if err != nil {
+					return false
+				}
+				var out types.ClientOutput
+				if err := out.UnmarshalWith(c, msg); err != nil {
+					return false
+				}
+				got, ok := out.Data.(map[string]interface{})
+				return ok && reflect.DeepEqual(got, asAny(data))
+			},
+			gen.MapOf(gen.AlphaString(), gen.AnyString()),
+		))
+	}
+
+	properties.TestingRun(t)
+}
+
+func TestClientInputRoundTripProperties(t *testing.T) {
+	properties := gopter.NewProperties(gopter.DefaultTestParameters())
+
+	for _, c := range codec.All {
+		c := c
+		properties.Property("RPC survives "+c.Name(), prop.ForAll(
+			func(reqId uint8, class, method string, params, header map[string]string) bool {
+				conn := &types.WebSocketConnection{Codec: c}
+				in := rpc.ClientInputRPC{ReqId: &reqId, Class: class, Method: method, Params: asAny(params), Header: header, WSConn: conn}
+				msg, err := in.Marshal()
+				if err != nil {
+					return false
+				}
+				out := rpc.ClientInputRPC{WSConn: conn}
+				if err := out.Unmarshal(msg); err != nil {
+					return false
+				}
+				return *out.ReqId == reqId && out.Class == class && out.Method == method &&
+					reflect.DeepEqual(out.Params, asAny(params)) && sameHeader(out.Header, header)
+			},
+			gen.UInt8(),
+			gen.AnyString(),
+			gen.AlphaString().SuchThat(func(s string) bool { return len(s) <= 0xFF }),
+			gen.MapOf(gen.AlphaString(), gen.AnyString()),
+			genHeader(),
+		))
+	}
+
+	properties.Property("Endpoint survives", prop.ForAll(
+		func(reqId uint8, method rest.RESTMethod, endpoint, data string, header map[string]string) bool {
+			in := rest.ClientInputRest{ReqId: &reqId, Method: method, Endpoint: endpoint, Data: data, Header: header}
+			msg, err := in.Marshal()
+			if err != nil {
+				return false
+			}
+			var out rest.ClientInputRest
+			if err := out.Unmarshal(msg); err != nil {
+				return false
+			}
+			return *out.ReqId == reqId && out.Method == method && out.Endpoint == endpoint &&
+				out.Data == data && sameHeader(out.Header, header)
+		},
+		gen.UInt8(),
+		genMethod(),
+		gen.AnyString(),
+		gen.AnyString(),
+		genHeader(),
+	))
+
+	properties.Property("Subscribe and unsubscribe survive", prop.ForAll(
+		func(reqId uint8, topic, data string, header map[string]string) bool {
+			in := subscribe.ClientInputSubscription{ReqId: &reqId, Topic: topic, Data: data, Header: header}
+			for connType, marshal := range map[types.WSConnType]func() ([]byte, error){
+				types.WSConnSubscribe:   in.Marshal,
+				types.WSConnUnsubscribe: in.MarshalUnsubscribe,
+			} {
+				msg, err := marshal()
+				if err != nil || types.WSConnType(msg[0]) != connType {
+					return false
+				}
+				var out subscribe.ClientInputSubscription
+				if err := out.Unmarshal(msg); err != nil {
+					return false
+				}
+				if *out.ReqId != reqId || out.Topic != topic || out.Data != data || !sameHeader(out.Header, header) {
+					return false
+				}
+			}
+			return true
+		},
+		gen.UInt8(),
+		gen.AnyString(),
+		gen.AnyString(),
+		genHeader(),
+	))
+
+	properties.TestingRun(t)
+}
+
+// Fields over their length prefix are rejected instead of truncated
+func TestMarshalLimits(t *testing.T) {
+	long := string(make([]byte, 0x10000))
+
+	if _, err := (types.ClientOutput{Destination: long}).Marshal(); err == nil {
+		t.Fatal("expected a 64 KB destination to fail")
+	}
+	if _, err := (rpc.ClientInputRPC{Class: long}).Marshal(); err == nil {
+		t.Fatal("expected a 64 KB bff to fail")
+	}
+	if _, err := (rpc.ClientInputRPC{Method: long[:0x100]}).Marshal(); err == nil {
+		t.Fatal("expected a 256 byte method to fail")
+	}
+	if _, err := (rest.ClientInputRest{Endpoint: long}).Marshal(); err == nil {
+		t.Fatal("expected a 64 KB endpoint to fail")
+	}
+	if _, err := (subscribe.ClientInputSubscription{Topic: long}).Marshal(); err == nil {
+		t.Fatal("expected a 64 KB topic to fail")
+	}
+}
@@ -0,0 +1,24 @@
+import WebSocketUtil from "./../../web/lib/WebSocketUtil.js";
+
+// The connection the generated module imports from ./lib/ws/conn.js,
+// to the server at WS_URL
+export const wsconn = new WebSocketUtil(process.env.WS_URL);
+
+export const opened = new Promise((resolve, reject) => {
+    wsconn.onopen = resolve;
+    wsconn.onrefused = reject;
+});
+
+// settled waits for the answers of every request sent so far, the
+// setters do not return theirs
+const pending = [];
+const requestRPC = wsconn.requestRPC.bind(wsconn);
+wsconn.requestRPC = (...args) => {
+    const answer = requestRPC(...args);
+    pending.push(answer.catch(() => {}));
+    return answer;
+};
+
+export function settled() {
+    return Promise.all(pending.splice(0));
+}
@@ -0,0 +1,134 @@
+package generator
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/milton-alvarenga/goreactivehtml/examples/signup/business"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// generate runs goBusiness2JS on the business file and writes the module
+// to a directory where it finds ./lib/ws/conn.js
+func generate(t *testing.T, business string) string {
+	t.Helper()
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("go", "run", "../../cmd/goBusiness2JS", business)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("goBusiness2JS: %v\n%s", err, stderr.String())
+	}
+
+	dir := t.TempDir()
+	conn, err := filepath.Abs("conn.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "lib", "ws"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lib", "ws", "conn.js"), []byte("export * from "+jsString(conn)+";\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	module := filepath.Join(dir, "business.js")
+	if err := os.WriteFile(module, stdout.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return module
+}
+
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// drive runs node.js with the module against a server, v receives its
+// output
+func drive(t *testing.T, module string, v any) {
+	t.Helper()
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	// Browsers cannot set the header, the token would come from a cookie
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer token")
+		handle.WS(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("node", "--experimental-websocket", "node.js")
+	cmd.Env = append(os.Environ(), "WS_URL=ws"+strings.TrimPrefix(srv.URL, "http"), "MODULE="+module)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("node: %v\n%s", err, stderr.String())
+	}
+	// The generated module logs the rejected writes, the result comes last
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), v); err != nil {
+		t.Fatalf("node output %q: %v", stdout.String(), err)
+	}
+}
+
+//
+// --- Tests ---
+//
+
+func TestGeneratedSignup(t *testing.T) {
+	module := generate(t, "../../examples/signup/business/index.go")
+
+	var out struct {
+		Match     any
+		State     map[string]any
+		Getters   map[string]any
+		Rewritten string
+	}
+	drive(t, module, &out)
+
+	// The writes reached the signup scope, PasswordsMatch was computed there
+	if out.Match != false {
+		t.Fatalf("expected PasswordsMatch false after the mismatching writes, got %v", out.Match)
+	}
+
+	// SubmitSignup ran on the same scope and its changes were pushed, with
+	// PasswordsMatch flipped by the cleared passwords
+	if got, _ := out.State["SuccessMsg"].(string); !strings.HasPrefix(got, "Check your inbox") || out.State["Password"] != "" || out.State["PasswordsMatch"] != true {
+		t.Fatalf("expected the submit pushed, got %v", out.State)
+	}
+
+	// The getters show the pushed values
+	if out.Getters["SuccessMsg"] != out.State["SuccessMsg"] || out.Getters["Password"] != "" || out.Getters["PasswordsMatch"] != true {
+		t.Fatalf("getters not bound to the connection state: %v", out.Getters)
+	}
+	// A stale version would have been a conflict showing the cleared value
+	if out.Rewritten != "again" {
+		t.Fatalf("expected the write after the push accepted, got %q", out.Rewritten)
+	}
+}
+
+func TestComputedIsReadOnly(t *testing.T) {
+	module, err := os.ReadFile(generate(t, "../../examples/signup/business/index.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(module), "export function PasswordsMatch() {") || strings.Contains(string(module), "sync('PasswordsMatch'") {
+		t.Fatalf("expected a getter only for PasswordsMatch:\n%s", module)
+	}
+	if !strings.Contains(string(module), "sync('Password', value)") {
+		t.Fatalf("expected a setter for Password:\n%s", module)
+	}
+}
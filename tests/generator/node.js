@@ -0,0 +1,33 @@
+import { wsconn, opened, settled } from "./conn.js";
+
+// MODULE: the file generated by goBusiness2JS for examples/signup
+// STDOUT: the signup state pushed by the server and what the getters read
+try {
+    const signup = await import(process.env.MODULE);
+    await opened;
+
+    signup.Password("secret");
+    signup.ConfirmPassword("typo");
+    await settled();
+
+    // Viewing the business pushes every value, PasswordsMatch computed
+    // from the writes
+    await wsconn.view("signup");
+    const match = signup.PasswordsMatch();
+
+    await signup.SubmitSignup("ana@example.com", "secret", "typo");
+    const state = { ...wsconn.state.signup };
+    const getters = { SuccessMsg: signup.SuccessMsg(), Password: signup.Password(), PasswordsMatch: signup.PasswordsMatch() };
+
+    // The server cleared the password, the next write goes from the
+    // version it pushed
+    signup.Password("again");
+    await settled();
+    const rewritten = signup.Password();
+
+    process.stdout.write(JSON.stringify({ match, state, getters, rewritten }) + "\n");
+    process.exit(0);
+} catch (err) {
+    console.error("Error driving the generated module:", err);
+    process.exit(1);
+}
@@ -0,0 +1,261 @@
+package hello
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/hello"
+)
+
+//
+// --- Test Helpers ---
+//
+
+const everything = types.CapBitProtocol | types.CapByteProtocol | types.CapPathProtocol
+
+func frame(t *testing.T, in hello.ClientInputHello) []byte {
+	t.Helper()
+
+	msg, err := in.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// connect opens a connection to handle.WS offering the codecs
+func connect(t *testing.T, codecs ...string) *websocket.Conn {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	t.Cleanup(srv.Close)
+
+	dialer := websocket.Dialer{}
+	for _, name := range codecs {
+		dialer.Subprotocols = append(dialer.Subprotocols, codec.SubprotocolPrefix+name)
+	}
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	c, _, err := dialer.Dial(url, http.Header{"Authorization": {"Bearer token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func read(t *testing.T, c *websocket.Conn, payloads codec.Codec) types.ClientOutput {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := c.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output types.ClientOutput
+	if err := output.UnmarshalWith(payloads, msg); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+//
+// --- Tests ---
+//
+
+func TestHelloRoundTrip(t *testing.T) {
+	reqId := uint8(9)
+	in := hello.ClientInputHello{ReqId: &reqId, Version: 1, ReqIdSize: 1, Capabilities: everything, ArrayVersions: []protocol.Version{1, 2}}
+
+	var out hello.ClientInputHello
+	if err := out.Unmarshal(frame(t, in)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+
+	msg := frame(t, in)
+	for _, bad := range [][]byte{msg[:5], msg[:len(msg)-1], append(msg, 0)} {
+		if err := out.Unmarshal(bad); err == nil {
+			t.Fatalf("expected %v to fail", bad)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		name     string
+		in       hello.ClientInputHello
+		server   types.Capability
+		array    types.Capability
+		version  protocol.Version
+		encoder  diff.Encoder
+		refusing string
+	}{
+		{"bit V2", hello.ClientInputHello{Version: 1, ReqIdSize: 1, Capabilities: everything, ArrayVersions: []protocol.Version{1, 2}}, types.ServerCapabilities, types.CapBitProtocol, protocol.V2, diff.BitV2, ""},
+		{"bit V1 when no version is offered", hello.ClientInputHello{Version: 1, ReqIdSize: 1, Capabilities: types.CapBitProtocol}, types.ServerCapabilities, types.CapBitProtocol, protocol.V1, diff.Bit, ""},
+		{"byte only client", hello.ClientInputHello{Version: 1, ReqIdSize: 1, Capabilities: types.CapByteProtocol}, types.ServerCapabilities, types.CapByteProtocol, 0, diff.Byte, ""},
+		{"byte only server", hello.ClientInputHello{Version: 1, ReqIdSize: 1, Capabilities: everything}, types.CapByteProtocol, types.CapByteProtocol, 0, diff.Byte, ""},
+		{"path only", hello.ClientInputHello{Version: 1, ReqIdSize: 1, Capabilities: types.CapPathProtocol}, types.ServerCapabilities, 0, 0, nil, ""},
+		{"deflate alone", hello.ClientInputHello{Version: 1, ReqIdSize: 1, Capabilities: types.CapDeflate}, types.ServerCapabilities, 0, 0, nil, "no common encoding"},
+		{"wide request ids", hello.ClientInputHello{Version: 1, ReqIdSize: 2, Capabilities: everything}, types.ServerCapabilities, 0, 0, nil, "request ids of 2 bytes"},
+		{"newer handshake", hello.ClientInputHello{Version: 2, ReqIdSize: 1, Capabilities: everything}, types.ServerCapabilities, 0, 0, nil, "hello version 2"},
+	}
+
+	for _, c := range cases {
+		session, err := c.in.Negotiate(c.server)
+		if c.refusing != "" {
+			if err == nil || !strings.Contains(err.Error(), c.refusing) {
+				t.Fatalf("%s: expected %q, got %v", c.name, c.refusing, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if session.Array != c.array || session.ArrayVersion != c.version || session.ArrayEncoder() != c.encoder {
+			t.Fatalf("%s: unexpected session %+v", c.name, session)
+		}
+		if session.Capabilities != c.in.Capabilities&c.server {
+			t.Fatalf("%s: expected capabilities %s, got %s", c.name, c.in.Capabilities&c.server, session.Capabilities)
+		}
+	}
+}
+
+// Connections without a HELLO keep what clients understood before it
+func TestDefaultSession(t *testing.T) {
+	var wsc *types.WebSocketConnection
+	if wsc.Negotiated() != types.DefaultSession || types.DefaultSession.ArrayEncoder() != diff.Bit {
+		t.Fatalf("unexpected default session %+v", wsc.Negotiated())
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	c := connect(t, "cbor")
+
+	reqId := uint8(1)
+	msg := frame(t, hello.ClientInputHello{ReqId: &reqId, Version: 1, ReqIdSize: 1, Capabilities: everything | types.CapDeflate, ArrayVersions: protocol.Supported})
+	if err := c.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	output := read(t, c, codec.CBOR)
+	if output.ReqId != 1 || output.MsgType != types.WSTypeSuccessOutputMessage || output.Destination != hello.Destination {
+		t.Fatalf("unexpected welcome %+v", output)
+	}
+	data, err := codec.CBOR.Marshal(output.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var welcome hello.Welcome
+	if err := codec.CBOR.Unmarshal(data, &welcome); err != nil {
+		t.Fatal(err)
+	}
+	want := hello.Welcome{Version: 1, Capabilities: everything | types.CapDeflate, Array: "bit", ArrayVersion: protocol.V2, ReqIdSize: 1, Codec: "cbor"}
+	if welcome != want {
+		t.Fatalf("expected %+v, got %+v", want, welcome)
+	}
+
+	// A second HELLO is an error, the connection stays open
+	reqId = 2
+	if err := c.WriteMessage(websocket.BinaryMessage, frame(t, hello.ClientInputHello{ReqId: &reqId, Version: 1, ReqIdSize: 1, Capabilities: everything})); err != nil {
+		t.Fatal(err)
+	}
+	if output := read(t, c, codec.CBOR); output.ReqId != 2 || output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected an error, got %+v", output)
+	}
+}
+
+func TestHandshakeRefused(t *testing.T) {
+	c := connect(t)
+
+	msg := frame(t, hello.ClientInputHello{Version: 1, ReqIdSize: 4, Capabilities: everything})
+	if err := c.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := c.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseProtocolError || !strings.Contains(closeErr.Text, "request ids of 4 bytes") {
+		t.Fatalf("expected a protocol error close with the reason, got %v", err)
+	}
+}
+
+// The text transport speaks the same protocol in JSON envelopes
+func TestTextHandshake(t *testing.T) {
+	c := connect(t, "text")
+	if c.Subprotocol() != types.TextSubprotocol {
+		t.Fatalf("expected the text subprotocol, got %q", c.Subprotocol())
+	}
+
+	write := func(text string) {
+		if err := c.WriteMessage(websocket.TextMessage, []byte(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readText := func() types.ClientOutput {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		msgType, msg, err := c.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msgType != websocket.TextMessage {
+			t.Fatalf("expected a text frame, got %d", msgType)
+		}
+		var output types.ClientOutput
+		if err := output.UnmarshalEnvelope(msg); err != nil {
+			t.Fatal(err)
+		}
+		return output
+	}
+
+	write(`{"type":"hello","reqId":1,"version":1,"reqIdSize":1,"capabilities":7,"arrayVersions":[1,2]}`)
+	output := readText()
+	welcome, ok := output.Data.(map[string]any)
+	if output.ReqId != 1 || output.MsgType != types.WSTypeSuccessOutputMessage || output.Destination != hello.Destination || !ok {
+		t.Fatalf("unexpected welcome %+v", output)
+	}
+	if welcome["array"] != "bit" || welcome["codec"] != "json" {
+		t.Fatalf("unexpected welcome %+v", welcome)
+	}
+
+	// A bad envelope is answered on its request id
+	write(`{"type":"subscribe","reqId":2}`)
+	if output := readText(); output.ReqId != 2 || output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected an error, got %+v", output)
+	}
+
+	// Binary frames are not read on a text connection
+	reqId := uint8(3)
+	if err := c.WriteMessage(websocket.BinaryMessage, frame(t, hello.ClientInputHello{ReqId: &reqId, Version: 1, ReqIdSize: 1, Capabilities: everything})); err != nil {
+		t.Fatal(err)
+	}
+	if output := readText(); output.ReqId != 3 || output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected an error, got %+v", output)
+	}
+}
+
+// Text frames are refused on binary connections
+func TestTextFrameOnBinaryConnection(t *testing.T) {
+	c := connect(t)
+
+	if err := c.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello","reqId":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if output := read(t, c, codec.JSON); output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected an error, got %+v", output)
+	}
+}
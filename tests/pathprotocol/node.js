@@ -0,0 +1,34 @@
+import { createPathDecoder } from "./../../web/lib/PathDecodeProtocol.js";
+import fs from 'fs';
+
+// STDIN: 4-byte length + initial state JSON, then every message as
+// 4-byte length + bytes, all applied with the same decoder
+// ARRAY: array protocol of the ARRAY operations, "bit" when unset
+// STDOUT: {"state": ..., "errors": count of messages that threw}
+try {
+    const raw = fs.readFileSync(0);
+    let offset = 0;
+
+    const initialLen = raw.readUInt32BE(offset);
+    offset += 4;
+    let state = JSON.parse(raw.slice(offset, offset + initialLen).toString());
+    offset += initialLen;
+
+    const decoder = createPathDecoder(false, 1, undefined, process.env.ARRAY || "bit");
+    let errors = 0;
+    while (offset < raw.length) {
+        const len = raw.readUInt32BE(offset);
+        offset += 4;
+        try {
+            state = decoder.apply(new Uint8Array(raw.slice(offset, offset + len)), state);
+        } catch (err) {
+            errors++;
+        }
+        offset += len;
+    }
+
+    process.stdout.write(JSON.stringify({ state, errors }) + "\n");
+} catch (err) {
+    console.error("Error processing input:", err);
+    process.exit(1);
+}
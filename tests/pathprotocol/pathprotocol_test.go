@@ -0,0 +1,393 @@
+package pathprotocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// --- Test Helpers ---
+//
+
+func mustPath(t *testing.T, s string) pathprotocol.Path {
+	t.Helper()
+	p, err := pathprotocol.ParsePath(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func encoded(t *testing.T) func([]byte, error) []byte {
+	return func(data []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+func state(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func asJSON(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// applyGo runs every message through one decoder
+func applyGo(t *testing.T, initial string, msgs [][]byte) string {
+	t.Helper()
+	dec := pathprotocol.NewDecoder()
+	root := state(t, initial)
+	for _, msg := range msgs {
+		var err error
+		if root, err = dec.DecodeApply(msg, root); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return asJSON(t, root)
+}
+
+// applyNode runs every message through one JS decoder, env is added to
+// the environment of node
+func applyNode(t *testing.T, initial string, msgs [][]byte, env ...string) string {
+	t.Helper()
+	got, errors := applyNodeErrors(t, initial, msgs, env...)
+	if errors > 0 {
+		t.Fatalf("node: %d messages failed", errors)
+	}
+	return got
+}
+
+// applyNodeErrors keeps going when a message fails and counts the failures
+func applyNodeErrors(t *testing.T, initial string, msgs [][]byte, env ...string) (string, int) {
+	t.Helper()
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node is not installed")
+	}
+
+	stdin := &bytes.Buffer{}
+	binary.Write(stdin, binary.BigEndian, uint32(len(initial)))
+	stdin.WriteString(initial)
+	for _, msg := range msgs {
+		binary.Write(stdin, binary.BigEndian, uint32(len(msg)))
+		stdin.Write(msg)
+	}
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.Command("node", "node.js")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	cmd.Env = append(os.Environ(), env...)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("node: %v\n%s", err, stderr.String())
+	}
+
+	var out struct {
+		State  json.RawMessage
+		Errors int
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return asJSON(t, state(t, string(out.State))), out.Errors
+}
+
+//
+// --- Tests ---
+//
+
+func TestParsePath(t *testing.T) {
+	p := mustPath(t, "Tasks[3].Tags[1]")
+	want := pathprotocol.Path{pathprotocol.Key("Tasks"), pathprotocol.Index(3), pathprotocol.Key("Tags"), pathprotocol.Index(1)}
+	if asJSON(t, p) != asJSON(t, want) {
+		t.Fatalf("expected %v, got %v", want, p)
+	}
+	if p.String() != "Tasks[3].Tags[1]" {
+		t.Fatalf("unexpected string %q", p.String())
+	}
+
+	for _, bad := range []string{"Tasks[", "Tasks[x]", ".Tasks", "Tasks.", "a..b", "Tasks[-1]", "Tasks[1]Tags"} {
+		if _, err := pathprotocol.ParsePath(bad); err == nil {
+			t.Fatalf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestKeysInterned(t *testing.T) {
+	enc := pathprotocol.NewEncoder()
+	b := encoded(t)
+
+	first := b(enc.EncodeSet(mustPath(t, "Form.Email"), []byte(`"a@b.c"`)))
+	second := b(enc.EncodeSet(mustPath(t, "Form.Email"), []byte(`"a@b.c"`)))
+	if len(second) != len(first)-len("Form")-len("Email") {
+		t.Fatalf("expected the keys to be sent once, got %d then %d bytes", len(first), len(second))
+	}
+
+	dec := pathprotocol.NewDecoder()
+	for _, msg := range [][]byte{first, second} {
+		op, err := dec.Decode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if op.Path.String() != "Form.Email" || string(op.Data) != `"a@b.c"` {
+			t.Fatalf("unexpected operation %+v", op)
+		}
+	}
+
+	// a decoder that missed the first message cannot resolve the ids
+	if _, err := pathprotocol.NewDecoder().Decode(second); err == nil {
+		t.Fatal("expected unknown key id error")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"empty":          {},
+		"unknown op":     {7, 0},
+		"missing path":   {0},
+		"short key":      {0, 1, 5<<2 | 0b11, 'a'},
+		"delete no path": {1, 0},
+		"delete body":    {1, 1, 0, '1'},
+	}
+	for name, msg := range cases {
+		if _, err := pathprotocol.NewDecoder().Decode(msg); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+type pathCase struct {
+	name    string
+	initial string
+	msgs    func(enc *pathprotocol.Encoder) [][]byte
+	want    string
+}
+
+func pathCases(t *testing.T) []pathCase {
+	b := encoded(t)
+	arr := protocol.Encoder{}
+
+	return []pathCase{
+		{"set field", `{"Form":{"Email":"","Name":"x"}}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeSet(mustPath(t, "Form.Email"), []byte(`"a@b.c"`)))}
+		}, `{"Form":{"Email":"a@b.c","Name":"x"}}`},
+
+		{"set creates containers", `{}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeSet(mustPath(t, "Tasks[1].Tags[0]"), []byte(`"urgent"`)))}
+		}, `{"Tasks":[null,{"Tags":["urgent"]}]}`},
+
+		{"set root", `{"a":1}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeSet(nil, []byte(`{"b":2}`)))}
+		}, `{"b":2}`},
+
+		{"delete field", `{"Form":{"Email":"x","Name":"y"}}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeDelete(mustPath(t, "Form.Email")))}
+		}, `{"Form":{"Name":"y"}}`},
+
+		{"delete element", `{"Tasks":[{"Tags":["a","b","c"]}]}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeDelete(mustPath(t, "Tasks[0].Tags[1]")))}
+		}, `{"Tasks":[{"Tags":["a","c"]}]}`},
+
+		{"delete missing", `{"Tasks":[]}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeDelete(mustPath(t, "Tasks[4]")))}
+		}, `{"Tasks":[]}`},
+
+		{"merge", `{"Tasks":[{"Id":1,"Done":false}]}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeMerge(mustPath(t, "Tasks[0]"), []byte(`{"Done":true}`)))}
+		}, `{"Tasks":[{"Done":true,"Id":1}]}`},
+
+		{"nested array insert", `{"Tasks":[{"Tags":["a"]}]}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeArray(mustPath(t, "Tasks[0].Tags"), b(arr.EncodeInsertRange(1, 2, [][]byte{[]byte(`"b"`), []byte(`"c"`)}))))}
+		}, `{"Tasks":[{"Tags":["a","b","c"]}]}`},
+
+		{"nested array move", `{"Tasks":[{"Tags":["a","b","c"]}]}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{b(enc.EncodeArray(mustPath(t, "Tasks[0].Tags"), b(arr.EncodeMove(2, 0))))}
+		}, `{"Tasks":[{"Tags":["c","a","b"]}]}`},
+
+		{"interned keys across messages", `{}`, func(enc *pathprotocol.Encoder) [][]byte {
+			return [][]byte{
+				b(enc.EncodeSet(mustPath(t, "Tasks"), []byte(`[]`))),
+				b(enc.EncodeArray(mustPath(t, "Tasks"), b(arr.EncodeInsert(0, []byte(`{"Id":1,"Tags":[]}`))))),
+				b(enc.EncodeSet(mustPath(t, "Tasks[0].Tags[0]"), []byte(`"x"`))),
+				b(enc.EncodeMerge(mustPath(t, "Tasks[0]"), []byte(`{"Done":true}`))),
+				b(enc.EncodeDelete(mustPath(t, "Tasks[0].Id"))),
+			}
+		}, `{"Tasks":[{"Done":true,"Tags":["x"]}]}`},
+	}
+}
+
+func TestApplyGo(t *testing.T) {
+	for _, c := range pathCases(t) {
+		if got := applyGo(t, c.initial, c.msgs(pathprotocol.NewEncoder())); got != asJSON(t, state(t, c.want)) {
+			t.Fatalf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestApplyNode(t *testing.T) {
+	for _, c := range pathCases(t) {
+		if got := applyNode(t, c.initial, c.msgs(pathprotocol.NewEncoder())); got != asJSON(t, state(t, c.want)) {
+			t.Fatalf("%s: expected %s, got %s", c.name, c.want, got)
+		}
+	}
+}
+
+// an index past the end grows the array up to protocol.MaxGrowth
+func TestIndexGrowthBounded(t *testing.T) {
+	b := encoded(t)
+	enc := pathprotocol.NewEncoder()
+	dec := pathprotocol.NewDecoder()
+
+	root, err := dec.DecodeApply(b(enc.EncodeSet(mustPath(t, fmt.Sprintf("Tasks[%d]", protocol.MaxGrowth-1)), []byte(`1`))), state(t, `{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tasks := root.(map[string]any)["Tasks"].([]any); len(tasks) != protocol.MaxGrowth {
+		t.Fatalf("expected %d elements, got %d", protocol.MaxGrowth, len(tasks))
+	}
+
+	for _, index := range []uint32{2 * protocol.MaxGrowth, 1<<32 - 1} {
+		msg := b(enc.EncodeSet(mustPath(t, fmt.Sprintf("Tasks[%d]", index)), []byte(`1`)))
+		if _, err := dec.DecodeApply(msg, root); err == nil {
+			t.Fatalf("index %d: expected error", index)
+		}
+	}
+}
+
+// a batch whose last operation fails: the transactional one leaves the
+// state as it was, the other one keeps the operations before the failure
+func failingBatch(t *testing.T, enc *pathprotocol.Encoder, transactional bool) [][]byte {
+	b := encoded(t)
+	return [][]byte{
+		b(enc.Batch(transactional).
+			Set(mustPath(t, "Total"), []byte(`2`)).
+			Merge(mustPath(t, "Form"), []byte(`{"Email":`)).
+			Encode()),
+		b(enc.EncodeSet(mustPath(t, "Form.Name"), []byte(`"x"`))),
+	}
+}
+
+func TestBatchAtomic(t *testing.T) {
+	for _, transactional := range []bool{true, false} {
+		want := `{"Form":{"Name":"x"},"Total":1}`
+		if !transactional {
+			want = `{"Form":{"Name":"x"},"Total":2}`
+		}
+
+		dec := pathprotocol.NewDecoder()
+		root := state(t, `{"Form":{},"Total":1}`)
+		msgs := failingBatch(t, pathprotocol.NewEncoder(), transactional)
+		var err error
+		if root, err = dec.DecodeApply(msgs[0], root); err == nil {
+			t.Fatal("expected the batch to fail")
+		}
+		// the keys of the failed batch stay interned on both sides
+		if root, err = dec.DecodeApply(msgs[1], root); err != nil {
+			t.Fatal(err)
+		}
+		if got := asJSON(t, root); got != want {
+			t.Fatalf("transactional %v: expected %s, got %s", transactional, want, got)
+		}
+
+		got, errors := applyNodeErrors(t, `{"Form":{},"Total":1}`, failingBatch(t, pathprotocol.NewEncoder(), transactional))
+		if errors != 1 || got != want {
+			t.Fatalf("node, transactional %v: expected %s and 1 error, got %s and %d", transactional, want, got, errors)
+		}
+	}
+}
+
+func TestBatchBuilderErrors(t *testing.T) {
+	enc := pathprotocol.NewEncoder()
+	if _, err := enc.Batch(true).Encode(); err == nil {
+		t.Fatal("expected empty batch error")
+	}
+	if _, err := enc.Batch(true).Set(mustPath(t, "A"), []byte(`1`)).Delete(nil).Encode(); err == nil {
+		t.Fatal("expected delete error")
+	}
+
+	// a batch that is never encoded interns nothing
+	enc.Batch(true).Set(mustPath(t, "A"), []byte(`1`))
+	msg := encoded(t)(enc.EncodeSet(mustPath(t, "A"), []byte(`1`)))
+	if _, err := pathprotocol.NewDecoder().Decode(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxKeys(t *testing.T) {
+	enc := pathprotocol.NewEncoder()
+	dec := pathprotocol.NewDecoder()
+	b := encoded(t)
+
+	var root any = map[string]any{}
+	for i := 0; i <= pathprotocol.MaxKeys; i++ {
+		key := pathprotocol.Path{pathprotocol.Key(string(rune('a'+i%26)) + string(rune(i)))}
+		var err error
+		if root, err = dec.DecodeApply(b(enc.EncodeSet(key, []byte(`1`))), root); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// past the limit keys keep working, sent in full every time
+	last := pathprotocol.Path{pathprotocol.Key("over")}
+	first := b(enc.EncodeSet(last, []byte(`1`)))
+	second := b(enc.EncodeSet(last, []byte(`1`)))
+	if len(first) != len(second) {
+		t.Fatalf("expected keys past the limit not to be interned")
+	}
+	for _, msg := range [][]byte{first, second} {
+		if _, err := dec.Decode(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestArrayVersion(t *testing.T) {
+	b := encoded(t)
+	arr := protocol.Encoder{Version: protocol.V2}
+	msg := b(pathprotocol.NewEncoder().EncodeArray(mustPath(t, "Tags"), b(arr.EncodeMove(0, 0x1000000))))
+
+	dec := pathprotocol.NewDecoder()
+	dec.ArrayVersion = protocol.V2
+	root, err := dec.DecodeApply(msg, state(t, `{"Tags":["a","b"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := asJSON(t, root); got != `{"Tags":["b","a"]}` {
+		t.Fatalf("unexpected state %s", got)
+	}
+
+	if _, err := pathprotocol.NewDecoder().DecodeApply(msg, state(t, `{"Tags":["a","b"]}`)); err == nil {
+		t.Fatal("expected a V1 decoder to fail")
+	}
+}
+
+func TestByteArrayNode(t *testing.T) {
+	b := encoded(t)
+	arr := byteprotocol.Encoder{}
+	msg := b(pathprotocol.NewEncoder().EncodeArray(mustPath(t, "Tags"), b(arr.EncodeInsert(1, []byte(`"c"`)))))
+
+	if got := applyNode(t, `{"Tags":["a","b"]}`, [][]byte{msg}, "ARRAY=byte"); got != `{"Tags":["a","c","b"]}` {
+		t.Fatalf("unexpected state %s", got)
+	}
+}
@@ -0,0 +1,164 @@
+package state
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+)
+
+var (
+	password        = state.NewSignal("account", "Password", "")
+	confirmPassword = state.NewSignal("account", "ConfirmPassword", "")
+	matchRuns       atomic.Int32
+	passwordsMatch  = state.NewComputed("account", "PasswordsMatch", func(s *state.Scope) bool {
+		matchRuns.Add(1)
+		return password.Get(s) == confirmPassword.Get(s)
+	})
+	canSubmit = state.NewComputed("account", "CanSubmit", func(s *state.Scope) bool {
+		return passwordsMatch.Get(s) && password.Get(s) != ""
+	})
+
+	writer = state.NewComputed("account", "Writer", func(s *state.Scope) bool {
+		password.Set(s, "x")
+		return true
+	})
+
+	sharedTodo = state.NewSlice("stats", "Tasks", func(t task) any { return t.Id }).Shared()
+	openTasks  = state.NewComputed("stats", "Open", func(s *state.Scope) int {
+		n := 0
+		for _, t := range sharedTodo.Get(s) {
+			if !t.Done {
+				n++
+			}
+		}
+		return n
+	})
+)
+
+// pushed flushes the scope and returns the paths of the values sent, dec
+// is the decoder of the client of the scope
+func pushed(t *testing.T, s *state.Scope, dec *pathprotocol.Decoder) []string {
+	t.Helper()
+
+	var paths []string
+	err := s.Flush(diff.Bit, codec.JSON, func(msg []byte) error {
+		op, err := dec.Decode(msg)
+		if err != nil {
+			return err
+		}
+		for _, sub := range op.Ops {
+			if sub.Path[0] == pathprotocol.Key("$$version") {
+				continue
+			}
+			paths = append(paths, sub.Path.String())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestComputedIsLazy(t *testing.T) {
+	s := state.NewScope()
+	start := matchRuns.Load()
+
+	if !passwordsMatch.Get(s) || !passwordsMatch.Get(s) {
+		t.Fatal("empty passwords match")
+	}
+	if runs := matchRuns.Load() - start; runs != 1 {
+		t.Fatalf("expected 1 run, got %d", runs)
+	}
+
+	// Values it does not read do not run it again
+	email.Set(s, "a@example.com")
+	passwordsMatch.Get(s)
+	if runs := matchRuns.Load() - start; runs != 1 {
+		t.Fatalf("expected 1 run, got %d", runs)
+	}
+
+	password.Set(s, "secret")
+	if passwordsMatch.Get(s) || canSubmit.Get(s) {
+		t.Fatal("different passwords match")
+	}
+	confirmPassword.Set(s, "secret")
+	if !passwordsMatch.Get(s) || !canSubmit.Get(s) {
+		t.Fatal("same passwords do not match")
+	}
+	if runs := matchRuns.Load() - start; runs != 3 {
+		t.Fatalf("expected 3 runs, got %d", runs)
+	}
+}
+
+func TestComputedIsPushedWhenItChanges(t *testing.T) {
+	s := state.NewScope()
+	dec := pathprotocol.NewDecoder()
+	pushedPaths := func(t *testing.T, s *state.Scope) []string { return pushed(t, s, dec) }
+
+	// Not pushed before the client has it
+	password.Set(s, "a")
+	if paths := pushedPaths(t, s); len(paths) != 1 || paths[0] != "account.Password" {
+		t.Fatalf("unexpected push %v", paths)
+	}
+
+	s.MarkDirty(passwordsMatch)
+	if paths := pushedPaths(t, s); len(paths) != 1 || paths[0] != "account.PasswordsMatch" {
+		t.Fatalf("unexpected push %v", paths)
+	}
+
+	// Still false, only the password is pushed
+	password.Set(s, "b")
+	if paths := pushedPaths(t, s); len(paths) != 1 || paths[0] != "account.Password" {
+		t.Fatalf("unexpected push %v", paths)
+	}
+
+	confirmPassword.Set(s, "b")
+	paths := pushedPaths(t, s)
+	if len(paths) != 2 || paths[0] != "account.ConfirmPassword" || paths[1] != "account.PasswordsMatch" {
+		t.Fatalf("unexpected push %v", paths)
+	}
+}
+
+// A computed value of a shared one follows the writes of other scopes
+func TestComputedOfSharedValue(t *testing.T) {
+	a, b := state.NewScope(), state.NewScope()
+	dec := pathprotocol.NewDecoder()
+	pushedPaths := func(t *testing.T, s *state.Scope) []string { return pushed(t, s, dec) }
+
+	sharedTodo.Set(a, []task{{Id: 1}, {Id: 2, Done: true}})
+	if n := openTasks.Get(b); n != 1 {
+		t.Fatalf("expected 1 open task, got %d", n)
+	}
+	sharedTodo.Append(a, task{Id: 3})
+	if n := openTasks.Get(b); n != 2 {
+		t.Fatalf("expected 2 open tasks, got %d", n)
+	}
+
+	// A viewer marked by the broadcast pushes the new count
+	pushedPaths(t, b)
+	b.MarkDirty(openTasks)
+	pushedPaths(t, b)
+	sharedTodo.Append(a, task{Id: 4})
+	b.MarkDirty(sharedTodo)
+	paths := pushedPaths(t, b)
+	if len(paths) != 2 || paths[1] != "stats.Open" {
+		t.Fatalf("unexpected push %v", paths)
+	}
+}
+
+func TestComputedIsReadOnly(t *testing.T) {
+	if _, err := state.NewScope().Sync("account", map[string]any{"PasswordsMatch": true}, nil); err == nil {
+		t.Fatal("a computed value was synced")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("a computed value wrote")
+		}
+	}()
+	writer.Get(state.NewScope())
+}
@@ -0,0 +1,194 @@
+package state
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state/crdt"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+)
+
+var (
+	notes    = state.NewText("wiki", "Notes").Shared()
+	tags     = state.NewList[string]("wiki", "Tags")
+	settings = state.NewMap[int]("wiki", "Settings")
+	noteLen  = state.NewComputed("wiki", "NoteLength", func(s *state.Scope) int { return notes.Len(s) })
+)
+
+// replica merges the operations pushed to a client like the browser does
+type replica struct {
+	*client
+	text *crdt.Sequence[string]
+}
+
+func newReplica(name string) *replica {
+	return &replica{client: newClient(codec.JSON), text: crdt.NewSequence[string](name)}
+}
+
+// pull flushes the scope and merges the operations of the notes
+func (r *replica) pull(t *testing.T, s *state.Scope) {
+	t.Helper()
+
+	flush(t, s, r.client, codec.JSON)
+	pushed, _ := at(r.root, "$$crdt", "wiki", "Notes").(map[string]any)
+	if pushed == nil {
+		return
+	}
+	if pushed["type"] != "text" {
+		t.Fatalf("unexpected type %v", pushed["type"])
+	}
+	var ops []crdt.SeqOp[string]
+	data, _ := codec.JSON.Marshal(pushed["ops"])
+	if err := codec.JSON.Unmarshal(data, &ops); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.text.Apply(ops...); err != nil {
+		t.Fatal(err)
+	}
+	delete(r.root.(map[string]any), "$$crdt")
+}
+
+// push sends operations of the client like the $$ops RPC
+func (r *replica) push(t *testing.T, s *state.Scope, ops []crdt.SeqOp[string]) {
+	t.Helper()
+
+	var params []any
+	data, _ := codec.JSON.Marshal(ops)
+	codec.JSON.Unmarshal(data, &params)
+	if err := s.ApplyOps("wiki", map[string]any{"Notes": params}, codec.JSON); err != nil {
+		t.Fatal(err)
+	}
+}
+
+//
+// --- Tests ---
+//
+
+// Two users type in the same text at once, nobody loses a character
+func TestReplicatedTextMerges(t *testing.T) {
+	a, b := state.NewScope(), state.NewScope()
+	alice, bob := newReplica("alice"), newReplica("bob")
+	start := notes.Len(a)
+	notes.Insert(a, start, "[ac]")
+	alice.pull(t, a)
+	bob.pull(t, b)
+	b.MarkDirty(notes)
+	bob.pull(t, b)
+
+	alice.push(t, a, alice.text.Insert(start+2, "X", "X"))
+	bob.push(t, b, bob.text.Insert(start+2, "y", "y"))
+	alice.pull(t, a)
+	a.MarkDirty(notes)
+	bob.pull(t, b)
+	b.MarkDirty(notes)
+	bob.pull(t, b)
+
+	want := notes.Get(a)
+	if added := string([]rune(want)[start:]); added != "[ayyXXc]" {
+		t.Fatalf("unexpected merge %q", added)
+	}
+	for name, r := range map[string]*replica{"alice": alice, "bob": bob} {
+		if got := text(r.text); got != want {
+			t.Fatalf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestReplicatedValuesAreEditedByTheServer(t *testing.T) {
+	s := state.NewScope()
+	tags.Append(s, "go", "js")
+	tags.Insert(s, 1, "crdt")
+	tags.Delete(s, 0, 1)
+	if got := tags.Get(s); !reflect.DeepEqual(got, []string{"crdt", "js"}) || tags.Len(s) != 2 {
+		t.Fatalf("unexpected list %v", got)
+	}
+
+	settings.Set(s, "width", 80)
+	settings.Set(s, "tabs", 4)
+	settings.Delete(s, "width")
+	if v, ok := settings.Value(s, "tabs"); !ok || v != 4 || !reflect.DeepEqual(settings.Get(s), map[string]int{"tabs": 4}) {
+		t.Fatalf("unexpected map %v", settings.Get(s))
+	}
+
+	// Only the new operations are pushed
+	var pushes []int
+	for i := 0; i < 2; i++ {
+		err := s.Flush(diff.Bit, codec.JSON, func([]byte) error { pushes = append(pushes, i); return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(pushes) != 1 {
+		t.Fatalf("expected one push, got %v", pushes)
+	}
+}
+
+func TestReplicatedValueIsNotSynced(t *testing.T) {
+	s := state.NewScope()
+	if _, err := s.Sync("wiki", map[string]any{"Tags": []any{"x"}}, nil); err == nil {
+		t.Fatal("a replicated value was synced")
+	}
+	if err := s.ApplyOps("profile", map[string]any{"Age": []any{}}, nil); err == nil {
+		t.Fatal("operations were applied to a signal")
+	}
+	if err := s.ApplyOps("wiki", map[string]any{"Tags": []any{map[string]any{"value": "x"}}}, nil); err == nil {
+		t.Fatal("an operation without id was applied")
+	}
+}
+
+func TestComputedOfReplicatedValue(t *testing.T) {
+	s := state.NewScope()
+	n := noteLen.Get(s)
+	notes.Insert(s, 0, "é!")
+	if noteLen.Get(s) != n+2 {
+		t.Fatalf("expected %d, got %d", n+2, noteLen.Get(s))
+	}
+}
+
+func TestOpsRPC(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	defer srv.Close()
+	conn := dial(t, srv)
+
+	r := crdt.NewSequence[string]("client")
+	var ops []any
+	data, _ := codec.JSON.Marshal(r.Insert(0, "a", "b"))
+	codec.JSON.Unmarshal(data, &ops)
+
+	call(t, conn, 1, "wiki", state.OpsMethod, map[string]interface{}{"Tags": ops})
+	c := newClient(codec.JSON)
+	readState(t, conn, c)
+	pushed, _ := at(c.root, "$$crdt", "wiki", "Tags").(map[string]any)
+	if pushed["type"] != "list" || len(pushed["ops"].([]any)) != 2 {
+		t.Fatalf("expected the operations back, got %v", pushed)
+	}
+	if ack := read(t, conn); ack.ReqId != 1 || ack.MsgType != types.WSTypeSuccessOutputMessage {
+		t.Fatalf("unexpected answer %+v", ack)
+	}
+
+	call(t, conn, 2, "wiki", state.OpsMethod, map[string]interface{}{"Tags": "nope"})
+	if output := read(t, conn); output.ReqId != 2 || output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected a rejection, got %+v", output)
+	}
+}
+
+// at returns the value at the keys, nil when missing
+func at(v any, keys ...string) any {
+	for _, key := range keys {
+		m, _ := v.(map[string]any)
+		v = m[key]
+	}
+	return v
+}
+
+// text is the value of a replica of a text
+func text(s *crdt.Sequence[string]) string {
+	return strings.Join(s.Values(), "")
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc/procedures"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe"
+)
+
+var (
+	boardTitle = state.NewSignal("board", "Title", "").Shared()
+	boardTasks = state.NewSlice("board", "Tasks", func(t task) any { return t.Id }).Shared()
+	counter    = state.NewSignal("counter", "Count", 0).Shared()
+)
+
+func TestSharedValuesAreOneForEveryScope(t *testing.T) {
+	a, b := state.NewScope(), state.NewScope()
+
+	title := boardTitle.Get(a) + "Sprint 1"
+	boardTitle.Set(a, title)
+	if boardTitle.Get(b) != title {
+		t.Fatalf("expected the shared value, got %q", boardTitle.Get(b))
+	}
+	if !a.Dirty() || b.Dirty() {
+		t.Fatal("only the writer is dirty, the others are reached by the broadcast")
+	}
+}
+
+// Concurrent writes are serialized, none is lost
+func TestSharedUpdatesAreSerialized(t *testing.T) {
+	start := counter.Get(state.NewScope())
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counter.Update(state.NewScope(), func(n int) int { return n + 1 })
+		}()
+	}
+	wg.Wait()
+
+	if n := counter.Get(state.NewScope()) - start; n != 50 {
+		t.Fatalf("expected 50 increments, got %d", n)
+	}
+}
+
+// One user's Add reaches everyone viewing the board as array operations
+func TestSharedChangesAreBroadcast(t *testing.T) {
+	procedures.Register("board", "Add", func(in *types.ClientInputInterface) *types.ClientOutput {
+		call := (*in).(*rpc.ClientInputRPC)
+		boardTasks.Append(call.WSConn.Scope(), task{Id: int(call.Params["id"].(float64)), Title: call.Params["title"].(string)})
+		return nil
+	})
+	boardTasks.Set(state.NewScope(), nil)
+
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	defer srv.Close()
+	alice, bob := dial(t, srv), dial(t, srv)
+	aliceState, bobState := newClient(codec.JSON), newClient(codec.JSON)
+	aliceState.dec.ArrayVersion = protocol.V1
+	bobState.dec.ArrayVersion = protocol.V1
+
+	// Viewing the board sends its current values
+	for i, conn := range []*websocket.Conn{alice, bob} {
+		reqId := uint8(i + 1)
+		frame, err := subscribe.ClientInputSubscription{ReqId: &reqId, Topic: state.ViewTopic("board")}.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatal(err)
+		}
+		readState(t, conn, []*client{aliceState, bobState}[i])
+		if ack := read(t, conn); ack.ReqId != reqId || ack.MsgType != types.WSTypeSuccessOutputMessage {
+			t.Fatalf("unexpected answer %+v", ack)
+		}
+	}
+
+	call(t, alice, 3, "board", "Add", map[string]interface{}{"id": 1, "title": "write tests"})
+	readState(t, bob, bobState)
+	readState(t, alice, aliceState)
+	if ack := read(t, alice); ack.ReqId != 3 || ack.MsgType != types.WSTypeSuccessOutputMessage {
+		t.Fatalf("unexpected answer %+v", ack)
+	}
+
+	call(t, bob, 4, "board", "Add", map[string]interface{}{"id": 2, "title": "review"})
+	readState(t, alice, aliceState)
+	readState(t, bob, bobState)
+	read(t, bob)
+
+	want := jsonValue(t, []task{{Id: 1, Title: "write tests"}, {Id: 2, Title: "review"}})
+	for name, c := range map[string]*client{"alice": aliceState, "bob": bobState} {
+		if got := jsonValue(t, c.root.(map[string]any)["board"].(map[string]any)["Tasks"]); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestViewingABusinessWithoutState(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	defer srv.Close()
+	conn := dial(t, srv)
+
+	reqId := uint8(1)
+	frame, err := subscribe.ClientInputSubscription{ReqId: &reqId, Topic: state.ViewTopic("nothing")}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+	if output := read(t, conn); output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected an error, got %+v", output)
+	}
+}
@@ -0,0 +1,416 @@
+package state
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/pathprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/rpc/procedures"
+)
+
+//
+// --- Test Helpers ---
+//
+
+type task struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+var (
+	age      = state.NewSignal("profile", "Age", 0)
+	email    = state.NewSignal("signup", "Email", "")
+	errorMsg = state.NewSignal("signup", "ErrorMsg", "")
+	tasks    = state.NewSlice("todo", "Tasks", func(t task) any { return t.Id })
+)
+
+// client applies the pushed batches like the browser does
+type client struct {
+	dec  *pathprotocol.Decoder
+	root any
+}
+
+func newClient(payloads codec.Codec) *client {
+	dec := pathprotocol.NewDecoder()
+	dec.ArrayVersion = protocol.V2
+	dec.Codec = payloads
+	return &client{dec: dec}
+}
+
+func (c *client) apply(t *testing.T, msg []byte) {
+	t.Helper()
+
+	root, err := c.dec.DecodeApply(msg, c.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.root = root
+}
+
+// values returns the state without the versions
+func (c *client) values() map[string]any {
+	values := make(map[string]any)
+	for k, v := range c.root.(map[string]any) {
+		if k != "$$version" {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+// version returns the pushed version of a value, 0 when none was. The
+// payloads must be JSON.
+func (c *client) version(business, name string) uint64 {
+	root, _ := c.root.(map[string]any)
+	versions, _ := root["$$version"].(map[string]any)
+	b, _ := versions[business].(map[string]any)
+	v, _ := b[name].(float64)
+	return uint64(v)
+}
+
+// flush pushes the scope to the client, false when nothing was sent
+func flush(t *testing.T, s *state.Scope, c *client, payloads codec.Codec) bool {
+	t.Helper()
+
+	sent := false
+	err := s.Flush(diff.BitV2, payloads, func(msg []byte) error {
+		sent = true
+		c.apply(t, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent
+}
+
+func jsonValue(t *testing.T, v any) any {
+	t.Helper()
+
+	data, err := codec.JSON.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out any
+	if err := codec.JSON.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// dial connects to handle.WS without a HELLO, the default session decodes
+// the path protocol with bit protocol V1
+func dial(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Authorization": {"Bearer token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// call sends an RPC frame
+func call(t *testing.T, conn *websocket.Conn, reqId uint8, class, method string, params map[string]interface{}) {
+	t.Helper()
+
+	frame, err := rpc.ClientInputRPC{ReqId: &reqId, Class: class, Method: method, Params: params}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, conn *websocket.Conn) types.ClientOutput {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output types.ClientOutput
+	if err := output.Unmarshal(msg); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+// readState reads a state push and applies it to the client
+func readState(t *testing.T, conn *websocket.Conn, c *client) {
+	t.Helper()
+
+	push := read(t, conn)
+	if push.ReqId != 0 || push.Destination != state.Destination {
+		t.Fatalf("expected a state push, got %+v", push)
+	}
+	// JSON payloads carry the bytes as base64
+	var msg []byte
+	data, _ := codec.JSON.Marshal(push.Data)
+	if err := codec.JSON.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	c.apply(t, msg)
+}
+
+//
+// --- Tests ---
+//
+
+func TestScopesAreIndependent(t *testing.T) {
+	a, b := state.NewScope(), state.NewScope()
+
+	email.Set(a, "a@example.com")
+	if email.Get(a) != "a@example.com" || email.Get(b) != "" {
+		t.Fatalf("scopes share values: %q, %q", email.Get(a), email.Get(b))
+	}
+	if !a.Dirty() || b.Dirty() {
+		t.Fatal("only the written scope is dirty")
+	}
+}
+
+func TestSetWithoutChangeIsNotDirty(t *testing.T) {
+	s := state.NewScope()
+
+	email.Set(s, "")
+	tasks.Set(s, nil)
+	if s.Dirty() {
+		t.Fatal("writing the current value marked the scope dirty")
+	}
+
+	items := []task{{Id: 1, Title: "a"}}
+	tasks.Set(s, items)
+	items[0].Title = "changed outside"
+	if got := tasks.Get(s); got[0].Title != "a" {
+		t.Fatalf("the scope kept the caller slice: %+v", got)
+	}
+}
+
+func TestFlushPushesTheChanges(t *testing.T) {
+	for _, payloads := range codec.All {
+		s := state.NewScope()
+		c := newClient(payloads)
+
+		email.Set(s, "a@example.com")
+		tasks.Set(s, []task{{1, "a", false}, {2, "b", false}, {3, "c", false}})
+		if !flush(t, s, c, payloads) {
+			t.Fatalf("%s: nothing pushed", payloads.Name())
+		}
+		if s.Dirty() || flush(t, s, c, payloads) {
+			t.Fatalf("%s: pushed twice", payloads.Name())
+		}
+
+		// Slices are sent as the operations of their diff
+		tasks.Update(s, func(items []task) []task {
+			items[1].Done = true
+			return append(items[:0], items[1:]...)
+		})
+		tasks.Append(s, task{4, "d", false})
+		errorMsg.Set(s, "Invalid email")
+		email.Set(s, "")
+		if !flush(t, s, c, payloads) {
+			t.Fatalf("%s: nothing pushed", payloads.Name())
+		}
+
+		want := jsonValue(t, map[string]any{
+			"signup": map[string]any{"Email": "", "ErrorMsg": "Invalid email"},
+			"todo":   map[string]any{"Tasks": []task{{2, "b", true}, {3, "c", false}, {4, "d", false}}},
+		})
+		if got := jsonValue(t, c.values()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %v, got %v", payloads.Name(), want, got)
+		}
+	}
+}
+
+func TestFailedFlushStaysDirty(t *testing.T) {
+	s := state.NewScope()
+	email.Set(s, "a@example.com")
+
+	err := s.Flush(diff.Bit, nil, func([]byte) error { return websocket.ErrCloseSent })
+	if err == nil || !s.Dirty() {
+		t.Fatalf("expected the values to stay dirty, got %v", err)
+	}
+}
+
+// tasksOp returns the operation of the pushed batch on todo.Tasks
+func tasksOp(t *testing.T, s *state.Scope, dec *pathprotocol.Decoder, enc diff.Encoder) pathprotocol.Operation {
+	t.Helper()
+
+	var batch pathprotocol.Operation
+	err := s.Flush(enc, codec.JSON, func(msg []byte) error {
+		var err error
+		batch, err = dec.Decode(msg)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range batch.Ops {
+		if op.Path.String() == tasks.Path().String() {
+			return op
+		}
+	}
+	t.Fatalf("todo.Tasks not pushed in %+v", batch)
+	return pathprotocol.Operation{}
+}
+
+func TestFlushWithTheSessionEncoder(t *testing.T) {
+	s := state.NewScope()
+	dec := pathprotocol.NewDecoder()
+	tasks.Set(s, []task{{1, "a", false}})
+	tasksOp(t, s, dec, diff.Byte)
+
+	tasks.Append(s, task{2, "b", false})
+	op := tasksOp(t, s, dec, diff.Byte)
+	if op.Op != pathprotocol.OpArray {
+		t.Fatalf("expected an array operation, got %v", op.Op)
+	}
+	arr, err := (&byteprotocol.Decoder{}).Decode(op.Data)
+	if err != nil || arr.Op != byteprotocol.OpInsert || arr.Pos != 1 {
+		t.Fatalf("expected a byte protocol insert at 1, got %+v %v", arr, err)
+	}
+
+	// Without array operations the list is sent whole
+	tasks.Append(s, task{3, "c", false})
+	if op := tasksOp(t, s, dec, nil); op.Op != pathprotocol.OpSet {
+		t.Fatalf("expected the whole list, got %v", op.Op)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	if v, ok := state.Lookup("signup", "Email"); !ok || v != email {
+		t.Fatal("Email not registered")
+	}
+	if _, ok := state.Lookup("signup", "Password"); ok {
+		t.Fatal("unknown name found")
+	}
+	if _, ok := state.Lookup("todo", "Email"); ok {
+		t.Fatal("name found in another business")
+	}
+	vars := state.Vars("signup")
+	if len(vars) != 2 || vars[0].Name() != "Email" || vars[1].Name() != "ErrorMsg" {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a name twice did not panic")
+		}
+	}()
+	state.NewSignal("signup", "Email", "")
+}
+
+func TestSync(t *testing.T) {
+	s := state.NewScope()
+	c := newClient(codec.JSON)
+
+	tasks.Set(s, []task{{Id: 1, Title: "a"}})
+	flush(t, s, c, codec.JSON)
+
+	// Rejected writes change nothing
+	for _, values := range []map[string]any{
+		nil,
+		{"Unknown": 1},
+		{"Tasks": []any{map[string]any{"id": 2, "title": "b"}}, "Email": "x"},
+		{"Tasks": "not a list"},
+		{"Tasks": []any{map[string]any{"id": "two"}}},
+	} {
+		if _, err := s.Sync("todo", values, codec.JSON); err == nil {
+			t.Fatalf("expected %v to be rejected", values)
+		}
+	}
+	if _, err := s.Sync("profile", map[string]any{"Age": "ten"}, nil); err == nil {
+		t.Fatal("expected a string to be rejected for an int")
+	}
+	if got := tasks.Get(s); len(got) != 1 || got[0].Id != 1 {
+		t.Fatalf("a rejected write was applied: %+v", got)
+	}
+
+	// Values are converted to the Go type, and not pushed back
+	if _, err := s.Sync("profile", map[string]any{"Age": float64(42)}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Sync("todo", map[string]any{"Tasks": []any{map[string]any{"id": 1, "title": "a"}, map[string]any{"id": 2, "title": "b"}}}, codec.JSON); err != nil {
+		t.Fatal(err)
+	}
+	if age.Get(s) != 42 || tasks.Len(s) != 2 || s.Dirty() {
+		t.Fatalf("unexpected state after sync: %d, %+v, dirty %v", age.Get(s), tasks.Get(s), s.Dirty())
+	}
+
+	// The next diff starts from what the client wrote
+	tasks.Append(s, task{Id: 3, Title: "c"})
+	c.root.(map[string]any)["todo"].(map[string]any)["Tasks"] = jsonValue(t, []task{{Id: 1, Title: "a"}, {Id: 2, Title: "b"}})
+	flush(t, s, c, codec.JSON)
+	want := jsonValue(t, []task{{Id: 1, Title: "a"}, {Id: 2, Title: "b"}, {Id: 3, Title: "c"}})
+	if got := jsonValue(t, c.root.(map[string]any)["todo"].(map[string]any)["Tasks"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+// The state changed by an RPC reaches the client before its answer
+func TestRPCPushesTheState(t *testing.T) {
+	procedures.Register("signup", "Submit", func(in *types.ClientInputInterface) *types.ClientOutput {
+		call := (*in).(*rpc.ClientInputRPC)
+		scope := call.WSConn.Scope()
+		email.Set(scope, call.Params["email"].(string))
+		errorMsg.Set(scope, "")
+		return nil
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	defer srv.Close()
+	conn := dial(t, srv)
+
+	call(t, conn, 1, "signup", "Submit", map[string]interface{}{"email": "a@example.com"})
+
+	c := newClient(codec.JSON)
+	c.dec.ArrayVersion = protocol.V1
+	readState(t, conn, c)
+	want := map[string]any{"signup": map[string]any{"Email": "a@example.com"}}
+	if !reflect.DeepEqual(c.values(), want) {
+		t.Fatalf("expected %v, got %v", want, c.values())
+	}
+
+	if answer := read(t, conn); answer.ReqId != 1 || answer.MsgType != types.WSTypeSuccessOutputMessage {
+		t.Fatalf("unexpected answer %+v", answer)
+	}
+}
+
+func TestSyncRPC(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	defer srv.Close()
+	conn := dial(t, srv)
+
+	sync := func(reqId uint8, params map[string]interface{}) types.ClientOutput {
+		call(t, conn, reqId, "profile", state.SyncMethod, params)
+		return read(t, conn)
+	}
+
+	if output := sync(1, map[string]interface{}{"Age": 30}); output.ReqId != 1 || output.MsgType != types.WSTypeSuccessOutputMessage {
+		t.Fatalf("expected an ack, got %+v", output)
+	}
+	if output := sync(2, map[string]interface{}{"Age": "thirty"}); output.ReqId != 2 || output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected a rejection, got %+v", output)
+	}
+	if output := sync(3, map[string]interface{}{"Password": "x"}); output.ReqId != 3 || output.MsgType != types.WSTypeErrorOutputMessage {
+		t.Fatalf("expected a rejection, got %+v", output)
+	}
+}
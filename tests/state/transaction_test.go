@@ -0,0 +1,207 @@
+package state
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/diff"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/handle"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/state"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+)
+
+var (
+	balance  = state.NewSignal("wallet", "Balance", 0)
+	history  = state.NewSlice[string]("wallet", "History", nil)
+	docTitle = state.NewSignal("doc", "Title", "").Shared()
+)
+
+func TestVersionsArePushed(t *testing.T) {
+	s := state.NewScope()
+	c := newClient(codec.JSON)
+
+	balance.Set(s, 10)
+	balance.Set(s, 20)
+	flush(t, s, c, codec.JSON)
+	if v := c.version("wallet", "Balance"); v != 2 || v != balance.Version(s) {
+		t.Fatalf("expected version 2, got %d", v)
+	}
+	history.Append(s, "a")
+	flush(t, s, c, codec.JSON)
+	if c.version("wallet", "History") != 1 || c.version("wallet", "Balance") != 2 {
+		t.Fatalf("unexpected versions %v", c.root)
+	}
+}
+
+func TestSyncCompareAndSet(t *testing.T) {
+	s := state.NewScope()
+	c := newClient(codec.JSON)
+	balance.Set(s, 10)
+	flush(t, s, c, codec.JSON)
+
+	versions, err := s.Sync("wallet", map[string]any{"Balance": float64(15), state.VersionsKey: map[string]any{"Balance": float64(1)}}, codec.JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions["Balance"] != 2 || balance.Get(s) != 15 || s.Dirty() {
+		t.Fatalf("unexpected write: versions %v, value %d", versions, balance.Get(s))
+	}
+
+	// The server changed it meanwhile, the client is given its value
+	balance.Set(s, 100)
+	_, err = s.Sync("wallet", map[string]any{"Balance": float64(16), "History": []any{"x"}, state.VersionsKey: map[string]any{"Balance": float64(2), "History": float64(0)}}, codec.JSON)
+	var conflict *state.ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+	if !reflect.DeepEqual(conflict.Values, map[string]any{"Balance": 100}) || !reflect.DeepEqual(conflict.Versions, map[string]uint64{"Balance": 3}) {
+		t.Fatalf("unexpected conflict %+v", conflict)
+	}
+	if balance.Get(s) != 100 || history.Len(s) != 0 {
+		t.Fatal("a write in conflict was applied")
+	}
+	// and is not pushed what the rejection carried
+	if flush(t, s, c, codec.JSON) {
+		t.Fatal("the value of the conflict was pushed again")
+	}
+
+	// Without versions the last write wins
+	if _, err := s.Sync("wallet", map[string]any{"Balance": float64(1)}, codec.JSON); err != nil || balance.Get(s) != 1 {
+		t.Fatalf("unexpected write: %v, %d", err, balance.Get(s))
+	}
+	if _, err := s.Sync("wallet", map[string]any{"Balance": float64(1), state.VersionsKey: "3"}, codec.JSON); err == nil {
+		t.Fatal("expected invalid versions to be rejected")
+	}
+}
+
+// Two clients write the same shared value from the same version, the
+// second is rejected with the first one's value
+func TestSharedWriteConflict(t *testing.T) {
+	a, b := state.NewScope(), state.NewScope()
+	docTitle.Set(a, docTitle.Get(a)+"draft")
+	version := docTitle.Version(a)
+	expected := map[string]any{"Title": float64(version)}
+
+	if _, err := a.Sync("doc", map[string]any{"Title": "by a", state.VersionsKey: expected}, codec.JSON); err != nil {
+		t.Fatal(err)
+	}
+	_, err := b.Sync("doc", map[string]any{"Title": "by b", state.VersionsKey: expected}, codec.JSON)
+	var conflict *state.ConflictError
+	if !errors.As(err, &conflict) || conflict.Values["Title"] != "by a" || conflict.Versions["Title"] != version+1 {
+		t.Fatalf("expected a conflict with the write of a, got %v", err)
+	}
+	if docTitle.Get(b) != "by a" {
+		t.Fatalf("unexpected value %q", docTitle.Get(b))
+	}
+}
+
+func TestTransactionIsOnePush(t *testing.T) {
+	s := state.NewScope()
+	c := newClient(codec.JSON)
+
+	pushes := 0
+	err := s.Transaction(func() error {
+		balance.Set(s, 50)
+		if err := s.Flush(diff.Bit, codec.JSON, func([]byte) error { pushes++; return nil }); err != nil {
+			return err
+		}
+		return s.Transaction(func() error {
+			history.Append(s, "deposit")
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushes != 0 {
+		t.Fatal("a flush pushed during the transaction")
+	}
+	if !flush(t, s, c, codec.JSON) {
+		t.Fatal("nothing pushed after the transaction")
+	}
+	want := jsonValue(t, map[string]any{"wallet": map[string]any{"Balance": 50, "History": []string{"deposit"}}})
+	if got := jsonValue(t, c.values()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	s := state.NewScope()
+	c := newClient(codec.JSON)
+	balance.Set(s, 10)
+	flush(t, s, c, codec.JSON)
+
+	failed := errors.New("insufficient funds")
+	err := s.Transaction(func() error {
+		balance.Update(s, func(b int) int { return b - 30 })
+		history.Append(s, "withdraw")
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected the error of the function, got %v", err)
+	}
+	if balance.Get(s) != 10 || history.Len(s) != 0 {
+		t.Fatalf("not rolled back: %d, %v", balance.Get(s), history.Get(s))
+	}
+	// Computed values still see a change
+	if balance.Version(s) != 3 {
+		t.Fatalf("expected version 3, got %d", balance.Version(s))
+	}
+
+	func() {
+		defer func() { recover() }()
+		s.Transaction(func() error {
+			balance.Set(s, 99)
+			panic("boom")
+		})
+	}()
+	if balance.Get(s) != 10 {
+		t.Fatalf("not rolled back after a panic: %d", balance.Get(s))
+	}
+}
+
+// The rollback keeps the write another connection made after it
+func TestTransactionRollbackKeepsOtherWrites(t *testing.T) {
+	a, b := state.NewScope(), state.NewScope()
+	docTitle.Set(a, "before")
+
+	a.Transaction(func() error {
+		docTitle.Set(a, "in transaction")
+		docTitle.Set(b, "by b")
+		return errors.New("failed")
+	})
+	if docTitle.Get(a) != "by b" {
+		t.Fatalf("expected the write of b, got %q", docTitle.Get(a))
+	}
+
+	a.Transaction(func() error {
+		docTitle.Set(a, "in transaction")
+		return errors.New("failed")
+	})
+	if docTitle.Get(b) != "by b" {
+		t.Fatalf("expected the value before the transaction, got %q", docTitle.Get(b))
+	}
+}
+
+func TestSyncConflictRPC(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(handle.WS))
+	defer srv.Close()
+	conn := dial(t, srv)
+
+	call(t, conn, 1, "wallet", state.SyncMethod, map[string]interface{}{"Balance": 5, state.VersionsKey: map[string]interface{}{"Balance": 0}})
+	ack := read(t, conn)
+	if ack.MsgType != types.WSTypeSuccessOutputMessage || !reflect.DeepEqual(ack.Data, map[string]any{"versions": map[string]any{"Balance": float64(1)}}) {
+		t.Fatalf("expected an ack with the version, got %+v", ack)
+	}
+
+	call(t, conn, 2, "wallet", state.SyncMethod, map[string]interface{}{"Balance": 6, state.VersionsKey: map[string]interface{}{"Balance": 0}})
+	rejection := read(t, conn)
+	data, _ := rejection.Data.(map[string]any)
+	if rejection.MsgType != types.WSTypeErrorOutputMessage || !reflect.DeepEqual(data["values"], map[string]any{"Balance": float64(5)}) || !reflect.DeepEqual(data["versions"], map[string]any{"Balance": float64(1)}) {
+		t.Fatalf("expected a rejection with the current value, got %+v", rejection)
+	}
+}
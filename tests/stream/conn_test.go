@@ -0,0 +1,123 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/codec"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// connect returns the server side of a websocket, as the handlers see it,
+// and the client side reading what it sends
+func connect(t *testing.T) (*types.WebSocketConnection, *websocket.Conn) {
+	t.Helper()
+
+	serverSide := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serverSide <- c
+	}))
+	t.Cleanup(srv.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return &types.WebSocketConnection{Conn: <-serverSide}, client
+}
+
+//
+// --- Tests ---
+//
+
+// Streamed messages and whole ones share the connection without mixing
+func TestWriteWithSharesTheConnection(t *testing.T) {
+	wsc, client := connect(t)
+	const n = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s := protocol.NewStreamEncoder(nil, protocol.V1)
+			err := wsc.WriteWith(websocket.BinaryMessage, func(w io.Writer) error {
+				s.Reset(w)
+				return s.Insert(3, payload)
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := wsc.Write(websocket.TextMessage, payload); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := encoded(t)((&protocol.Encoder{}).EncodeInsert(3, payload))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for i := 0; i < 2*n; i++ {
+		kind, msg, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind == websocket.BinaryMessage && !bytes.Equal(msg, want) || kind == websocket.TextMessage && !bytes.Equal(msg, payload) {
+			t.Fatalf("message %d mixed up: %q", i, msg)
+		}
+	}
+}
+
+// A ClientOutput streamed into the connection is the one MarshalWith builds
+func TestEncodeToMatchesMarshal(t *testing.T) {
+	output := types.ClientOutput{
+		MsgType:     types.WSTypeSuccessOutputMessage,
+		Destination: "$$/state",
+		Data:        payload,
+		Header:      map[string]string{"seq": "1"},
+	}
+	for _, c := range codec.All {
+		var buf bytes.Buffer
+		if err := output.EncodeTo(&buf, c); err != nil {
+			t.Fatal(err)
+		}
+		want, err := output.MarshalWith(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("%s: expected %x, got %x", c.Name(), want, buf.Bytes())
+		}
+	}
+
+	// nothing reaches the writer when the data can not be encoded
+	var buf bytes.Buffer
+	output.Data = make(chan int)
+	if err := output.EncodeTo(&buf, codec.JSON); err == nil || buf.Len() != 0 {
+		t.Fatalf("expected an error and nothing written, got %v and %x", err, buf.Bytes())
+	}
+}
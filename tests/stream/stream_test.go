@@ -0,0 +1,298 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/byteprotocol"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/encode/protocol"
+)
+
+//
+// --- Test Helpers ---
+//
+
+// recorder keeps every Write as one message
+type recorder struct {
+	msgs [][]byte
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.msgs = append(r.msgs, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+var (
+	payload  = []byte(`{"Id":1,"Title":"write the report","Done":false}`)
+	payloads = [][]byte{payload, payload, payload}
+	patches  = []protocol.PartialPatch{{Pos: 1, Data: []byte(`{"Done":true}`)}, {Pos: 300, Data: []byte(`{"Done":false}`)}}
+	bpatches = []byteprotocol.PartialPatch{{Pos: 1, Data: []byte(`{"Done":true}`)}, {Pos: 300, Data: []byte(`{"Done":false}`)}}
+)
+
+// every operation three ways: Encode, Append and through a StreamEncoder
+type bitCase struct {
+	name   string
+	encode func(e *protocol.Encoder) ([]byte, error)
+	append func(e *protocol.Encoder, dst []byte) ([]byte, error)
+	stream func(s *protocol.StreamEncoder) error
+}
+
+var bitCases = []bitCase{
+	{"delete",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeDelete(70000) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendDelete(dst, 70000) },
+		func(s *protocol.StreamEncoder) error { return s.Delete(70000) }},
+	{"delete range",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeDeleteRange(1, 3) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendDeleteRange(dst, 1, 3) },
+		func(s *protocol.StreamEncoder) error { return s.DeleteRange(1, 3) }},
+	{"move",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeMove(4, 0) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendMove(dst, 4, 0) },
+		func(s *protocol.StreamEncoder) error { return s.Move(4, 0) }},
+	{"move range",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeMoveRange(2, 5, 300) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendMoveRange(dst, 2, 5, 300) },
+		func(s *protocol.StreamEncoder) error { return s.MoveRange(2, 5, 300) }},
+	{"insert",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeInsert(3, payload) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendInsert(dst, 3, payload) },
+		func(s *protocol.StreamEncoder) error { return s.Insert(3, payload) }},
+	{"update",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeUpdate(3, payload) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendUpdate(dst, 3, payload) },
+		func(s *protocol.StreamEncoder) error { return s.Update(3, payload) }},
+	{"insert range",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeInsertRange(0, 2, payloads) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendInsertRange(dst, 0, 2, payloads) },
+		func(s *protocol.StreamEncoder) error { return s.InsertRange(0, 2, payloads) }},
+	{"update range",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodeUpdateRange(0, 2, payloads) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendUpdateRange(dst, 0, 2, payloads) },
+		func(s *protocol.StreamEncoder) error { return s.UpdateRange(0, 2, payloads) }},
+	{"partial",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodePartialUpdate(7, payload) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) { return e.AppendPartialUpdate(dst, 7, payload) },
+		func(s *protocol.StreamEncoder) error { return s.PartialUpdate(7, payload) }},
+	{"partial range",
+		func(e *protocol.Encoder) ([]byte, error) { return e.EncodePartialUpdateRange(0, 300, patches) },
+		func(e *protocol.Encoder, dst []byte) ([]byte, error) {
+			return e.AppendPartialUpdateRange(dst, 0, 300, patches)
+		},
+		func(s *protocol.StreamEncoder) error { return s.PartialUpdateRange(0, 300, patches) }},
+}
+
+type byteCase struct {
+	name   string
+	encode func(e *byteprotocol.Encoder) ([]byte, error)
+	append func(e *byteprotocol.Encoder, dst []byte) ([]byte, error)
+	stream func(s *byteprotocol.StreamEncoder) error
+}
+
+var byteCases = []byteCase{
+	{"delete",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeDelete(0x1000000) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) { return e.AppendDelete(dst, 0x1000000) },
+		func(s *byteprotocol.StreamEncoder) error { return s.Delete(0x1000000) }},
+	{"delete range",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeDeleteRange(1, 3) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) { return e.AppendDeleteRange(dst, 1, 3) },
+		func(s *byteprotocol.StreamEncoder) error { return s.DeleteRange(1, 3) }},
+	{"move",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeMove(4, 0) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) { return e.AppendMove(dst, 4, 0) },
+		func(s *byteprotocol.StreamEncoder) error { return s.Move(4, 0) }},
+	{"move range",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeMoveRange(2, 5, 300) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) { return e.AppendMoveRange(dst, 2, 5, 300) },
+		func(s *byteprotocol.StreamEncoder) error { return s.MoveRange(2, 5, 300) }},
+	{"insert",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeInsert(3, payload) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) { return e.AppendInsert(dst, 3, payload) },
+		func(s *byteprotocol.StreamEncoder) error { return s.Insert(3, payload) }},
+	{"update",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeUpdate(3, payload) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) { return e.AppendUpdate(dst, 3, payload) },
+		func(s *byteprotocol.StreamEncoder) error { return s.Update(3, payload) }},
+	{"insert range",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeInsertRange(0, 2, payloads) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) {
+			return e.AppendInsertRange(dst, 0, 2, payloads)
+		},
+		func(s *byteprotocol.StreamEncoder) error { return s.InsertRange(0, 2, payloads) }},
+	{"update range",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodeUpdateRange(0, 2, payloads) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) {
+			return e.AppendUpdateRange(dst, 0, 2, payloads)
+		},
+		func(s *byteprotocol.StreamEncoder) error { return s.UpdateRange(0, 2, payloads) }},
+	{"partial",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodePartialUpdate(7, payload) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) {
+			return e.AppendPartialUpdate(dst, 7, payload)
+		},
+		func(s *byteprotocol.StreamEncoder) error { return s.PartialUpdate(7, payload) }},
+	{"partial range",
+		func(e *byteprotocol.Encoder) ([]byte, error) { return e.EncodePartialUpdateRange(0, 300, bpatches) },
+		func(e *byteprotocol.Encoder, dst []byte) ([]byte, error) {
+			return e.AppendPartialUpdateRange(dst, 0, 300, bpatches)
+		},
+		func(s *byteprotocol.StreamEncoder) error { return s.PartialUpdateRange(0, 300, bpatches) }},
+}
+
+func encoded(t *testing.T) func([]byte, error) []byte {
+	return func(data []byte, err error) []byte {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+}
+
+//
+// --- Tests ---
+//
+
+// AppendX adds exactly the bytes of EncodeX after what dst holds
+func TestAppendMatchesEncode(t *testing.T) {
+	b := encoded(t)
+	prefix := []byte{0xAA, 0xBB}
+
+	for _, version := range []protocol.Version{protocol.V1, protocol.V2} {
+		enc := &protocol.Encoder{Version: version}
+		for _, c := range bitCases {
+			want := append(append([]byte(nil), prefix...), b(c.encode(enc))...)
+			got := b(c.append(enc, append([]byte(nil), prefix...)))
+			if !bytes.Equal(got, want) {
+				t.Fatalf("bit V%d %s: expected %x, got %x", version, c.name, want, got)
+			}
+		}
+	}
+
+	enc := &byteprotocol.Encoder{}
+	for _, c := range byteCases {
+		want := append(append([]byte(nil), prefix...), b(c.encode(enc))...)
+		got := b(c.append(enc, append([]byte(nil), prefix...)))
+		if !bytes.Equal(got, want) {
+			t.Fatalf("byte %s: expected %x, got %x", c.name, want, got)
+		}
+	}
+}
+
+func TestAppendErrorKeepsDst(t *testing.T) {
+	dst := []byte{1, 2, 3}
+
+	got, err := (&protocol.Encoder{}).AppendInsert(dst, 0x1000000, payload)
+	if err == nil || !bytes.Equal(got, dst) {
+		t.Fatalf("expected error and %x, got %v and %x", dst, err, got)
+	}
+	got, err = (&protocol.Encoder{}).AppendInsertRange(dst, 0, 5, payloads)
+	if err == nil || !bytes.Equal(got, dst) {
+		t.Fatalf("expected error and %x, got %v and %x", dst, err, got)
+	}
+	got, err = (&byteprotocol.Encoder{}).AppendMoveRange(dst, 3, 1, 0)
+	if err == nil || !bytes.Equal(got, dst) {
+		t.Fatalf("expected error and %x, got %v and %x", dst, err, got)
+	}
+}
+
+// Every call is one Write holding one message
+func TestStreamEncoder(t *testing.T) {
+	b := encoded(t)
+	rec := &recorder{}
+	s := protocol.NewStreamEncoder(rec, protocol.V2)
+	enc := &protocol.Encoder{Version: protocol.V2}
+	for _, c := range bitCases {
+		if err := c.stream(s); err != nil {
+			t.Fatal(err)
+		}
+		if want := b(c.encode(enc)); !bytes.Equal(rec.msgs[len(rec.msgs)-1], want) {
+			t.Fatalf("bit %s: expected %x, got %x", c.name, want, rec.msgs[len(rec.msgs)-1])
+		}
+	}
+	if len(rec.msgs) != len(bitCases) {
+		t.Fatalf("expected %d writes, got %d", len(bitCases), len(rec.msgs))
+	}
+
+	brec := &recorder{}
+	bs := byteprotocol.NewStreamEncoder(brec)
+	for _, c := range byteCases {
+		if err := c.stream(bs); err != nil {
+			t.Fatal(err)
+		}
+		if want := b(c.encode(&byteprotocol.Encoder{})); !bytes.Equal(brec.msgs[len(brec.msgs)-1], want) {
+			t.Fatalf("byte %s: expected %x, got %x", c.name, want, brec.msgs[len(brec.msgs)-1])
+		}
+	}
+
+	// an error writes nothing
+	if err := s.Delete(0xFFFFFFFF); err != nil {
+		t.Fatal(err)
+	}
+	before := len(rec.msgs)
+	if err := s.InsertRange(0, 9, payloads); err == nil || len(rec.msgs) != before {
+		t.Fatalf("expected an error and no write, got %v and %d writes", err, len(rec.msgs)-before)
+	}
+
+	// Reset moves the output
+	other := &recorder{}
+	s.Reset(other)
+	if err := s.Delete(1); err != nil || len(other.msgs) != 1 {
+		t.Fatalf("expected one write after Reset, got %v and %d", err, len(other.msgs))
+	}
+}
+
+func TestStreamZeroAllocs(t *testing.T) {
+	s := protocol.NewStreamEncoder(io.Discard, protocol.V1)
+	for _, c := range bitCases {
+		if allocs := testing.AllocsPerRun(100, func() { c.stream(s) }); allocs != 0 {
+			t.Fatalf("bit %s: %v allocations per op", c.name, allocs)
+		}
+	}
+
+	bs := byteprotocol.NewStreamEncoder(io.Discard)
+	for _, c := range byteCases {
+		if allocs := testing.AllocsPerRun(100, func() { c.stream(bs) }); allocs != 0 {
+			t.Fatalf("byte %s: %v allocations per op", c.name, allocs)
+		}
+	}
+}
+
+//
+// --- Benchmarks ---
+//
+
+func BenchmarkEncodeInsert(b *testing.B) {
+	enc := &protocol.Encoder{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		enc.EncodeInsert(uint32(i&0xFFFF), payload)
+	}
+}
+
+func BenchmarkAppendInsert(b *testing.B) {
+	enc := &protocol.Encoder{}
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ = enc.AppendInsert(buf[:0], uint32(i&0xFFFF), payload)
+	}
+}
+
+func BenchmarkStreamInsert(b *testing.B) {
+	s := protocol.NewStreamEncoder(io.Discard, protocol.V1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.Insert(uint32(i&0xFFFF), payload)
+	}
+}
+
+func BenchmarkStreamPartialUpdateRange(b *testing.B) {
+	s := protocol.NewStreamEncoder(io.Discard, protocol.V1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.PartialUpdateRange(0, 300, patches)
+	}
+}
@@ -0,0 +1,157 @@
+package topics
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types"
+	"github.com/milton-alvarenga/goreactivehtml/internal/server/types/input/subscribe/topics"
+)
+
+func patterns(matches []topics.Match[int]) []topics.Topic {
+	list := make([]topics.Topic, len(matches))
+	for i, m := range matches {
+		list[i] = m.Pattern
+	}
+	return list
+}
+
+func TestExactMatch(t *testing.T) {
+	trie := topics.NewTrie[int]()
+	trie.Set("chat/lobby", 1)
+
+	m, ok := trie.Lookup("chat/lobby")
+	if !ok || m.Value != 1 {
+		t.Fatalf("expected chat/lobby, got %v", m)
+	}
+	if _, ok := trie.Lookup("chat/lobby/x"); ok {
+		t.Fatal("longer topic must not match")
+	}
+	if _, ok := trie.Lookup("chat"); ok {
+		t.Fatal("shorter topic must not match")
+	}
+}
+
+func TestParamsExtraction(t *testing.T) {
+	trie := topics.NewTrie[int]()
+	trie.Set("chat/room/{id}", 1)
+	trie.Set("$$/listen/var/<varname>", 2)
+	trie.Set("users/{user}/+/{item}", 3)
+
+	cases := []struct {
+		topic  topics.Topic
+		value  int
+		params topics.Params
+	}{
+		{"chat/room/42", 1, topics.Params{"id": "42"}},
+		{"$$/listen/var/Email", 2, topics.Params{"varname": "Email"}},
+		{"users/ana/cart/7", 3, topics.Params{"user": "ana", "item": "7"}},
+	}
+
+	for _, c := range cases {
+		m, ok := trie.Lookup(c.topic)
+		if !ok || m.Value != c.value {
+			t.Fatalf("%s: expected value %d, got %v", c.topic, c.value, m)
+		}
+		if fmt.Sprint(m.Params) != fmt.Sprint(c.params) {
+			t.Fatalf("%s: expected params %v, got %v", c.topic, c.params, m.Params)
+		}
+	}
+}
+
+func TestMultiLevelWildcard(t *testing.T) {
+	trie := topics.NewTrie[int]()
+	trie.Set("news/#", 1)
+
+	for _, topic := range []topics.Topic{"news", "news/sport", "news/sport/football"} {
+		if _, ok := trie.Lookup(topic); !ok {
+			t.Fatalf("%s must match news/#", topic)
+		}
+	}
+
+	m, _ := trie.Lookup("news/sport/football")
+	if m.Params[topics.MultiWildcard] != "sport/football" {
+		t.Fatalf("expected remaining segments in params, got %v", m.Params)
+	}
+}
+
+func TestMostSpecificFirst(t *testing.T) {
+	trie := topics.NewTrie[int]()
+	trie.Set("chat/#", 1)
+	trie.Set("chat/+/42", 2)
+	trie.Set("chat/room/42", 3)
+	trie.Set("chat/room/+", 4)
+
+	got := patterns(trie.Match("chat/room/42"))
+	want := []topics.Topic{"chat/room/42", "chat/room/+", "chat/+/42", "chat/#"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSetReplacesAndDelete(t *testing.T) {
+	trie := topics.NewTrie[int]()
+	trie.Set("a/{x}", 1)
+	trie.Set("a/{y}", 2)
+
+	if trie.Len() != 1 {
+		t.Fatalf("patterns with the same shape share the entry, got %d", trie.Len())
+	}
+	if v, _ := trie.Get("a/+"); v != 2 {
+		t.Fatalf("expected replaced value, got %d", v)
+	}
+
+	if !trie.Delete("a/{y}") {
+		t.Fatal("delete must succeed")
+	}
+	if trie.Delete("a/{y}") {
+		t.Fatal("second delete must fail")
+	}
+	if trie.Len() != 0 {
+		t.Fatalf("expected empty trie, got %d", trie.Len())
+	}
+	if _, ok := trie.Lookup("a/1"); ok {
+		t.Fatal("deleted pattern must not match")
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	trie := topics.NewTrie[int]()
+	if err := trie.Set("a/#/b", 1); err == nil {
+		t.Fatal("expected error for # in the middle")
+	}
+}
+
+// A client subscribes to concrete topics only, a wildcard would match every
+// room of the registered pattern
+func TestClientTopicsAreConcrete(t *testing.T) {
+	if err := topics.Register("test/room/{id}", func(*types.ClientInputInterface, topics.Params) *types.ClientOutput { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if !topics.IsValid("test/room/1") {
+		t.Fatal("expected a concrete topic to be valid")
+	}
+	for _, topic := range []topics.Topic{"test/room/+", "test/room/#", "test/#", "test/room/{id}", "test/room/<id>"} {
+		if topics.IsValid(topic) {
+			t.Fatalf("expected %q to be rejected", topic)
+		}
+		if out := topics.Exec(topic, nil); out != nil {
+			t.Fatalf("expected %q not to run the handler", topic)
+		}
+	}
+}
+
+func BenchmarkMatchThousandsOfTopics(b *testing.B) {
+	trie := topics.NewTrie[int]()
+	for i := 0; i < 10000; i++ {
+		trie.Set(topics.Topic(fmt.Sprintf("chat/room/%d", i)), i)
+	}
+	trie.Set("chat/room/+", -1)
+	trie.Set("chat/#", -2)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Match(topics.Topic(fmt.Sprintf("chat/room/%d", i%10000)))
+	}
+}
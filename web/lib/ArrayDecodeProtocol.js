@@ -1,10 +1,24 @@
 //
 //  Binary Decoder and Array/Object Transformer Protocol
 //
+//  version is agreed with the server once per connection, it only changes
+//  size class 11: 3 bytes in version 1, uvarint in version 2
+//
+//  codec decodes the payloads, the one of the connection (JSON by default)
+//
+//  A compressed bulk INSERT (bulk + partial on INSERT) must go through
+//  inflateOperation (Compress.js) first
+//
+
+import { json } from "./Codec.js";
 
-export function applyBinaryOperation(buffer, target, debug) {
+export const SUPPORTED_VERSIONS = [1, 2];
+
+export function applyBinaryOperation(buffer, target, debug, version, codec) {
     const view = new DataView(buffer.buffer, buffer.byteOffset, buffer.length);
     debug = debug || false;
+    version = version || 1;
+    codec = codec || json;
     let offset = 0;
 
     if (debug) {
@@ -43,6 +57,9 @@ export function applyBinaryOperation(buffer, target, debug) {
                 return v;
             }
             case 3: {
+                if (version === 2) {
+                    return readUvarint();
+                }
                 const v =
                     (view.getUint8(offset) << 16) |
                     (view.getUint8(offset + 1) << 8) |
@@ -53,11 +70,24 @@ export function applyBinaryOperation(buffer, target, debug) {
         }
     }
 
-    function readJSON(sizeIndicator) {
+    function readUvarint() {
+        let value = 0;
+        let shift = 1;
+        for (;;) {
+            const b = view.getUint8(offset++);
+            value += (b & 0x7f) * shift;
+            if ((b & 0x80) === 0) {
+                return value;
+            }
+            shift *= 128;
+        }
+    }
+
+    function readValue(sizeIndicator) {
         const dataLen = readSizedInt(sizeIndicator);
         const bytes = new Uint8Array(buffer.buffer, buffer.byteOffset + offset, dataLen);
         offset += dataLen;
-        return JSON.parse(new TextDecoder().decode(bytes));
+        return codec.decode(bytes);
     }
 
     //
@@ -77,13 +107,13 @@ export function applyBinaryOperation(buffer, target, debug) {
                     return;
 
                 case 0b01: { // UPDATE
-                    const value = readJSON(dataSize);
+                    const value = readValue(dataSize);
                     target[pos] = value;
                     return;
                 }
 
                 case 0b11: { // INSERT
-                    const value = readJSON(dataSize);
+                    const value = readValue(dataSize);
 
                     // Ensure the array is long enough
                     if (pos > target.length) {
@@ -93,11 +123,19 @@ export function applyBinaryOperation(buffer, target, debug) {
                     target.splice(pos, 0, value);
                     return;
                 }
+
+                case 0b10: { // MOVE
+                    const to = readSizedInt(posSize);
+                    if (pos < target.length) {
+                        moveItems(target, pos, 1, to);
+                    }
+                    return;
+                }
             }
         }
 
         // ---- PARTIAL UPDATE (non-bulk) ----
-        const patch = readJSON(dataSize);
+        const patch = readValue(dataSize);
         applyPartialPatch(target, pos, patch);
         return;
     }
@@ -111,6 +149,10 @@ export function applyBinaryOperation(buffer, target, debug) {
     let end   = readSizedInt(posSize);
     let count = (end - start) + 1;
 
+    if (op === 0b11 && partial) {
+        throw new Error("Compressed bulk insert, inflate it first with inflateOperation");
+    }
+
     // ----------------------------------------------------
     // Bulk Delete (no partial mode)
     // ----------------------------------------------------
@@ -119,6 +161,17 @@ export function applyBinaryOperation(buffer, target, debug) {
         return;
     }
 
+    // ----------------------------------------------------
+    // Bulk Move (no partial mode)
+    // ----------------------------------------------------
+    if (op === 0b10 && !partial) {
+        const to = readSizedInt(posSize);
+        if (start < target.length) {
+            moveItems(target, start, count, to);
+        }
+        return;
+    }
+
     // ----------------------------------------------------
     // Bulk FULL Update / Insert (dense update)
     // ----------------------------------------------------
@@ -127,7 +180,7 @@ export function applyBinaryOperation(buffer, target, debug) {
             case 0b01: { // FULL BULK UPDATE
                 if (end >= target.length) target.length = end + 1;
                 for (let i = start; i <= end; i++) {
-                    target[i] = readJSON(dataSize);
+                    target[i] = readValue(dataSize);
                 }
                 return;
             }
@@ -135,7 +188,7 @@ export function applyBinaryOperation(buffer, target, debug) {
             case 0b11: { // FULL BULK INSERT
                 const values = new Array(count);
                 for (let i = 0; i < count; i++) {
-                    values[i] = readJSON(dataSize);
+                    values[i] = readValue(dataSize);
                 }
                 if (start > target.length) {
                     target.length = start;
@@ -153,17 +206,32 @@ export function applyBinaryOperation(buffer, target, debug) {
     //
     while (offset < view.byteLength) {
         const innerPos = readSizedInt(posSize);
-        const patch = readJSON(dataSize);
+        const patch = readValue(dataSize);
         applyPartialPatch(target, innerPos, patch);
     }
 }
 
 
+// ===================================================================
+//  Utility: move items, the target position counts after removal
+// ===================================================================
+function moveItems(target, start, count, to) {
+    const items = target.splice(start, count);
+    target.splice(Math.min(to, target.length), 0, ...items);
+}
+
+
 // ===================================================================
 //  Utility: apply a partial patch to array or object
 // ===================================================================
 function applyPartialPatch(target, posOrKey, patchValue) {
     if (Array.isArray(target)) {
+        // Objects receive only the changed keys, anything else is replaced
+        const current = target[posOrKey];
+        if (isPlainObject(current) && isPlainObject(patchValue)) {
+            Object.assign(current, patchValue);
+            return;
+        }
         target[posOrKey] = patchValue;
         return;
     }
@@ -175,3 +243,7 @@ function applyPartialPatch(target, posOrKey, patchValue) {
 
     throw new Error("Partial patch applied on a non-container type");
 }
+
+function isPlainObject(v) {
+    return v !== null && typeof v === "object" && !Array.isArray(v);
+}
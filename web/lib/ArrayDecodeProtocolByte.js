@@ -1,10 +1,18 @@
 //
 //  Binary Decoder and Array/Object Transformer Protocol (Byte-based)
 //
+//  codec decodes the payloads, the one of the connection (JSON by default)
+//
+//  A compressed bulk INSERT (flag bit 2) must go through
+//  inflateOperationByte (Compress.js) first
+//
 
-export function applyBinaryOperationByte(buffer, target, debug) {
+import { json } from "./Codec.js";
+
+export function applyBinaryOperationByte(buffer, target, debug, codec) {
     const view = new DataView(buffer.buffer, buffer.byteOffset, buffer.length);
     debug = debug || false;
+    codec = codec || json;
     let offset = 0;
 
     if (debug) {
@@ -19,6 +27,9 @@ export function applyBinaryOperationByte(buffer, target, debug) {
     
     const bulk = (flags & 1) !== 0;
     const partial = (flags & 2) !== 0;
+    if ((flags & 4) !== 0) {
+        throw new Error("Compressed bulk insert, inflate it first with inflateOperationByte");
+    }
 
 
     if (debug) {
@@ -48,14 +59,20 @@ export function applyBinaryOperationByte(buffer, target, debug) {
                 offset += 3;
                 return v;
             }
+            case 4: {
+                const v = view.getUint32(offset, false);
+                offset += 4;
+                return v;
+            }
         }
+        throw new Error("Invalid size indicator " + size);
     }
 
-    function readJSON(sizeIndicator) {
+    function readValue(sizeIndicator) {
         const dataLen = readSizedInt(sizeIndicator);
         const bytes = new Uint8Array(buffer.buffer, buffer.byteOffset + offset, dataLen);
         offset += dataLen;
-        return JSON.parse(new TextDecoder().decode(bytes));
+        return codec.decode(bytes);
     }
 
     //
@@ -75,13 +92,13 @@ export function applyBinaryOperationByte(buffer, target, debug) {
                     return;
 
                 case 0b01: { // UPDATE
-                    const value = readJSON(dataSize);
+                    const value = readValue(dataSize);
                     target[pos] = value;
                     return;
                 }
 
                 case 0b11: { // INSERT
-                    const value = readJSON(dataSize);
+                    const value = readValue(dataSize);
 
                     // Ensure the array is long enough
                     if (pos > target.length) {
@@ -91,11 +108,19 @@ export function applyBinaryOperationByte(buffer, target, debug) {
                     target.splice(pos, 0, value);
                     return;
                 }
+
+                case 0b10: { // MOVE
+                    const to = readSizedInt(posSize);
+                    if (pos < target.length) {
+                        moveItems(target, pos, 1, to);
+                    }
+                    return;
+                }
             }
         }
 
         // ---- PARTIAL UPDATE (non-bulk) ----
-        const patch = readJSON(dataSize);
+        const patch = readValue(dataSize);
         applyPartialPatch(target, pos, patch);
         return;
     }
@@ -117,6 +142,17 @@ export function applyBinaryOperationByte(buffer, target, debug) {
         return;
     }
 
+    // ----------------------------------------------------
+    // Bulk Move (no partial mode)
+    // ----------------------------------------------------
+    if (op === 0b10 && !partial) {
+        const to = readSizedInt(posSize);
+        if (start < target.length) {
+            moveItems(target, start, count, to);
+        }
+        return;
+    }
+
     // ----------------------------------------------------
     // Bulk FULL Update / Insert (dense update)
     // ----------------------------------------------------
@@ -125,7 +161,7 @@ export function applyBinaryOperationByte(buffer, target, debug) {
             case 0b01: { // FULL BULK UPDATE
                 if (end >= target.length) target.length = end + 1;
                 for (let i = start; i <= end; i++) {
-                    target[i] = readJSON(dataSize);
+                    target[i] = readValue(dataSize);
                 }
                 return;
             }
@@ -133,7 +169,7 @@ export function applyBinaryOperationByte(buffer, target, debug) {
             case 0b11: { // FULL BULK INSERT
                 const values = new Array(count);
                 for (let i = 0; i < count; i++) {
-                    values[i] = readJSON(dataSize);
+                    values[i] = readValue(dataSize);
                 }
                 if (start > target.length) {
                     target.length = start;
@@ -151,17 +187,32 @@ export function applyBinaryOperationByte(buffer, target, debug) {
     //
     while (offset < view.byteLength) {
         const innerPos = readSizedInt(posSize);
-        const patch = readJSON(dataSize);
+        const patch = readValue(dataSize);
         applyPartialPatch(target, innerPos, patch);
     }
 }
 
 
+// ===================================================================
+//  Utility: move items, the target position counts after removal
+// ===================================================================
+function moveItems(target, start, count, to) {
+    const items = target.splice(start, count);
+    target.splice(Math.min(to, target.length), 0, ...items);
+}
+
+
 // ===================================================================
 //  Utility: apply a partial patch to array or object
 // ===================================================================
 function applyPartialPatch(target, posOrKey, patchValue) {
     if (Array.isArray(target)) {
+        // Objects receive only the changed keys, anything else is replaced
+        const current = target[posOrKey];
+        if (isPlainObject(current) && isPlainObject(patchValue)) {
+            Object.assign(current, patchValue);
+            return;
+        }
         target[posOrKey] = patchValue;
         return;
     }
@@ -173,3 +224,7 @@ function applyPartialPatch(target, posOrKey, patchValue) {
 
     throw new Error("Partial patch applied on a non-container type");
 }
+
+function isPlainObject(v) {
+    return v !== null && typeof v === "object" && !Array.isArray(v);
+}
@@ -0,0 +1,392 @@
+//
+//  Payload codecs
+//
+//  The codec encodes the payloads of a connection: RPC params and results,
+//  subscription data and the elements inside array operations. Frame
+//  layouts, headers and path keys stay the same with every codec.
+//
+//  The client offers codecs as WebSocket subprotocols in order of
+//  preference, the server answers with the one it picked and JSON is used
+//  when it answers none:
+//
+//      new WebSocket(url, codecProtocols(["msgpack", "json"]))
+//      const codec = codecFromProtocol(ws.protocol)
+//
+
+export const SUBPROTOCOL_PREFIX = "goreactivehtml.";
+
+// Offered like a codec, selects text frames holding JSON envelopes instead
+// of binary frames. The payloads are JSON.
+export const TEXT_PROTOCOL = SUBPROTOCOL_PREFIX + "text";
+
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder();
+
+export const json = {
+    name: "json",
+    encode(value) {
+        return textEncoder.encode(JSON.stringify(value));
+    },
+    decode(bytes) {
+        return JSON.parse(textDecoder.decode(bytes));
+    },
+};
+
+export const msgpack = {
+    name: "msgpack",
+    encode(value) {
+        const w = writer();
+        encodeMsgpack(w, value);
+        return w.bytes();
+    },
+    decode(bytes) {
+        const r = reader(bytes);
+        const value = decodeMsgpack(r);
+        r.end();
+        return value;
+    },
+};
+
+export const cbor = {
+    name: "cbor",
+    encode(value) {
+        const w = writer();
+        encodeCbor(w, value);
+        return w.bytes();
+    },
+    decode(bytes) {
+        const r = reader(bytes);
+        const value = decodeCbor(r);
+        r.end();
+        return value;
+    },
+};
+
+export const CODECS = { json, msgpack, cbor };
+
+// codecProtocols returns the subprotocols offering the named codecs
+export function codecProtocols(names) {
+    return names.map((name) => SUBPROTOCOL_PREFIX + name);
+}
+
+// codecFromProtocol returns the codec the server answered with, JSON when
+// it answered none
+export function codecFromProtocol(protocol) {
+    if (protocol && protocol.startsWith(SUBPROTOCOL_PREFIX)) {
+        const codec = CODECS[protocol.slice(SUBPROTOCOL_PREFIX.length)];
+        if (codec) {
+            return codec;
+        }
+    }
+    return json;
+}
+
+
+// ===================================================================
+//  Byte writer and reader
+// ===================================================================
+function writer() {
+    let buf = new Uint8Array(64);
+    let view = new DataView(buf.buffer);
+    let len = 0;
+
+    function grow(n) {
+        if (len + n <= buf.length) {
+            return;
+        }
+        const next = new Uint8Array(Math.max(buf.length * 2, len + n));
+        next.set(buf);
+        buf = next;
+        view = new DataView(buf.buffer);
+    }
+
+    return {
+        u8(v) { grow(1); buf[len++] = v; },
+        u16(v) { grow(2); view.setUint16(len, v); len += 2; },
+        u32(v) { grow(4); view.setUint32(len, v); len += 4; },
+        u64(v) { grow(8); view.setBigUint64(len, BigInt(v)); len += 8; },
+        i64(v) { grow(8); view.setBigInt64(len, BigInt(v)); len += 8; },
+        f64(v) { grow(8); view.setFloat64(len, v); len += 8; },
+        raw(bytes) { grow(bytes.length); buf.set(bytes, len); len += bytes.length; },
+        bytes() { return buf.slice(0, len); },
+    };
+}
+
+function reader(bytes) {
+    const view = new DataView(bytes.buffer, bytes.byteOffset, bytes.length);
+    let offset = 0;
+
+    function need(n) {
+        if (offset + n > bytes.length) {
+            throw new Error("Unexpected end of payload at " + offset);
+        }
+    }
+
+    return {
+        u8() { need(1); return view.getUint8(offset++); },
+        u16() { need(2); const v = view.getUint16(offset); offset += 2; return v; },
+        u32() { need(4); const v = view.getUint32(offset); offset += 4; return v; },
+        u64() { need(8); const v = view.getBigUint64(offset); offset += 8; return toNumber(v); },
+        i8() { need(1); return view.getInt8(offset++); },
+        i16() { need(2); const v = view.getInt16(offset); offset += 2; return v; },
+        i32() { need(4); const v = view.getInt32(offset); offset += 4; return v; },
+        i64() { need(8); const v = view.getBigInt64(offset); offset += 8; return toNumber(v); },
+        f16() { need(2); const v = half(view.getUint16(offset)); offset += 2; return v; },
+        f32() { need(4); const v = view.getFloat32(offset); offset += 4; return v; },
+        f64() { need(8); const v = view.getFloat64(offset); offset += 8; return v; },
+        raw(n) { need(n); const v = bytes.subarray(offset, offset + n); offset += n; return v; },
+        str(n) { return textDecoder.decode(this.raw(n)); },
+        end() {
+            if (offset !== bytes.length) {
+                throw new Error((bytes.length - offset) + " trailing bytes in payload");
+            }
+        },
+    };
+}
+
+// 64 bit integers are numbers when they are safe, BigInt otherwise
+function toNumber(v) {
+    return v >= BigInt(Number.MIN_SAFE_INTEGER) && v <= BigInt(Number.MAX_SAFE_INTEGER) ? Number(v) : v;
+}
+
+function half(bits) {
+    const sign = bits & 0x8000 ? -1 : 1;
+    const exp = (bits >> 10) & 0x1f;
+    const frac = bits & 0x3ff;
+    if (exp === 0) {
+        return sign * Math.pow(2, -14) * (frac / 1024);
+    }
+    if (exp === 0x1f) {
+        return frac ? NaN : sign * Infinity;
+    }
+    return sign * Math.pow(2, exp - 15) * (1 + frac / 1024);
+}
+
+function isObject(v) {
+    return v !== null && typeof v === "object";
+}
+
+
+// ===================================================================
+//  MessagePack
+// ===================================================================
+function encodeMsgpack(w, v) {
+    if (v === null || v === undefined) {
+        w.u8(0xc0);
+    } else if (v === false || v === true) {
+        w.u8(v ? 0xc3 : 0xc2);
+    } else if (typeof v === "number" && Number.isInteger(v) && Number.isSafeInteger(v)) {
+        if (v >= 0) {
+            if (v < 0x80) { w.u8(v); }
+            else if (v <= 0xff) { w.u8(0xcc); w.u8(v); }
+            else if (v <= 0xffff) { w.u8(0xcd); w.u16(v); }
+            else if (v <= 0xffffffff) { w.u8(0xce); w.u32(v); }
+            else { w.u8(0xcf); w.u64(v); }
+        } else {
+            if (v >= -32) { w.u8(v & 0xff); }
+            else if (v >= -0x80) { w.u8(0xd0); w.u8(v & 0xff); }
+            else if (v >= -0x8000) { w.u8(0xd1); w.u16(v & 0xffff); }
+            else if (v >= -0x80000000) { w.u8(0xd2); w.u32(v >>> 0); }
+            else { w.u8(0xd3); w.i64(v); }
+        }
+    } else if (typeof v === "number") {
+        w.u8(0xcb);
+        w.f64(v);
+    } else if (typeof v === "bigint") {
+        if (v >= 0n) { w.u8(0xcf); w.u64(v); } else { w.u8(0xd3); w.i64(v); }
+    } else if (typeof v === "string") {
+        const bytes = textEncoder.encode(v);
+        const n = bytes.length;
+        if (n < 32) { w.u8(0xa0 | n); }
+        else if (n <= 0xff) { w.u8(0xd9); w.u8(n); }
+        else if (n <= 0xffff) { w.u8(0xda); w.u16(n); }
+        else { w.u8(0xdb); w.u32(n); }
+        w.raw(bytes);
+    } else if (v instanceof Uint8Array) {
+        const n = v.length;
+        if (n <= 0xff) { w.u8(0xc4); w.u8(n); }
+        else if (n <= 0xffff) { w.u8(0xc5); w.u16(n); }
+        else { w.u8(0xc6); w.u32(n); }
+        w.raw(v);
+    } else if (Array.isArray(v)) {
+        const n = v.length;
+        if (n < 16) { w.u8(0x90 | n); }
+        else if (n <= 0xffff) { w.u8(0xdc); w.u16(n); }
+        else { w.u8(0xdd); w.u32(n); }
+        v.forEach((e) => encodeMsgpack(w, e));
+    } else if (isObject(v)) {
+        const keys = Object.keys(v).filter((k) => v[k] !== undefined);
+        const n = keys.length;
+        if (n < 16) { w.u8(0x80 | n); }
+        else if (n <= 0xffff) { w.u8(0xde); w.u16(n); }
+        else { w.u8(0xdf); w.u32(n); }
+        keys.forEach((k) => {
+            encodeMsgpack(w, k);
+            encodeMsgpack(w, v[k]);
+        });
+    } else {
+        throw new Error("Cannot encode " + typeof v + " as msgpack");
+    }
+}
+
+function decodeMsgpack(r) {
+    const b = r.u8();
+
+    if (b < 0x80) return b;
+    if (b >= 0xe0) return b - 0x100;
+    if ((b & 0xf0) === 0x80) return msgpackMap(r, b & 0x0f);
+    if ((b & 0xf0) === 0x90) return msgpackArray(r, b & 0x0f);
+    if ((b & 0xe0) === 0xa0) return r.str(b & 0x1f);
+
+    switch (b) {
+        case 0xc0: return null;
+        case 0xc2: return false;
+        case 0xc3: return true;
+        case 0xc4: return r.raw(r.u8()).slice();
+        case 0xc5: return r.raw(r.u16()).slice();
+        case 0xc6: return r.raw(r.u32()).slice();
+        case 0xca: return r.f32();
+        case 0xcb: return r.f64();
+        case 0xcc: return r.u8();
+        case 0xcd: return r.u16();
+        case 0xce: return r.u32();
+        case 0xcf: return r.u64();
+        case 0xd0: return r.i8();
+        case 0xd1: return r.i16();
+        case 0xd2: return r.i32();
+        case 0xd3: return r.i64();
+        case 0xd9: return r.str(r.u8());
+        case 0xda: return r.str(r.u16());
+        case 0xdb: return r.str(r.u32());
+        case 0xdc: return msgpackArray(r, r.u16());
+        case 0xdd: return msgpackArray(r, r.u32());
+        case 0xde: return msgpackMap(r, r.u16());
+        case 0xdf: return msgpackMap(r, r.u32());
+    }
+    throw new Error("Unsupported msgpack type 0x" + b.toString(16));
+}
+
+function msgpackArray(r, n) {
+    const out = new Array(n);
+    for (let i = 0; i < n; i++) {
+        out[i] = decodeMsgpack(r);
+    }
+    return out;
+}
+
+function msgpackMap(r, n) {
+    const out = {};
+    for (let i = 0; i < n; i++) {
+        const key = decodeMsgpack(r);
+        out[String(key)] = decodeMsgpack(r);
+    }
+    return out;
+}
+
+
+// ===================================================================
+//  CBOR
+// ===================================================================
+function cborHead(w, major, n) {
+    const m = major << 5;
+    if (typeof n === "bigint" || n > 0xffffffff) { w.u8(m | 27); w.u64(n); }
+    else if (n < 24) { w.u8(m | n); }
+    else if (n <= 0xff) { w.u8(m | 24); w.u8(n); }
+    else if (n <= 0xffff) { w.u8(m | 25); w.u16(n); }
+    else { w.u8(m | 26); w.u32(n); }
+}
+
+function encodeCbor(w, v) {
+    if (v === null || v === undefined) {
+        w.u8(0xf6);
+    } else if (v === false || v === true) {
+        w.u8(v ? 0xf5 : 0xf4);
+    } else if (typeof v === "number" && Number.isInteger(v) && Number.isSafeInteger(v)) {
+        if (v >= 0) { cborHead(w, 0, v); } else { cborHead(w, 1, -1 - v); }
+    } else if (typeof v === "number") {
+        w.u8(0xfb);
+        w.f64(v);
+    } else if (typeof v === "bigint") {
+        if (v >= 0n) { cborHead(w, 0, v); } else { cborHead(w, 1, -1n - v); }
+    } else if (typeof v === "string") {
+        const bytes = textEncoder.encode(v);
+        cborHead(w, 3, bytes.length);
+        w.raw(bytes);
+    } else if (v instanceof Uint8Array) {
+        cborHead(w, 2, v.length);
+        w.raw(v);
+    } else if (Array.isArray(v)) {
+        cborHead(w, 4, v.length);
+        v.forEach((e) => encodeCbor(w, e));
+    } else if (isObject(v)) {
+        const keys = Object.keys(v).filter((k) => v[k] !== undefined);
+        cborHead(w, 5, keys.length);
+        keys.forEach((k) => {
+            encodeCbor(w, k);
+            encodeCbor(w, v[k]);
+        });
+    } else {
+        throw new Error("Cannot encode " + typeof v + " as cbor");
+    }
+}
+
+function cborArgument(r, info) {
+    if (info < 24) return info;
+    switch (info) {
+        case 24: return r.u8();
+        case 25: return r.u16();
+        case 26: return r.u32();
+        case 27: return r.u64();
+    }
+    throw new Error("Unsupported cbor length " + info);
+}
+
+function decodeCbor(r) {
+    const b = r.u8();
+    const major = b >> 5;
+    const info = b & 0x1f;
+
+    if (major === 7) {
+        switch (info) {
+            case 20: return false;
+            case 21: return true;
+            case 22: return null;
+            case 23: return undefined;
+            case 25: return r.f16();
+            case 26: return r.f32();
+            case 27: return r.f64();
+        }
+        throw new Error("Unsupported cbor simple value " + info);
+    }
+
+    const n = cborArgument(r, info);
+    switch (major) {
+        case 0:
+            return n;
+        case 1:
+            return typeof n === "bigint" ? -1n - n : -1 - n;
+        case 2:
+            return r.raw(Number(n)).slice();
+        case 3:
+            return r.str(Number(n));
+        case 4: {
+            const out = new Array(Number(n));
+            for (let i = 0; i < out.length; i++) {
+                out[i] = decodeCbor(r);
+            }
+            return out;
+        }
+        case 5: {
+            const out = {};
+            for (let i = 0; i < n; i++) {
+                const key = decodeCbor(r);
+                out[String(key)] = decodeCbor(r);
+            }
+            return out;
+        }
+        case 6:
+            // tags are not used by the server, keep the tagged value
+            return decodeCbor(r);
+    }
+    throw new Error("Unsupported cbor major type " + major);
+}
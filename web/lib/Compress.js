@@ -0,0 +1,68 @@
+//
+//  Compressed bulk inserts
+//
+//  A bulk INSERT may carry its payloads as raw DEFLATE when the client
+//  offered CAPABILITIES.DEFLATE in the HELLO. Inflating is asynchronous in
+//  the browser, so it is done before the operation is applied:
+//
+//      const plain = await inflateOperation(buffer, version)
+//      applyBinaryOperation(plain, target, false, version, codec)
+//
+//  inflate defaults to DecompressionStream("deflate-raw"), which knows no
+//  dictionary. When the server announces one in welcome.dictionary pass a
+//  function inflating with it (pako's inflateRaw with { dictionary }).
+//
+
+export async function inflateRaw(bytes) {
+    const stream = new Blob([bytes]).stream().pipeThrough(new DecompressionStream("deflate-raw"));
+    return new Uint8Array(await new Response(stream).arrayBuffer());
+}
+
+// Bit protocol: bulk INSERT with the partial bit set. Returns the same
+// operation uncompressed, any other operation as is.
+export async function inflateOperation(buffer, version, inflate) {
+    const header = buffer[0];
+    const op      = header & 0b11;
+    const posSize = (header >> 2) & 0b11;
+    const partial = (header >> 6) & 1;
+    const bulk    = (header >> 7) & 1;
+    if (!bulk || !partial || op !== 0b11) {
+        return buffer;
+    }
+
+    let offset = 1;
+    offset += sizedIntLength(buffer, offset, posSize, version || 1);
+    offset += sizedIntLength(buffer, offset, posSize, version || 1);
+    return inflated(buffer, offset, header & ~(1 << 6), 0, inflate);
+}
+
+// Byte protocol: bulk INSERT with flag bit 2 set
+export async function inflateOperationByte(buffer, inflate) {
+    const flags = buffer[1];
+    if ((flags & 4) === 0) {
+        return buffer;
+    }
+    const posSize = buffer[2];
+    return inflated(buffer, 4 + 2 * posSize, flags & ~4, 1, inflate);
+}
+
+async function inflated(buffer, offset, cleared, at, inflate) {
+    const plain = await (inflate || inflateRaw)(buffer.subarray(offset));
+    const out = new Uint8Array(offset + plain.length);
+    out.set(buffer.subarray(0, offset));
+    out[at] = cleared;
+    out.set(plain, offset);
+    return out;
+}
+
+// Bytes of an integer of size class size, a uvarint for 3 in version 2
+function sizedIntLength(buffer, offset, size, version) {
+    if (size !== 3 || version !== 2) {
+        return size;
+    }
+    let n = 1;
+    while (buffer[offset + n - 1] & 0x80) {
+        n++;
+    }
+    return n;
+}
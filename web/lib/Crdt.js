@@ -0,0 +1,211 @@
+//
+//  Replicated data types of the state edited by several users at once,
+//  the same merge as internal/server/state/crdt so every replica ends with
+//  the same value whatever order it received the operations in
+//
+//  id: {counter, replica}, the Lamport clock of the replica that made the
+//  operation; the greater id wins (counter, then replica)
+//
+//  Sequence (lists and text): {id, after, value, delete}
+//      inserts value after the element after (zero id for the start), or
+//      with delete removes the element id. Elements inserted after the
+//      same one are ordered by descending id.
+//
+//  LWWMap: {key, id, value, delete}
+//      the key holds the value of the operation with the greatest id
+//
+
+const ZERO = { counter: 0, replica: "" };
+
+export function compareIds(a, b) {
+    if (a.counter !== b.counter) return a.counter < b.counter ? -1 : 1;
+    if (a.replica === b.replica) return 0;
+    return a.replica < b.replica ? -1 : 1;
+}
+
+function isZero(id) {
+    return !id || (id.counter === 0 && id.replica === "");
+}
+
+function idKey(id) {
+    return id.counter + "@" + id.replica;
+}
+
+function valid(id) {
+    return id && id.counter > 0 && typeof id.replica === "string" && id.replica !== "";
+}
+
+// Random name of the replica of this client
+export function randomReplica() {
+    return "c" + Math.random().toString(36).slice(2) + Date.now().toString(36);
+}
+
+export class Sequence {
+    constructor(replica) {
+        this.replica = replica;
+        this.counter = 0;
+        this.elems = [];
+        this.known = new Set();
+        this.pending = [];
+    }
+
+    values() {
+        return this.elems.filter(e => !e.deleted).map(e => e.value);
+    }
+
+    get length() {
+        return this.elems.reduce((n, e) => e.deleted ? n : n + 1, 0);
+    }
+
+    // Inserts the values at the index of the elements not deleted, returns
+    // the operations to send
+    insert(index, values) {
+        let after = index > 0 ? this.elems[this.position(index - 1)].id : ZERO;
+        const ops = [];
+        for (const value of values) {
+            const op = { id: this.next(), after: after, value: value, delete: false };
+            this.applyReady(op);
+            ops.push(op);
+            after = op.id;
+        }
+        return ops;
+    }
+
+    // Removes n elements from the index, returns the operations to send
+    delete(index, n) {
+        const ids = [];
+        for (let i = 0; i < n; i++) {
+            ids.push(this.elems[this.position(index + i)].id);
+        }
+        return ids.map(id => {
+            const op = { id: id, after: ZERO, value: null, delete: true };
+            this.applyReady(op);
+            return op;
+        });
+    }
+
+    // Merges the operations of another replica, returns the ones that
+    // changed the sequence. Operations referring to elements not received
+    // yet wait for them.
+    apply(ops) {
+        for (const op of ops) {
+            if (!valid(op.id) || !(isZero(op.after) || valid(op.after))) {
+                throw new Error("Operation without a valid id");
+            }
+        }
+        const applied = [];
+        let waiting = this.pending.concat(ops);
+        for (let progress = true; progress;) {
+            progress = false;
+            const rest = [];
+            for (const op of waiting) {
+                if (!this.ready(op)) {
+                    rest.push(op);
+                } else if (this.applyReady(op)) {
+                    applied.push(op);
+                    progress = true;
+                }
+            }
+            waiting = rest;
+        }
+        this.pending = waiting;
+        return applied;
+    }
+
+    ready(op) {
+        if (op.delete) return this.known.has(idKey(op.id));
+        return isZero(op.after) || this.known.has(idKey(op.after));
+    }
+
+    applyReady(op) {
+        this.counter = Math.max(this.counter, op.id.counter);
+        if (op.delete) {
+            const e = this.elems[this.find(op.id)];
+            if (e.deleted) return false;
+            e.deleted = true;
+            return true;
+        }
+        if (this.known.has(idKey(op.id))) return false;
+
+        let i = isZero(op.after) ? 0 : this.find(op.after) + 1;
+        // Skip the elements inserted after the same one with a greater id,
+        // and theirs
+        while (i < this.elems.length && compareIds(this.elems[i].id, op.id) > 0) i++;
+        this.elems.splice(i, 0, { id: op.id, value: op.value, deleted: false });
+        this.known.add(idKey(op.id));
+        return true;
+    }
+
+    next() {
+        this.counter++;
+        return { counter: this.counter, replica: this.replica };
+    }
+
+    find(id) {
+        const i = this.elems.findIndex(e => e.id.counter === id.counter && e.id.replica === id.replica);
+        if (i < 0) throw new Error("Element not found");
+        return i;
+    }
+
+    position(index) {
+        for (let i = 0; i < this.elems.length; i++) {
+            if (this.elems[i].deleted) continue;
+            if (index === 0) return i;
+            index--;
+        }
+        throw new Error("Index out of range");
+    }
+}
+
+export class LWWMap {
+    constructor(replica) {
+        this.replica = replica;
+        this.counter = 0;
+        this.registers = new Map();
+    }
+
+    get(key) {
+        const r = this.registers.get(key);
+        return r && !r.deleted ? r.value : undefined;
+    }
+
+    values() {
+        const values = {};
+        for (const [key, r] of this.registers) {
+            if (!r.deleted) values[key] = r.value;
+        }
+        return values;
+    }
+
+    // Stores the value of the key, returns the operation to send
+    set(key, value) {
+        this.counter++;
+        const op = { key: key, id: { counter: this.counter, replica: this.replica }, value: value, delete: false };
+        this.applyOne(op);
+        return op;
+    }
+
+    // Removes the key, returns the operation to send
+    delete(key) {
+        this.counter++;
+        const op = { key: key, id: { counter: this.counter, replica: this.replica }, value: null, delete: true };
+        this.applyOne(op);
+        return op;
+    }
+
+    // Merges the operations of another replica, returns the ones that won
+    apply(ops) {
+        for (const op of ops) {
+            if (!valid(op.id)) throw new Error("Operation without a valid id");
+        }
+        return ops.filter(op => this.applyOne(op));
+    }
+
+    applyOne(op) {
+        this.counter = Math.max(this.counter, op.id.counter);
+        const r = this.registers.get(op.key);
+        if (r && compareIds(r.id, op.id) >= 0) return false;
+        this.registers.set(op.key, { id: op.id, value: op.delete ? null : op.value, deleted: op.delete });
+        return true;
+    }
+}
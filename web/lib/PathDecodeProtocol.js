@@ -0,0 +1,244 @@
+//
+//  Binary Decoder for key and path addressed operations
+//
+//  header (1 byte): bits 0-2 operation, bits 3-7 flags
+//      000 SET     value stored at the path
+//      001 DELETE  removes the key or the array element
+//      010 MERGE   object merged into the object at the path
+//      011 ARRAY   array protocol message applied to the array at the path
+//      100 BATCH   no path, uvarint count then every operation as
+//                  uvarint length + message, bit 3 transactional
+//
+//  path: uvarint count, then one uvarint per segment, kind in the low 2 bits
+//      00 index   01 interned key id   10 key bytes   11 key bytes, interned
+//
+
+import { applyBinaryOperation } from "./ArrayDecodeProtocol.js";
+import { applyBinaryOperationByte } from "./ArrayDecodeProtocolByte.js";
+import { json } from "./Codec.js";
+
+const OP_SET = 0b000;
+const OP_DELETE = 0b001;
+const OP_MERGE = 0b010;
+const OP_ARRAY = 0b011;
+const OP_BATCH = 0b100;
+
+const OP_MASK = 0b111;
+const FLAG_TRANSACTIONAL = 0b1000;
+
+// One decoder per connection, it keeps the interned keys. arrayVersion is
+// the array protocol version of the connection, 1 by default, and codec
+// decodes the values, JSON by default. array is the array protocol the
+// server selected, "bit" by default or "byte".
+export function createPathDecoder(debug, arrayVersion, codec, array) {
+    const keys = [];
+    debug = debug || false;
+    codec = codec || json;
+
+    // decode reads one message, new keys are collected in added and
+    // interned by the caller once the whole message decoded
+    function decode(buffer, added, inBatch) {
+        let offset = 0;
+
+        function readUvarint() {
+            let value = 0;
+            let shift = 1;
+            for (;;) {
+                if (offset >= buffer.length) {
+                    throw new Error("Unexpected end of message");
+                }
+                const b = buffer[offset++];
+                value += (b & 0x7f) * shift;
+                if ((b & 0x80) === 0) {
+                    return value;
+                }
+                shift *= 128;
+            }
+        }
+
+        if (buffer.length === 0) {
+            throw new Error("Empty message");
+        }
+        const op = buffer[offset] & OP_MASK;
+        const flags = buffer[offset++] & ~OP_MASK;
+        if (op > OP_BATCH) {
+            throw new Error("Unknown path operation " + op);
+        }
+
+        // ---- Batch ----
+        if (op === OP_BATCH) {
+            if (inBatch) {
+                throw new Error("Batch inside a batch");
+            }
+            if ((flags & ~FLAG_TRANSACTIONAL) !== 0) {
+                throw new Error("Reserved flags set in " + buffer[0]);
+            }
+            const count = readUvarint();
+            if (count === 0) {
+                throw new Error("Empty batch");
+            }
+            const ops = [];
+            for (let i = 0; i < count; i++) {
+                const len = readUvarint();
+                if (offset + len > buffer.length) {
+                    throw new Error("Operation " + i + " exceeds the batch");
+                }
+                ops.push(decode(buffer.subarray(offset, offset + len), added, true));
+                offset += len;
+            }
+            if (offset !== buffer.length) {
+                throw new Error("Trailing bytes in batch");
+            }
+            return { op, ops, transactional: flags !== 0 };
+        }
+        if (flags !== 0) {
+            throw new Error("Reserved flags set in " + buffer[0]);
+        }
+
+        // ---- Path ----
+        const count = readUvarint();
+        const path = [];
+        for (let i = 0; i < count; i++) {
+            const v = readUvarint();
+            const kind = v % 4;
+            const n = Math.floor(v / 4);
+
+            switch (kind) {
+                case 0b00:
+                    path.push(n);
+                    break;
+                case 0b01:
+                    if (n < keys.length) {
+                        path.push(keys[n]);
+                    } else if (n < keys.length + added.length) {
+                        path.push(added[n - keys.length]);
+                    } else {
+                        throw new Error("Unknown key id " + n);
+                    }
+                    break;
+                default: {
+                    if (offset + n > buffer.length) {
+                        throw new Error("Key exceeds the message");
+                    }
+                    const key = new TextDecoder().decode(buffer.subarray(offset, offset + n));
+                    offset += n;
+                    if (kind === 0b11) {
+                        added.push(key);
+                    }
+                    path.push(key);
+                }
+            }
+        }
+
+        return { op, path, body: buffer.subarray(offset) };
+    }
+
+    function applyOp(state, msg) {
+        const { op, path, body } = msg;
+
+        if (debug) {
+            console.log("op", op, "path", path, "body", body);
+        }
+
+        function readValue() {
+            return codec.decode(body);
+        }
+
+        switch (op) {
+            case OP_SET: {
+                const value = readValue();
+                return update(state, path, () => value);
+            }
+
+            case OP_DELETE: {
+                const last = path[path.length - 1];
+                return update(state, path.slice(0, -1), (parent) => remove(parent, last));
+            }
+
+            case OP_MERGE: {
+                const patch = readValue();
+                return update(state, path, (current) => {
+                    if (isPlainObject(current) && isPlainObject(patch)) {
+                        return Object.assign(current, patch);
+                    }
+                    return patch;
+                });
+            }
+
+            case OP_ARRAY:
+                return update(state, path, (current) => {
+                    const target = Array.isArray(current) ? current : [];
+                    if (array === "byte") {
+                        applyBinaryOperationByte(body, target, debug, codec);
+                    } else {
+                        applyBinaryOperation(body, target, debug, arrayVersion, codec);
+                    }
+                    return target;
+                });
+
+            case OP_BATCH: {
+                // a transactional batch works on a copy, state is untouched
+                // when one of its operations throws
+                let next = msg.transactional ? structuredClone(state) : state;
+                for (const sub of msg.ops) {
+                    next = applyOp(next, sub);
+                }
+                return next;
+            }
+        }
+
+        throw new Error("Unknown path operation " + op);
+    }
+
+    // apply returns the state, replaced when the path is empty. A batch is
+    // applied in full before apply returns, render once per call.
+    function apply(buffer, state) {
+        const added = [];
+        const msg = decode(buffer, added, false);
+        keys.push(...added);
+        return applyOp(state, msg);
+    }
+
+    return { apply, keys };
+}
+
+
+// ===================================================================
+//  Utility: replace the value at the path, creating missing containers
+// ===================================================================
+function update(node, path, fn) {
+    if (path.length === 0) {
+        return fn(node);
+    }
+
+    const segment = path[0];
+    if (typeof segment === "number") {
+        const arr = Array.isArray(node) ? node : [];
+        while (arr.length <= segment) {
+            arr.push(null);
+        }
+        arr[segment] = update(arr[segment], path.slice(1), fn);
+        return arr;
+    }
+
+    const obj = isPlainObject(node) ? node : {};
+    obj[segment] = update(obj[segment], path.slice(1), fn);
+    return obj;
+}
+
+function remove(parent, segment) {
+    if (typeof segment === "number") {
+        if (Array.isArray(parent) && segment < parent.length) {
+            parent.splice(segment, 1);
+        }
+        return parent;
+    }
+    if (isPlainObject(parent)) {
+        delete parent[segment];
+    }
+    return parent;
+}
+
+function isPlainObject(v) {
+    return v !== null && typeof v === "object" && !Array.isArray(v);
+}
@@ -1,3 +1,5 @@
+import { codecFromProtocol } from './Codec.js'
+
 class WebSocketEvents {
     ws;
     pendingRequests;
@@ -5,7 +7,11 @@ class WebSocketEvents {
 
     constructor(ws) {
         this.ws = ws;
+        // Frames are read byte by byte, not as Blob
+        this.ws.binaryType = "arraybuffer";
         this.subscriptions = {};
+        //last sequence number received per retained topic
+        this.lastSeq = {};
         //zero is reserved for all subscriptions requests
         this.pendingRequests = new Array(256);
         this.RequestId[0] = 0;
@@ -17,10 +23,16 @@ class WebSocketEvents {
     }
 
     getNextRequestId() {
-        while (this.RequestId[0] > 0 && this.pendingRequests[this.RequestId[0]] !== null){
+        for (let i = 0; i < 255; i++) {
             this.RequestId[0]++; // wrap around after 255
+            if (this.RequestId[0] === 0) {
+                this.RequestId[0] = 1;
+            }
+            if (!this.pendingRequests[this.RequestId[0]]) {
+                return this.RequestId[0];
+            }
         }
-        return this.RequestId[0];
+        throw new Error("All request ids are pending");
     }
 
     // Method to subscribe to a specific event (eventType could be MsgType, Destination, or other criteria)
@@ -52,7 +64,10 @@ class WebSocketEvents {
     }
 
     processBinaryResponse(event) {
-        let response = this.unmarshalBinaryResponse(event.data)
+        //Text frames hold JSON envelopes, on connections that asked for them
+        let response = typeof event.data === "string"
+            ? this.unmarshalTextResponse(event.data)
+            : this.unmarshalBinaryResponse(new Uint8Array(event.data))
         if (response.ReqId == 0) {
             // Trigger the subscription callbacks if applicable
             this.triggerSubscriptions(response);
@@ -107,10 +122,23 @@ class WebSocketEvents {
     */
     // Method to trigger all relevant subscriptions based on the response
     triggerSubscriptions(response) {
-        // Check if there are any subscriptions for the given response's eventType
-        const eventType = `${response.Destination}`;
-        
-        if (this.subscriptions[eventType]) {
+        // Destination is the published topic, subscriptions may be patterns
+        const topic = `${response.Destination}`;
+
+        // Retained topics carry a sequence number. Drop what was already
+        // received, like a replay overlapping a live message.
+        const seq = response.Header && response.Header.seq ? Number(response.Header.seq) : 0;
+        if (seq > 0) {
+            if (seq <= (this.lastSeq[topic] || 0)) {
+                return;
+            }
+            this.lastSeq[topic] = seq;
+        }
+
+        for (const eventType in this.subscriptions) {
+            if (!WebSocketEvents.topicMatches(eventType, topic)) {
+                continue;
+            }
             // Loop through all the callbacks for the given eventType and invoke them
             for (const callback of this.subscriptions[eventType]) {
                 callback(response);
@@ -118,6 +146,33 @@ class WebSocketEvents {
         }
     }
 
+    /*
+    Same rules as the Go topics.Trie:
+        +        any single segment
+        {name}   any single segment
+        <name>   any single segment
+        #        any remaining segments (last segment only)
+    */
+    static topicMatches(pattern, topic) {
+        const p = pattern.split('/');
+        const t = topic.split('/');
+
+        for (let i = 0; i < p.length; i++) {
+            const s = p[i];
+            if (s === '#' && i === p.length - 1) {
+                return true;
+            }
+            if (i >= t.length) {
+                return false;
+            }
+            const isParam = s.length > 2 && ((s[0] === '{' && s[s.length - 1] === '}') || (s[0] === '<' && s[s.length - 1] === '>'));
+            if (s !== '+' && !isParam && s !== t[i]) {
+                return false;
+            }
+        }
+        return p.length === t.length;
+    }
+
     /*
     Steps for Unmarshalling in JavaScript:
         Read ReqId (1 byte).
@@ -148,6 +203,21 @@ class WebSocketEvents {
         console.error("Error unmarshalling:", err);
     }
     */
+    /*
+    JSON envelope of a text frame:
+        {"reqId":3,"msgType":"S","destination":"tasks","data":{"id":1},"header":{"seq":"4"}}
+    */
+    unmarshalTextResponse(text) {
+        const envelope = JSON.parse(text);
+        return {
+            ReqId: envelope.reqId,
+            MsgType: envelope.msgType,
+            Destination: envelope.destination,
+            Data: envelope.data,
+            Header: envelope.header || null
+        };
+    }
+
     unmarshalBinaryResponse(binaryData) {
         let offset = 0;
 
@@ -166,17 +236,16 @@ class WebSocketEvents {
         // ReqId (1 byte) - now required (must be non-zero)
         let reqId = readByte();
 
-        // MsgType (1 byte for length + N bytes for content)
-        let msgTypeLen = readByte(); // 1 byte for length of MsgType
-        let msgType = new TextDecoder().decode(readBytes(msgTypeLen)); // Read the MsgType string
+        // MsgType (1 byte, 'S' or 'E')
+        let msgType = String.fromCharCode(readByte());
 
         // Destination (2 bytes for length + N bytes for content)
         let destLen = (readByte() << 8) | readByte(); // 2 bytes for length
         let destination = new TextDecoder().decode(readBytes(destLen));
 
-        // Data (4 bytes for length + N bytes for JSON serialized content)
+        // Data (4 bytes for length + N bytes encoded with the codec of the connection)
         let dataLen = (readByte() << 24) | (readByte() << 16) | (readByte() << 8) | readByte(); // 4 bytes for length
-        let data = JSON.parse(new TextDecoder().decode(readBytes(dataLen)));
+        let data = codecFromProtocol(this.ws.protocol).decode(readBytes(dataLen));
 
         // Header (2 bytes for length + N bytes for JSON serialized content)
         let headerLen = (readByte() << 8) | readByte(); // 2 bytes length
@@ -1,23 +1,257 @@
-import WebSocketEvents from './WebSocketEvents'
+import WebSocketEvents from './WebSocketEvents.js'
+import { codecProtocols, codecFromProtocol, TEXT_PROTOCOL } from './Codec.js'
+import { SUPPORTED_VERSIONS } from './ArrayDecodeProtocol.js'
+import { createPathDecoder } from './PathDecodeProtocol.js'
+import { Sequence, LWWMap, randomReplica } from './Crdt.js'
+
+//Version of the HELLO frame sent on open
+export const HELLO_VERSION = 1
+
+//Destination of the business state pushed after each RPC
+export const STATE_DESTINATION = "$$state"
+
+//Reserved RPC method of the writes of the business state, and its param
+//with the versions written against
+const SYNC_METHOD = "$$sync"
+const VERSIONS_PARAM = "$$versions"
+
+//Reserved RPC method of the operations of the replicated values, and the
+//key of the state where the server pushes them
+const OPS_METHOD = "$$ops"
+const CRDT_KEY = "$$crdt"
+
+//Capability flags of the HELLO frame
+export const CAPABILITIES = {
+    BIT: 1,     //ArrayDecodeProtocol.js
+    BYTE: 2,    //ArrayDecodeProtocolByte.js
+    PATH: 4,    //PathDecodeProtocol.js
+    DEFLATE: 8,
+}
 
 class WebSocketUtil {
-    constructor(url) {
+    //codecs are the payload codecs offered to the server in order of
+    //preference, e.g. ["msgpack", "json"]. JSON when none is agreed.
+    //["text"] asks for text frames with JSON envelopes, readable in the
+    //browser devtools.
+    constructor(url, codecs) {
         this.url = url
-        this.ws = new WebSocket(url);
+        this.ws = codecs && codecs.length ? new WebSocket(url, codecProtocols(codecs)) : new WebSocket(url);
         this.connected = false
+        //What the server selected in the handshake, see hello()
+        this.welcome = null
         this.ws.onopen = () => {
             this.connected = true
-            this.onopen && this.onopen()
+            this.hello().then(() => {
+                this.onopen && this.onopen()
+            }, (err) => {
+                this.onrefused && this.onrefused(err)
+            })
         }
 
         this.conn_type = {
             SUBSCRIBE:1,
             RPC:2,
-            ENDPOINT:3
+            ENDPOINT:3,
+            UNSUBSCRIBE:4,
+            HELLO:5
         }
 
         this.WebSocketEvents = new WebSocketEvents(this.ws)
         this.encoder = new TextEncoder();
+
+        //State of the business packages, {business: {name: value}}, kept
+        //up to date with the path operations the server pushes. onstate is
+        //called after each push.
+        this.state = {}
+        this.pathDecoder = null
+        //Replicas of the Text, List and Map values, {business: {name: replica}}
+        this.replica = randomReplica()
+        this.replicas = {}
+        this.WebSocketEvents.subscribe(STATE_DESTINATION, this.applyState.bind(this))
+    }
+
+    applyState(response) {
+        //Keys are interned per connection, the decoder lives as long as it
+        if (!this.pathDecoder) {
+            const arrayVersion = this.welcome ? this.welcome.arrayVersion : 1
+            const array = this.welcome ? this.welcome.array : "bit"
+            this.pathDecoder = createPathDecoder(false, arrayVersion, this.codec, array)
+        }
+        //Bytes are base64 in JSON payloads
+        const body = typeof response.Data === "string"
+            ? Uint8Array.from(atob(response.Data), (c) => c.charCodeAt(0))
+            : response.Data
+        this.state = this.pathDecoder.apply(body, this.state)
+        this.mergeOps()
+        this.onstate && this.onstate(this.state)
+    }
+
+    //Merges the operations pushed at $$crdt.business.name into the replicas,
+    //the state shows their values at business.name
+    mergeOps() {
+        const pushed = this.state[CRDT_KEY]
+        if (!pushed) {
+            return
+        }
+        delete this.state[CRDT_KEY]
+        for (const business in pushed) {
+            for (const name in pushed[business]) {
+                const { type, ops } = pushed[business][name]
+                this.replicaOf(business, name, type).apply(ops)
+                this.showReplica(business, name)
+            }
+        }
+    }
+
+    replicaOf(business, name, type) {
+        const replicas = this.replicas[business] = this.replicas[business] || {}
+        if (!replicas[name]) {
+            const crdt = type === "map" ? new LWWMap(this.replica) : new Sequence(this.replica)
+            replicas[name] = { type: type, crdt: crdt }
+        }
+        return replicas[name].crdt
+    }
+
+    showReplica(business, name) {
+        const { type, crdt } = this.replicas[business][name]
+        const values = this.state[business] = this.state[business] || {}
+        values[name] = type === "text" ? crdt.values().join("") : crdt.values()
+    }
+
+    //Edits a Text (inserted is a string) or a List (inserted is an array)
+    //of the business: removes deleteCount elements from the index and
+    //inserts there. It is shown at once, the server merges it with the
+    //edits of the others.
+    splice(business, name, index, deleteCount, inserted = []) {
+        const type = typeof inserted === "string" ? "text" : "list"
+        const crdt = this.replicaOf(business, name, type)
+        const ops = crdt.delete(index, deleteCount).concat(crdt.insert(index, Array.from(inserted)))
+        return this.sendOps(business, name, ops)
+    }
+
+    //Sets the key of a Map of the business
+    setKey(business, name, key, value) {
+        return this.sendOps(business, name, [this.replicaOf(business, name, "map").set(key, value)])
+    }
+
+    //Deletes the key of a Map of the business
+    deleteKey(business, name, key) {
+        return this.sendOps(business, name, [this.replicaOf(business, name, "map").delete(key)])
+    }
+
+    sendOps(business, name, ops) {
+        this.showReplica(business, name)
+        this.onstate && this.onstate(this.state)
+        if (ops.length === 0) {
+            return Promise.resolve()
+        }
+        return this.requestRPC(business, OPS_METHOD, {[name]: ops})
+    }
+
+    //Payload codec agreed with the server
+    get codec() {
+        return codecFromProtocol(this.ws.protocol)
+    }
+
+    //Frames are JSON envelopes in text frames
+    get text() {
+        return this.ws.protocol === TEXT_PROTOCOL
+    }
+
+    //JSON envelope of a frame, the text equivalent of the binary layouts
+    formatEnvelope(type, reqId, fields) {
+        return JSON.stringify(Object.assign({ type: type, reqId: reqId }, fields))
+    }
+
+    //First frame of the connection: tells the server which encodings this
+    //client decodes. A server that cannot serve it closes the connection
+    //with the reason.
+    hello(){
+        const reqId = this.WebSocketEvents.getNextRequestId()
+
+        const refused = new Promise((_, reject) => {
+            this.ws.addEventListener('close', (event) => {
+                reject(new Error("Server refused the client: " + event.reason))
+            }, { once: true })
+        })
+        const frame = this.text
+            ? this.formatEnvelope("hello", reqId, {
+                version: HELLO_VERSION,
+                reqIdSize: 1,
+                capabilities: CAPABILITIES.BIT | CAPABILITIES.BYTE | CAPABILITIES.PATH,
+                arrayVersions: SUPPORTED_VERSIONS,
+            })
+            : this.formatRequestHello(reqId)
+        const welcome = this.send(frame, reqId).then((response) => {
+            this.welcome = response.Data
+            return this.welcome
+        })
+        return Promise.race([welcome, refused])
+    }
+
+    formatRequestHello(reqId, capabilities = CAPABILITIES.BIT | CAPABILITIES.BYTE | CAPABILITIES.PATH) {
+        const message = new Uint8Array(1 + 1 + 1 + 1 + 4 + 1 + SUPPORTED_VERSIONS.length);
+        const view = new DataView(message.buffer);
+
+        message[0] = this.conn_type.HELLO;
+        message[1] = reqId;
+        message[2] = HELLO_VERSION;
+        // --- request ids of 1 byte
+        message[3] = 1;
+        view.setUint32(4, capabilities);
+        // --- array protocol versions decoded
+        message[8] = SUPPORTED_VERSIONS.length;
+        message.set(SUPPORTED_VERSIONS, 9);
+
+        return message;
+    }
+
+    //Receives the values of the business in state, then the shared ones
+    //the other users change
+    view(business){
+        return this.subscribe(STATE_DESTINATION + "/" + business, () => {})
+    }
+
+    //Writes a value of the business state. It is shown at once and sent
+    //with the version it was changed from: when the server changed it
+    //meanwhile the write is rejected and its value shown instead, other
+    //errors put the old value back. A later write is never undone.
+    syncValue(business, name, value){
+        const values = this.state[business] = this.state[business] || {}
+        const old = values[name]
+        values[name] = value
+        this.onstate && this.onstate(this.state)
+
+        const params = {[name]: value, [VERSIONS_PARAM]: {[name]: this.stateVersion(business, name)}}
+        return this.requestRPC(business, SYNC_METHOD, params).then((response) => {
+            this.setStateVersion(business, name, response.Data.versions[name])
+            return value
+        }, (response) => {
+            const current = this.state[business]
+            if (current && current[name] === value) {
+                const data = response && response.Data
+                if (data && data.values && name in data.values) {
+                    current[name] = data.values[name]
+                    this.setStateVersion(business, name, data.versions[name])
+                } else {
+                    current[name] = old
+                }
+                this.onstate && this.onstate(this.state)
+            }
+            throw response
+        })
+    }
+
+    //Version of a value pushed by the server at $$version.business.name
+    stateVersion(business, name){
+        const versions = this.state["$$version"] && this.state["$$version"][business]
+        return (versions && versions[name]) || 0
+    }
+
+    setStateVersion(business, name, version){
+        const versions = this.state["$$version"] = this.state["$$version"] || {}
+        versions[business] = versions[business] || {}
+        versions[business][name] = version
     }
 
     //Permanent listen connection
@@ -26,11 +260,34 @@ class WebSocketUtil {
 
         const reqId = this.WebSocketEvents.getNextRequestId()
 
-        return send(binaryData, reqId)
+        //After a reconnect only ask for the retained messages not received yet
+        const lastSeq = this.WebSocketEvents.lastSeq[destination]
+        if (lastSeq) {
+            header = Object.assign({}, header, {since: String(lastSeq)})
+        }
+
+        const binaryData = this.text
+            ? this.formatEnvelope("subscribe", reqId, { topic: destination, data: data, header: header })
+            : this.formatRequestSubscribe(reqId, destination, data, header)
+
+        return this.send(binaryData, reqId)
     }
 
     unsubscribe(destination, callback){
         this.WebSocketEvents.unsubscribe(destination, callback)
+
+        //Other callbacks still listen to this destination
+        if (this.WebSocketEvents.subscriptions[destination]) {
+            return Promise.resolve()
+        }
+
+        const reqId = this.WebSocketEvents.getNextRequestId()
+
+        const binaryData = this.text
+            ? this.formatEnvelope("unsubscribe", reqId, { topic: destination })
+            : this.formatRequestSubscribe(reqId, destination, null, {}, this.conn_type.UNSUBSCRIBE)
+
+        return this.send(binaryData, reqId)
     }
 
     //Like a client server request
@@ -45,9 +302,11 @@ class WebSocketUtil {
     requestRPC(bff,method,params,headers){
         const reqId = this.WebSocketEvents.getNextRequestId();
 
-        const binaryData = formatRequestRPC(reqId, bff, method, params, headers);
+        const binaryData = this.text
+            ? this.formatEnvelope("rpc", reqId, { class: bff, method: method, params: params, header: headers })
+            : this.formatRequestRPC(reqId, bff, method, params, headers);
 
-        return send(binaryData,reqId)
+        return this.send(binaryData,reqId)
     }
 
     formatRequestEndpoint(reqId, endpoint, operation, origin, data){
@@ -60,7 +319,7 @@ class WebSocketUtil {
         // Encode individual parts
         const bffBytes = encoder.encode(bff);
         const methodBytes = encoder.encode(method);
-        const paramsBytes = encoder.encode(JSON.stringify(params));
+        const paramsBytes = this.codec.encode(params);
         const headerBytes = encoder.encode(JSON.stringify(header));
 
         // Compute total length
@@ -118,11 +377,11 @@ class WebSocketUtil {
     }
 
 
-    formatRequestSubscribe(topic, data, header = {}) {
+    formatRequestSubscribe(reqId, topic, data, header = {}, conn_type = this.conn_type.SUBSCRIBE) {
         const encoder = new TextEncoder();
 
-        // Encode data (assuming it's a JSON string)
-        const dataBytes = encoder.encode(JSON.stringify(data));
+        // Encode data with the codec of the connection
+        const dataBytes = this.codec.encode(data);
         // Encode header (also assuming it's a JSON string)
         const headerBytes = encoder.encode(JSON.stringify(header));
 
@@ -138,6 +397,7 @@ class WebSocketUtil {
         // Calculate total message size
         const totalLength =
             1 + // conn_type (1 byte)
+            1 + // reqId (1 byte)
             2 + topicLength + // topic length (2 bytes + topic length)
             4 + dataBytes.length + // data length (4 bytes + data bytes)
             2 + headerBytes.length; // header length (2 bytes + header bytes)
@@ -146,7 +406,10 @@ class WebSocketUtil {
         let offset = 0;
 
         // --- Write conn_type (1 byte)
-        message[offset++] = this.conn_type.SUBSCRIBE;
+        message[offset++] = conn_type;
+
+        // --- Write reqId (1 byte)
+        message[offset++] = reqId;
 
         // --- Write topic length (2 bytes)
         message[offset++] = (topicLength >> 8) & 0xff;
@@ -184,7 +447,7 @@ class WebSocketUtil {
         // Encode strings
         const methodBytes = encoder.encode(method);
         const endpointBytes = encoder.encode(endpoint);
-        const payloadBytes = encoder.encode(JSON.stringify(data));
+        const payloadBytes = this.codec.encode(data);
         const headerBytes = encoder.encode(JSON.stringify(header));
 
         // Calculate total message size
@@ -251,10 +514,10 @@ class WebSocketUtil {
             throw new Error("WebSocket is not open. Could not connect on "+this.url)
         }
 
-        let promise = new Promise(function(resolve, reject) {
-            this.ws.send(binaryData)
+        let promise = new Promise((resolve, reject) => {
             // Add the resolve/reject callbacks to pendingRequests using reqId
             this.WebSocketEvents.pendingRequests[reqId] = {resolve, reject};
+            this.ws.send(binaryData)
         });
         return promise
     }
@@ -27,7 +27,7 @@ function parseHeader(header) {
  *  NEW buildBuffer — now header controls posSize/dataSize
  *  ---------------------------------------------------------
  */
-function buildBuffer({ header, pos = 0, json = null, rangeEnd = null }) {
+function buildBuffer({ header, pos = 0, json = null, rangeEnd = null, to = null }) {
     const { posSize, dataSize, bulk } = parseHeader(header);
     const bufParts = [];
 
@@ -56,6 +56,17 @@ function buildBuffer({ header, pos = 0, json = null, rangeEnd = null }) {
         bufParts.push(arr);
     }
 
+    // -----------------------------
+    // Move target (op = 10)
+    // -----------------------------
+    if (to !== null) {
+        const arr = new Uint8Array(posSize);
+        for (let i = 0; i < posSize; i++) {
+            arr[posSize - 1 - i] = (to >> (8 * i)) & 0xff;
+        }
+        bufParts.push(arr);
+    }
+
     // -----------------------------
     // JSON Payload
     // -----------------------------
@@ -216,3 +227,43 @@ test("PARTIAL PATCH", () => {
 
     expect(target).toEqual([{ n: 1 }, { n: 999 }]);
 });
+
+/*
+MOVE — header:
+    op = 10
+    posSize = 1
+*/
+test("MOVE element", () => {
+    const target = ["a", "b", "c", "d"];
+
+    const buffer = buildBuffer({
+        header: 0b00000110, // MOVE, posSize=1
+        pos: 0,
+        to: 3,
+    });
+
+    applyBinaryOperation(buffer, target);
+
+    expect(target).toEqual(["b", "c", "d", "a"]);
+});
+
+/*
+BULK MOVE — header:
+    bulk = 1
+    op = 10
+    posSize = 1
+*/
+test("BULK MOVE", () => {
+    const target = ["a", "b", "c", "d", "e"];
+
+    const buffer = buildBuffer({
+        header: 0b10000110,
+        pos: 3,        // begin
+        rangeEnd: 4,   // end
+        to: 0,
+    });
+
+    applyBinaryOperation(buffer, target);
+
+    expect(target).toEqual(["d", "e", "a", "b", "c"]);
+});
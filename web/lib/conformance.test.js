@@ -0,0 +1,39 @@
+import fs from "fs";
+import path from "path";
+import { applyBinaryOperation } from "./ArrayDecodeProtocol.js";
+import { applyBinaryOperationByte } from "./ArrayDecodeProtocolByte.js";
+
+/** -----------------------------------------
+ *  Conformance vectors written by the Go encoders
+ *  -----------------------------------------
+ *  Generated with `go run ./cmd/conformance` and also checked by
+ *  tests/conformance/conformance_test.go, so both decoders must leave the
+ *  array the same way.
+ */
+const CORPUS_VERSION = 1;
+const corpusFile = path.join(__dirname, "../../tests/conformance/testdata", `vectors.v${CORPUS_VERSION}.json`);
+const corpus = JSON.parse(fs.readFileSync(corpusFile, "utf8"));
+
+function hexToBytes(hex) {
+    return Uint8Array.from(Buffer.from(hex, "hex"));
+}
+
+test("corpus version", () => {
+    expect(corpus.version).toBe(CORPUS_VERSION);
+    expect(corpus.vectors.length).toBeGreaterThan(0);
+});
+
+describe.each(corpus.vectors.map(v => [`${v.name} (${v.protocol}${v.protocolVersion ? " v" + v.protocolVersion : ""})`, v]))("%s", (_, vector) => {
+    test("decodes to the expected array", () => {
+        const target = structuredClone(vector.input);
+        const buffer = hexToBytes(vector.encoded);
+
+        if (vector.protocol === "bit") {
+            applyBinaryOperation(buffer, target, false, vector.protocolVersion);
+        } else {
+            applyBinaryOperationByte(buffer, target, false);
+        }
+
+        expect(target).toEqual(vector.expected);
+    });
+});
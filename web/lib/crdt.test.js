@@ -0,0 +1,75 @@
+import { Sequence, LWWMap } from "./Crdt.js";
+
+/** -----------------------------------------
+ *  Same merges as tests/crdt/crdt_test.go
+ *  -----------------------------------------
+ */
+const text = (s) => s.values().join("");
+
+test("concurrent inserts keep both, in the same order", () => {
+    const a = new Sequence("alice");
+    const b = new Sequence("bob");
+    b.apply(a.insert(0, ["a", "c"]));
+
+    const fromA = a.insert(1, ["X", "X"]);
+    const fromB = b.insert(1, ["y", "y"]);
+    a.apply(fromB);
+    b.apply(fromA);
+
+    expect(text(a)).toBe("ayyXXc");
+    expect(text(b)).toBe("ayyXXc");
+});
+
+test("concurrent insert and delete", () => {
+    const a = new Sequence("a");
+    const b = new Sequence("b");
+    b.apply(a.insert(0, ["a", "b", "c"]));
+
+    const fromA = a.delete(1, 1);
+    const fromB = b.insert(2, ["X"]).concat(b.delete(1, 1));
+    a.apply(fromB);
+    b.apply(fromA);
+
+    expect(text(a)).toBe("aXc");
+    expect(text(b)).toBe("aXc");
+});
+
+test("operations wait for their dependencies, duplicates are ignored", () => {
+    const a = new Sequence("a");
+    const b = new Sequence("b");
+    const first = a.insert(0, ["a", "b"]);
+    const second = a.insert(2, ["c"]).concat(a.delete(0, 1));
+
+    expect(b.apply(second)).toHaveLength(0);
+    expect(b.apply(first)).toHaveLength(4);
+    expect(b.apply(first)).toHaveLength(0);
+    expect(text(b)).toBe("bc");
+});
+
+test("operations decoded from the server", () => {
+    const s = new Sequence("client");
+    s.apply([
+        { id: { counter: 1, replica: "server" }, after: { counter: 0, replica: "" }, value: "h", delete: false },
+        { id: { counter: 2, replica: "server" }, after: { counter: 1, replica: "server" }, value: "i", delete: false },
+    ]);
+    expect(text(s)).toBe("hi");
+    expect(() => s.apply([{ id: { counter: 0, replica: "" }, value: "x" }])).toThrow();
+});
+
+test("map, the last writer wins", () => {
+    const a = new LWWMap("alice");
+    const b = new LWWMap("bob");
+    const fromA = a.set("color", "red");
+    const fromB = b.set("color", "blue");
+    a.apply([fromB]);
+    b.apply([fromA]);
+
+    expect(a.get("color")).toBe("blue");
+    expect(b.values()).toEqual(a.values());
+
+    const del = a.delete("color");
+    const set = a.set("size", "L");
+    b.apply([set, del]);
+    expect(b.values()).toEqual({ size: "L" });
+    expect(b.apply([fromA])).toHaveLength(0);
+});